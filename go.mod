@@ -3,12 +3,15 @@ module github.com/alam0rt/kubectl-doktor
 go 1.16
 
 require (
+	github.com/google/pprof v0.0.0-20200229191704-1ebb73c60ed3
 	github.com/pkg/errors v0.9.1
 	github.com/rs/zerolog v1.21.0
 	github.com/spf13/cobra v1.1.3
 	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.7.0
 	github.com/stretchr/testify v1.6.1
+	golang.org/x/arch v0.3.0
+	golang.org/x/net v0.0.0-20210224082022-3d97a244fca7
 	k8s.io/api v0.21.0
 	k8s.io/apimachinery v0.21.0
 	k8s.io/cli-runtime v0.21.0
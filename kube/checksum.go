@@ -0,0 +1,84 @@
+package kube
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalChecksums returns the SHA-256 of src, or of every regular file below src
+// when it is a directory, keyed by the path the file will have under remotePath.
+func LocalChecksums(src string, remotePath string) (map[string]string, error) {
+	checksums := map[string]string{}
+
+	err := filepath.Walk(src, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(src, filePath)
+		if err != nil {
+			return err
+		}
+
+		dst := remotePath
+		if rel != "." {
+			dst = strings.TrimSuffix(remotePath, "/") + "/" + filepath.ToSlash(rel)
+		}
+
+		sum, err := fileChecksum(filePath)
+		if err != nil {
+			return err
+		}
+
+		checksums[dst] = sum
+		return nil
+	})
+
+	return checksums, err
+}
+
+func fileChecksum(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ParseChecksums parses sha256sum output into a map of path to checksum.
+// Lines sha256sum couldn't compute (e.g. missing files) are ignored. The
+// checksum is followed by a space and by another one or a '*' depending on
+// the mode the file was read in, the rest of the line being the path, which
+// may hold spaces.
+func ParseChecksums(output string) map[string]string {
+	checksums := map[string]string{}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 2)
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 || len(fields[1]) < 2 ||
+			(fields[1][0] != ' ' && fields[1][0] != '*') {
+			continue
+		}
+
+		checksums[fields[1][1:]] = fields[0]
+	}
+
+	return checksums
+}
@@ -2,8 +2,9 @@ package kube
 
 import (
 	"context"
-	"fmt"
 	"io"
+	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -18,31 +19,110 @@ import (
 )
 
 type KubernetesApiService interface {
+	GetTargetPod(podName string) (*corev1.Pod, error)
+
+	GetNode(nodeName string) (*corev1.Node, error)
+
+	// GetPersistentVolumeClaim gets a claim of the target namespace.
+	GetPersistentVolumeClaim(claimName string) (*corev1.PersistentVolumeClaim, error)
+
+	// ListNodePods lists the pods of every namespace scheduled on nodeName.
+	ListNodePods(nodeName string) ([]corev1.Pod, error)
+
+	// ListPods lists the pods of every namespace.
+	ListPods() ([]corev1.Pod, error)
+
+	// ListServices lists the services of every namespace.
+	ListServices() ([]corev1.Service, error)
+
 	ExecuteCommand(podName string, containerName string, command []string, stdOut io.Writer) (int, error)
 
 	DeletePod(podName string) error
 
 	CreatePrivilegedPod(nodeName string, containerName string, image string, socketPath string, timeout time.Duration) (*corev1.Pod, error)
 
+	// UploadFile uploads a file, or a directory with all its files, to
+	// remotePath. Files already present with a matching SHA-256 are not
+	// uploaded again.
 	UploadFile(localPath string, remotePath string, podName string, containerName string) error
+
+	// DownloadFile downloads a remote file or directory into the local
+	// directory localDir.
+	DownloadFile(remotePath string, localDir string, podName string, containerName string) error
 }
 
+// KubernetesApiServiceImpl resolves the target pod in targetNamespace while
+// the privileged helper pod is created, executed in and deleted from
+// helperNamespace, which may be a dedicated namespace (e.g. doktor-system)
+// without the restrictive PodSecurity labels of the application namespace.
 type KubernetesApiServiceImpl struct {
 	clientset       *kubernetes.Clientset
 	restConfig      *rest.Config
 	targetNamespace string
+	helperNamespace string
 }
 
 func NewKubernetesApiService(clientset *kubernetes.Clientset,
-	restConfig *rest.Config, targetNamespace string) KubernetesApiService {
+	restConfig *rest.Config, targetNamespace string, helperNamespace string) KubernetesApiService {
 
 	return &KubernetesApiServiceImpl{clientset: clientset,
 		restConfig:      restConfig,
-		targetNamespace: targetNamespace}
+		targetNamespace: targetNamespace,
+		helperNamespace: helperNamespace}
+}
+
+func (k *KubernetesApiServiceImpl) GetTargetPod(podName string) (*corev1.Pod, error) {
+	return k.clientset.CoreV1().Pods(k.targetNamespace).Get(context.TODO(), podName, v1.GetOptions{})
+}
+
+func (k *KubernetesApiServiceImpl) GetNode(nodeName string) (*corev1.Node, error) {
+	return k.clientset.CoreV1().Nodes().Get(context.TODO(), nodeName, v1.GetOptions{})
+}
+
+func (k *KubernetesApiServiceImpl) GetPersistentVolumeClaim(claimName string) (*corev1.PersistentVolumeClaim, error) {
+	return k.clientset.CoreV1().PersistentVolumeClaims(k.targetNamespace).Get(context.TODO(), claimName, v1.GetOptions{})
+}
+
+func (k *KubernetesApiServiceImpl) ListNodePods(nodeName string) ([]corev1.Pod, error) {
+	pods, err := k.clientset.CoreV1().Pods("").List(context.TODO(), v1.ListOptions{
+		FieldSelector: "spec.nodeName=" + nodeName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pods.Items, nil
+}
+
+func (k *KubernetesApiServiceImpl) ListPods() ([]corev1.Pod, error) {
+	pods, err := k.clientset.CoreV1().Pods("").List(context.TODO(), v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return pods.Items, nil
+}
+
+func (k *KubernetesApiServiceImpl) ListServices() ([]corev1.Service, error) {
+	services, err := k.clientset.CoreV1().Services("").List(context.TODO(), v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return services.Items, nil
+}
+
+func (k *KubernetesApiServiceImpl) checkIfHelperNamespaceExist() error {
+	_, err := k.clientset.CoreV1().Namespaces().Get(context.TODO(), k.helperNamespace, v1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "helper namespace '%s' is not available", k.helperNamespace)
+	}
+
+	return nil
 }
 
 func (k *KubernetesApiServiceImpl) IsSupportedContainerRuntime(nodeName string) (bool, error) {
-	node, err := k.clientset.CoreV1().Nodes().Get(context.TODO(), nodeName, v1.GetOptions{})
+	node, err := k.GetNode(nodeName)
 	if err != nil {
 		return false, err
 	}
@@ -61,14 +141,14 @@ func (k *KubernetesApiServiceImpl) IsSupportedContainerRuntime(nodeName string)
 func (k *KubernetesApiServiceImpl) ExecuteCommand(podName string, containerName string, command []string, stdOut io.Writer) (int, error) {
 
 	log.Info().
-		Msgf("executing command: '%s' on container: '%s', pod: '%s', namespace: '%s'", command, containerName, podName, k.targetNamespace)
+		Msgf("executing command: '%s' on container: '%s', pod: '%s', namespace: '%s'", command, containerName, podName, k.helperNamespace)
 	stdErr := new(Writer)
 
 	executeTcpdumpRequest := ExecCommandRequest{
 		KubeRequest: KubeRequest{
 			Clientset:  k.clientset,
 			RestConfig: k.restConfig,
-			Namespace:  k.targetNamespace,
+			Namespace:  k.helperNamespace,
 			Pod:        podName,
 			Container:  containerName,
 		},
@@ -102,7 +182,7 @@ func (k *KubernetesApiServiceImpl) DeletePod(podName string) error {
 
 	var gracePeriodTime int64 = 0
 
-	err := k.clientset.CoreV1().Pods(k.targetNamespace).Delete(context.TODO(), podName, v1.DeleteOptions{
+	err := k.clientset.CoreV1().Pods(k.helperNamespace).Delete(context.TODO(), podName, v1.DeleteOptions{
 		GracePeriodSeconds: &gracePeriodTime,
 	})
 
@@ -122,6 +202,10 @@ func (k *KubernetesApiServiceImpl) CreatePrivilegedPod(nodeName string, containe
 		return nil, errors.Errorf("Container runtime on node %s isn't supported. Supported container runtimes are: %v", nodeName, runtime.SupportedContainerRuntimes)
 	}
 
+	if err := k.checkIfHelperNamespaceExist(); err != nil {
+		return nil, err
+	}
+
 	typeMetadata := v1.TypeMeta{
 		Kind:       "Pod",
 		APIVersion: "v1",
@@ -129,7 +213,7 @@ func (k *KubernetesApiServiceImpl) CreatePrivilegedPod(nodeName string, containe
 
 	objectMetadata := v1.ObjectMeta{
 		GenerateName: "doktor-",
-		Namespace:    k.targetNamespace,
+		Namespace:    k.helperNamespace,
 		Labels: map[string]string{
 			"app": "doktor",
 		},
@@ -146,6 +230,13 @@ func (k *KubernetesApiServiceImpl) CreatePrivilegedPod(nodeName string, containe
 			ReadOnly:  false,
 			MountPath: "/host",
 		},
+		// bpftrace attaches kprobes and tracepoints through tracefs, which
+		// minimal images have no way of mounting themselves.
+		{
+			Name:      "debugfs",
+			ReadOnly:  false,
+			MountPath: "/sys/kernel/debug",
+		},
 	}
 
 	privileged := true
@@ -188,6 +279,15 @@ func (k *KubernetesApiServiceImpl) CreatePrivilegedPod(nodeName string, containe
 					},
 				},
 			},
+			{
+				Name: "debugfs",
+				VolumeSource: corev1.VolumeSource{
+					HostPath: &corev1.HostPathVolumeSource{
+						Path: "/sys/kernel/debug",
+						Type: &directoryType,
+					},
+				},
+			},
 		},
 	}
 
@@ -197,7 +297,7 @@ func (k *KubernetesApiServiceImpl) CreatePrivilegedPod(nodeName string, containe
 		Spec:       podSpecs,
 	}
 
-	createdPod, err := k.clientset.CoreV1().Pods(k.targetNamespace).Create(context.TODO(), &pod, v1.CreateOptions{})
+	createdPod, err := k.clientset.CoreV1().Pods(k.helperNamespace).Create(context.TODO(), &pod, v1.CreateOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -208,7 +308,7 @@ func (k *KubernetesApiServiceImpl) CreatePrivilegedPod(nodeName string, containe
 		Msgf("created pod details: %v", createdPod)
 
 	verifyPodState := func() bool {
-		podStatus, err := k.clientset.CoreV1().Pods(k.targetNamespace).Get(context.TODO(), createdPod.Name, v1.GetOptions{})
+		podStatus, err := k.clientset.CoreV1().Pods(k.helperNamespace).Get(context.TODO(), createdPod.Name, v1.GetOptions{})
 		if err != nil {
 			return false
 		}
@@ -230,53 +330,84 @@ func (k *KubernetesApiServiceImpl) CreatePrivilegedPod(nodeName string, containe
 	return createdPod, nil
 }
 
-func (k *KubernetesApiServiceImpl) checkIfFileExistOnPod(remotePath string, podName string, containerName string) (bool, error) {
+// remoteChecksums runs sha256sum directly, without a shell, and returns the
+// checksums of the given remote paths that exist.
+func (k *KubernetesApiServiceImpl) remoteChecksums(remotePaths []string, podName string, containerName string) (map[string]string, error) {
 	stdOut := new(Writer)
-	stdErr := new(Writer)
 
-	command := []string{"/bin/sh", "-c", fmt.Sprintf("test -f %s", remotePath)}
+	command := append([]string{"sha256sum"}, remotePaths...)
 
 	exitCode, err := k.ExecuteCommand(podName, containerName, command, stdOut)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	if exitCode != 0 {
-		return false, nil
+	if IsMissingCommand(exitCode) {
+		return nil, MissingCommandError("sha256sum")
 	}
 
-	if stdErr.Output != "" {
-		return false, errors.New("failed to check for tcpdump")
-	}
+	// sha256sum exits non-zero when some of the files are missing, the
+	// checksums of the files that do exist are still printed.
+	log.Debug().
+		Msgf("sha256sum exitCode: '%d', output: '%s'", exitCode, stdOut.Output)
 
-	log.Info().
-		Msgf("file found: '%s'", stdOut.Output)
+	return ParseChecksums(stdOut.Output), nil
+}
 
-	return true, nil
+// MissingCommandError reports a command the helper image doesn't ship, as
+// told by IsMissingCommand.
+func MissingCommandError(command string) error {
+	return errors.Errorf("'%s' not found in the helper image, use an image shipping it, "+
+		"e.g. with coreutils or busybox, with --image", command)
+}
+
+func checksumsMatch(expected map[string]string, actual map[string]string) bool {
+	for remotePath, sum := range expected {
+		if actual[remotePath] != sum {
+			return false
+		}
+	}
+
+	return true
 }
 
 func (k *KubernetesApiServiceImpl) UploadFile(localPath string, remotePath string, podName string, containerName string) error {
 	log.Info().
 		Msgf("uploading file: '%s' to '%s' on container: '%s'", localPath, remotePath, containerName)
 
-	isExist, err := k.checkIfFileExistOnPod(remotePath, podName, containerName)
+	expected, err := LocalChecksums(localPath, remotePath)
+	if err != nil {
+		return err
+	}
+
+	if len(expected) == 0 {
+		return errors.Errorf("no files found to upload in: '%s'", localPath)
+	}
+
+	remotePaths := make([]string, 0, len(expected))
+	for p := range expected {
+		remotePaths = append(remotePaths, p)
+	}
+	sort.Strings(remotePaths)
+
+	actual, err := k.remoteChecksums(remotePaths, podName, containerName)
 	if err != nil {
 		return err
 	}
 
-	if isExist {
-		log.Info().Msg("file was already found on remote pod")
+	if checksumsMatch(expected, actual) {
+		log.Info().Msg("file with matching checksum was already found on remote pod")
 		return nil
 	}
 
 	log.Info().
-		Msgf("file not found on: '%s', starting to upload", remotePath)
+		Msgf("file not found on: '%s' or checksum differs, starting to upload", remotePath)
 
 	req := UploadFileRequest{
 		KubeRequest: KubeRequest{
 			Clientset:  k.clientset,
 			RestConfig: k.restConfig,
-			Namespace:  k.targetNamespace,
+			Namespace:  k.helperNamespace,
 			Pod:        podName,
 			Container:  containerName,
 		},
@@ -285,22 +416,30 @@ func (k *KubernetesApiServiceImpl) UploadFile(localPath string, remotePath strin
 	}
 
 	exitCode, err := PodUploadFile(req)
-	if err != nil || exitCode != 0 {
+	if err != nil {
 		return errors.Wrapf(err, "upload file failed, exitCode: %d", exitCode)
 	}
 
+	if IsMissingCommand(exitCode) {
+		return MissingCommandError("tar")
+	}
+
+	if exitCode != 0 {
+		return errors.Errorf("upload file failed, exitCode: %d", exitCode)
+	}
+
 	log.Info().
 		Msgf("verifying file uploaded successfully")
 
-	isExist, err = k.checkIfFileExistOnPod(remotePath, podName, containerName)
+	actual, err = k.remoteChecksums(remotePaths, podName, containerName)
 	if err != nil {
 		return err
 	}
 
-	if !isExist {
+	if !checksumsMatch(expected, actual) {
 		log.Error().
 			Msg("failed to upload file.")
-		return errors.New("couldn't locate file on pod after upload done")
+		return errors.New("checksum mismatch on pod after upload done")
 	}
 
 	log.Info().
@@ -308,3 +447,42 @@ func (k *KubernetesApiServiceImpl) UploadFile(localPath string, remotePath strin
 
 	return nil
 }
+
+func (k *KubernetesApiServiceImpl) DownloadFile(remotePath string, localDir string, podName string, containerName string) error {
+	log.Info().
+		Msgf("downloading file: '%s' from container: '%s' to '%s'", remotePath, containerName, localDir)
+
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		return err
+	}
+
+	req := DownloadFileRequest{
+		KubeRequest: KubeRequest{
+			Clientset:  k.clientset,
+			RestConfig: k.restConfig,
+			Namespace:  k.helperNamespace,
+			Pod:        podName,
+			Container:  containerName,
+		},
+		Src: remotePath,
+		Dst: localDir,
+	}
+
+	exitCode, err := PodDownloadFile(req)
+	if err != nil {
+		return errors.Wrapf(err, "download file failed, exitCode: %d", exitCode)
+	}
+
+	if IsMissingCommand(exitCode) {
+		return MissingCommandError("tar")
+	}
+
+	if exitCode != 0 {
+		return errors.Errorf("download file failed, exitCode: %d", exitCode)
+	}
+
+	log.Info().
+		Msgf("file: '%s' downloaded successfully", remotePath)
+
+	return nil
+}
@@ -1,10 +1,10 @@
 package kube
 
 import (
-	"bytes"
 	"io"
 	"io/ioutil"
 	"path"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 	corev1 "k8s.io/api/core/v1"
@@ -37,6 +37,12 @@ type UploadFileRequest struct {
 	Dst string
 }
 
+type DownloadFileRequest struct {
+	KubeRequest
+	Src string
+	Dst string
+}
+
 func (w *NopWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
@@ -63,31 +69,16 @@ func PodUploadFile(req UploadFileRequest) (int, error) {
 	log.Debug().
 		Msgf("uploading file from: '%s' to '%s'", req.Src, req.Dst)
 
-	fileContent, err := ioutil.ReadFile(req.Src)
-	if err != nil {
-		return 0, err
-	}
-
-	log.Debug().
-		Msgf("read '%s' to memory, file size: '%d'", req.Src, len(fileContent))
-
-	destFileName := path.Base(req.Dst)
-	tarFile, err := WrapAsTar(destFileName, fileContent)
-	if err != nil {
-		return 0, err
-	}
-
-	log.Debug().
-		Msgf("formatted '%s' as tar, tar size: '%d'", req.Src, len(tarFile))
-
-	stdIn := bytes.NewReader(tarFile)
+	// entries are named after their absolute destination and extracted at the
+	// root so missing parent directories get created by tar itself.
+	nameOnTar := strings.TrimPrefix(path.Clean(req.Dst), "/")
+	tarCmd := []string{"tar", "-xf", "-", "-C", "/"}
 
-	tarCmd := []string{"tar", "-xf", "-"}
-
-	destDir := path.Dir(req.Dst)
-	if len(destDir) > 0 {
-		tarCmd = append(tarCmd, "-C", destDir)
-	}
+	stdIn, tarWriter := io.Pipe()
+	go func() {
+		err := WriteTar(tarWriter, req.Src, nameOnTar)
+		_ = tarWriter.CloseWithError(err)
+	}()
 
 	log.Debug().
 		Msgf("executing tar: '%v'", tarCmd)
@@ -108,6 +99,9 @@ func PodUploadFile(req UploadFileRequest) (int, error) {
 
 	exitCode, err := PodExecuteCommand(execTarRequest)
 
+	// unblock the tar writer in case the remote side stopped reading early
+	_ = stdIn.Close()
+
 	log.Debug().
 		Msgf("done uploading file, exitCode: '%d', stdOut: '%s', stdErr: '%s'",
 			exitCode, stdOut.Output, stdErr.Output)
@@ -115,6 +109,80 @@ func PodUploadFile(req UploadFileRequest) (int, error) {
 	return exitCode, err
 }
 
+// PodDownloadFile streams a tar of the remote file or directory Src out of the
+// pod and extracts it below the local directory Dst, keeping Src's base name.
+func PodDownloadFile(req DownloadFileRequest) (int, error) {
+	stdErr := new(Writer)
+
+	log.Debug().
+		Msgf("downloading file from: '%s' to '%s'", req.Src, req.Dst)
+
+	src := path.Clean(req.Src)
+	tarCmd := []string{"tar", "-cf", "-", "-C", path.Dir(src), path.Base(src)}
+
+	log.Debug().
+		Msgf("executing tar: '%v'", tarCmd)
+
+	stdOut, tarReader := io.Pipe()
+
+	type result struct {
+		exitCode int
+		err      error
+	}
+	done := make(chan result, 1)
+
+	go func() {
+		exitCode, err := PodExecuteCommand(ExecCommandRequest{
+			KubeRequest: KubeRequest{
+				Clientset:  req.Clientset,
+				RestConfig: req.RestConfig,
+				Namespace:  req.Namespace,
+				Pod:        req.Pod,
+				Container:  req.Container,
+			},
+			Command: tarCmd,
+			StdOut:  tarReader,
+			StdErr:  stdErr,
+		})
+		_ = tarReader.Close()
+		done <- result{exitCode: exitCode, err: err}
+	}()
+
+	extractErr := ExtractTar(stdOut, req.Dst)
+	if extractErr == nil {
+		// tar pads its output past the end-of-archive marker
+		_, _ = io.Copy(ioutil.Discard, stdOut)
+	}
+
+	// unblock the remote tar in case extraction stopped reading early
+	_ = stdOut.CloseWithError(extractErr)
+
+	res := <-done
+
+	log.Debug().
+		Msgf("done downloading file, exitCode: '%d', stdErr: '%s'", res.exitCode, stdErr.Output)
+
+	if res.err != nil {
+		return res.exitCode, res.err
+	}
+
+	return res.exitCode, extractErr
+}
+
+// Exit codes of a command that can't be run in a container, as reported by
+// the shell and the container runtimes: found but not executable, or not
+// found at all.
+const (
+	ExitNotExecutable = 126
+	ExitNotFound      = 127
+)
+
+// IsMissingCommand tells whether exitCode reports a command missing from the
+// container image.
+func IsMissingCommand(exitCode int) bool {
+	return exitCode == ExitNotExecutable || exitCode == ExitNotFound
+}
+
 func PodExecuteCommand(req ExecCommandRequest) (int, error) {
 
 	execRequest := req.Clientset.CoreV1().RESTClient().Post().
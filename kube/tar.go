@@ -2,30 +2,142 @@ package kube
 
 import (
 	"archive/tar"
-	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 )
 
-func WrapAsTar(fileNameOnTar string, fileContent []byte) ([]byte, error) {
-	var buf bytes.Buffer
-	tw := tar.NewWriter(&buf)
+// WriteTar streams src as a tar archive into w. When src is a directory every
+// regular file below it is added, otherwise src itself is added. Entries are
+// named after nameOnTar so the archive can be extracted at its final location.
+func WriteTar(w io.Writer, src string, nameOnTar string) error {
+	tw := tar.NewWriter(w)
+
+	err := filepath.Walk(src, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, filePath)
+		if err != nil {
+			return err
+		}
+
+		name := nameOnTar
+		if rel != "." {
+			name = strings.TrimSuffix(nameOnTar, "/") + "/" + filepath.ToSlash(rel)
+		}
+
+		if info.IsDir() {
+			return tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     name + "/",
+				Mode:     0755,
+			})
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
 
-	hdr := &tar.Header{
-		Name: fileNameOnTar,
-		Mode: 0755,
-		Size: int64(len(fileContent)),
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     int64(info.Mode().Perm()),
+			Size:     info.Size(),
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err != nil {
+		return err
 	}
 
-	if err := tw.WriteHeader(hdr); err != nil {
-		return nil, err
+	return tw.Close()
+}
+
+// ExtractTar extracts the tar stream r below dstDir. Entries escaping dstDir
+// through absolute paths or ".." are rejected, links are skipped since they
+// could later redirect writes outside of dstDir.
+func ExtractTar(r io.Reader, dstDir string) error {
+	root, err := filepath.Abs(dstDir)
+	if err != nil {
+		return err
 	}
 
-	if _, err := tw.Write(fileContent); err != nil {
-		return nil, err
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := securePath(root, hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+
+			if err := extractFile(tr, target, os.FileMode(hdr.Mode).Perm()); err != nil {
+				return err
+			}
+		default:
+			log.Debug().
+				Msgf("skipping tar entry: '%s' of type: '%c'", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+func securePath(root string, name string) (string, error) {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return "", errors.Errorf("refusing to extract absolute path: '%s'", name)
+	}
+
+	target := filepath.Join(root, filepath.FromSlash(name))
+	if target != root && !strings.HasPrefix(target, root+string(os.PathSeparator)) {
+		return "", errors.Errorf("refusing to extract path outside of '%s': '%s'", root, name)
+	}
+
+	return target, nil
+}
+
+func extractFile(r io.Reader, target string, mode os.FileMode) error {
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
 	}
 
-	if err := tw.Close(); err != nil {
-		return nil, err
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
 	}
 
-	return buf.Bytes(), nil
+	return file.Close()
 }
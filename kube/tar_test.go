@@ -0,0 +1,163 @@
+package kube
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteTar_Directory(t *testing.T) {
+	// given
+	dir, err := ioutil.TempDir("", "doktor-tar")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "lib"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "main.bt"), []byte("BEGIN {}"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "lib", "common.h"), []byte("#define X 1"), 0644))
+
+	// when
+	var buf bytes.Buffer
+	err = WriteTar(&buf, dir, "tmp/doktor/scripts")
+
+	// then
+	assert.NoError(t, err)
+
+	files := map[string]string{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+
+		content, err := ioutil.ReadAll(tr)
+		assert.NoError(t, err)
+		files[hdr.Name] = string(content)
+	}
+
+	assert.Equal(t, "BEGIN {}", files["tmp/doktor/scripts/main.bt"])
+	assert.Equal(t, "#define X 1", files["tmp/doktor/scripts/lib/common.h"])
+	assert.Contains(t, files, "tmp/doktor/scripts/lib/")
+}
+
+func TestLocalChecksums_File(t *testing.T) {
+	// given
+	file, err := ioutil.TempFile("", "doktor-checksum")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString("hello")
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	// when
+	checksums, err := LocalChecksums(file.Name(), "/tmp/doktor/bin/bpftrace")
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"/tmp/doktor/bin/bpftrace": "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+	}, checksums)
+}
+
+func TestParseChecksums(t *testing.T) {
+	// given
+	output := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824  /tmp/doktor/bin/bpftrace\n" +
+		"486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7  /tmp/doktor/scripts/my bundle/main.bt\n" +
+		"fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9 */tmp/doktor/scripts/lib.bt\n" +
+		"sha256sum: /tmp/doktor/scripts/main.bt: No such file or directory\n"
+
+	// when
+	checksums := ParseChecksums(output)
+
+	// then
+	assert.Equal(t, map[string]string{
+		"/tmp/doktor/bin/bpftrace":              "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		"/tmp/doktor/scripts/my bundle/main.bt": "486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7",
+		"/tmp/doktor/scripts/lib.bt":            "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9",
+	}, checksums)
+}
+
+func TestExtractTar_RoundTrip(t *testing.T) {
+	// given
+	src, err := ioutil.TempDir("", "doktor-src")
+	assert.NoError(t, err)
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir("", "doktor-dst")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dst)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(src, "perf.data"), []byte("data"), 0600))
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteTar(&buf, src, "artifacts"))
+
+	// when
+	err = ExtractTar(&buf, dst)
+
+	// then
+	assert.NoError(t, err)
+	content, err := ioutil.ReadFile(filepath.Join(dst, "artifacts", "perf.data"))
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(content))
+}
+
+func TestExtractTar_PathTraversal(t *testing.T) {
+	for _, name := range []string{"../escape", "artifacts/../../escape", "/etc/escape"} {
+		// given
+		dst, err := ioutil.TempDir("", "doktor-dst")
+		assert.NoError(t, err)
+
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: 1}))
+		_, err = tw.Write([]byte("x"))
+		assert.NoError(t, err)
+		assert.NoError(t, tw.Close())
+
+		// when
+		err = ExtractTar(&buf, dst)
+
+		// then
+		assert.Error(t, err, name)
+		_, statErr := os.Stat(filepath.Join(filepath.Dir(dst), "escape"))
+		assert.True(t, os.IsNotExist(statErr), name)
+
+		os.RemoveAll(dst)
+	}
+}
+
+func TestExtractTar_SkipsSymlinks(t *testing.T) {
+	// given
+	dst, err := ioutil.TempDir("", "doktor-dst")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dst)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "/etc"}))
+	assert.NoError(t, tw.Close())
+
+	// when
+	err = ExtractTar(&buf, dst)
+
+	// then
+	assert.NoError(t, err)
+	_, statErr := os.Lstat(filepath.Join(dst, "link"))
+	assert.True(t, os.IsNotExist(statErr))
+}
+
+func TestIsMissingCommand(t *testing.T) {
+	assert.True(t, IsMissingCommand(ExitNotFound))
+	assert.True(t, IsMissingCommand(ExitNotExecutable))
+	assert.False(t, IsMissingCommand(0))
+	assert.False(t, IsMissingCommand(1))
+}
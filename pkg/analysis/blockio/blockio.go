@@ -0,0 +1,293 @@
+// Package blockio measures the latency and throughput of the block devices
+// backing the persistent volumes of a pod.
+package blockio
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/rs/zerolog/log"
+)
+
+// Maps the program reports in, keyed by device and operation.
+const (
+	OpsMap     = "@ops"
+	BytesMap   = "@bytes"
+	LatencyMap = "@latency_ns"
+)
+
+// Operations measured.
+const (
+	OpRead  = "read"
+	OpWrite = "write"
+)
+
+// The first character of the rwbs field of the block tracepoints, the
+// operation of the request.
+const (
+	rwbsRead  = 'R'
+	rwbsWrite = 'W'
+	// rwbsFlush precedes the operation of a request flushing the volatile
+	// cache of the device first
+	rwbsFlush = 'F'
+)
+
+// Program measures the latency, from being issued to the device to
+// completing, and the size of the read and write requests of the devices.
+// Block I/O is mostly issued asynchronously, e.g. by writeback, so it's
+// measured per device rather than per process.
+func Program(devices []Device, duration time.Duration) string {
+	conditions := make([]string, 0, len(devices))
+	for _, device := range devices {
+		conditions = append(conditions, fmt.Sprintf("args->dev == %d", device.Kernel()))
+	}
+
+	var b strings.Builder
+
+	b.WriteString("tracepoint:block:block_rq_issue\n")
+	fmt.Fprintf(&b, "/%s/\n{\n", strings.Join(conditions, " || "))
+	fmt.Fprintf(&b, "\t$op = args->rwbs[0] == %d ? args->rwbs[1] : args->rwbs[0];\n", rwbsFlush)
+	fmt.Fprintf(&b, "\tif ($op == %d || $op == %d) {\n", rwbsRead, rwbsWrite)
+	b.WriteString("\t\t@start[args->dev, args->sector] = nsecs;\n")
+	b.WriteString("\t\t@size[args->dev, args->sector] = args->bytes;\n")
+	b.WriteString("\t}\n}\n\n")
+
+	b.WriteString("tracepoint:block:block_rq_complete\n")
+	b.WriteString("/@start[args->dev, args->sector]/\n{\n")
+	fmt.Fprintf(&b, "\t$op = args->rwbs[0] == %d ? args->rwbs[1] : args->rwbs[0];\n", rwbsFlush)
+	b.WriteString("\t$ns = nsecs - @start[args->dev, args->sector];\n")
+	fmt.Fprintf(&b, "\t%s[args->dev, $op] = count();\n", OpsMap)
+	fmt.Fprintf(&b, "\t%s[args->dev, $op] = sum(@size[args->dev, args->sector]);\n", BytesMap)
+	fmt.Fprintf(&b, "\t%s[args->dev, $op] = hist($ns);\n", LatencyMap)
+	b.WriteString("\tdelete(@start[args->dev, args->sector]);\n")
+	b.WriteString("\tdelete(@size[args->dev, args->sector]);\n}\n\n")
+
+	fmt.Fprintf(&b, "interval:s:%d {\n\tclear(@start);\n\tclear(@size);\n\texit();\n}\n", bpftrace.Seconds(duration))
+
+	return b.String()
+}
+
+// Stats summarizes the reads or writes of a device, durations are in
+// nanoseconds.
+type Stats struct {
+	Ops            int64             `json:"ops"`
+	Bytes          int64             `json:"bytes"`
+	BytesPerSecond int64             `json:"bytesPerSecond"`
+	P50            int64             `json:"p50Ns"`
+	P90            int64             `json:"p90Ns"`
+	P99            int64             `json:"p99Ns"`
+	Max            int64             `json:"maxNs"`
+	Histogram      []bpftrace.Bucket `json:"histogram,omitempty"`
+}
+
+type deviceStats struct {
+	read  Stats
+	write Stats
+}
+
+// Collector is an output.Printer summarizing the maps of the program per
+// device.
+type Collector struct {
+	devices map[Device]*deviceStats
+}
+
+func NewCollector() *Collector {
+	return &Collector{devices: map[Device]*deviceStats{}}
+}
+
+// stats returns the stats of the device and operation of a map key.
+func (c *Collector) stats(key string) (*Stats, bool) {
+	fields := strings.SplitN(key, ",", 2)
+	if len(fields) != 2 {
+		return nil, false
+	}
+
+	dev, err := strconv.ParseUint(strings.TrimSpace(fields[0]), 10, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	device := kernelDevice(dev)
+	stats, ok := c.devices[device]
+	if !ok {
+		stats = &deviceStats{}
+		c.devices[device] = stats
+	}
+
+	switch operation(strings.TrimSpace(fields[1])) {
+	case rwbsRead:
+		return &stats.read, true
+	case rwbsWrite:
+		return &stats.write, true
+	default:
+		return nil, false
+	}
+}
+
+// operation decodes the rwbs character of a key, printed as a number or as
+// a character depending on the version of bpftrace.
+func operation(field string) int64 {
+	if value, err := strconv.ParseInt(field, 10, 64); err == nil {
+		return value
+	}
+
+	if field = strings.Trim(field, `"'`); len(field) == 1 {
+		return int64(field[0])
+	}
+
+	return 0
+}
+
+func (c *Collector) Print(record bpftrace.Record) error {
+	switch record.Type {
+	case bpftrace.TypeMap, bpftrace.TypeHist:
+	case bpftrace.TypeText:
+		log.Warn().Msg(record.String())
+		return nil
+	default:
+		return nil
+	}
+
+	maps, err := record.Maps()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range maps {
+		for _, key := range m.Keys() {
+			stats, ok := c.stats(key)
+			if !ok {
+				continue
+			}
+
+			value := m.Entries[key]
+
+			switch m.Name {
+			case OpsMap:
+				stats.Ops, err = bpftrace.ParseInt(value)
+			case BytesMap:
+				stats.Bytes, err = bpftrace.ParseInt(value)
+			case LatencyMap:
+				err = addLatency(stats, value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func addLatency(stats *Stats, value json.RawMessage) error {
+	buckets, err := bpftrace.ParseBuckets(value)
+	if err != nil {
+		return err
+	}
+
+	stats.Histogram = buckets
+	stats.P50 = bpftrace.Percentile(buckets, 50)
+	stats.P90 = bpftrace.Percentile(buckets, 90)
+	stats.P99 = bpftrace.Percentile(buckets, 99)
+	stats.Max = bpftrace.Percentile(buckets, 100)
+	return nil
+}
+
+func (c *Collector) Flush() error {
+	return nil
+}
+
+// Report is the read and write I/O of the device of a volume.
+type Report struct {
+	Volume
+	Read  Stats `json:"read"`
+	Write Stats `json:"write"`
+}
+
+// Reports returns the I/O of the devices of the volumes, over the duration
+// it was traced for.
+func (c *Collector) Reports(volumes []Volume, duration time.Duration) []Report {
+	reports := make([]Report, 0, len(volumes))
+	for _, volume := range volumes {
+		report := Report{Volume: volume}
+
+		if stats, ok := c.devices[volume.Device]; ok {
+			report.Read, report.Write = stats.read, stats.write
+		}
+
+		if seconds := duration.Seconds(); seconds > 0 {
+			report.Read.BytesPerSecond = int64(float64(report.Read.Bytes) / seconds)
+			report.Write.BytesPerSecond = int64(float64(report.Write.Bytes) / seconds)
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports
+}
+
+// Print writes the reports as a table followed, when histograms is set, by
+// the latency histograms of every volume, or as JSON for the json format.
+func Print(w io.Writer, format string, reports []Report, histograms bool) error {
+	if format == output.FormatJson {
+		return json.NewEncoder(w).Encode(reports)
+	}
+
+	rows := make([][]string, 0, len(reports))
+	for _, r := range reports {
+		device := r.DeviceName
+		if device == "" {
+			device = r.Device.String()
+		}
+		if r.Shared {
+			device += " (shared)"
+		}
+
+		rows = append(rows, []string{
+			r.Claim,
+			r.MountPath,
+			device,
+			strconv.FormatInt(r.Read.Ops, 10),
+			output.Size(r.Read.BytesPerSecond) + "/s",
+			latency(r.Read.P50),
+			latency(r.Read.P99),
+			strconv.FormatInt(r.Write.Ops, 10),
+			output.Size(r.Write.BytesPerSecond) + "/s",
+			latency(r.Write.P50),
+			latency(r.Write.P99),
+		})
+	}
+
+	err := output.WriteTable(w, []string{"CLAIM", "MOUNT", "DEVICE", "READS", "READ", "READ P50", "READ P99",
+		"WRITES", "WRITE", "WRITE P50", "WRITE P99"}, rows)
+	if err != nil || !histograms {
+		return err
+	}
+
+	for _, r := range reports {
+		for _, op := range []struct {
+			name  string
+			stats Stats
+		}{{OpRead, r.Read}, {OpWrite, r.Write}} {
+			if op.stats.Ops == 0 {
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "\n%s %s latency (ns):\n%s", r.Claim, op.name, output.FormatHistogram(op.stats.Histogram)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func latency(ns int64) string {
+	return time.Duration(ns).Round(time.Microsecond / 10).String()
+}
@@ -0,0 +1,196 @@
+package blockio
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const mountInfo = `22 1 259:1 / / rw,relatime shared:1 - ext4 /dev/nvme0n1p1 rw
+1201 1187 0:312 / / rw,relatime master:1 - overlay overlay rw,lowerdir=/l,upperdir=/u
+1215 1201 259:3 / /var/lib/postgresql/data rw,relatime - ext4 /dev/nvme1n1 rw
+1216 1201 259:1 /var/lib/kubelet/pods/3f/volumes/local /cache rw,relatime - ext4 /dev/nvme0n1p1 rw
+1217 1201 0:55 / /shared rw,relatime - nfs4 10.0.0.5:/export rw
+1218 1201 259:5 / /with\040space rw,relatime - xfs /dev/nvme2n1 rw
+1219 1201 259:6 /pgdata /var/lib/postgresql/wal rw,relatime - ext4 /dev/nvme3n1 rw
+`
+
+func TestParseMountInfo(t *testing.T) {
+	// when
+	mounts, err := ParseMountInfo([]byte(mountInfo))
+
+	// then
+	assert.NoError(t, err)
+	assert.Len(t, mounts, 7)
+	assert.Equal(t, Mount{Device: Device{Major: 259, Minor: 3}, Root: "/", MountPoint: "/var/lib/postgresql/data",
+		FsType: "ext4", Source: "/dev/nvme1n1"}, mounts[2])
+	assert.Equal(t, "/with space", mounts[5].MountPoint)
+
+	root, ok := RootDevice(mounts)
+	assert.False(t, ok)
+	assert.Equal(t, Device{Major: 0, Minor: 312}, root)
+
+	root, ok = RootDevice(mounts[:1])
+	assert.True(t, ok)
+	assert.Equal(t, Device{Major: 259, Minor: 1}, root)
+
+	// when
+	_, err = ParseMountInfo([]byte("22 1 259 / /"))
+
+	// then
+	assert.EqualError(t, err, "malformed device number: '259'")
+}
+
+func TestPodVolumes(t *testing.T) {
+	// given
+	pod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{Name: "postgres-0"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name: "postgres",
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "data", MountPath: "/var/lib/postgresql/data"},
+					{Name: "scratch", MountPath: "/scratch", SubPath: "tmp"},
+					{Name: "config", MountPath: "/etc/postgresql"},
+				},
+				VolumeDevices: []corev1.VolumeDevice{{Name: "raw", DevicePath: "/dev/xvdb"}},
+			}},
+			Volumes: []corev1.Volume{
+				{Name: "data", VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-postgres-0"}}},
+				{Name: "scratch", VolumeSource: corev1.VolumeSource{Ephemeral: &corev1.EphemeralVolumeSource{}}},
+				{Name: "config", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{}}},
+				{Name: "raw", VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "raw-postgres-0"}}},
+			},
+		},
+	}
+
+	// when
+	volumes := PodVolumes(pod, "postgres")
+
+	// then
+	assert.Equal(t, []Volume{
+		{Name: "data", Claim: "data-postgres-0", MountPath: "/var/lib/postgresql/data"},
+		{Name: "scratch", Claim: "postgres-0-scratch", MountPath: "/scratch", SubPath: "tmp", Ephemeral: true},
+	}, volumes)
+	assert.Empty(t, PodVolumes(pod, "sidecar"))
+}
+
+func TestVolumeResolve(t *testing.T) {
+	// given
+	mounts, _ := ParseMountInfo([]byte(mountInfo))
+	volumes := []Volume{{MountPath: "/var/lib/postgresql/data/"}, {MountPath: "/cache"}, {MountPath: "/shared"}, {MountPath: "/missing"},
+		{MountPath: "/var/lib/postgresql/wal", SubPath: "pgdata"}}
+
+	// when
+	var errs []error
+	for i := range volumes {
+		errs = append(errs, volumes[i].Resolve(mounts))
+	}
+
+	// then
+	assert.NoError(t, errs[0])
+	assert.NoError(t, errs[1])
+	assert.EqualError(t, errs[2], "its nfs4 filesystem isn't backed by a block device")
+	assert.EqualError(t, errs[3], "nothing is mounted at: '/missing'")
+	assert.NoError(t, errs[4])
+	assert.Equal(t, "259:3", volumes[0].Device.String())
+	assert.Equal(t, "259:6", volumes[4].Device.String())
+
+	// when
+	MarkShared(volumes[:2], Device{Major: 259, Minor: 1})
+
+	// then
+	assert.False(t, volumes[0].Shared)
+	assert.True(t, volumes[1].Shared)
+}
+
+func TestVolumeCheckClaim(t *testing.T) {
+	// given
+	pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "postgres-0", UID: "8c1d"}}
+	isController := true
+	owned := &corev1.PersistentVolumeClaim{ObjectMeta: v1.ObjectMeta{Name: "postgres-0-scratch",
+		OwnerReferences: []v1.OwnerReference{{Kind: "Pod", Name: "postgres-0", UID: "8c1d", Controller: &isController}}}}
+	foreign := &corev1.PersistentVolumeClaim{ObjectMeta: v1.ObjectMeta{Name: "postgres-0-scratch"}}
+	ephemeral := Volume{Name: "scratch", Claim: "postgres-0-scratch", Ephemeral: true}
+	persistent := Volume{Name: "data", Claim: "postgres-0-scratch"}
+
+	// then
+	assert.NoError(t, ephemeral.CheckClaim(owned, pod))
+	assert.EqualError(t, ephemeral.CheckClaim(foreign, pod),
+		"claim: 'postgres-0-scratch' of ephemeral volume: 'scratch' isn't owned by pod: 'postgres-0'")
+	assert.NoError(t, persistent.CheckClaim(foreign, pod))
+}
+
+func TestDevice(t *testing.T) {
+	// when
+	device, err := ParseDevice("259:3\n")
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(271581187), device.Kernel())
+	assert.Equal(t, device, kernelDevice(271581187))
+	assert.Equal(t, "/sys/dev/block/259:3", SysfsPath(device))
+}
+
+func TestProgram(t *testing.T) {
+	// when
+	program := Program([]Device{{Major: 259, Minor: 3}, {Major: 8, Minor: 16}}, 30*time.Second)
+
+	// then
+	assert.Contains(t, program, "tracepoint:block:block_rq_issue\n/args->dev == 271581187 || args->dev == 8388624/")
+	assert.Contains(t, program, "$op = args->rwbs[0] == 70 ? args->rwbs[1] : args->rwbs[0];")
+	assert.Contains(t, program, "if ($op == 82 || $op == 87) {")
+	assert.Contains(t, program, "@latency_ns[args->dev, $op] = hist($ns);")
+	assert.Contains(t, program, "interval:s:30 {")
+}
+
+func TestCollector(t *testing.T) {
+	// given
+	collector := NewCollector()
+	records := []string{
+		`{"type": "map", "data": {"@ops": {"271581187, 82": 1000, "271581187, 87": 200, "8388624, 87": 5}}}`,
+		`{"type": "map", "data": {"@bytes": {"271581187, 82": 40960000, "271581187, 87": 8192000}}}`,
+		`{"type": "hist", "data": {"@latency_ns": {"271581187, 82": [{"min": 262144, "max": 524287, "count": 1000}], "271581187, 87": [{"min": 1048576, "max": 2097151, "count": 200}]}}}`,
+	}
+	for _, record := range records {
+		assert.NoError(t, collector.Print(bpftrace.ParseRecord([]byte(record))))
+	}
+	volumes := []Volume{
+		{Name: "data", Claim: "data-postgres-0", MountPath: "/var/lib/postgresql/data", Device: Device{Major: 259, Minor: 3}, DeviceName: "nvme1n1"},
+		{Name: "cache", Claim: "cache", MountPath: "/cache", Device: Device{Major: 259, Minor: 1}, Shared: true},
+	}
+
+	// when
+	reports := collector.Reports(volumes, 10*time.Second)
+
+	// then
+	assert.Equal(t, int64(1000), reports[0].Read.Ops)
+	assert.Equal(t, int64(4096000), reports[0].Read.BytesPerSecond)
+	assert.Equal(t, int64(1572863), reports[0].Write.P50)
+	assert.Len(t, reports[0].Write.Histogram, 1)
+	assert.Equal(t, Stats{}, reports[1].Read)
+
+	// when
+	var buf bytes.Buffer
+	err := Print(&buf, output.FormatText, reports, true)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, ""+
+		"CLAIM            MOUNT                     DEVICE          READS  READ      READ P50  READ P99  WRITES  WRITE       WRITE P50  WRITE P99\n"+
+		"data-postgres-0  /var/lib/postgresql/data  nvme1n1         1000   3.9MiB/s  393.2µs   521.7µs   200     800.0KiB/s  1.5729ms   2.0867ms\n"+
+		"cache            /cache                    259:1 (shared)  0      0B/s      0s        0s        0       0B/s        0s         0s\n"+
+		"\ndata-postgres-0 read latency (ns):\n"+
+		"[256K, 512K)             1000 |@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@|\n"+
+		"\ndata-postgres-0 write latency (ns):\n"+
+		"[1M, 2M)                  200 |@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@|\n",
+		buf.String())
+}
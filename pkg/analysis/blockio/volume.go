@@ -0,0 +1,283 @@
+package blockio
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// sysfsBlockPath holds a link to the sysfs directory of every block device,
+// named after its device number.
+const sysfsBlockPath = "/sys/dev/block"
+
+// MountInfoPath is the mountinfo of a host process, listing the mounts of
+// its mount namespace.
+func MountInfoPath(pid string) string {
+	return fmt.Sprintf("%s/%s/mountinfo", bpftrace.HostProcPath, pid)
+}
+
+// SysfsPath is the sysfs directory of a block device.
+func SysfsPath(device Device) string {
+	return fmt.Sprintf("%s/%s", sysfsBlockPath, device)
+}
+
+// Device is the number of a block device.
+type Device struct {
+	Major uint32
+	Minor uint32
+}
+
+// ParseDevice parses a device number written as major:minor.
+func ParseDevice(s string) (Device, error) {
+	fields := strings.SplitN(strings.TrimSpace(s), ":", 2)
+	if len(fields) != 2 {
+		return Device{}, errors.Errorf("malformed device number: '%s'", s)
+	}
+
+	major, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return Device{}, errors.Wrapf(err, "malformed device number: '%s'", s)
+	}
+
+	minor, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return Device{}, errors.Wrapf(err, "malformed device number: '%s'", s)
+	}
+
+	return Device{Major: uint32(major), Minor: uint32(minor)}, nil
+}
+
+func (d Device) String() string {
+	return fmt.Sprintf("%d:%d", d.Major, d.Minor)
+}
+
+// Kernel returns the device number the way the block tracepoints report it,
+// the kernel's own encoding rather than the one of userspace.
+func (d Device) Kernel() uint64 {
+	return uint64(d.Major)<<20 | uint64(d.Minor)
+}
+
+func kernelDevice(dev uint64) Device {
+	return Device{Major: uint32(dev >> 20), Minor: uint32(dev & 0xfffff)}
+}
+
+// Mount is a line of a mountinfo file.
+type Mount struct {
+	Device     Device
+	Root       string
+	MountPoint string
+	FsType     string
+	Source     string
+}
+
+// ParseMountInfo parses a mountinfo file, see proc(5).
+func ParseMountInfo(content []byte) ([]Mount, error) {
+	var mounts []Mount
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			return nil, errors.Errorf("malformed mountinfo line: '%s'", line)
+		}
+
+		device, err := ParseDevice(fields[2])
+		if err != nil {
+			return nil, err
+		}
+
+		mount := Mount{Device: device, Root: unescape(fields[3]), MountPoint: unescape(fields[4])}
+
+		// the optional fields end with a single hyphen
+		for i := 5; i < len(fields); i++ {
+			if fields[i] == "-" && i+2 < len(fields) {
+				mount.FsType = fields[i+1]
+				mount.Source = unescape(fields[i+2])
+				break
+			}
+		}
+
+		mounts = append(mounts, mount)
+	}
+
+	return mounts, nil
+}
+
+// unescape decodes the octal escapes of the spaces, tabs, newlines and
+// backslashes of mountinfo paths.
+func unescape(path string) string {
+	if !strings.Contains(path, "\\") {
+		return path
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if value, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(value))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+
+	return b.String()
+}
+
+// Volume is a persistent volume claim mounted in the target container.
+type Volume struct {
+	// Name is the name of the volume in the pod spec.
+	Name             string `json:"volume"`
+	Claim            string `json:"claim"`
+	PersistentVolume string `json:"persistentVolume,omitempty"`
+	StorageClass     string `json:"storageClass,omitempty"`
+	MountPath        string `json:"mountPath"`
+	SubPath          string `json:"subPath,omitempty"`
+	// Ephemeral is set for generic ephemeral volumes, their claim named
+	// after the pod.
+	Ephemeral bool `json:"-"`
+	// Device is the disk backing the volume, rather than its partition.
+	Device     Device `json:"-"`
+	DeviceName string `json:"device,omitempty"`
+	// Shared is set when the disk backs other volumes or the node itself,
+	// its I/O not being only the one of the volume.
+	Shared bool `json:"sharedDevice"`
+}
+
+// PodVolumes returns the persistent volume claims of the pod mounted in the
+// container, generic ephemeral volumes included.
+func PodVolumes(pod *corev1.Pod, container string) []Volume {
+	var spec *corev1.Container
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == container {
+			spec = &pod.Spec.Containers[i]
+			break
+		}
+	}
+	if spec == nil {
+		return nil
+	}
+
+	var volumes []Volume
+	for _, podVolume := range pod.Spec.Volumes {
+		volume := Volume{Name: podVolume.Name}
+		switch {
+		case podVolume.PersistentVolumeClaim != nil:
+			volume.Claim = podVolume.PersistentVolumeClaim.ClaimName
+		case podVolume.Ephemeral != nil:
+			// the claim of a generic ephemeral volume is named after the pod
+			volume.Claim = fmt.Sprintf("%s-%s", pod.Name, podVolume.Name)
+			volume.Ephemeral = true
+		default:
+			continue
+		}
+
+		for _, mount := range spec.VolumeMounts {
+			if mount.Name == podVolume.Name {
+				volume.MountPath, volume.SubPath = mount.MountPath, mount.SubPath
+				break
+			}
+		}
+
+		if volume.MountPath == "" {
+			for _, device := range spec.VolumeDevices {
+				if device.Name == podVolume.Name {
+					log.Warn().
+						Msgf("skipping block mode volume: '%s' at: '%s', only filesystem volumes are supported", podVolume.Name, device.DevicePath)
+				}
+			}
+			continue
+		}
+
+		volumes = append(volumes, volume)
+	}
+
+	return volumes
+}
+
+// CheckClaim tells whether claim is the one of the volume. The claim named
+// after the pod is only the one of a generic ephemeral volume when the pod
+// owns it, Kubernetes leaving a claim of that name created by someone else
+// alone rather than using it.
+func (v *Volume) CheckClaim(claim *corev1.PersistentVolumeClaim, pod *corev1.Pod) error {
+	if v.Ephemeral && !metav1.IsControlledBy(claim, pod) {
+		return errors.Errorf("claim: '%s' of ephemeral volume: '%s' isn't owned by pod: '%s'", claim.Name, v.Name, pod.Name)
+	}
+
+	return nil
+}
+
+// Resolve sets the device mounted at the mount path of the volume, as listed
+// by the mountinfo of a process of the container. The mount of a subPath has
+// the directory of the volume it binds as root, it's still mounted at the
+// mount path. It fails when nothing is mounted there or its filesystem isn't
+// backed by a block device, e.g. NFS.
+func (v *Volume) Resolve(mounts []Mount) error {
+	mountPath := path.Clean(v.MountPath)
+
+	var found *Mount
+	for i := range mounts {
+		// the last mount hides the ones before
+		if mounts[i].MountPoint == mountPath {
+			found = &mounts[i]
+		}
+	}
+
+	if found == nil {
+		return errors.Errorf("nothing is mounted at: '%s'", mountPath)
+	}
+
+	if v.SubPath != "" && !strings.HasSuffix(found.Root, path.Clean("/"+v.SubPath)) {
+		log.Debug().
+			Msgf("mount at: '%s' has root: '%s' rather than subPath: '%s'", mountPath, found.Root, v.SubPath)
+	}
+
+	// major 0 is the one of the filesystems without a device
+	if found.Device.Major == 0 {
+		return errors.Errorf("its %s filesystem isn't backed by a block device", found.FsType)
+	}
+
+	v.Device = found.Device
+	return nil
+}
+
+// RootDevice returns the device of the root filesystem of a mountinfo.
+func RootDevice(mounts []Mount) (Device, bool) {
+	var device Device
+	found := false
+	for _, mount := range mounts {
+		if mount.MountPoint == "/" {
+			device = mount.Device
+			found = true
+		}
+	}
+
+	return device, found && device.Major != 0
+}
+
+// MarkShared marks the volumes sharing their disk with another volume, or
+// with one of the given devices of the node.
+func MarkShared(volumes []Volume, nodeDevices ...Device) {
+	users := map[Device]int{}
+	for _, volume := range volumes {
+		users[volume.Device]++
+	}
+	for _, device := range nodeDevices {
+		users[device]++
+	}
+
+	for i := range volumes {
+		volumes[i].Shared = users[volumes[i].Device] > 1
+	}
+}
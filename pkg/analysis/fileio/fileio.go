@@ -0,0 +1,379 @@
+// Package fileio measures the reads and writes of the regular files of a
+// container: their count, bytes and latency per file, or the slow ones.
+package fileio
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/analysis/syscalls"
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// Maps the program reports in, keyed by operation and path.
+const (
+	OpsMap     = "@ops"
+	BytesMap   = "@bytes"
+	TotalMap   = "@total_ns"
+	LatencyMap = "@latency_ns"
+)
+
+// Operations traced.
+const (
+	OpRead  = "read"
+	OpWrite = "write"
+)
+
+// PathSize bounds the length of the paths read.
+const PathSize = 200
+
+// Options controls the file I/O tracing.
+type Options struct {
+	Duration time.Duration
+	// Prefixes restrict the files traced to those under any of them.
+	Prefixes []string
+	// Slow prints the operations lasting at least as long as it, rather than
+	// summarizing every operation, when positive.
+	Slow time.Duration
+}
+
+// Program measures the reads and writes of regular files by the target
+// processes. The path is resolved by security_file_permission, called by
+// vfs_read and vfs_write before any I/O, as d_path is only allowed there.
+// d_path resolves it from the root of the process, the root of its container.
+func Program(target bpftrace.Target, options Options) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "kfunc:vfs_read,\nkfunc:vfs_write\n/%s/\n{\n\t@start[tid] = nsecs;\n}\n\n", target.Predicate())
+
+	// S_IFREG, and only the outermost file, overlayfs checking the
+	// permission of the files it's backed by too
+	b.WriteString("kfunc:security_file_permission\n")
+	b.WriteString("/@start[tid] && !@traced[tid] && (args->file->f_inode->i_mode & 0xf000) == 0x8000/\n{\n")
+	b.WriteString("\t$path = path(args->file->f_path);\n")
+	if len(options.Prefixes) > 0 {
+		fmt.Fprintf(&b, "\tif (%s) {\n\t\t@path[tid] = $path;\n\t\t@traced[tid] = 1;\n\t}\n}\n\n", prefixCondition("$path", options.Prefixes))
+	} else {
+		b.WriteString("\t@path[tid] = $path;\n\t@traced[tid] = 1;\n}\n\n")
+	}
+
+	for _, op := range []string{OpRead, OpWrite} {
+		fmt.Fprintf(&b, "kretfunc:vfs_%s\n/@start[tid]/\n{\n", op)
+		b.WriteString("\tif (@traced[tid]) {\n")
+		b.WriteString("\t\t$ns = nsecs - @start[tid];\n")
+		b.WriteString("\t\t$bytes = retval > 0 ? retval : 0;\n")
+
+		if options.Slow > 0 {
+			fmt.Fprintf(&b, "\t\tif ($ns >= %d) {\n", options.Slow.Nanoseconds())
+			fmt.Fprintf(&b, "\t\t\tprintf(\"%s pid=%%d ns=%%d bytes=%%d ret=%%d comm=%%s path=%%s\\n\", pid, $ns, $bytes, retval, comm, @path[tid]);\n", op)
+			b.WriteString("\t\t}\n")
+		} else {
+			fmt.Fprintf(&b, "\t\t%s[\"%s\", @path[tid]] = count();\n", OpsMap, op)
+			fmt.Fprintf(&b, "\t\t%s[\"%s\", @path[tid]] = sum($bytes);\n", BytesMap, op)
+			fmt.Fprintf(&b, "\t\t%s[\"%s\", @path[tid]] = sum($ns);\n", TotalMap, op)
+			fmt.Fprintf(&b, "\t\t%s[\"%s\", @path[tid]] = hist($ns);\n", LatencyMap, op)
+		}
+
+		b.WriteString("\t}\n\tdelete(@start[tid]);\n\tdelete(@path[tid]);\n\tdelete(@traced[tid]);\n}\n\n")
+	}
+
+	fmt.Fprintf(&b, "interval:s:%d {\n\tclear(@start);\n\tclear(@path);\n\tclear(@traced);\n\texit();\n}\n", bpftrace.Seconds(options.Duration))
+
+	return b.String()
+}
+
+// prefixCondition generates the condition of path being any of the prefixes
+// or under one of them, the prefixes being directories: "/data" matches
+// "/data/db" but not "/data2/db".
+func prefixCondition(path string, prefixes []string) string {
+	conditions := make([]string, 0, 2*len(prefixes))
+	for _, prefix := range prefixes {
+		dir := strings.TrimSuffix(prefix, "/")
+		if dir != "" {
+			conditions = append(conditions, fmt.Sprintf("%s == %s", path, strconv.Quote(dir)))
+		}
+		conditions = append(conditions, fmt.Sprintf("strncmp(%s, %s, %d) == 0", path, strconv.Quote(dir+"/"), len(dir)+1))
+	}
+
+	return strings.Join(conditions, " || ")
+}
+
+// Stats summarizes the reads or writes of a file, durations are in
+// nanoseconds.
+type Stats struct {
+	Ops   int64 `json:"ops"`
+	Bytes int64 `json:"bytes"`
+	Total int64 `json:"totalNs"`
+	P50   int64 `json:"p50Ns"`
+	P99   int64 `json:"p99Ns"`
+	Max   int64 `json:"maxNs"`
+}
+
+// File is the summary of the I/O of a file.
+type File struct {
+	Path  string `json:"path"`
+	Read  Stats  `json:"read"`
+	Write Stats  `json:"write"`
+}
+
+// Collector is an output.Printer summarizing the maps of the program.
+type Collector struct {
+	files map[string]*File
+}
+
+func NewCollector() *Collector {
+	return &Collector{files: map[string]*File{}}
+}
+
+// stats returns the stats of the operation and path of a map key.
+func (c *Collector) stats(key string) (*Stats, bool) {
+	fields := strings.SplitN(key, ",", 2)
+	if len(fields) != 2 {
+		return nil, false
+	}
+
+	path := strings.TrimSpace(fields[1])
+
+	file, ok := c.files[path]
+	if !ok {
+		file = &File{Path: path}
+		c.files[path] = file
+	}
+
+	switch strings.TrimSpace(fields[0]) {
+	case OpRead:
+		return &file.Read, true
+	case OpWrite:
+		return &file.Write, true
+	default:
+		return nil, false
+	}
+}
+
+func (c *Collector) Print(record bpftrace.Record) error {
+	switch record.Type {
+	case bpftrace.TypeMap, bpftrace.TypeHist:
+	case bpftrace.TypeText:
+		log.Warn().Msg(record.String())
+		return nil
+	default:
+		return nil
+	}
+
+	maps, err := record.Maps()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range maps {
+		for _, key := range m.Keys() {
+			stats, ok := c.stats(key)
+			if !ok {
+				continue
+			}
+
+			value := m.Entries[key]
+
+			switch m.Name {
+			case OpsMap:
+				stats.Ops, err = bpftrace.ParseInt(value)
+			case BytesMap:
+				stats.Bytes, err = bpftrace.ParseInt(value)
+			case TotalMap:
+				stats.Total, err = bpftrace.ParseInt(value)
+			case LatencyMap:
+				err = addLatency(stats, value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func addLatency(stats *Stats, value json.RawMessage) error {
+	buckets, err := bpftrace.ParseBuckets(value)
+	if err != nil {
+		return err
+	}
+
+	stats.P50 = bpftrace.Percentile(buckets, 50)
+	stats.P99 = bpftrace.Percentile(buckets, 99)
+	stats.Max = bpftrace.Percentile(buckets, 100)
+	return nil
+}
+
+func (c *Collector) Flush() error {
+	return nil
+}
+
+// Files returns the summary of every file read or written, the ones the most
+// time was spent on first.
+func (c *Collector) Files() []*File {
+	files := make([]*File, 0, len(c.files))
+	for _, file := range c.files {
+		files = append(files, file)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		ti, tj := files[i].Read.Total+files[i].Write.Total, files[j].Read.Total+files[j].Write.Total
+		if ti != tj {
+			return ti > tj
+		}
+		return files[i].Path < files[j].Path
+	})
+
+	return files
+}
+
+// Print writes the summary as a table, or as JSON for the json format.
+func Print(w io.Writer, format string, files []*File) error {
+	if format == output.FormatJson {
+		return json.NewEncoder(w).Encode(files)
+	}
+
+	rows := make([][]string, 0, len(files))
+	for _, f := range files {
+		rows = append(rows, []string{
+			f.Path,
+			strconv.FormatInt(f.Read.Ops, 10),
+			output.Size(f.Read.Bytes),
+			duration(f.Read.P50),
+			duration(f.Read.P99),
+			strconv.FormatInt(f.Write.Ops, 10),
+			output.Size(f.Write.Bytes),
+			duration(f.Write.P50),
+			duration(f.Write.P99),
+			duration(f.Read.Total + f.Write.Total),
+		})
+	}
+
+	return output.WriteTable(w, []string{"FILE", "READS", "READ", "READ P50", "READ P99",
+		"WRITES", "WRITTEN", "WRITE P50", "WRITE P99", "TOTAL"}, rows)
+}
+
+func duration(ns int64) string {
+	return time.Duration(ns).Round(time.Microsecond / 10).String()
+}
+
+// Event is a slow read or write, as printed by the program in slow mode.
+type Event struct {
+	Op       string `json:"op"`
+	Pid      int    `json:"pid"`
+	Comm     string `json:"comm"`
+	Path     string `json:"path"`
+	Bytes    int64  `json:"bytes"`
+	Duration int64  `json:"durationNs"`
+	// Errno is set when the operation failed.
+	Errno int64 `json:"errno,omitempty"`
+}
+
+// ParseEvent parses a line printed by the program in slow mode. The path
+// comes last as it may hold spaces.
+func ParseEvent(line string) (Event, error) {
+	line = strings.TrimSuffix(line, "\n")
+
+	i := strings.Index(line, " path=")
+	if i < 0 {
+		return Event{}, errors.Errorf("malformed file I/O event: '%s'", line)
+	}
+
+	event := Event{Path: line[i+len(" path="):]}
+
+	j := strings.Index(line[:i], " comm=")
+	if j < 0 {
+		return Event{}, errors.Errorf("malformed file I/O event: '%s'", line)
+	}
+	event.Comm = line[j+len(" comm=") : i]
+
+	fields := strings.Fields(line[:j])
+	if len(fields) == 0 {
+		return Event{}, errors.New("empty file I/O event")
+	}
+	event.Op = fields[0]
+
+	for _, field := range fields[1:] {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return Event{}, errors.Errorf("malformed file I/O event field: '%s'", field)
+		}
+
+		value, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return Event{}, errors.Wrapf(err, "malformed file I/O event field: '%s'", field)
+		}
+
+		switch parts[0] {
+		case "pid":
+			event.Pid = int(value)
+		case "ns":
+			event.Duration = value
+		case "bytes":
+			event.Bytes = value
+		case "ret":
+			if value < 0 {
+				event.Errno = -value
+			}
+		}
+	}
+
+	return event, nil
+}
+
+// String formats the event as a line of text output.
+func (e Event) String() string {
+	status := output.Size(e.Bytes)
+	if e.Errno != 0 {
+		status = syscalls.ErrnoName(strconv.FormatInt(e.Errno, 10))
+	}
+
+	return fmt.Sprintf("%-5s %10s %10s %-16s pid=%d %s", e.Op, duration(e.Duration), status, e.Comm, e.Pid, e.Path)
+}
+
+// EventPrinter is an output.Printer printing the slow operations reported
+// by the program in slow mode.
+type EventPrinter struct {
+	w      io.Writer
+	format string
+}
+
+func NewEventPrinter(w io.Writer, format string) *EventPrinter {
+	return &EventPrinter{w: w, format: format}
+}
+
+func (p *EventPrinter) Print(record bpftrace.Record) error {
+	switch record.Type {
+	case bpftrace.TypePrintf:
+	case bpftrace.TypeText, bpftrace.TypeLostEvents:
+		log.Warn().Msg(record.String())
+		return nil
+	default:
+		return nil
+	}
+
+	event, err := ParseEvent(record.String())
+	if err != nil {
+		return err
+	}
+
+	if p.format == output.FormatJson {
+		return json.NewEncoder(p.w).Encode(event)
+	}
+
+	_, err = fmt.Fprintln(p.w, event.String())
+	return err
+}
+
+func (p *EventPrinter) Flush() error {
+	return nil
+}
@@ -0,0 +1,101 @@
+package fileio
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgram(t *testing.T) {
+	// when
+	program := Program(bpftrace.Target{Pids: []string{"4123"}}, Options{Duration: 30 * time.Second, Prefixes: []string{"/data", "/var/lib/"}})
+
+	// then
+	assert.Contains(t, program, "kfunc:vfs_read,\nkfunc:vfs_write\n/pid == 4123/")
+	assert.Contains(t, program, `if ($path == "/data" || strncmp($path, "/data/", 6) == 0 || `+
+		`$path == "/var/lib" || strncmp($path, "/var/lib/", 9) == 0) {`)
+	assert.Contains(t, program, `@bytes["write", @path[tid]] = sum($bytes);`)
+	assert.Contains(t, program, `@latency_ns["read", @path[tid]] = hist($ns);`)
+	assert.NotContains(t, program, "printf")
+	assert.Contains(t, program, "interval:s:30 {")
+
+	// when
+	program = Program(bpftrace.Target{Pids: []string{"4123"}}, Options{Duration: time.Second, Prefixes: []string{"/"}})
+
+	// then
+	assert.Contains(t, program, `if (strncmp($path, "/", 1) == 0) {`)
+
+	// when
+	program = Program(bpftrace.Target{Pids: []string{"4123"}}, Options{Duration: time.Second, Slow: 20 * time.Millisecond})
+
+	// then
+	assert.NotContains(t, program, "strncmp")
+	assert.Contains(t, program, "\t@path[tid] = $path;\n")
+	assert.Contains(t, program, "if ($ns >= 20000000) {")
+	assert.Contains(t, program, `printf("read pid=%d ns=%d bytes=%d ret=%d comm=%s path=%s\n"`)
+	assert.NotContains(t, program, "@ops")
+}
+
+func TestCollector(t *testing.T) {
+	// given
+	collector := NewCollector()
+	records := []string{
+		`{"type": "map", "data": {"@ops": {"read, /data/db.sqlite": 120, "write, /data/db.sqlite": 30, "read, /etc/hosts": 2}}}`,
+		`{"type": "map", "data": {"@bytes": {"read, /data/db.sqlite": 491520, "write, /data/db.sqlite": 122880, "read, /etc/hosts": 300}}}`,
+		`{"type": "map", "data": {"@total_ns": {"read, /data/db.sqlite": 1200000, "write, /data/db.sqlite": 9000000, "read, /etc/hosts": 4000}}}`,
+		`{"type": "hist", "data": {"@latency_ns": {"read, /data/db.sqlite": [{"min": 8192, "max": 16383, "count": 120}], "write, /data/db.sqlite": [{"min": 262144, "max": 524287, "count": 30}]}}}`,
+	}
+	for _, record := range records {
+		assert.NoError(t, collector.Print(bpftrace.ParseRecord([]byte(record))))
+	}
+
+	// when
+	files := collector.Files()
+
+	// then
+	assert.Len(t, files, 2)
+	assert.Equal(t, "/data/db.sqlite", files[0].Path)
+	assert.Equal(t, Stats{Ops: 30, Bytes: 122880, Total: 9000000, P50: 393215, P99: 521665, Max: 524287}, files[0].Write)
+	assert.Equal(t, int64(120), files[0].Read.Ops)
+	assert.Equal(t, "/etc/hosts", files[1].Path)
+
+	// when
+	var buf bytes.Buffer
+	err := Print(&buf, output.FormatText, files)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, ""+
+		"FILE             READS  READ      READ P50  READ P99  WRITES  WRITTEN   WRITE P50  WRITE P99  TOTAL\n"+
+		"/data/db.sqlite  120    480.0KiB  12.3µs    16.3µs    30      120.0KiB  393.2µs    521.7µs    10.2ms\n"+
+		"/etc/hosts       2      300B      0s        0s        0       0B        0s         0s         4µs\n",
+		buf.String())
+}
+
+func TestParseEvent(t *testing.T) {
+	// when
+	event, err := ParseEvent("write pid=4123 ns=25000000 bytes=4096 ret=4096 comm=postgres path=/data/pg wal/000001\n")
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, Event{Op: OpWrite, Pid: 4123, Comm: "postgres", Path: "/data/pg wal/000001", Bytes: 4096, Duration: 25000000}, event)
+	assert.Equal(t, "write       25ms     4.0KiB postgres         pid=4123 /data/pg wal/000001", event.String())
+
+	// when
+	event, err = ParseEvent("read pid=7 ns=30000000 bytes=0 ret=-5 comm=app path=/data/x")
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), event.Errno)
+	assert.Contains(t, event.String(), "EIO")
+
+	// when
+	_, err = ParseEvent("read pid=7")
+
+	// then
+	assert.EqualError(t, err, "malformed file I/O event: 'read pid=7'")
+}
@@ -0,0 +1,95 @@
+package funcs
+
+import (
+	"debug/elf"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+)
+
+// Maps the program reports in, keyed by function name.
+const (
+	CallsMap   = "@calls"
+	LatencyMap = "@latency_us"
+)
+
+// ThreadKey keys in-flight calls by thread, which is only right for code that
+// doesn't migrate between threads mid-call.
+const ThreadKey = "tid"
+
+// Options controls the function tracing.
+type Options struct {
+	Duration time.Duration
+}
+
+// Probe is a function to measure, addresses are link time virtual addresses.
+type Probe struct {
+	Name  string
+	Entry uint64
+	// Returns are the addresses of the function's return instructions, a
+	// uretprobe is used when empty.
+	Returns []uint64
+}
+
+// GoroutineKey returns the expression identifying the running goroutine, the
+// register holding the current g with Go's register ABI (Go 1.17 and later).
+// Goroutines move between threads, so calls are keyed by goroutine instead.
+func GoroutineKey(machine elf.Machine) string {
+	switch machine {
+	case elf.EM_X86_64:
+		return `reg("r14")`
+	case elf.EM_AARCH64:
+		return `reg("r28")`
+	default:
+		return ThreadKey
+	}
+}
+
+// Program counts the calls of the given functions of binaryPath made by the
+// target processes and their latency in microseconds, until the configured
+// duration elapsed. key identifies the caller between entry and return.
+func Program(binaryPath string, target bpftrace.Target, probes []Probe, key string, options Options) string {
+	var b strings.Builder
+
+	for i, probe := range probes {
+		name := quote(probe.Name)
+		start := fmt.Sprintf("@start_%d[%s]", i, key)
+
+		var returns []string
+		if len(probe.Returns) == 0 {
+			returns = append(returns, fmt.Sprintf("uretprobe:%s:0x%x", binaryPath, probe.Entry))
+		}
+		for _, address := range probe.Returns {
+			returns = append(returns, fmt.Sprintf("uprobe:%s:0x%x", binaryPath, address))
+		}
+
+		fmt.Fprintf(&b, `// %[1]s
+uprobe:%[2]s:0x%[3]x /%[4]s/ {
+	%[5]s = nsecs;
+	%[6]s[%[7]s] = count();
+}
+
+%[8]s /%[5]s/ {
+	%[9]s[%[7]s] = hist((nsecs - %[5]s) / 1000);
+	delete(%[5]s);
+}
+
+`, probe.Name, binaryPath, probe.Entry, target.Predicate(), start, CallsMap, name,
+			strings.Join(returns, ",\n"), LatencyMap)
+	}
+
+	b.WriteString(fmt.Sprintf("interval:s:%d {\n", bpftrace.Seconds(options.Duration)))
+	for i := range probes {
+		b.WriteString(fmt.Sprintf("\tclear(@start_%d);\n", i))
+	}
+	b.WriteString("\texit();\n}\n")
+
+	return b.String()
+}
+
+// quote returns name as a bpftrace string literal.
+func quote(name string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+}
@@ -0,0 +1,49 @@
+package funcs
+
+import (
+	"debug/elf"
+	"testing"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgram_Go(t *testing.T) {
+	// given
+	probes := []Probe{{Name: "main.(*Server).handle", Entry: 0x4a5c00, Returns: []uint64{0x4a5c21, 0x4a5c80}}}
+
+	// when
+	program := Program("/host/proc/42/root/app/server", bpftrace.Target{Pids: []string{"42"}}, probes, GoroutineKey(elf.EM_X86_64), Options{Duration: time.Minute})
+
+	// then
+	assert.Equal(t, `// main.(*Server).handle
+uprobe:/host/proc/42/root/app/server:0x4a5c00 /pid == 42/ {
+	@start_0[reg("r14")] = nsecs;
+	@calls["main.(*Server).handle"] = count();
+}
+
+uprobe:/host/proc/42/root/app/server:0x4a5c21,
+uprobe:/host/proc/42/root/app/server:0x4a5c80 /@start_0[reg("r14")]/ {
+	@latency_us["main.(*Server).handle"] = hist((nsecs - @start_0[reg("r14")]) / 1000);
+	delete(@start_0[reg("r14")]);
+}
+
+interval:s:60 {
+	clear(@start_0);
+	exit();
+}
+`, program)
+}
+
+func TestProgram_Uretprobe(t *testing.T) {
+	// given
+	probes := []Probe{{Name: `say"hi"`, Entry: 0x1000}}
+
+	// when
+	program := Program("/host/proc/42/root/bin/app", bpftrace.Target{Pids: []string{"42"}}, probes, ThreadKey, Options{Duration: time.Second})
+
+	// then
+	assert.Contains(t, program, "uretprobe:/host/proc/42/root/bin/app:0x1000 /@start_0[tid]/ {")
+	assert.Contains(t, program, `@calls["say\"hi\""] = count();`)
+}
@@ -0,0 +1,246 @@
+package memleak
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/stack"
+	"github.com/alam0rt/kubectl-doktor/pkg/symbolize"
+	"github.com/google/pprof/profile"
+)
+
+// Maps the program reports outstanding allocations in, both keyed by
+// [pid, ustack(perf)] of the allocation site.
+const (
+	BytesMap       = "@leaked_bytes"
+	AllocationsMap = "@leaked_allocs"
+)
+
+// allocatorPattern matches the C libraries implementing malloc, glibc and musl.
+var allocatorPattern = regexp.MustCompile(`^(libc([.-].*)?\.so.*|ld-musl-.*\.so.*)$`)
+
+// Options controls the allocation tracing.
+type Options struct {
+	Duration time.Duration
+	// Library is the allocator to trace, a path in the container. The C
+	// library mapped by the target processes is used when empty.
+	Library string
+}
+
+// Allocators returns the allocator libraries mapped by pid, as paths of its
+// root filesystem reachable from the privileged pod. Uprobes attach to files
+// so a library is only returned once, for the first process mapping it.
+func Allocators(pid string, mappings []symbolize.Mapping, library string, seen map[string]bool) []string {
+	var paths []string
+
+	for _, mapping := range mappings {
+		if mapping.Deleted() || seen[mapping.Path] {
+			continue
+		}
+
+		if library != "" && mapping.Path != library {
+			continue
+		}
+		if library == "" && !allocatorPattern.MatchString(path.Base(mapping.Path)) {
+			continue
+		}
+
+		seen[mapping.Path] = true
+		paths = append(paths, symbolize.RootPath(pid, mapping.Path))
+	}
+
+	return paths
+}
+
+// Program tracks the allocations of the target processes made through the
+// allocator libraries, and the frees releasing them, reporting the bytes and
+// allocations still outstanding per allocation site once the window ends.
+// Calls made from within another traced call, such as glibc's realloc calling
+// malloc, are only accounted for once.
+func Program(target bpftrace.Target, libraries []string, options Options) string {
+	predicate := "(" + target.Predicate() + ")"
+
+	var b strings.Builder
+	for _, library := range libraries {
+		fmt.Fprintf(&b, `uprobe:%[1]s:malloc /%[2]s && !@inflight[tid]/ { @inflight[tid] = 1; @size[tid] = arg0; }
+uprobe:%[1]s:calloc /%[2]s && !@inflight[tid]/ { @inflight[tid] = 2; @size[tid] = arg0 * arg1; }
+uprobe:%[1]s:realloc /%[2]s && !@inflight[tid]/ { @inflight[tid] = 3; @size[tid] = arg1; @old[tid] = arg0; }
+uprobe:%[1]s:mmap /%[2]s && !@inflight[tid]/ { @inflight[tid] = 4; @size[tid] = arg1; }
+
+uretprobe:%[1]s:malloc /@inflight[tid] == 1/ {
+%[3]s}
+
+uretprobe:%[1]s:calloc /@inflight[tid] == 2/ {
+%[3]s}
+
+uretprobe:%[1]s:realloc /@inflight[tid] == 3/ {
+%[4]s%[3]s	delete(@old[tid]);
+}
+
+uretprobe:%[1]s:mmap /@inflight[tid] == 4/ {
+%[3]s}
+
+uprobe:%[1]s:free /%[2]s/ {
+%[5]s}
+
+uprobe:%[1]s:munmap /%[2]s/ {
+%[5]s}
+
+`, library, predicate, allocate, release("@old[tid]"), release("arg0"))
+	}
+
+	fmt.Fprintf(&b, `interval:s:%d {
+	clear(@inflight);
+	clear(@size);
+	clear(@old);
+	clear(@addr_size);
+	clear(@addr_pid);
+	clear(@addr_stack);
+	exit();
+}
+`, bpftrace.Seconds(options.Duration))
+
+	return b.String()
+}
+
+// allocate records the allocation returned by the probed function, mmap
+// returns MAP_FAILED rather than NULL on failure.
+var allocate = fmt.Sprintf(`	if (retval != 0 && (int64)retval != -1) {
+		@addr_size[retval] = @size[tid];
+		@addr_pid[retval] = pid;
+		@addr_stack[retval] = ustack(perf);
+		%[1]s[pid, ustack(perf)] += @size[tid];
+		%[2]s[pid, ustack(perf)] += 1;
+		%[3]s[pid] = comm;
+	}
+	delete(@inflight[tid]);
+	delete(@size[tid]);
+`, BytesMap, AllocationsMap, stack.CommMap)
+
+// release forgets the allocation at address, if it was recorded.
+func release(address string) string {
+	return fmt.Sprintf(`	$size = @addr_size[%[1]s];
+	if ($size) {
+		%[2]s[@addr_pid[%[1]s], @addr_stack[%[1]s]] -= $size;
+		%[3]s[@addr_pid[%[1]s], @addr_stack[%[1]s]] -= 1;
+		delete(@addr_size[%[1]s]);
+		delete(@addr_pid[%[1]s]);
+		delete(@addr_stack[%[1]s]);
+	}
+`, address, BytesMap, AllocationsMap)
+}
+
+// Site is an allocation site with memory still outstanding.
+type Site struct {
+	Pid         string
+	Comm        string
+	Bytes       int64
+	Allocations int64
+	// Frames are ordered leaf first.
+	Frames []stack.Frame
+}
+
+// Sites joins the outstanding bytes and allocations per allocation site,
+// leaving out sites whose allocations were all freed, largest first.
+func Sites(bytes []stack.Sample, allocations []stack.Sample) []Site {
+	counts := map[string]int64{}
+	for _, sample := range allocations {
+		counts[siteKey(sample)] += sample.Values[0]
+	}
+
+	var sites []Site
+	for _, sample := range bytes {
+		if sample.Values[0] <= 0 {
+			continue
+		}
+
+		sites = append(sites, Site{
+			Pid:         sample.Pid,
+			Comm:        sample.Comm,
+			Bytes:       sample.Values[0],
+			Allocations: counts[siteKey(sample)],
+			Frames:      sample.Frames,
+		})
+	}
+
+	sort.SliceStable(sites, func(i, j int) bool {
+		return sites[i].Bytes > sites[j].Bytes
+	})
+
+	return sites
+}
+
+func siteKey(sample stack.Sample) string {
+	addresses := make([]string, 0, len(sample.Frames)+1)
+	addresses = append(addresses, sample.Pid)
+	for _, frame := range sample.Frames {
+		addresses = append(addresses, strconv.FormatUint(frame.Address, 16))
+	}
+
+	return strings.Join(addresses, ",")
+}
+
+// Samples converts the sites back to stack samples valued by outstanding
+// bytes and allocations, to write them as folded stacks or profiles.
+func Samples(sites []Site) []stack.Sample {
+	samples := make([]stack.Sample, 0, len(sites))
+	for _, site := range sites {
+		samples = append(samples, stack.Sample{
+			Pid:    site.Pid,
+			Comm:   site.Comm,
+			Frames: site.Frames,
+			Values: []int64{site.Bytes, site.Allocations},
+		})
+	}
+
+	return samples
+}
+
+// Build converts the outstanding allocations into a profile that opens in
+// go tool pprof, the way Go reports its in-use heap.
+func Build(sites []Site, options Options, start time.Time) *profile.Profile {
+	return stack.BuildProfile(Samples(sites), stack.ProfileOptions{
+		SampleTypes: []*profile.ValueType{
+			{Type: "inuse_space", Unit: "bytes"},
+			{Type: "inuse_objects", Unit: "count"},
+		},
+		PeriodType: &profile.ValueType{Type: "space", Unit: "bytes"},
+		Period:     1,
+		Start:      start,
+		Duration:   options.Duration,
+	})
+}
+
+// PrintTop prints the top allocation sites by outstanding bytes, leaf frame
+// first.
+func PrintTop(w io.Writer, sites []Site, top int) error {
+	if top > 0 && len(sites) > top {
+		sites = sites[:top]
+	}
+
+	for _, site := range sites {
+		if _, err := fmt.Fprintf(w, "%d bytes in %d allocations from %s (%s)\n",
+			site.Bytes, site.Allocations, site.Comm, site.Pid); err != nil {
+			return err
+		}
+
+		for _, frame := range site.Frames {
+			if _, err := fmt.Fprintf(w, "\t%s\n", frame.Name()); err != nil {
+				return err
+			}
+		}
+
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
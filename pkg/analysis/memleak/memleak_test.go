@@ -0,0 +1,70 @@
+package memleak
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/stack"
+	"github.com/alam0rt/kubectl-doktor/pkg/symbolize"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllocators(t *testing.T) {
+	// given
+	mappings := []symbolize.Mapping{
+		{Path: "/app/server"},
+		{Path: "/usr/lib/x86_64-linux-gnu/libcrypto.so.3"},
+		{Path: "/usr/lib/x86_64-linux-gnu/libc.so.6"},
+		{Path: "/lib/ld-musl-x86_64.so.1"},
+	}
+	seen := map[string]bool{}
+
+	// when
+	first := Allocators("42", mappings, "", seen)
+	second := Allocators("43", mappings, "", seen)
+	library := Allocators("42", mappings, "/app/server", map[string]bool{})
+
+	// then
+	assert.Equal(t, []string{
+		"/host/proc/42/root/usr/lib/x86_64-linux-gnu/libc.so.6",
+		"/host/proc/42/root/lib/ld-musl-x86_64.so.1",
+	}, first)
+	assert.Empty(t, second)
+	assert.Equal(t, []string{"/host/proc/42/root/app/server"}, library)
+}
+
+func TestProgram(t *testing.T) {
+	// when
+	program := Program(bpftrace.Target{Pids: []string{"42"}}, []string{"/host/proc/42/root/libc.so.6"}, Options{Duration: time.Minute})
+
+	// then
+	assert.Contains(t, program, "uprobe:/host/proc/42/root/libc.so.6:malloc /(pid == 42) && !@inflight[tid]/")
+	assert.Contains(t, program, "uretprobe:/host/proc/42/root/libc.so.6:realloc /@inflight[tid] == 3/ {\n\t$size = @addr_size[@old[tid]];")
+	assert.Contains(t, program, "@leaked_bytes[pid, ustack(perf)] += @size[tid];")
+	assert.Contains(t, program, "interval:s:60 {")
+}
+
+func TestSites(t *testing.T) {
+	// given
+	record := func(name string, leaked, freed int) bpftrace.Record {
+		return bpftrace.ParseRecord([]byte(fmt.Sprintf(`{"type": "map", "data": {"%s": {`+
+			`"42, \n\t4a5c21 main.cache+5 (/app/server)\n": %d, `+
+			`"42, \n\t4a6000 main.request+9 (/app/server)\n": %d}}}`, name, leaked, freed)))
+	}
+	bytesCollector := stack.NewCollector(BytesMap)
+	allocationsCollector := stack.NewCollector(AllocationsMap)
+	assert.NoError(t, bytesCollector.Print(record(BytesMap, 8, 0)))
+	assert.NoError(t, allocationsCollector.Print(record(AllocationsMap, 2, 0)))
+
+	// when
+	sites := Sites(bytesCollector.Samples(), allocationsCollector.Samples())
+	var buf bytes.Buffer
+	err := PrintTop(&buf, sites, 0)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "8 bytes in 2 allocations from  (42)\n\tmain.cache\n\n", buf.String())
+}
@@ -0,0 +1,619 @@
+package network
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	dnsPort = 53
+
+	// dnsHeaderSize is the size of the fixed DNS message header.
+	dnsHeaderSize = 12
+
+	// DnsCaptureSize bounds the bytes of a DNS message printed by DnsProgram,
+	// enough for the header and the question of names of 180 characters.
+	DnsCaptureSize = 200
+
+	ipprotoUdp = 17
+)
+
+// DNS packet directions, as printed by DnsProgram.
+const (
+	dnsQuery    = "query"
+	dnsResponse = "response"
+)
+
+var dnsTypes = map[uint16]string{
+	1: "A", 2: "NS", 5: "CNAME", 6: "SOA", 12: "PTR", 15: "MX", 16: "TXT",
+	28: "AAAA", 33: "SRV", 35: "NAPTR", 64: "SVCB", 65: "HTTPS", 255: "ANY",
+}
+
+var dnsRcodes = []string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED"}
+
+// RcodeUnanswered is the response code of lookups no response was seen for.
+const RcodeUnanswered = "unanswered"
+
+type DnsOptions struct {
+	Duration time.Duration
+	// Slow is the latency from which lookups are reported as slow.
+	Slow time.Duration
+}
+
+// DnsProgram generates a program printing the DNS queries sent and the
+// responses received over UDP and TCP port 53 by the network namespace
+// netns. Packets are parsed from the headers of the socket buffers of its
+// devices, queries when transmitted and responses when received, so they
+// are seen whatever resolver library, e.g. glibc, musl or Go's, sent them.
+func DnsProgram(netns string, options DnsOptions) string {
+	var b strings.Builder
+
+	directions := []struct {
+		packet     string
+		tracepoint string
+		ip         string
+		port       string
+	}{
+		// the device transmitting a packet may not have set skb->data to the
+		// network header, a received one points past the link layer header
+		{dnsQuery, "net:net_dev_start_xmit", "$skb->head + $skb->network_header", "$dport"},
+		{dnsResponse, "net:netif_receive_skb", "$skb->data", "$sport"},
+	}
+
+	for _, direction := range directions {
+		for _, version := range []int{4, 6} {
+			for _, protocol := range []int{ipprotoUdp, ipprotoTcp} {
+				writeDnsProbe(&b, netns, direction.packet, direction.tracepoint, direction.ip, direction.port, version, protocol)
+			}
+		}
+	}
+
+	fmt.Fprintf(&b, "interval:s:%d {\n\texit();\n}\n", bpftrace.Seconds(options.Duration))
+
+	return b.String()
+}
+
+// writeDnsProbe generates the probe of the packets of one direction, IP
+// version and transport protocol, each parsed at fixed offsets.
+func writeDnsProbe(b *strings.Builder, netns string, packet string, tracepoint string, ip string, port string,
+	version int, protocol int) {
+
+	skb := "((struct sk_buff *)args->skbaddr)"
+	predicateIp := strings.ReplaceAll(ip, "$skb", skb)
+
+	protocolOffset, header, protocolName := 9, "iphdr", "udp"
+	if version == 6 {
+		protocolOffset, header = 6, "ipv6hdr"
+	}
+	if protocol == ipprotoTcp {
+		protocolName = "tcp"
+	}
+
+	fmt.Fprintf(b, "tracepoint:%s\n/%s->dev->nd_net.net->ns.inum == %s && (*(%s) >> 4) == %d && *(%s + %d) == %d/\n{\n",
+		tracepoint, skb, netns, predicateIp, version, predicateIp, protocolOffset, protocol)
+	b.WriteString("\t$skb = (struct sk_buff *)args->skbaddr;\n")
+	fmt.Fprintf(b, "\t$ip = %s;\n", ip)
+
+	// the length of the transport header and payload
+	if version == 4 {
+		b.WriteString("\t$l4 = $ip + (*$ip & 0xf) * 4;\n")
+		b.WriteString("\t$l4len = ((((uint64)*($ip + 2)) << 8) | *($ip + 3)) - (*$ip & 0xf) * 4;\n")
+	} else {
+		b.WriteString("\t$l4 = $ip + 40;\n")
+		b.WriteString("\t$l4len = (((uint64)*($ip + 4)) << 8) | *($ip + 5);\n")
+	}
+
+	b.WriteString("\t$sport = (((uint64)*$l4) << 8) | *($l4 + 1);\n")
+	b.WriteString("\t$dport = (((uint64)*($l4 + 2)) << 8) | *($l4 + 3);\n")
+
+	// DNS over TCP prefixes messages with their length
+	if protocol == ipprotoTcp {
+		b.WriteString("\t$offset = (*($l4 + 12) >> 4) * 4 + 2;\n")
+	} else {
+		b.WriteString("\t$offset = 8;\n")
+	}
+	b.WriteString("\t$dns = $l4 + $offset;\n")
+	b.WriteString("\t$len = $l4len - $offset;\n")
+
+	fmt.Fprintf(b, "\tif (%s == %d && $l4len >= $offset + %d) {\n", port, dnsPort, dnsHeaderSize)
+	fmt.Fprintf(b, "\t\tif ($len > %d) {\n\t\t\t$len = %d;\n\t\t}\n", DnsCaptureSize, DnsCaptureSize)
+	fmt.Fprintf(b, "\t\tprintf(\"%s proto=%s saddr=%%s sport=%%d daddr=%%s dport=%%d ts=%%d pid=%%d data=%%r comm=%%s\\n\",\n", packet, protocolName)
+	fmt.Fprintf(b, "\t\t\tntop(((struct %s *)$ip)->saddr%s), $sport, ntop(((struct %s *)$ip)->daddr%s), $dport, nsecs, pid, buf($dns, $len), comm);\n",
+		header, v6Bytes(version), header, v6Bytes(version))
+	b.WriteString("\t}\n}\n\n")
+}
+
+func v6Bytes(version int) string {
+	if version == 6 {
+		return ".in6_u.u6_addr8"
+	}
+
+	return ""
+}
+
+// DnsPacket is a DNS message printed by DnsProgram.
+type DnsPacket struct {
+	Direction   string
+	Protocol    string
+	Source      Address
+	Destination Address
+	// Timestamp is the monotonic time the packet was seen at, in nanoseconds.
+	Timestamp int64
+	Pid       int
+	Comm      string
+	Data      []byte
+}
+
+// ParseDnsPacket parses a line printed by DnsProgram.
+func ParseDnsPacket(line string) (DnsPacket, error) {
+	line = strings.TrimSuffix(line, "\n")
+
+	var packet DnsPacket
+
+	if i := strings.LastIndex(line, " comm="); i >= 0 {
+		line, packet.Comm = line[:i], line[i+len(" comm="):]
+	}
+
+	i := strings.Index(line, " data=")
+	if i < 0 {
+		return DnsPacket{}, errors.Errorf("malformed DNS packet: '%s'", line)
+	}
+	line, packet.Data = line[:i], unescapeBuf(line[i+len(" data="):])
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return DnsPacket{}, errors.New("empty DNS packet")
+	}
+
+	packet.Direction = fields[0]
+
+	for _, field := range fields[1:] {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return DnsPacket{}, errors.Errorf("malformed DNS packet field: '%s'", field)
+		}
+
+		key, value := parts[0], parts[1]
+
+		switch key {
+		case "proto":
+			packet.Protocol = value
+		case "saddr":
+			packet.Source.IP = value
+		case "daddr":
+			packet.Destination.IP = value
+		default:
+			number, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return DnsPacket{}, errors.Wrapf(err, "malformed DNS packet field: '%s'", field)
+			}
+
+			switch key {
+			case "sport":
+				packet.Source.Port = int(number)
+			case "dport":
+				packet.Destination.Port = int(number)
+			case "ts":
+				packet.Timestamp = number
+			case "pid":
+				packet.Pid = int(number)
+			}
+		}
+	}
+
+	return packet, nil
+}
+
+// unescapeBuf decodes a buffer printed with %r, which escapes the bytes that
+// aren't printable as \xNN.
+func unescapeBuf(s string) []byte {
+	data := make([]byte, 0, len(s))
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if value, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				data = append(data, byte(value))
+				i += 3
+				continue
+			}
+		}
+
+		data = append(data, s[i])
+	}
+
+	return data
+}
+
+// DnsMessage is the header and first question of a DNS message.
+type DnsMessage struct {
+	Id       uint16
+	Response bool
+	Rcode    string
+	Name     string
+	Type     string
+}
+
+// ParseDnsMessage parses the header and the first question of a DNS message,
+// which may be truncated after the question.
+func ParseDnsMessage(data []byte) (DnsMessage, error) {
+	if len(data) < dnsHeaderSize {
+		return DnsMessage{}, errors.New("DNS message shorter than its header")
+	}
+
+	flags := binary.BigEndian.Uint16(data[2:4])
+
+	message := DnsMessage{
+		Id:       binary.BigEndian.Uint16(data[0:2]),
+		Response: flags&0x8000 != 0,
+		Rcode:    rcodeName(int(flags & 0xf)),
+	}
+
+	if binary.BigEndian.Uint16(data[4:6]) == 0 {
+		return message, nil
+	}
+
+	var labels []string
+
+	offset := dnsHeaderSize
+	for {
+		if offset >= len(data) {
+			return message, errors.New("truncated DNS question")
+		}
+
+		length := int(data[offset])
+		offset++
+
+		if length == 0 {
+			break
+		}
+
+		// questions aren't expected to be compressed
+		if length&0xc0 != 0 || offset+length > len(data) {
+			return message, errors.New("malformed DNS question")
+		}
+
+		labels = append(labels, string(data[offset:offset+length]))
+		offset += length
+	}
+
+	message.Name = strings.Join(labels, ".") + "."
+
+	if offset+2 <= len(data) {
+		qtype := binary.BigEndian.Uint16(data[offset : offset+2])
+		if name, ok := dnsTypes[qtype]; ok {
+			message.Type = name
+		} else {
+			message.Type = fmt.Sprintf("TYPE%d", qtype)
+		}
+	}
+
+	return message, nil
+}
+
+func rcodeName(rcode int) string {
+	if rcode < len(dnsRcodes) {
+		return dnsRcodes[rcode]
+	}
+
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// Lookup is a DNS query and its response, if any. Queries retried with the
+// same id, e.g. after a timeout, count as one lookup with several attempts,
+// timed from the first one.
+type Lookup struct {
+	Name     string  `json:"name"`
+	Type     string  `json:"type"`
+	Rcode    string  `json:"rcode"`
+	Latency  int64   `json:"latencyUs,omitempty"`
+	Attempts int     `json:"attempts"`
+	Protocol string  `json:"protocol"`
+	Client   Address `json:"client"`
+	Server   Address `json:"server"`
+	Pid      int     `json:"pid,omitempty"`
+	Comm     string  `json:"comm,omitempty"`
+
+	timestamp int64
+}
+
+// Answered reports whether a response was seen for the lookup.
+func (l *Lookup) Answered() bool {
+	return l.Rcode != RcodeUnanswered
+}
+
+// Failed reports whether the lookup wasn't answered or not successfully.
+func (l *Lookup) Failed() bool {
+	return l.Rcode != dnsRcodes[0]
+}
+
+// String formats the lookup as a line of text output.
+func (l *Lookup) String() string {
+	latency := "-"
+	if l.Answered() {
+		latency = microseconds(l.Latency)
+	}
+
+	line := fmt.Sprintf("%-10s %-6s %s %s server=%s", l.Rcode, l.Type, l.Name, latency, l.Server)
+	if l.Attempts > 1 {
+		line += fmt.Sprintf(" attempts=%d", l.Attempts)
+	}
+	if l.Pid != 0 {
+		line += fmt.Sprintf(" pid=%d comm=%s", l.Pid, l.Comm)
+	}
+
+	return line
+}
+
+// DnsCollector is an output.Printer matching the queries and responses
+// printed by DnsProgram into lookups, printed as they complete, followed by
+// the lookups still unanswered and a summary of the slow and failed ones
+// once tracing ends.
+type DnsCollector struct {
+	w       io.Writer
+	format  string
+	slow    time.Duration
+	resolve ResolveFunc
+	pending map[string]*Lookup
+	// order keeps the pending lookups in the order they were sent
+	order   []string
+	lookups []*Lookup
+}
+
+func NewDnsCollector(w io.Writer, format string, slow time.Duration, resolve ResolveFunc) *DnsCollector {
+	return &DnsCollector{w: w, format: format, slow: slow, resolve: resolve, pending: map[string]*Lookup{}}
+}
+
+func (c *DnsCollector) Print(record bpftrace.Record) error {
+	switch record.Type {
+	case bpftrace.TypePrintf:
+	case bpftrace.TypeText, bpftrace.TypeLostEvents:
+		log.Warn().Msg(record.String())
+		return nil
+	default:
+		return nil
+	}
+
+	packet, err := ParseDnsPacket(record.String())
+	if err != nil {
+		return err
+	}
+
+	message, err := ParseDnsMessage(packet.Data)
+	if err != nil {
+		log.Debug().
+			Err(err).
+			Msgf("ignoring DNS packet from: '%s'", packet.Source)
+		return nil
+	}
+
+	switch {
+	case packet.Direction == dnsQuery && !message.Response:
+		c.query(packet, message)
+	case packet.Direction == dnsResponse && message.Response:
+		return c.response(packet, message)
+	}
+
+	return nil
+}
+
+func lookupKey(protocol string, client Address, id uint16) string {
+	return fmt.Sprintf("%s|%s|%d", protocol, client, id)
+}
+
+func (c *DnsCollector) query(packet DnsPacket, message DnsMessage) {
+	key := lookupKey(packet.Protocol, packet.Source, message.Id)
+
+	if lookup, ok := c.pending[key]; ok {
+		lookup.Attempts++
+		return
+	}
+
+	c.pending[key] = &Lookup{
+		Name:      message.Name,
+		Type:      message.Type,
+		Rcode:     RcodeUnanswered,
+		Attempts:  1,
+		Protocol:  packet.Protocol,
+		Client:    packet.Source,
+		Server:    c.name(packet.Destination),
+		Pid:       packet.Pid,
+		Comm:      packet.Comm,
+		timestamp: packet.Timestamp,
+	}
+	c.order = append(c.order, key)
+}
+
+func (c *DnsCollector) response(packet DnsPacket, message DnsMessage) error {
+	key := lookupKey(packet.Protocol, packet.Destination, message.Id)
+
+	lookup, ok := c.pending[key]
+	if !ok {
+		return nil
+	}
+	delete(c.pending, key)
+
+	lookup.Rcode = message.Rcode
+	lookup.Latency = (packet.Timestamp - lookup.timestamp) / int64(time.Microsecond)
+	// the resolver answering may not be the one queried, e.g. behind NAT
+	lookup.Server = c.name(packet.Source)
+
+	return c.print(lookup)
+}
+
+func (c *DnsCollector) name(address Address) Address {
+	if c.resolve != nil {
+		address.Name, _ = c.resolve(address.IP)
+	}
+
+	return address
+}
+
+func (c *DnsCollector) print(lookup *Lookup) error {
+	c.lookups = append(c.lookups, lookup)
+
+	if c.format == output.FormatJson {
+		return json.NewEncoder(c.w).Encode(lookup)
+	}
+
+	_, err := fmt.Fprintln(c.w, lookup.String())
+	return err
+}
+
+func (c *DnsCollector) Flush() error {
+	for _, key := range c.order {
+		if lookup, ok := c.pending[key]; ok {
+			if err := c.print(lookup); err != nil {
+				return err
+			}
+		}
+	}
+
+	summary := c.Summary()
+
+	if c.format == output.FormatJson {
+		return json.NewEncoder(c.w).Encode(map[string]interface{}{"summary": summary})
+	}
+
+	if _, err := fmt.Fprintln(c.w); err != nil {
+		return err
+	}
+
+	if len(summary) == 0 {
+		_, err := fmt.Fprintf(c.w, "no slow or failed lookups out of %d\n", len(c.lookups))
+		return err
+	}
+
+	return PrintDnsSummary(c.w, summary)
+}
+
+// DnsSummary aggregates the lookups of a name and type, P50 and Max are the
+// latencies of the answered ones.
+type DnsSummary struct {
+	Name    string         `json:"name"`
+	Type    string         `json:"type"`
+	Lookups int            `json:"lookups"`
+	Failed  int            `json:"failed"`
+	Slow    int            `json:"slow"`
+	P50     int64          `json:"p50Us"`
+	Max     int64          `json:"maxUs"`
+	Rcodes  map[string]int `json:"rcodes"`
+	Servers map[string]int `json:"servers"`
+	latency []int64
+}
+
+// Summary aggregates the lookups by name and type, keeping those with slow
+// or failed lookups, the most problematic first.
+func (c *DnsCollector) Summary() []*DnsSummary {
+	byName := map[string]*DnsSummary{}
+
+	for _, lookup := range c.lookups {
+		key := lookup.Name + " " + lookup.Type
+
+		summary, ok := byName[key]
+		if !ok {
+			summary = &DnsSummary{Name: lookup.Name, Type: lookup.Type, Rcodes: map[string]int{}, Servers: map[string]int{}}
+			byName[key] = summary
+		}
+
+		summary.Lookups++
+		summary.Rcodes[lookup.Rcode]++
+		summary.Servers[lookup.Server.String()]++
+
+		if lookup.Failed() {
+			summary.Failed++
+		}
+
+		if lookup.Answered() {
+			summary.latency = append(summary.latency, lookup.Latency)
+			if lookup.Latency >= c.slow.Microseconds() {
+				summary.Slow++
+			}
+		}
+	}
+
+	var summaries []*DnsSummary
+	for _, summary := range byName {
+		if summary.Failed == 0 && summary.Slow == 0 {
+			continue
+		}
+
+		sort.Slice(summary.latency, func(i, j int) bool { return summary.latency[i] < summary.latency[j] })
+		if n := len(summary.latency); n > 0 {
+			summary.P50 = summary.latency[(n-1)/2]
+			summary.Max = summary.latency[n-1]
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+		if a.Failed+a.Slow != b.Failed+b.Slow {
+			return a.Failed+a.Slow > b.Failed+b.Slow
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Type < b.Type
+	})
+
+	return summaries
+}
+
+// PrintDnsSummary prints the summaries as a table.
+func PrintDnsSummary(w io.Writer, summaries []*DnsSummary) error {
+	rows := make([][]string, 0, len(summaries))
+	for _, s := range summaries {
+		p50, max := "-", "-"
+		if len(s.latency) > 0 {
+			p50, max = microseconds(s.P50), microseconds(s.Max)
+		}
+
+		rows = append(rows, []string{
+			s.Name,
+			s.Type,
+			strconv.Itoa(s.Lookups),
+			strconv.Itoa(s.Failed),
+			strconv.Itoa(s.Slow),
+			p50,
+			max,
+			breakdown(s.Rcodes),
+			breakdown(s.Servers),
+		})
+	}
+
+	return output.WriteTable(w, []string{"NAME", "TYPE", "LOOKUPS", "FAILED", "SLOW", "P50", "MAX", "RCODES", "SERVERS"}, rows)
+}
+
+// breakdown lists counts by key, most frequent first.
+func breakdown(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s:%d", key, counts[key]))
+	}
+
+	return strings.Join(parts, " ")
+}
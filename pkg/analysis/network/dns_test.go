@@ -0,0 +1,117 @@
+package network
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/stretchr/testify/assert"
+)
+
+// dnsMessage builds a DNS message with a single question.
+func dnsMessage(id uint16, flags uint16, name string, qtype uint16) []byte {
+	message := []byte{byte(id >> 8), byte(id), byte(flags >> 8), byte(flags), 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		message = append(message, byte(len(label)))
+		message = append(message, label...)
+	}
+	return append(message, 0, byte(qtype>>8), byte(qtype), 0, 1)
+}
+
+// escapeBuf escapes data as bpftrace's %r does.
+func escapeBuf(data []byte) string {
+	var b strings.Builder
+	for _, c := range data {
+		if c >= 0x20 && c < 0x7f {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "\\x%02x", c)
+		}
+	}
+	return b.String()
+}
+
+func dnsRecord(direction string, saddr string, sport int, daddr string, dport int, ts int64, data []byte) bpftrace.Record {
+	line := fmt.Sprintf("%s proto=udp saddr=%s sport=%d daddr=%s dport=%d ts=%d pid=4123 data=%s comm=api\n",
+		direction, saddr, sport, daddr, dport, ts, escapeBuf(data))
+	encoded, _ := json.Marshal(line)
+	return bpftrace.Record{Type: bpftrace.TypePrintf, Data: encoded}
+}
+
+func TestDnsProgram(t *testing.T) {
+	// when
+	program := DnsProgram("4026532412", DnsOptions{Duration: time.Minute, Slow: time.Second})
+
+	// then
+	assert.Contains(t, program, "tracepoint:net:net_dev_start_xmit\n"+
+		"/((struct sk_buff *)args->skbaddr)->dev->nd_net.net->ns.inum == 4026532412 && "+
+		"(*(((struct sk_buff *)args->skbaddr)->head + ((struct sk_buff *)args->skbaddr)->network_header) >> 4) == 4 && "+
+		"*(((struct sk_buff *)args->skbaddr)->head + ((struct sk_buff *)args->skbaddr)->network_header + 9) == 17/")
+	assert.Contains(t, program, "tracepoint:net:netif_receive_skb\n")
+	assert.Contains(t, program, "\t$offset = (*($l4 + 12) >> 4) * 4 + 2;\n")
+	assert.Contains(t, program, "ntop(((struct ipv6hdr *)$ip)->saddr.in6_u.u6_addr8)")
+	assert.Equal(t, 8, strings.Count(program, "printf("))
+	assert.Contains(t, program, "interval:s:60 {")
+}
+
+func TestParseDnsMessage(t *testing.T) {
+	// when
+	message, err := ParseDnsMessage(dnsMessage(0x5c78, 0x8183, "api.example.com.default.svc.cluster.local", 28))
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, DnsMessage{
+		Id: 0x5c78, Response: true, Rcode: "NXDOMAIN", Name: "api.example.com.default.svc.cluster.local.", Type: "AAAA",
+	}, message)
+
+	_, err = ParseDnsMessage([]byte{0, 1, 2})
+	assert.Error(t, err)
+}
+
+func TestDnsCollector(t *testing.T) {
+	// given
+	resolve := func(ip string) (string, bool) {
+		if ip == "10.96.0.10" {
+			return "service kube-system/kube-dns", true
+		}
+		return "", false
+	}
+
+	records := []bpftrace.Record{
+		dnsRecord("query", "10.244.1.12", 40001, "10.96.0.10", 53, 1000000, dnsMessage(1, 0x0100, "db.payments.svc.cluster.local", 1)),
+		dnsRecord("query", "10.244.1.12", 40001, "10.96.0.10", 53, 1000500, dnsMessage(2, 0x0100, "db.payments.svc.cluster.local", 28)),
+		dnsRecord("response", "10.96.0.10", 53, "10.244.1.12", 40001, 2500000, dnsMessage(1, 0x8180, "db.payments.svc.cluster.local", 1)),
+		// retried after a timeout, then answered
+		dnsRecord("query", "10.244.1.12", 40001, "10.96.0.10", 53, 5001000500, dnsMessage(2, 0x0100, "db.payments.svc.cluster.local", 28)),
+		dnsRecord("response", "10.96.0.10", 53, "10.244.1.12", 40001, 5003000500, dnsMessage(2, 0x8180, "db.payments.svc.cluster.local", 28)),
+		dnsRecord("query", "10.244.1.12", 40002, "10.96.0.10", 53, 6000000000, dnsMessage(3, 0x0100, "nope.example.com", 1)),
+		dnsRecord("response", "10.96.0.10", 53, "10.244.1.12", 40002, 6001000000, dnsMessage(3, 0x8183, "nope.example.com", 1)),
+		dnsRecord("query", "10.244.1.12", 40003, "10.96.0.10", 53, 7000000000, dnsMessage(4, 0x0100, "lost.example.com", 1)),
+	}
+
+	// when
+	var buf bytes.Buffer
+	collector := NewDnsCollector(&buf, output.FormatText, 100*time.Millisecond, resolve)
+	for _, record := range records {
+		assert.NoError(t, collector.Print(record))
+	}
+	assert.NoError(t, collector.Flush())
+
+	// then
+	assert.Equal(t, ""+
+		"NOERROR    A      db.payments.svc.cluster.local. 1.5ms server=10.96.0.10:53 (service kube-system/kube-dns) pid=4123 comm=api\n"+
+		"NOERROR    AAAA   db.payments.svc.cluster.local. 5.002s server=10.96.0.10:53 (service kube-system/kube-dns) attempts=2 pid=4123 comm=api\n"+
+		"NXDOMAIN   A      nope.example.com. 1ms server=10.96.0.10:53 (service kube-system/kube-dns) pid=4123 comm=api\n"+
+		"unanswered A      lost.example.com. - server=10.96.0.10:53 (service kube-system/kube-dns) pid=4123 comm=api\n"+
+		"\n"+
+		"NAME                            TYPE  LOOKUPS  FAILED  SLOW  P50     MAX     RCODES        SERVERS\n"+
+		"db.payments.svc.cluster.local.  AAAA  1        0       1     5.002s  5.002s  NOERROR:1     10.96.0.10:53 (service kube-system/kube-dns):1\n"+
+		"lost.example.com.               A     1        1       0     -       -       unanswered:1  10.96.0.10:53 (service kube-system/kube-dns):1\n"+
+		"nope.example.com.               A     1        1       0     1ms     1ms     NXDOMAIN:1    10.96.0.10:53 (service kube-system/kube-dns):1\n",
+		buf.String())
+}
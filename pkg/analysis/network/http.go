@@ -0,0 +1,884 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/http2/hpack"
+)
+
+// HttpCaptureSize bounds the bytes of a read or write printed by
+// HttpProgram.
+const HttpCaptureSize = 200
+
+// Chunk directions, as printed by HttpProgram.
+const (
+	chunkRead  = "read"
+	chunkWrite = "write"
+	chunkClose = "close"
+)
+
+// Roles of the traced processes in an exchange.
+const (
+	RoleClient = "client"
+	RoleServer = "server"
+)
+
+// Protocols of an exchange.
+const (
+	ProtocolHttp1 = "http/1.1"
+	ProtocolHttp2 = "h2"
+	ProtocolGrpc  = "grpc"
+)
+
+// httpPrefixes start the messages marking a connection as HTTP: HTTP/1.x
+// requests and responses, and the HTTP/2 connection preface.
+var httpPrefixes = []string{"GET ", "POST", "PUT ", "DELE", "HEAD", "PATC", "OPTI", "HTTP", "PRI "}
+
+const httpPrefixSize = 4
+
+var http2Preface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+
+type HttpOptions struct {
+	Duration time.Duration
+	// Tls are the TLS libraries to read the plaintext of, if any.
+	Tls []TlsLibrary
+}
+
+// syscallBuffer is where the data of a read or write syscall is.
+type syscallBuffer struct {
+	syscall string
+	buffer  string
+	length  string
+}
+
+var (
+	httpWrites = []syscallBuffer{
+		{"write", "args->buf", "args->count"},
+		{"sendto", "args->buff", "args->len"},
+		// only the first buffer of vectored I/O is captured
+		{"writev", "((struct iovec *)args->vec)->iov_base", "((struct iovec *)args->vec)->iov_len"},
+	}
+
+	httpReads = []syscallBuffer{
+		{"read", "args->buf", ""},
+		{"recvfrom", "args->ubuf", ""},
+		{"readv", "((struct iovec *)args->vec)->iov_base", ""},
+	}
+)
+
+// HttpProgram generates a program printing the beginning of the data the
+// target processes read from and write to connections carrying HTTP, the
+// file descriptors of which are remembered when their data starts like an
+// HTTP message, together with the closing of those descriptors. The
+// plaintext passed to the given TLS libraries is printed the same way.
+func HttpProgram(target bpftrace.Target, options HttpOptions) string {
+	var b strings.Builder
+
+	predicate := target.Predicate()
+
+	for _, write := range httpWrites {
+		fmt.Fprintf(&b, "tracepoint:syscalls:sys_enter_%s\n/%s/\n{\n", write.syscall, predicate)
+		fmt.Fprintf(&b, "\t$prefix = str(%s, %d);\n", write.buffer, httpPrefixSize+1)
+		fmt.Fprintf(&b, "\tif (@http[pid, args->fd] || %s) {\n", startsLikeHttp("$prefix"))
+		b.WriteString("\t\t@http[pid, args->fd] = 1;\n")
+		fmt.Fprintf(&b, "\t\t$len = %s;\n", write.length)
+		writeChunk(&b, 2, chunkWrite, fdConn, "args->fd", write.buffer)
+		b.WriteString("\t}\n}\n\n")
+	}
+
+	// the data read is only there once the syscall returns
+	for _, read := range httpReads {
+		fmt.Fprintf(&b, "tracepoint:syscalls:sys_enter_%s\n/%s/\n{\n", read.syscall, predicate)
+		fmt.Fprintf(&b, "\t@read_buf[tid] = (uint64)%s;\n\t@read_fd[tid] = args->fd;\n}\n\n", read.buffer)
+
+		fmt.Fprintf(&b, "tracepoint:syscalls:sys_exit_%s\n/@read_buf[tid]/\n{\n", read.syscall)
+		b.WriteString("\t$buf = (uint8 *)@read_buf[tid];\n\t$fd = @read_fd[tid];\n")
+		b.WriteString("\tdelete(@read_buf[tid]);\n\tdelete(@read_fd[tid]);\n")
+		b.WriteString("\tif (args->ret > 0) {\n")
+		fmt.Fprintf(&b, "\t\t$prefix = str($buf, %d);\n", httpPrefixSize+1)
+		fmt.Fprintf(&b, "\t\tif (@http[pid, $fd] || %s) {\n", startsLikeHttp("$prefix"))
+		b.WriteString("\t\t\t@http[pid, $fd] = 1;\n")
+		b.WriteString("\t\t\t$len = args->ret;\n")
+		writeChunk(&b, 3, chunkRead, fdConn, "$fd", "$buf")
+		b.WriteString("\t\t}\n\t}\n}\n\n")
+	}
+
+	b.WriteString("tracepoint:syscalls:sys_enter_close\n/@http[pid, args->fd]/\n{\n")
+	writeClose(&b, 1, fdConn, "args->fd")
+	b.WriteString("\tdelete(@http[pid, args->fd]);\n}\n\n")
+
+	for _, library := range options.Tls {
+		tlsProbes(&b, predicate, library)
+	}
+
+	fmt.Fprintf(&b, "interval:s:%d {\n", bpftrace.Seconds(options.Duration))
+	b.WriteString("\tclear(@http);\n\tclear(@read_buf);\n\tclear(@read_fd);\n")
+	if len(options.Tls) > 0 {
+		b.WriteString("\tclear(@tls);\n\tclear(@tls_buf);\n\tclear(@tls_conn);\n\tclear(@tls_len);\n")
+	}
+	b.WriteString("\texit();\n}\n")
+
+	return b.String()
+}
+
+// startsLikeHttp generates the condition of prefix, the beginning of a
+// buffer, starting like an HTTP message.
+func startsLikeHttp(prefix string) string {
+	conditions := make([]string, 0, len(httpPrefixes))
+	for _, httpPrefix := range httpPrefixes {
+		conditions = append(conditions, fmt.Sprintf("strncmp(%s, \"%s\", %d) == 0", prefix, httpPrefix, httpPrefixSize))
+	}
+
+	return strings.Join(conditions, " || ")
+}
+
+// Formats of the connections in the printed chunks: file descriptors for
+// sockets, and the addresses of the objects TLS libraries keep their state in.
+const (
+	fdConn      = "%d"
+	opensslConn = "ssl:%lx"
+	goTlsConn   = "tls:%lx"
+)
+
+// writeChunk generates the printf of the first $len bytes of buffer, the
+// whole length being printed for the parser to keep track of the messages
+// it couldn't see.
+func writeChunk(b *strings.Builder, depth int, direction string, connFormat string, conn string, buffer string) {
+	indent := strings.Repeat("\t", depth)
+
+	fmt.Fprintf(b, "%s$captured = $len;\n%sif ($captured > %d) {\n%s\t$captured = %d;\n%s}\n",
+		indent, indent, HttpCaptureSize, indent, HttpCaptureSize, indent)
+	fmt.Fprintf(b, "%sprintf(\"%s pid=%%d conn=%s ts=%%d len=%%d data=%%r\\n\", pid, %s, nsecs, $len, buf(%s, $captured));\n",
+		indent, direction, connFormat, conn, buffer)
+}
+
+// writeClose generates the printf of the closing of a connection.
+func writeClose(b *strings.Builder, depth int, connFormat string, conn string) {
+	fmt.Fprintf(b, "%sprintf(\"%s pid=%%d conn=%s ts=%%d len=0 data=\\n\", pid, %s, nsecs);\n",
+		strings.Repeat("\t", depth), chunkClose, connFormat, conn)
+}
+
+// HttpChunk is the beginning of the data read from or written to a
+// connection, as printed by HttpProgram.
+type HttpChunk struct {
+	Direction string
+	Pid       int
+	// Conn identifies the connection within the process, a file descriptor.
+	Conn string
+	// Timestamp is the monotonic time of the read or write, in nanoseconds.
+	Timestamp int64
+	// Length is the length of the whole read or write, Data its beginning.
+	Length int
+	Data   []byte
+}
+
+// ParseHttpChunk parses a line printed by HttpProgram.
+func ParseHttpChunk(line string) (HttpChunk, error) {
+	line = strings.TrimSuffix(line, "\n")
+
+	i := strings.Index(line, " data=")
+	if i < 0 {
+		return HttpChunk{}, errors.Errorf("malformed HTTP chunk: '%s'", line)
+	}
+
+	chunk := HttpChunk{Data: unescapeBuf(line[i+len(" data="):])}
+
+	fields := strings.Fields(line[:i])
+	if len(fields) == 0 {
+		return HttpChunk{}, errors.New("empty HTTP chunk")
+	}
+
+	chunk.Direction = fields[0]
+
+	for _, field := range fields[1:] {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return HttpChunk{}, errors.Errorf("malformed HTTP chunk field: '%s'", field)
+		}
+
+		key, value := parts[0], parts[1]
+		if key == "conn" {
+			chunk.Conn = value
+			continue
+		}
+
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return HttpChunk{}, errors.Wrapf(err, "malformed HTTP chunk field: '%s'", field)
+		}
+
+		switch key {
+		case "pid":
+			chunk.Pid = int(number)
+		case "ts":
+			chunk.Timestamp = number
+		case "len":
+			chunk.Length = int(number)
+		}
+	}
+
+	return chunk, nil
+}
+
+// Exchange is a request and its response. Latency is the time from the
+// request until the beginning of the response, in microseconds.
+type Exchange struct {
+	Role     string `json:"role"`
+	Protocol string `json:"protocol"`
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Status   string `json:"status"`
+	Latency  int64  `json:"latencyUs"`
+	Pid      int    `json:"pid"`
+}
+
+// String formats the exchange as a line of text output.
+func (e Exchange) String() string {
+	return fmt.Sprintf("%-6s %-8s %-7s %s %s %s pid=%d",
+		e.Role, e.Protocol, e.Method, e.Path, e.Status, microseconds(e.Latency), e.Pid)
+}
+
+// request is a request waiting for its response.
+type request struct {
+	role      string
+	protocol  string
+	method    string
+	path      string
+	timestamp int64
+	// set once the beginning of an HTTP/2 response was seen
+	status   string
+	latency  int64
+	answered bool
+}
+
+func (r *request) exchange(pid int) Exchange {
+	return Exchange{
+		Role:     r.role,
+		Protocol: r.protocol,
+		Method:   r.method,
+		Path:     r.path,
+		Status:   r.status,
+		Latency:  r.latency,
+		Pid:      pid,
+	}
+}
+
+// HttpTracker reconstructs the exchanges of HTTP/1.x and HTTP/2 connections
+// from the chunks of data read and written by their processes.
+type HttpTracker struct {
+	connections map[string]*connection
+}
+
+func NewHttpTracker() *HttpTracker {
+	return &HttpTracker{connections: map[string]*connection{}}
+}
+
+// connection is the state of an HTTP connection.
+type connection struct {
+	pid   int
+	http2 bool
+	// requests are the HTTP/1.x requests waiting for a response, in order
+	requests []*request
+	// streams are the HTTP/2 requests by stream id
+	streams map[uint32]*request
+	// frames parses the HTTP/2 frames read and written
+	frames map[string]*frameParser
+}
+
+// Track feeds a chunk to its connection, returning the exchanges it
+// completed.
+func (t *HttpTracker) Track(chunk HttpChunk) []Exchange {
+	key := fmt.Sprintf("%d/%s", chunk.Pid, chunk.Conn)
+
+	if chunk.Direction == chunkClose {
+		var exchanges []Exchange
+		if conn, ok := t.connections[key]; ok {
+			exchanges = conn.answered()
+			delete(t.connections, key)
+		}
+		return exchanges
+	}
+
+	conn, ok := t.connections[key]
+	if !ok {
+		conn = &connection{pid: chunk.Pid, streams: map[uint32]*request{}, frames: map[string]*frameParser{}}
+		t.connections[key] = conn
+	}
+
+	data, length := chunk.Data, chunk.Length
+	if bytes.HasPrefix(data, http2Preface) {
+		conn.http2 = true
+		data, length = data[len(http2Preface):], length-len(http2Preface)
+	}
+
+	if conn.http2 {
+		return conn.trackFrames(chunk.Direction, chunk.Timestamp, data, length)
+	}
+
+	return conn.trackHttp1(chunk.Direction, chunk.Timestamp, data)
+}
+
+// Flush returns the HTTP/2 exchanges answered but not yet ended.
+func (t *HttpTracker) Flush() []Exchange {
+	keys := make([]string, 0, len(t.connections))
+	for key := range t.connections {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var exchanges []Exchange
+	for _, key := range keys {
+		exchanges = append(exchanges, t.connections[key].answered()...)
+	}
+
+	return exchanges
+}
+
+// role is the role of the process in the exchanges whose requests it
+// read or wrote.
+func role(direction string) string {
+	if direction == chunkRead {
+		return RoleServer
+	}
+
+	return RoleClient
+}
+
+func (c *connection) trackHttp1(direction string, timestamp int64, data []byte) []Exchange {
+	line := data
+	if i := bytes.Index(data, []byte("\r\n")); i >= 0 {
+		line = data[:i]
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 {
+		return nil
+	}
+
+	if strings.HasPrefix(fields[0], "HTTP/1.") {
+		// a response answers the oldest request sent the other way
+		for i, r := range c.requests {
+			if r.role == role(direction) {
+				continue
+			}
+
+			c.requests = append(c.requests[:i], c.requests[i+1:]...)
+			r.status = fields[1]
+			r.latency = (timestamp - r.timestamp) / int64(time.Microsecond)
+
+			return []Exchange{r.exchange(c.pid)}
+		}
+
+		return nil
+	}
+
+	if len(fields) < 3 || !strings.HasPrefix(fields[2], "HTTP/1.") {
+		return nil
+	}
+
+	c.requests = append(c.requests, &request{
+		role:      role(direction),
+		protocol:  ProtocolHttp1,
+		method:    fields[0],
+		path:      fields[1],
+		timestamp: timestamp,
+	})
+
+	return nil
+}
+
+// HTTP/2 frame types and flags, RFC 7540 section 6.
+const (
+	frameHeaderSize = 9
+
+	frameData         = 0x0
+	frameHeaders      = 0x1
+	frameContinuation = 0x9
+
+	flagEndStream  = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+
+	maxFrameType = 0x9
+	// maxFrameSize is the largest frame size peers may allow, 2^24-1
+	maxFrameSize = 1<<24 - 1
+)
+
+// frameParser follows the frames sent one way over an HTTP/2 connection,
+// across chunks that may only hold the beginning of the data.
+type frameParser struct {
+	decoder *hpack.Decoder
+	// skip is the length of the end of a frame in the next chunks
+	skip int
+	// lost is set when the beginning of a frame wasn't captured, the next
+	// chunk starting like a frame resumes parsing
+	lost bool
+	// block is the header block being received on stream
+	block  []byte
+	stream uint32
+}
+
+func newFrameParser() *frameParser {
+	return &frameParser{decoder: hpack.NewDecoder(4096, nil)}
+}
+
+func (c *connection) trackFrames(direction string, timestamp int64, data []byte, length int) []Exchange {
+	parser, ok := c.frames[direction]
+	if !ok {
+		parser = newFrameParser()
+		c.frames[direction] = parser
+	}
+
+	offset := 0
+	if parser.skip > 0 {
+		if parser.skip >= length {
+			parser.skip -= length
+			return nil
+		}
+		offset, parser.skip = parser.skip, 0
+	}
+
+	if parser.lost {
+		if !looksLikeFrame(data[min(offset, len(data)):]) {
+			return nil
+		}
+		parser.lost = false
+	}
+
+	var exchanges []Exchange
+
+	for offset < length {
+		if offset+frameHeaderSize > len(data) {
+			parser.lost = true
+			break
+		}
+
+		header := data[offset : offset+frameHeaderSize]
+		size := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+		frameType, flags := header[3], header[4]
+		stream := binary.BigEndian.Uint32(header[5:9]) & 0x7fffffff
+
+		start, end := offset+frameHeaderSize, offset+frameHeaderSize+size
+		if end > length {
+			parser.skip = end - length
+		}
+
+		switch frameType {
+		case frameHeaders, frameContinuation:
+			if end > len(data) {
+				// the header block can't be decoded, and neither the ones
+				// relying on the entries it added to the dynamic table
+				parser.decoder = hpack.NewDecoder(4096, nil)
+				parser.block = nil
+				break
+			}
+
+			fragment := data[start:end]
+			if frameType == frameHeaders {
+				fragment = headerBlockFragment(fragment, flags)
+				parser.block, parser.stream = nil, stream
+			}
+			parser.block = append(parser.block, fragment...)
+
+			if flags&flagEndHeaders != 0 && parser.stream == stream {
+				fields, err := parser.decoder.DecodeFull(parser.block)
+				parser.block = nil
+				if err == nil {
+					c.headers(direction, timestamp, stream, fields)
+				}
+			}
+		}
+
+		if flags&flagEndStream != 0 && (frameType == frameHeaders || frameType == frameData) {
+			if r, ok := c.streams[stream]; ok && r.answered && r.role != role(direction) {
+				delete(c.streams, stream)
+				exchanges = append(exchanges, r.exchange(c.pid))
+			}
+		}
+
+		offset = end
+	}
+
+	return exchanges
+}
+
+// headerBlockFragment strips the padding and priority of a HEADERS frame.
+func headerBlockFragment(payload []byte, flags byte) []byte {
+	padding := 0
+	if flags&flagPadded != 0 && len(payload) > 0 {
+		padding = int(payload[0])
+		payload = payload[1:]
+	}
+
+	if flags&flagPriority != 0 && len(payload) >= 5 {
+		payload = payload[5:]
+	}
+
+	if padding > len(payload) {
+		return nil
+	}
+
+	return payload[:len(payload)-padding]
+}
+
+// looksLikeFrame reports whether data starts with a plausible frame header.
+func looksLikeFrame(data []byte) bool {
+	if len(data) < frameHeaderSize {
+		return false
+	}
+
+	size := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+
+	return data[3] <= maxFrameType && size <= maxFrameSize && data[5]&0x80 == 0
+}
+
+func (c *connection) headers(direction string, timestamp int64, stream uint32, fields []hpack.HeaderField) {
+	values := map[string]string{}
+	for _, field := range fields {
+		values[field.Name] = field.Value
+	}
+
+	if path, ok := values[":path"]; ok {
+		protocol := ProtocolHttp2
+		if strings.HasPrefix(values["content-type"], "application/grpc") {
+			protocol = ProtocolGrpc
+		}
+
+		c.streams[stream] = &request{
+			role:      role(direction),
+			protocol:  protocol,
+			method:    values[":method"],
+			path:      path,
+			timestamp: timestamp,
+		}
+		return
+	}
+
+	r, ok := c.streams[stream]
+	if !ok || r.role == role(direction) {
+		return
+	}
+
+	// trailers carry the status of gRPC calls, unless there's no response
+	if status, ok := values["grpc-status"]; ok {
+		r.status = grpcStatusName(status)
+	} else if status, ok := values[":status"]; ok && !r.answered {
+		r.status = status
+	}
+
+	if !r.answered {
+		r.answered = true
+		r.latency = (timestamp - r.timestamp) / int64(time.Microsecond)
+	}
+}
+
+// answered returns the HTTP/2 exchanges answered but not ended, e.g. as their
+// end wasn't captured.
+func (c *connection) answered() []Exchange {
+	streams := make([]uint32, 0, len(c.streams))
+	for stream, r := range c.streams {
+		if r.answered {
+			streams = append(streams, stream)
+		}
+	}
+	sort.Slice(streams, func(i, j int) bool { return streams[i] < streams[j] })
+
+	exchanges := make([]Exchange, 0, len(streams))
+	for _, stream := range streams {
+		exchanges = append(exchanges, c.streams[stream].exchange(c.pid))
+		delete(c.streams, stream)
+	}
+
+	return exchanges
+}
+
+var grpcStatuses = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND", "ALREADY_EXISTS",
+	"PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE",
+	"UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED",
+}
+
+func grpcStatusName(status string) string {
+	code, err := strconv.Atoi(status)
+	if err != nil || code < 0 || code >= len(grpcStatuses) {
+		return status
+	}
+
+	return grpcStatuses[code]
+}
+
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// Redactions of the paths of exchanges.
+const (
+	// RedactQuery masks the values of query parameters.
+	RedactQuery = "query"
+	// RedactIds masks the path segments holding digits, such as ids and
+	// tokens, but API versions.
+	RedactIds  = "ids"
+	RedactNone = "none"
+)
+
+var Redactions = []string{RedactQuery, RedactIds, RedactNone}
+
+var apiVersion = regexp.MustCompile(`^v[0-9]+$`)
+
+// Redact masks the parts of the path of exchange that may hold secrets or
+// personal data. The paths of gRPC calls are method names, left untouched.
+func Redact(exchange Exchange, redactions []string) Exchange {
+	if exchange.Protocol == ProtocolGrpc {
+		return exchange
+	}
+
+	path, query := exchange.Path, ""
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path, query = path[:i], path[i+1:]
+	}
+
+	for _, redaction := range redactions {
+		switch redaction {
+		case RedactQuery:
+			parameters := strings.Split(query, "&")
+			for i, parameter := range parameters {
+				if j := strings.IndexByte(parameter, '='); j >= 0 {
+					parameters[i] = parameter[:j+1] + "*"
+				} else if parameter != "" {
+					parameters[i] = "*"
+				}
+			}
+			query = strings.Join(parameters, "&")
+		case RedactIds:
+			segments := strings.Split(path, "/")
+			for i, segment := range segments {
+				if strings.ContainsAny(segment, "0123456789") && !apiVersion.MatchString(segment) {
+					segments[i] = "*"
+				}
+			}
+			path = strings.Join(segments, "/")
+		}
+	}
+
+	if query != "" || strings.Contains(exchange.Path, "?") {
+		path += "?" + query
+	}
+	exchange.Path = path
+
+	return exchange
+}
+
+// Endpoint aggregates the exchanges of a method and path, without its query,
+// Histogram counts their latencies in power of 2 microseconds buckets.
+type Endpoint struct {
+	Role      string            `json:"role"`
+	Protocol  string            `json:"protocol"`
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	Count     int               `json:"count"`
+	P50       int64             `json:"p50Us"`
+	P90       int64             `json:"p90Us"`
+	P99       int64             `json:"p99Us"`
+	Statuses  map[string]int    `json:"statuses"`
+	Histogram []bpftrace.Bucket `json:"histogram"`
+	latencies []int64
+}
+
+// Aggregate groups exchanges by endpoint, the busiest first.
+func Aggregate(exchanges []Exchange) []*Endpoint {
+	byKey := map[string]*Endpoint{}
+
+	for _, exchange := range exchanges {
+		path := exchange.Path
+		if i := strings.IndexByte(path, '?'); i >= 0 {
+			path = path[:i]
+		}
+
+		key := strings.Join([]string{exchange.Role, exchange.Protocol, exchange.Method, path}, " ")
+
+		endpoint, ok := byKey[key]
+		if !ok {
+			endpoint = &Endpoint{
+				Role:     exchange.Role,
+				Protocol: exchange.Protocol,
+				Method:   exchange.Method,
+				Path:     path,
+				Statuses: map[string]int{},
+			}
+			byKey[key] = endpoint
+		}
+
+		endpoint.Count++
+		endpoint.Statuses[exchange.Status]++
+		endpoint.latencies = append(endpoint.latencies, exchange.Latency)
+	}
+
+	endpoints := make([]*Endpoint, 0, len(byKey))
+	for _, endpoint := range byKey {
+		endpoint.Histogram = log2Histogram(endpoint.latencies)
+		endpoint.P50 = bpftrace.Percentile(endpoint.Histogram, 50)
+		endpoint.P90 = bpftrace.Percentile(endpoint.Histogram, 90)
+		endpoint.P99 = bpftrace.Percentile(endpoint.Histogram, 99)
+		endpoints = append(endpoints, endpoint)
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].Count != endpoints[j].Count {
+			return endpoints[i].Count > endpoints[j].Count
+		}
+		return endpoints[i].key() < endpoints[j].key()
+	})
+
+	return endpoints
+}
+
+func (e *Endpoint) key() string {
+	return strings.Join([]string{e.Role, e.Protocol, e.Method, e.Path}, " ")
+}
+
+// log2Histogram counts values the way bpftrace's hist() does, from the
+// bucket of the lowest value to the one of the highest.
+func log2Histogram(values []int64) []bpftrace.Bucket {
+	counts := map[int]uint64{}
+	lowest, highest := 64, -1
+
+	for _, value := range values {
+		// bucket 0 holds 0, bucket k the values in [2^(k-1), 2^k)
+		k := 0
+		for v := value; v > 0; v >>= 1 {
+			k++
+		}
+
+		counts[k]++
+		if k < lowest {
+			lowest = k
+		}
+		if k > highest {
+			highest = k
+		}
+	}
+
+	var buckets []bpftrace.Bucket
+	for k := lowest; k <= highest; k++ {
+		var min, max int64
+		if k > 0 {
+			min, max = int64(1)<<(k-1), int64(1)<<k-1
+		}
+
+		buckets = append(buckets, bpftrace.Bucket{Min: &min, Max: &max, Count: counts[k]})
+	}
+
+	return buckets
+}
+
+// HttpPrinter is an output.Printer reconstructing the exchanges of the chunks
+// printed by HttpProgram, printed as they complete or, when aggregating, as
+// latency histograms per endpoint once tracing ends.
+type HttpPrinter struct {
+	w          io.Writer
+	format     string
+	aggregate  bool
+	redactions []string
+	tracker    *HttpTracker
+	exchanges  []Exchange
+}
+
+func NewHttpPrinter(w io.Writer, format string, aggregate bool, redactions []string) *HttpPrinter {
+	return &HttpPrinter{
+		w:          w,
+		format:     format,
+		aggregate:  aggregate,
+		redactions: redactions,
+		tracker:    NewHttpTracker(),
+	}
+}
+
+func (p *HttpPrinter) Print(record bpftrace.Record) error {
+	switch record.Type {
+	case bpftrace.TypePrintf:
+	case bpftrace.TypeText, bpftrace.TypeLostEvents:
+		log.Warn().Msg(record.String())
+		return nil
+	default:
+		return nil
+	}
+
+	chunk, err := ParseHttpChunk(record.String())
+	if err != nil {
+		return err
+	}
+
+	return p.print(p.tracker.Track(chunk))
+}
+
+func (p *HttpPrinter) print(exchanges []Exchange) error {
+	for i := range exchanges {
+		exchanges[i] = Redact(exchanges[i], p.redactions)
+	}
+
+	if p.aggregate {
+		p.exchanges = append(p.exchanges, exchanges...)
+		return nil
+	}
+
+	for _, exchange := range exchanges {
+		if p.format == output.FormatJson {
+			if err := json.NewEncoder(p.w).Encode(exchange); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := fmt.Fprintln(p.w, exchange.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *HttpPrinter) Flush() error {
+	if err := p.print(p.tracker.Flush()); err != nil {
+		return err
+	}
+
+	if !p.aggregate {
+		return nil
+	}
+
+	endpoints := Aggregate(p.exchanges)
+
+	if p.format == output.FormatJson {
+		return json.NewEncoder(p.w).Encode(endpoints)
+	}
+
+	for _, endpoint := range endpoints {
+		_, err := fmt.Fprintf(p.w, "%s %s %s %s count=%d p50=%s p90=%s p99=%s statuses=%s\n%s\n",
+			endpoint.Role, endpoint.Protocol, endpoint.Method, endpoint.Path, endpoint.Count,
+			microseconds(endpoint.P50), microseconds(endpoint.P90), microseconds(endpoint.P99),
+			breakdown(endpoint.Statuses), output.FormatHistogram(endpoint.Histogram))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
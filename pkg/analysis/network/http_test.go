@@ -0,0 +1,218 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2/hpack"
+)
+
+func httpRecord(direction string, conn int, ts time.Duration, data []byte) bpftrace.Record {
+	captured := data
+	if len(captured) > HttpCaptureSize {
+		captured = captured[:HttpCaptureSize]
+	}
+
+	line := fmt.Sprintf("%s pid=812 conn=%d ts=%d len=%d data=%s\n",
+		direction, conn, ts.Nanoseconds(), len(data), escapeBuf(captured))
+	encoded, _ := json.Marshal(line)
+	return bpftrace.Record{Type: bpftrace.TypePrintf, Data: encoded}
+}
+
+// frame builds an HTTP/2 frame.
+func frame(frameType byte, flags byte, stream uint32, payload []byte) []byte {
+	header := []byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), frameType, flags, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[5:], stream)
+	return append(header, payload...)
+}
+
+// headerBlock encodes fields, given as name and value pairs.
+func headerBlock(encoder *hpack.Encoder, buf *bytes.Buffer, fields ...string) []byte {
+	buf.Reset()
+	for i := 0; i < len(fields); i += 2 {
+		_ = encoder.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]})
+	}
+	return append([]byte(nil), buf.Bytes()...)
+}
+
+func TestHttpProgram(t *testing.T) {
+	// when
+	program := HttpProgram(bpftrace.Target{Pids: []string{"812", "813"}}, HttpOptions{Duration: 2 * time.Minute})
+
+	// then
+	assert.Contains(t, program, "tracepoint:syscalls:sys_enter_write\n/pid == 812 || pid == 813/\n{\n"+
+		"\t$prefix = str(args->buf, 5);\n"+
+		"\tif (@http[pid, args->fd] || strncmp($prefix, \"GET \", 4) == 0 || strncmp($prefix, \"POST\", 4) == 0")
+	assert.Contains(t, program, "strncmp($prefix, \"PRI \", 4) == 0) {\n")
+	assert.Contains(t, program, "tracepoint:syscalls:sys_exit_recvfrom\n/@read_buf[tid]/\n")
+	assert.Contains(t, program, "\t\t\tprintf(\"read pid=%d conn=%d ts=%d len=%d data=%r\\n\", pid, $fd, nsecs, $len, buf($buf, $captured));\n")
+	assert.Contains(t, program, "((struct iovec *)args->vec)->iov_base")
+	assert.Contains(t, program, "tracepoint:syscalls:sys_enter_close\n/@http[pid, args->fd]/\n")
+	assert.Contains(t, program, "interval:s:120 {")
+}
+
+func TestParseHttpChunk(t *testing.T) {
+	// when
+	chunk, err := ParseHttpChunk("write pid=812 conn=7 ts=1500 len=512 data=GET / HTTP/1.1\\x0d\\x0a\n")
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, HttpChunk{
+		Direction: "write", Pid: 812, Conn: "7", Timestamp: 1500, Length: 512, Data: []byte("GET / HTTP/1.1\r\n"),
+	}, chunk)
+
+	_, err = ParseHttpChunk("write pid=812")
+	assert.Error(t, err)
+}
+
+func TestHttpPrinterHttp1(t *testing.T) {
+	// given
+	var out bytes.Buffer
+	printer := NewHttpPrinter(&out, output.FormatText, false, nil)
+
+	records := []bpftrace.Record{
+		// served requests, pipelined
+		httpRecord("read", 5, time.Second, []byte("GET /orders?page=2 HTTP/1.1\r\nHost: api\r\n\r\n")),
+		httpRecord("read", 5, time.Second+time.Millisecond, []byte("POST /orders HTTP/1.1\r\nContent-Length: 2\r\n\r\n{}")),
+		// a request made while serving them
+		httpRecord("write", 9, time.Second+2*time.Millisecond, []byte("GET /stock HTTP/1.1\r\n\r\n")),
+		httpRecord("read", 9, time.Second+5*time.Millisecond, []byte("HTTP/1.1 200 OK\r\n\r\n")),
+		httpRecord("write", 5, time.Second+12*time.Millisecond, []byte("HTTP/1.1 200 OK\r\n\r\n")),
+		httpRecord("write", 5, time.Second+31*time.Millisecond, []byte("HTTP/1.1 503 Service Unavailable\r\n\r\n")),
+		httpRecord("close", 5, 2*time.Second, nil),
+	}
+
+	// when
+	for _, record := range records {
+		assert.NoError(t, printer.Print(record))
+	}
+	assert.NoError(t, printer.Flush())
+
+	// then
+	assert.Equal(t, ""+
+		"client http/1.1 GET     /stock 200 3ms pid=812\n"+
+		"server http/1.1 GET     /orders?page=2 200 12ms pid=812\n"+
+		"server http/1.1 POST    /orders 503 30ms pid=812\n", out.String())
+}
+
+func TestHttpPrinterGrpc(t *testing.T) {
+	// given
+	var out bytes.Buffer
+	printer := NewHttpPrinter(&out, output.FormatJson, false, nil)
+
+	var clientBuf, serverBuf bytes.Buffer
+	client, server := hpack.NewEncoder(&clientBuf), hpack.NewEncoder(&serverBuf)
+
+	request := headerBlock(client, &clientBuf, ":method", "POST", ":path", "/orders.Orders/Create",
+		"content-type", "application/grpc")
+	// the second request only refers to the dynamic table
+	again := headerBlock(client, &clientBuf, ":method", "POST", ":path", "/orders.Orders/Create",
+		"content-type", "application/grpc")
+
+	var first []byte
+	first = append(first, http2Preface...)
+	first = append(first, frame(frameHeaders, flagEndHeaders, 1, request)...)
+	first = append(first, frame(frameData, flagEndStream, 1, make([]byte, 300))...)
+
+	responseHeaders := headerBlock(server, &serverBuf, ":status", "200", "content-type", "application/grpc")
+	trailers := headerBlock(server, &serverBuf, "grpc-status", "5")
+
+	var response []byte
+	response = append(response, frame(frameHeaders, flagEndHeaders, 1, responseHeaders)...)
+	response = append(response, frame(frameData, 0, 1, []byte{0, 0, 0, 0, 0})...)
+	response = append(response, frame(frameHeaders, flagEndHeaders|flagEndStream, 1, trailers)...)
+
+	records := []bpftrace.Record{
+		// the end of the first data frame isn't captured
+		httpRecord("read", 11, time.Second, first),
+		httpRecord("write", 11, time.Second+4*time.Millisecond, response),
+		httpRecord("read", 11, 2*time.Second, frame(frameHeaders, flagEndHeaders|flagEndStream, 3, again)),
+		httpRecord("write", 11, 2*time.Second+time.Millisecond,
+			frame(frameHeaders, flagEndHeaders, 3, headerBlock(server, &serverBuf, ":status", "200", "content-type", "application/grpc"))),
+		httpRecord("close", 11, 3*time.Second, nil),
+	}
+
+	// when
+	for _, record := range records {
+		assert.NoError(t, printer.Print(record))
+	}
+	assert.NoError(t, printer.Flush())
+
+	// then
+	assert.Equal(t, ""+
+		`{"role":"server","protocol":"grpc","method":"POST","path":"/orders.Orders/Create","status":"NOT_FOUND","latencyUs":4000,"pid":812}`+"\n"+
+		`{"role":"server","protocol":"grpc","method":"POST","path":"/orders.Orders/Create","status":"200","latencyUs":1000,"pid":812}`+"\n",
+		out.String())
+}
+
+func TestHttpPrinterAggregate(t *testing.T) {
+	// given
+	var out bytes.Buffer
+	printer := NewHttpPrinter(&out, output.FormatText, true, nil)
+
+	var records []bpftrace.Record
+	for i, latency := range []time.Duration{3 * time.Millisecond, 5 * time.Millisecond, 6 * time.Millisecond} {
+		start := time.Duration(i) * time.Second
+		records = append(records,
+			httpRecord("read", 5, start, []byte(fmt.Sprintf("GET /orders?page=%d HTTP/1.1\r\n\r\n", i))),
+			httpRecord("write", 5, start+latency, []byte("HTTP/1.1 200 OK\r\n\r\n")))
+	}
+	records = append(records,
+		httpRecord("read", 5, 10*time.Second, []byte("DELETE /orders/1 HTTP/1.1\r\n\r\n")),
+		httpRecord("write", 5, 10*time.Second+100*time.Microsecond, []byte("HTTP/1.1 404 Not Found\r\n\r\n")))
+
+	// when
+	for _, record := range records {
+		assert.NoError(t, printer.Print(record))
+	}
+	assert.NoError(t, printer.Flush())
+
+	// then
+	text := out.String()
+	assert.True(t, strings.HasPrefix(text, "server http/1.1 GET /orders count=3 "), text)
+	assert.Contains(t, text, "statuses=200:3\n")
+	assert.Contains(t, text, "[2K, 4K)")
+	assert.Contains(t, text, "[4K, 8K)")
+	assert.Contains(t, text, "server http/1.1 DELETE /orders/1 count=1 ")
+	assert.Contains(t, text, "statuses=404:1\n")
+}
+
+func TestLog2Histogram(t *testing.T) {
+	// when
+	buckets := log2Histogram([]int64{0, 5, 6, 40})
+
+	// then
+	assert.Len(t, buckets, 7)
+	assert.Equal(t, int64(0), *buckets[0].Max)
+	assert.Equal(t, uint64(1), buckets[0].Count)
+	assert.Equal(t, int64(4), *buckets[3].Min)
+	assert.Equal(t, int64(7), *buckets[3].Max)
+	assert.Equal(t, uint64(2), buckets[3].Count)
+	assert.Equal(t, uint64(0), buckets[4].Count)
+	assert.Equal(t, int64(32), *buckets[6].Min)
+	assert.Equal(t, uint64(1), buckets[6].Count)
+}
+
+func TestRedact(t *testing.T) {
+	// given
+	exchange := Exchange{Protocol: ProtocolHttp1, Path: "/api/v2/users/8312/tokens/a1b2c3?access_token=s3cr3t&debug&page=2"}
+
+	// when, then
+	assert.Equal(t, "/api/v2/users/8312/tokens/a1b2c3?access_token=*&*&page=*",
+		Redact(exchange, []string{RedactQuery}).Path)
+	assert.Equal(t, "/api/v2/users/*/tokens/*?access_token=*&*&page=*",
+		Redact(exchange, []string{RedactQuery, RedactIds}).Path)
+	assert.Equal(t, exchange.Path, Redact(exchange, []string{RedactNone}).Path)
+	assert.Equal(t, "/orders/*", Redact(Exchange{Path: "/orders/17"}, []string{RedactQuery, RedactIds}).Path)
+
+	grpc := Exchange{Protocol: ProtocolGrpc, Path: "/grpc.health.v1.Health/Check"}
+	assert.Equal(t, grpc, Redact(grpc, []string{RedactIds}))
+}
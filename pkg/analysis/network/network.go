@@ -0,0 +1,54 @@
+// Package network generates bpftrace programs tracing the network activity of
+// a pod, scoped to its network namespace rather than to its processes, as
+// much of the TCP stack runs in softirq context on behalf of no process.
+package network
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/pkg/errors"
+)
+
+// namespacePattern matches the target of a /proc/<pid>/ns/net link.
+var namespacePattern = regexp.MustCompile(`^net:\[(\d+)\]$`)
+
+// NamespacePath is the link to the network namespace of a host pid, as seen
+// from the privileged pod.
+func NamespacePath(pid string) string {
+	return fmt.Sprintf("%s/%s/ns/net", bpftrace.HostProcPath, pid)
+}
+
+// ParseNamespace returns the inode number of a network namespace, the id the
+// kernel's struct net exposes as ns.inum, from the target of its link.
+func ParseNamespace(link string) (string, error) {
+	match := namespacePattern.FindStringSubmatch(link)
+	if match == nil {
+		return "", errors.Errorf("unexpected network namespace: '%s'", link)
+	}
+
+	return match[1], nil
+}
+
+// Address is one end of a connection, Name is the service or pod the IP
+// belongs to when known.
+type Address struct {
+	IP   string `json:"ip"`
+	Port int    `json:"port"`
+	Name string `json:"name,omitempty"`
+}
+
+func (a Address) String() string {
+	address := net.JoinHostPort(a.IP, strconv.Itoa(a.Port))
+	if a.Name != "" {
+		address += " (" + a.Name + ")"
+	}
+
+	return address
+}
+
+// ResolveFunc names the service or pod of an IP address.
+type ResolveFunc func(ip string) (string, bool)
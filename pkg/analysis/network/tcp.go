@@ -0,0 +1,353 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// TCP events traced by TcpProgram.
+const (
+	EventConnect       = "connect"
+	EventAccept        = "accept"
+	EventClose         = "close"
+	EventRetransmit    = "retransmit"
+	EventResetSent     = "reset-sent"
+	EventResetReceived = "reset-received"
+	EventDrop          = "drop"
+)
+
+var TcpEvents = []string{
+	EventConnect, EventAccept, EventClose, EventRetransmit, EventResetSent, EventResetReceived, EventDrop,
+}
+
+// TCP states, as numbered by include/net/tcp_states.h.
+const (
+	tcpEstablished = 1
+	tcpSynSent     = 2
+	tcpSynRecv     = 3
+	tcpClose       = 7
+)
+
+var tcpStates = []string{
+	"", "ESTABLISHED", "SYN_SENT", "SYN_RECV", "FIN_WAIT1", "FIN_WAIT2", "TIME_WAIT",
+	"CLOSE", "CLOSE_WAIT", "LAST_ACK", "LISTEN", "CLOSING", "NEW_SYN_RECV",
+}
+
+const (
+	afInet     = 2
+	ipprotoTcp = 6
+)
+
+type TcpOptions struct {
+	Duration time.Duration
+	Events   []string
+}
+
+func (o TcpOptions) traces(event string) bool {
+	for _, e := range o.Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TcpProgram generates a program tracing the TCP connections of the network
+// namespace netns. Connections are owned by the process that connected or
+// accepted them, retransmits, resets and closes are mostly handled in
+// softirq context, where pid and comm are meaningless.
+func TcpProgram(netns string, options TcpOptions) string {
+	var b strings.Builder
+
+	inNamespace := fmt.Sprintf("((struct sock *)args->skaddr)->__sk_common.skc_net.net->ns.inum == %s", netns)
+
+	// connects are timed from SYN_SENT, accepted connections from
+	// ESTABLISHED until accept() returns them, i.e. their accept queue time
+	fmt.Fprintf(&b, "tracepoint:sock:inet_sock_set_state\n/args->protocol == %d && %s/\n{\n", ipprotoTcp, inNamespace)
+	b.WriteString("\t$sk = (struct sock *)args->skaddr;\n")
+	fmt.Fprintf(&b, "\tif (args->newstate == %d) {\n", tcpSynSent)
+	b.WriteString("\t\t@start[$sk] = nsecs;\n\t\t@owner[$sk] = pid;\n\t\t@owner_comm[$sk] = comm;\n\t}\n")
+	fmt.Fprintf(&b, "\tif (args->newstate == %d) {\n", tcpEstablished)
+	fmt.Fprintf(&b, "\t\tif (args->oldstate == %d) {\n", tcpSynSent)
+	b.WriteString(printSock(3, EventConnect, "latency_us=%d ok=1", "(nsecs - @start[$sk]) / 1000"))
+	b.WriteString("\t\t\tdelete(@start[$sk]);\n")
+	fmt.Fprintf(&b, "\t\t} else if (args->oldstate == %d) {\n", tcpSynRecv)
+	b.WriteString("\t\t\t@start[$sk] = nsecs;\n\t\t}\n")
+	b.WriteString("\t\t@established[$sk] = nsecs;\n\t}\n")
+	fmt.Fprintf(&b, "\tif (args->newstate == %d) {\n", tcpClose)
+	b.WriteString("\t\tif (@established[$sk]) {\n")
+	b.WriteString("\t\t\t$tp = (struct tcp_sock *)$sk;\n")
+	b.WriteString(printSock(3, EventClose, "duration_us=%d sent=%d received=%d",
+		"(nsecs - @established[$sk]) / 1000", "$tp->bytes_acked", "$tp->bytes_received"))
+	fmt.Fprintf(&b, "\t\t} else if (args->oldstate == %d) {\n", tcpSynSent)
+	b.WriteString(printSock(3, EventConnect, "latency_us=%d ok=0", "(nsecs - @start[$sk]) / 1000"))
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t\tdelete(@start[$sk]);\n\t\tdelete(@established[$sk]);\n")
+	b.WriteString("\t\tdelete(@owner[$sk]);\n\t\tdelete(@owner_comm[$sk]);\n\t}\n}\n\n")
+
+	// only sockets of the namespace are in @established
+	b.WriteString("kretprobe:inet_csk_accept\n/retval != 0/\n{\n")
+	b.WriteString("\t$sk = (struct sock *)retval;\n")
+	b.WriteString("\tif (@established[$sk]) {\n")
+	b.WriteString("\t\t@owner[$sk] = pid;\n\t\t@owner_comm[$sk] = comm;\n")
+	b.WriteString("\t\tif (@start[$sk]) {\n")
+	b.WriteString(printSock(3, EventAccept, "latency_us=%d", "(nsecs - @start[$sk]) / 1000"))
+	b.WriteString("\t\t\tdelete(@start[$sk]);\n\t\t}\n\t}\n}\n\n")
+
+	if options.traces(EventRetransmit) {
+		fmt.Fprintf(&b, "tracepoint:tcp:tcp_retransmit_skb\n/%s/\n{\n", inNamespace)
+		b.WriteString("\t$sk = (struct sock *)args->skaddr;\n")
+		b.WriteString(printSock(1, EventRetransmit, "state=%d", "args->state"))
+		b.WriteString("}\n\n")
+	}
+
+	// resets sent for segments without a socket, e.g. to a closed port,
+	// can't be attributed to a network namespace and aren't traced
+	if options.traces(EventResetSent) {
+		fmt.Fprintf(&b, "tracepoint:tcp:tcp_send_reset\n/args->skaddr != 0 && %s/\n{\n", inNamespace)
+		b.WriteString("\t$sk = (struct sock *)args->skaddr;\n")
+		b.WriteString(printSock(1, EventResetSent, "state=%d", "$sk->__sk_common.skc_state"))
+		b.WriteString("}\n\n")
+	}
+
+	if options.traces(EventResetReceived) {
+		fmt.Fprintf(&b, "tracepoint:tcp:tcp_receive_reset\n/%s/\n{\n", inNamespace)
+		b.WriteString("\t$sk = (struct sock *)args->skaddr;\n")
+		b.WriteString(printSock(1, EventResetReceived, "state=%d", "$sk->__sk_common.skc_state"))
+		b.WriteString("}\n\n")
+	}
+
+	// the kernel drops the handshake completing ACK when the accept queue
+	// of the listener is full
+	if options.traces(EventDrop) {
+		b.WriteString("kprobe:tcp_v4_syn_recv_sock,\nkprobe:tcp_v6_syn_recv_sock\n{\n")
+		b.WriteString("\t$sk = (struct sock *)arg0;\n")
+		fmt.Fprintf(&b, "\tif ($sk->__sk_common.skc_net.net->ns.inum == %s && $sk->sk_ack_backlog > $sk->sk_max_ack_backlog) {\n", netns)
+		b.WriteString(printSock(2, EventDrop, "backlog=%d max_backlog=%d", "$sk->sk_ack_backlog", "$sk->sk_max_ack_backlog"))
+		b.WriteString("\t}\n}\n\n")
+	}
+
+	fmt.Fprintf(&b, "interval:s:%d {\n", bpftrace.Seconds(options.Duration))
+	b.WriteString("\tclear(@start);\n\tclear(@established);\n\tclear(@owner);\n\tclear(@owner_comm);\n")
+	b.WriteString("\texit();\n}\n")
+
+	return b.String()
+}
+
+// printSock generates the printf of an event of the socket $sk, an IPv4 or
+// IPv6 one, followed by the given fields. The process name comes last, as
+// it may contain spaces.
+func printSock(depth int, event string, format string, args ...string) string {
+	indent := strings.Repeat("\t", depth)
+	dport := "(($sk->__sk_common.skc_dport >> 8) | (($sk->__sk_common.skc_dport << 8) & 0xff00))"
+
+	inner := indent + "\t"
+
+	printf := func(saddr string, daddr string) string {
+		values := append([]string{"@owner[$sk]", "ntop(" + saddr + ")", "$sk->__sk_common.skc_num",
+			"ntop(" + daddr + ")", dport}, args...)
+		values = append(values, "@owner_comm[$sk]")
+
+		return fmt.Sprintf("%sprintf(\"%s pid=%%d laddr=%%s lport=%%d raddr=%%s rport=%%d %s comm=%%s\\n\",\n%s\t%s);\n",
+			inner, event, format, inner, strings.Join(values, ", "))
+	}
+
+	ipv4 := printf("$sk->__sk_common.skc_rcv_saddr", "$sk->__sk_common.skc_daddr")
+	ipv6 := printf("$sk->__sk_common.skc_v6_rcv_saddr.in6_u.u6_addr8", "$sk->__sk_common.skc_v6_daddr.in6_u.u6_addr8")
+
+	return fmt.Sprintf("%[1]sif ($sk->__sk_common.skc_family == %[2]d) {\n%[3]s%[1]s} else {\n%[4]s%[1]s}\n",
+		indent, afInet, ipv4, ipv6)
+}
+
+// TcpEvent is a traced TCP event. Durations are in microseconds.
+type TcpEvent struct {
+	Type       string  `json:"type"`
+	Pid        int     `json:"pid,omitempty"`
+	Comm       string  `json:"comm,omitempty"`
+	Local      Address `json:"local"`
+	Remote     Address `json:"remote"`
+	Failed     bool    `json:"failed,omitempty"`
+	Latency    int64   `json:"latencyUs,omitempty"`
+	Duration   int64   `json:"durationUs,omitempty"`
+	Sent       int64   `json:"sent,omitempty"`
+	Received   int64   `json:"received,omitempty"`
+	State      string  `json:"state,omitempty"`
+	Backlog    int64   `json:"backlog,omitempty"`
+	MaxBacklog int64   `json:"maxBacklog,omitempty"`
+}
+
+// ParseTcpEvent parses a line printed by TcpProgram.
+func ParseTcpEvent(line string) (TcpEvent, error) {
+	line = strings.TrimSuffix(line, "\n")
+
+	var comm string
+	if i := strings.Index(line, " comm="); i >= 0 {
+		line, comm = line[:i], line[i+len(" comm="):]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return TcpEvent{}, errors.New("empty TCP event")
+	}
+
+	event := TcpEvent{Type: fields[0], Comm: comm}
+
+	for _, field := range fields[1:] {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return TcpEvent{}, errors.Errorf("malformed TCP event field: '%s'", field)
+		}
+
+		key, value := parts[0], parts[1]
+
+		switch key {
+		case "laddr":
+			event.Local.IP = value
+		case "raddr":
+			event.Remote.IP = value
+		case "state":
+			event.State = stateName(value)
+		default:
+			number, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return TcpEvent{}, errors.Wrapf(err, "malformed TCP event field: '%s'", field)
+			}
+
+			switch key {
+			case "pid":
+				event.Pid = int(number)
+			case "lport":
+				event.Local.Port = int(number)
+			case "rport":
+				event.Remote.Port = int(number)
+			case "ok":
+				event.Failed = number == 0
+			case "latency_us":
+				event.Latency = number
+			case "duration_us":
+				event.Duration = number
+			case "sent":
+				event.Sent = number
+			case "received":
+				event.Received = number
+			case "backlog":
+				event.Backlog = number
+			case "max_backlog":
+				event.MaxBacklog = number
+			}
+		}
+	}
+
+	return event, nil
+}
+
+func stateName(value string) string {
+	state, err := strconv.Atoi(value)
+	if err != nil || state <= 0 || state >= len(tcpStates) {
+		return value
+	}
+
+	return tcpStates[state]
+}
+
+// String formats the event as a line of text output.
+func (e TcpEvent) String() string {
+	var b strings.Builder
+
+	// listeners have no remote end
+	if e.Type == EventDrop {
+		fmt.Fprintf(&b, "%-15s %s", e.Type, e.Local)
+	} else {
+		fmt.Fprintf(&b, "%-15s %s -> %s", e.Type, e.Local, e.Remote)
+	}
+
+	switch e.Type {
+	case EventConnect:
+		if e.Failed {
+			fmt.Fprintf(&b, " failed after=%s", microseconds(e.Latency))
+		} else {
+			fmt.Fprintf(&b, " latency=%s", microseconds(e.Latency))
+		}
+	case EventAccept:
+		fmt.Fprintf(&b, " queued=%s", microseconds(e.Latency))
+	case EventClose:
+		fmt.Fprintf(&b, " duration=%s sent=%d received=%d", microseconds(e.Duration), e.Sent, e.Received)
+	case EventRetransmit, EventResetSent, EventResetReceived:
+		fmt.Fprintf(&b, " state=%s", e.State)
+	case EventDrop:
+		fmt.Fprintf(&b, " backlog=%d/%d", e.Backlog, e.MaxBacklog)
+	}
+
+	if e.Pid != 0 {
+		fmt.Fprintf(&b, " pid=%d comm=%s", e.Pid, e.Comm)
+	}
+
+	return b.String()
+}
+
+func microseconds(us int64) string {
+	return (time.Duration(us) * time.Microsecond).String()
+}
+
+// TcpPrinter is an output.Printer printing the events of TcpProgram, with
+// their remote address resolved to a service or pod, as text or JSON lines.
+type TcpPrinter struct {
+	w       io.Writer
+	format  string
+	events  map[string]bool
+	resolve ResolveFunc
+}
+
+func NewTcpPrinter(w io.Writer, format string, events []string, resolve ResolveFunc) *TcpPrinter {
+	selected := map[string]bool{}
+	for _, event := range events {
+		selected[event] = true
+	}
+
+	return &TcpPrinter{w: w, format: format, events: selected, resolve: resolve}
+}
+
+func (p *TcpPrinter) Print(record bpftrace.Record) error {
+	switch record.Type {
+	case bpftrace.TypePrintf:
+	case bpftrace.TypeText, bpftrace.TypeLostEvents:
+		log.Warn().Msg(record.String())
+		return nil
+	default:
+		return nil
+	}
+
+	event, err := ParseTcpEvent(record.String())
+	if err != nil {
+		return err
+	}
+
+	if !p.events[event.Type] {
+		return nil
+	}
+
+	if p.resolve != nil && event.Type != EventDrop {
+		event.Remote.Name, _ = p.resolve(event.Remote.IP)
+	}
+
+	if p.format == output.FormatJson {
+		return json.NewEncoder(p.w).Encode(event)
+	}
+
+	_, err = fmt.Fprintln(p.w, event.String())
+	return err
+}
+
+func (p *TcpPrinter) Flush() error {
+	return nil
+}
@@ -0,0 +1,84 @@
+package network
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNamespace(t *testing.T) {
+	netns, err := ParseNamespace("net:[4026532412]")
+	assert.NoError(t, err)
+	assert.Equal(t, "4026532412", netns)
+
+	_, err = ParseNamespace("pid:[4026532412]")
+	assert.Error(t, err)
+}
+
+func TestTcpProgram(t *testing.T) {
+	// when
+	program := TcpProgram("4026532412", TcpOptions{Duration: 90 * time.Second, Events: []string{EventConnect, EventRetransmit}})
+
+	// then
+	assert.Contains(t, program, "tracepoint:sock:inet_sock_set_state\n"+
+		"/args->protocol == 6 && ((struct sock *)args->skaddr)->__sk_common.skc_net.net->ns.inum == 4026532412/")
+	assert.Contains(t, program, "kretprobe:inet_csk_accept")
+	assert.Contains(t, program, "tracepoint:tcp:tcp_retransmit_skb")
+	assert.Contains(t, program, `printf("connect pid=%d laddr=%s lport=%d raddr=%s rport=%d latency_us=%d ok=0 comm=%s\n"`)
+	assert.NotContains(t, program, "tcp_send_reset")
+	assert.NotContains(t, program, "tcp_v4_syn_recv_sock")
+	assert.Contains(t, program, "interval:s:90 {")
+}
+
+func TestTcpPrinter(t *testing.T) {
+	// given
+	resolve := func(ip string) (string, bool) {
+		if ip == "10.96.0.10" {
+			return "service kube-system/kube-dns", true
+		}
+		return "", false
+	}
+
+	lines := []string{
+		`{"type": "printf", "data": "connect pid=4123 laddr=10.244.1.12 lport=43122 raddr=10.96.0.10 rport=53 latency_us=1250 ok=1 comm=api server\n"}`,
+		`{"type": "printf", "data": "connect pid=4123 laddr=fd00::12 lport=43124 raddr=fd00::99 rport=443 latency_us=3000000 ok=0 comm=api\n"}`,
+		`{"type": "printf", "data": "retransmit pid=0 laddr=10.244.1.12 lport=8080 raddr=10.244.2.7 rport=51234 state=1 comm=\n"}`,
+		`{"type": "printf", "data": "close pid=4123 laddr=10.244.1.12 lport=43122 raddr=10.96.0.10 rport=53 duration_us=20000 sent=120 received=4096 comm=api\n"}`,
+		`{"type": "printf", "data": "drop pid=0 laddr=0.0.0.0 lport=8080 raddr=0.0.0.0 rport=0 backlog=129 max_backlog=128 comm=\n"}`,
+	}
+
+	// when
+	var buf bytes.Buffer
+	printer := NewTcpPrinter(&buf, output.FormatText, []string{EventConnect, EventRetransmit, EventDrop}, resolve)
+	for _, line := range lines {
+		assert.NoError(t, printer.Print(bpftrace.ParseRecord([]byte(line))))
+	}
+
+	// then
+	assert.Equal(t, ""+
+		"connect         10.244.1.12:43122 -> 10.96.0.10:53 (service kube-system/kube-dns) latency=1.25ms pid=4123 comm=api server\n"+
+		"connect         [fd00::12]:43124 -> [fd00::99]:443 failed after=3s pid=4123 comm=api\n"+
+		"retransmit      10.244.1.12:8080 -> 10.244.2.7:51234 state=ESTABLISHED\n"+
+		"drop            0.0.0.0:8080 backlog=129/128\n",
+		buf.String())
+}
+
+func TestTcpPrinter_Json(t *testing.T) {
+	// given
+	var buf bytes.Buffer
+	printer := NewTcpPrinter(&buf, output.FormatJson, TcpEvents, nil)
+
+	// when
+	err := printer.Print(bpftrace.ParseRecord([]byte(
+		`{"type": "printf", "data": "close pid=4123 laddr=10.244.1.12 lport=43122 raddr=10.96.0.10 rport=53 duration_us=20000 sent=120 received=4096 comm=api\n"}`)))
+
+	// then
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"type": "close", "pid": 4123, "comm": "api",
+		"local": {"ip": "10.244.1.12", "port": 43122}, "remote": {"ip": "10.96.0.10", "port": 53},
+		"durationUs": 20000, "sent": 120, "received": 4096}`, buf.String())
+}
@@ -0,0 +1,221 @@
+package network
+
+import (
+	"debug/elf"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/analysis/funcs"
+	"github.com/alam0rt/kubectl-doktor/pkg/symbolize"
+	"github.com/pkg/errors"
+)
+
+// Kinds of TLS libraries.
+const (
+	TlsOpenssl = "openssl"
+	TlsGo      = "go"
+)
+
+// TlsLibrary is a library, or an executable statically linking one, the
+// plaintext of whose TLS connections can be read with uprobes.
+type TlsLibrary struct {
+	Kind string
+	// Path is the file to probe, as seen from the privileged pod.
+	Path string
+
+	// OpenSSL only, set when SSL_read_ex and SSL_write_ex are there too
+	// (OpenSSL 1.1.1 and later).
+	Extended bool
+
+	// Go only, the link time virtual addresses of the methods of
+	// crypto/tls.Conn and of the RET instructions of Read.
+	Machine     elf.Machine
+	Read        uint64
+	ReadReturns []uint64
+	Write       uint64
+	Close       uint64
+}
+
+// goRegisters are the registers of the receiver, the slice data and length
+// arguments, and the first result with Go's register ABI.
+type goRegisters struct {
+	receiver string
+	data     string
+	length   string
+	result   string
+}
+
+var goTlsRegisters = map[elf.Machine]goRegisters{
+	elf.EM_X86_64:  {`reg("ax")`, `reg("bx")`, `reg("cx")`, `reg("ax")`},
+	elf.EM_AARCH64: {`reg("r0")`, `reg("r1")`, `reg("r2")`, `reg("r0")`},
+}
+
+var opensslSymbols = regexp.MustCompile(`^SSL_(read|write)(_ex)?$|^SSL_free$`)
+
+// IsOpensslLibrary tells whether the file of a memory mapping is OpenSSL's,
+// or BoringSSL's, libssl.
+func IsOpensslLibrary(path string) bool {
+	base := path[strings.LastIndex(path, "/")+1:]
+	return strings.HasPrefix(base, "libssl.so")
+}
+
+// FindTlsLibrary returns the TLS library binary is, or statically links, to
+// be probed at remotePath: Go's crypto/tls, or OpenSSL and its forks.
+func FindTlsLibrary(remotePath string, binary *symbolize.Binary) (TlsLibrary, bool, error) {
+	if binary.IsGo() {
+		return goTlsLibrary(remotePath, binary)
+	}
+
+	found := map[string]bool{}
+	for _, function := range binary.Functions(opensslSymbols) {
+		found[function.Name] = true
+	}
+
+	if !found["SSL_read"] || !found["SSL_write"] || !found["SSL_free"] {
+		return TlsLibrary{}, false, nil
+	}
+
+	return TlsLibrary{
+		Kind:     TlsOpenssl,
+		Path:     remotePath,
+		Extended: found["SSL_read_ex"] && found["SSL_write_ex"],
+	}, true, nil
+}
+
+func goTlsLibrary(remotePath string, binary *symbolize.Binary) (TlsLibrary, bool, error) {
+	methods := map[string]symbolize.Function{}
+	for _, function := range binary.Functions(regexp.MustCompile(`^crypto/tls\.\(\*Conn\)\.(Read|Write|Close)$`)) {
+		methods[strings.TrimPrefix(function.Name, "crypto/tls.(*Conn).")] = function
+	}
+
+	if len(methods) < 3 {
+		return TlsLibrary{}, false, nil
+	}
+
+	if _, ok := goTlsRegisters[binary.Machine()]; !ok {
+		return TlsLibrary{}, false, errors.Errorf("unsupported architecture: '%s' of Go binary: '%s'",
+			binary.Machine(), remotePath)
+	}
+
+	returns, err := binary.Returns(methods["Read"])
+	if err != nil {
+		return TlsLibrary{}, false, err
+	}
+
+	return TlsLibrary{
+		Kind:        TlsGo,
+		Path:        remotePath,
+		Machine:     binary.Machine(),
+		Read:        methods["Read"].Address,
+		ReadReturns: returns,
+		Write:       methods["Write"].Address,
+		Close:       methods["Close"].Address,
+	}, true, nil
+}
+
+// tlsProbes generates the probes printing the plaintext written to and read
+// from the TLS connections of library, as the chunks of sockets are. Like
+// file descriptors, connections are remembered once their plaintext starts
+// like an HTTP message.
+func tlsProbes(b *strings.Builder, predicate string, library TlsLibrary) {
+	switch library.Kind {
+	case TlsOpenssl:
+		opensslProbes(b, predicate, library)
+	case TlsGo:
+		goTlsProbes(b, predicate, library)
+	}
+}
+
+func opensslProbes(b *strings.Builder, predicate string, library TlsLibrary) {
+	path := library.Path
+
+	// int SSL_write(SSL *ssl, const void *buf, int num), and
+	// int SSL_write_ex(SSL *ssl, const void *buf, size_t num, size_t *written)
+	writes := []string{"SSL_write"}
+	if library.Extended {
+		writes = append(writes, "SSL_write_ex")
+	}
+
+	for _, function := range writes {
+		fmt.Fprintf(b, "uprobe:%s:%s\n/%s/\n{\n", path, function, predicate)
+		tlsWrite(b, opensslConn, "arg0", "arg1", "(int64)arg2")
+	}
+
+	// int SSL_read(SSL *ssl, void *buf, int num), and
+	// int SSL_read_ex(SSL *ssl, void *buf, size_t num, size_t *readbytes)
+	fmt.Fprintf(b, "uprobe:%s:SSL_read", path)
+	if library.Extended {
+		fmt.Fprintf(b, ",\nuprobe:%s:SSL_read_ex", path)
+	}
+	fmt.Fprintf(b, "\n/%s/\n{\n", predicate)
+	b.WriteString("\t@tls_buf[tid] = arg1;\n\t@tls_conn[tid] = arg0;\n\t@tls_len[tid] = arg3;\n}\n\n")
+
+	fmt.Fprintf(b, "uretprobe:%s:SSL_read\n/@tls_buf[tid]/\n{\n", path)
+	tlsRead(b, opensslConn, "tid", "(int32)retval")
+
+	if library.Extended {
+		// SSL_read_ex returns 1 on success, the length read is stored in readbytes
+		fmt.Fprintf(b, "uretprobe:%s:SSL_read_ex\n/@tls_buf[tid]/\n{\n", path)
+		tlsRead(b, opensslConn, "tid", "(int32)retval == 1 ? (int64)*(uint64 *)@tls_len[tid] : 0")
+	}
+
+	fmt.Fprintf(b, "uprobe:%s:SSL_free\n/@tls[pid, arg0]/\n{\n", path)
+	writeClose(b, 1, opensslConn, "arg0")
+	b.WriteString("\tdelete(@tls[pid, arg0]);\n}\n\n")
+}
+
+// goTlsProbes probes the methods of crypto/tls.Conn. Uretprobes crash Go
+// programs, so Read is probed on its RET instructions, the data it read
+// being remembered per goroutine.
+func goTlsProbes(b *strings.Builder, predicate string, library TlsLibrary) {
+	registers := goTlsRegisters[library.Machine]
+	goroutine := funcs.GoroutineKey(library.Machine)
+
+	// func (c *Conn) Write(b []byte) (int, error)
+	fmt.Fprintf(b, "uprobe:%s:0x%x\n/%s/\n{\n", library.Path, library.Write, predicate)
+	tlsWrite(b, goTlsConn, registers.receiver, registers.data, fmt.Sprintf("(int64)%s", registers.length))
+
+	// func (c *Conn) Read(b []byte) (int, error)
+	fmt.Fprintf(b, "uprobe:%s:0x%x\n/%s/\n{\n", library.Path, library.Read, predicate)
+	fmt.Fprintf(b, "\t@tls_buf[%[1]s] = %[2]s;\n\t@tls_conn[%[1]s] = %[3]s;\n}\n\n",
+		goroutine, registers.data, registers.receiver)
+
+	returns := make([]string, 0, len(library.ReadReturns))
+	for _, address := range library.ReadReturns {
+		returns = append(returns, fmt.Sprintf("uprobe:%s:0x%x", library.Path, address))
+	}
+
+	fmt.Fprintf(b, "%s\n/@tls_buf[%s]/\n{\n", strings.Join(returns, ",\n"), goroutine)
+	tlsRead(b, goTlsConn, goroutine, fmt.Sprintf("(int64)%s", registers.result))
+
+	// func (c *Conn) Close() error
+	fmt.Fprintf(b, "uprobe:%s:0x%x\n/@tls[pid, %s]/\n{\n", library.Path, library.Close, registers.receiver)
+	writeClose(b, 1, goTlsConn, registers.receiver)
+	fmt.Fprintf(b, "\tdelete(@tls[pid, %s]);\n}\n\n", registers.receiver)
+}
+
+// tlsWrite generates the body of a probe on a write function, its data being
+// there on entry.
+func tlsWrite(b *strings.Builder, connFormat string, conn string, buffer string, length string) {
+	fmt.Fprintf(b, "\t$prefix = str(%s, %d);\n", buffer, httpPrefixSize+1)
+	fmt.Fprintf(b, "\tif (@tls[pid, %s] || %s) {\n", conn, startsLikeHttp("$prefix"))
+	fmt.Fprintf(b, "\t\t@tls[pid, %s] = 1;\n", conn)
+	fmt.Fprintf(b, "\t\t$len = %s;\n", length)
+	writeChunk(b, 2, chunkWrite, connFormat, conn, buffer)
+	b.WriteString("\t}\n}\n\n")
+}
+
+// tlsRead generates the body of a probe on the return of a read function,
+// the buffer and connection of which were remembered by key on entry.
+func tlsRead(b *strings.Builder, connFormat string, key string, length string) {
+	fmt.Fprintf(b, "\t$buf = (uint8 *)@tls_buf[%[1]s];\n\t$conn = @tls_conn[%[1]s];\n", key)
+	fmt.Fprintf(b, "\t$len = %s;\n", length)
+	fmt.Fprintf(b, "\tdelete(@tls_buf[%[1]s]);\n\tdelete(@tls_conn[%[1]s]);\n\tdelete(@tls_len[%[1]s]);\n", key)
+	b.WriteString("\tif ($len > 0) {\n")
+	fmt.Fprintf(b, "\t\t$prefix = str($buf, %d);\n", httpPrefixSize+1)
+	fmt.Fprintf(b, "\t\tif (@tls[pid, $conn] || %s) {\n", startsLikeHttp("$prefix"))
+	b.WriteString("\t\t\t@tls[pid, $conn] = 1;\n")
+	writeChunk(b, 3, chunkRead, connFormat, "$conn", "$buf")
+	b.WriteString("\t\t}\n\t}\n}\n\n")
+}
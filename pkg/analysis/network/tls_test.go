@@ -0,0 +1,98 @@
+package network
+
+import (
+	"debug/elf"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttpProgramOpenssl(t *testing.T) {
+	// given
+	library := TlsLibrary{Kind: TlsOpenssl, Path: "/host/proc/812/root/usr/lib/libssl.so.3", Extended: true}
+
+	// when
+	program := HttpProgram(bpftrace.Target{Pids: []string{"812"}}, HttpOptions{Duration: time.Minute, Tls: []TlsLibrary{library}})
+
+	// then
+	assert.Contains(t, program, "uprobe:/host/proc/812/root/usr/lib/libssl.so.3:SSL_write_ex\n/pid == 812/\n{\n"+
+		"\t$prefix = str(arg1, 5);\n"+
+		"\tif (@tls[pid, arg0] || strncmp($prefix, \"GET \", 4) == 0")
+	assert.Contains(t, program, "\t\tprintf(\"write pid=%d conn=ssl:%lx ts=%d len=%d data=%r\\n\", pid, arg0, nsecs, $len, buf(arg1, $captured));\n")
+	assert.Contains(t, program, "uprobe:/host/proc/812/root/usr/lib/libssl.so.3:SSL_read,\n"+
+		"uprobe:/host/proc/812/root/usr/lib/libssl.so.3:SSL_read_ex\n/pid == 812/\n")
+	assert.Contains(t, program, "\t$len = (int32)retval == 1 ? (int64)*(uint64 *)@tls_len[tid] : 0;\n")
+	assert.Contains(t, program, "uprobe:/host/proc/812/root/usr/lib/libssl.so.3:SSL_free\n/@tls[pid, arg0]/\n{\n"+
+		"\tprintf(\"close pid=%d conn=ssl:%lx ts=%d len=0 data=\\n\", pid, arg0, nsecs);\n")
+	assert.Contains(t, program, "\tclear(@tls);\n")
+
+	// when
+	library.Extended = false
+	program = HttpProgram(bpftrace.Target{Pids: []string{"812"}}, HttpOptions{Duration: time.Minute, Tls: []TlsLibrary{library}})
+
+	// then
+	assert.NotContains(t, program, "SSL_read_ex")
+	assert.NotContains(t, program, "SSL_write_ex")
+}
+
+func TestHttpProgramGoTls(t *testing.T) {
+	// given
+	library := TlsLibrary{
+		Kind:        TlsGo,
+		Path:        "/host/proc/812/root/app",
+		Machine:     elf.EM_X86_64,
+		Read:        0x5e1200,
+		ReadReturns: []uint64{0x5e12f3, 0x5e1340},
+		Write:       0x5e2000,
+		Close:       0x5e2800,
+	}
+
+	// when
+	program := HttpProgram(bpftrace.Target{Pids: []string{"812"}}, HttpOptions{Duration: time.Minute, Tls: []TlsLibrary{library}})
+
+	// then
+	assert.Contains(t, program, "uprobe:/host/proc/812/root/app:0x5e2000\n/pid == 812/\n{\n"+
+		"\t$prefix = str(reg(\"bx\"), 5);\n")
+	assert.Contains(t, program, "\t\t$len = (int64)reg(\"cx\");\n")
+	assert.Contains(t, program, "uprobe:/host/proc/812/root/app:0x5e1200\n/pid == 812/\n{\n"+
+		"\t@tls_buf[reg(\"r14\")] = reg(\"bx\");\n\t@tls_conn[reg(\"r14\")] = reg(\"ax\");\n}\n")
+	assert.Contains(t, program, "uprobe:/host/proc/812/root/app:0x5e12f3,\n"+
+		"uprobe:/host/proc/812/root/app:0x5e1340\n/@tls_buf[reg(\"r14\")]/\n{\n")
+	assert.Contains(t, program, "\t\t\tprintf(\"read pid=%d conn=tls:%lx ts=%d len=%d data=%r\\n\", pid, $conn, nsecs, $len, buf($buf, $captured));\n")
+	assert.Contains(t, program, "uprobe:/host/proc/812/root/app:0x5e2800\n/@tls[pid, reg(\"ax\")]/\n")
+	assert.NotContains(t, program, "uretprobe")
+}
+
+func TestIsOpensslLibrary(t *testing.T) {
+	assert.True(t, IsOpensslLibrary("/usr/lib/x86_64-linux-gnu/libssl.so.1.1"))
+	assert.True(t, IsOpensslLibrary("/lib/libssl.so.3"))
+	assert.False(t, IsOpensslLibrary("/lib/libcrypto.so.3"))
+	assert.False(t, IsOpensslLibrary("/usr/lib/libssl3.so"))
+}
+
+func TestHttpChunkTls(t *testing.T) {
+	// given
+	tracker := NewHttpTracker()
+
+	// when
+	chunks := []string{
+		"write pid=812 conn=ssl:55d0c2a4b8e0 ts=1000000 len=23 data=GET /login HTTP/1.1\\x0d\\x0a",
+		"read pid=812 conn=ssl:55d0c2a4b8e0 ts=9000000 len=19 data=HTTP/1.1 302 Found",
+	}
+
+	var exchanges []Exchange
+	for _, line := range chunks {
+		chunk, err := ParseHttpChunk(line)
+		assert.NoError(t, err)
+		exchanges = append(exchanges, tracker.Track(chunk)...)
+	}
+
+	// then
+	assert.Equal(t, []Exchange{{
+		Role: RoleClient, Protocol: ProtocolHttp1, Method: "GET", Path: "/login", Status: "302", Latency: 8000, Pid: 812,
+	}}, exchanges)
+	assert.True(t, strings.HasPrefix(exchanges[0].String(), "client http/1.1 GET"))
+}
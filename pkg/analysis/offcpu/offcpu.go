@@ -0,0 +1,172 @@
+package offcpu
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/stack"
+	"github.com/google/pprof/profile"
+)
+
+// StacksMap is the map the program aggregates blocked time in, keyed by
+// [pid, state, waker, kstack(perf), ustack(perf)].
+const StacksMap = "@offcpu"
+
+// Options controls the off-CPU tracing.
+type Options struct {
+	Duration time.Duration
+	// MinBlock is the shortest block that is accounted for, shorter ones
+	// are mostly noise and cost overhead.
+	MinBlock time.Duration
+}
+
+// Program measures the time the threads of the target processes spend off-CPU
+// between being switched out and in again, together with the stacks they
+// blocked in, their state when switched out and the process that woke them.
+// Values are in microseconds.
+func Program(target bpftrace.Target, options Options) string {
+	return fmt.Sprintf(`tracepoint:sched:sched_switch /%[1]s/ {
+	@start[args->prev_pid] = nsecs;
+	@state[args->prev_pid] = args->prev_state;
+	@tgid[args->prev_pid] = pid;
+	@kstack[args->prev_pid] = kstack(perf);
+	@ustack[args->prev_pid] = ustack(perf);
+	%[2]s[pid] = comm;
+}
+
+tracepoint:sched:sched_wakeup,tracepoint:sched:sched_wakeup_new /@start[args->pid]/ {
+	@waker[args->pid] = comm;
+}
+
+tracepoint:sched:sched_switch /@start[args->next_pid]/ {
+	$tid = args->next_pid;
+	$us = (nsecs - @start[$tid]) / 1000;
+	if ($us >= %[3]d) {
+		%[4]s[@tgid[$tid], @state[$tid], @waker[$tid], @kstack[$tid], @ustack[$tid]] = sum($us);
+	}
+
+	delete(@start[$tid]);
+	delete(@state[$tid]);
+	delete(@tgid[$tid]);
+	delete(@kstack[$tid]);
+	delete(@ustack[$tid]);
+	delete(@waker[$tid]);
+}
+
+interval:s:%[5]d {
+	clear(@start);
+	clear(@state);
+	clear(@tgid);
+	clear(@kstack);
+	clear(@ustack);
+	clear(@waker);
+	exit();
+}
+`, target.Predicate(), stack.CommMap, options.MinBlock.Microseconds(), StacksMap, bpftrace.Seconds(options.Duration))
+}
+
+// Reason describes why a thread was off-CPU from its state when switched out
+// and the process that woke it, e.g. "[D] woken by kworker/u8:2".
+func Reason(state string, waker string) string {
+	reason := "[" + stateName(state) + "]"
+	if waker != "" {
+		reason += " woken by " + waker
+	}
+
+	return reason
+}
+
+// stateName maps the prev_state of sched_switch to the letters ps uses.
+func stateName(state string) string {
+	value, err := strconv.ParseInt(state, 10, 64)
+	if err != nil {
+		return state
+	}
+
+	switch {
+	case value == 0:
+		return "R"
+	case value == 1:
+		return "S"
+	case value == 2:
+		return "D"
+	case value == 4:
+		return "T"
+	case value == 8:
+		return "t"
+	case value == 64:
+		return "I"
+	case value >= 256:
+		// TASK_REPORT_MAX, the thread was preempted while runnable
+		return "R+"
+	default:
+		return state
+	}
+}
+
+// Annotate adds the wakeup reason as the leaf frame of every sample, so it
+// shows in folded stacks, flame graphs and profiles alike.
+func Annotate(samples []stack.Sample) {
+	for i := range samples {
+		fields := samples[i].Fields
+
+		var state, waker string
+		if len(fields) > 1 {
+			state = fields[1]
+		}
+		if len(fields) > 2 {
+			waker = strings.Join(fields[2:], ",")
+		}
+
+		reason := stack.Frame{Symbol: Reason(state, waker)}
+		samples[i].Frames = append([]stack.Frame{reason}, samples[i].Frames...)
+	}
+}
+
+// Build converts the blocked time into a profile that opens in go tool pprof.
+func Build(samples []stack.Sample, options Options, start time.Time) *profile.Profile {
+	return stack.BuildProfile(samples, stack.ProfileOptions{
+		SampleTypes: []*profile.ValueType{{Type: "offcpu", Unit: "microseconds"}},
+		PeriodType:  &profile.ValueType{Type: "offcpu", Unit: "microseconds"},
+		Period:      1,
+		Start:       start,
+		Duration:    options.Duration,
+	})
+}
+
+// PrintTop prints the top stacks by blocked time, leaf frame first.
+func PrintTop(w io.Writer, samples []stack.Sample, top int) error {
+	sorted := make([]stack.Sample, len(samples))
+	copy(sorted, samples)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Values[0] > sorted[j].Values[0]
+	})
+
+	if top > 0 && len(sorted) > top {
+		sorted = sorted[:top]
+	}
+
+	for _, sample := range sorted {
+		blocked := time.Duration(sample.Values[0]) * time.Microsecond
+		if _, err := fmt.Fprintf(w, "%-12s %s (%s)\n", blocked, sample.Comm, sample.Pid); err != nil {
+			return err
+		}
+
+		for _, frame := range sample.Frames {
+			if _, err := fmt.Fprintf(w, "\t%s\n", frame.Name()); err != nil {
+				return err
+			}
+		}
+
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
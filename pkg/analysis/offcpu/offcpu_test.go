@@ -0,0 +1,47 @@
+package offcpu
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/stack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgram(t *testing.T) {
+	// when
+	program := Program(bpftrace.Target{Pids: []string{"42", "43"}}, Options{Duration: 1500 * time.Millisecond, MinBlock: 2 * time.Millisecond})
+
+	// then
+	assert.Contains(t, program, "tracepoint:sched:sched_switch /pid == 42 || pid == 43/ {")
+	assert.Contains(t, program, "if ($us >= 2000) {")
+	assert.Contains(t, program, "@offcpu[@tgid[$tid], @state[$tid], @waker[$tid], @kstack[$tid], @ustack[$tid]] = sum($us);")
+	assert.Contains(t, program, "interval:s:2 {")
+}
+
+func TestAnnotateAndPrintTop(t *testing.T) {
+	// given
+	collector := stack.NewCollector(StacksMap)
+	record := bpftrace.ParseRecord([]byte(`{"type": "map", "data": {"@offcpu": {` +
+		`"42, 2, kworker/u8:2, \n\tffffffff8a2d1c5e io_schedule+18 ([kernel.kallsyms])\n, \n\t4a5c21 main.read+5 (/app/server)\n": 1500, ` +
+		`"42, 256, , \n\tffffffff8a2d1000 schedule+20 ([kernel.kallsyms])\n, \n": 40}}}`))
+	comms := bpftrace.ParseRecord([]byte(`{"type": "map", "data": {"@comm": {"42": "server"}}}`))
+	assert.NoError(t, collector.Print(record))
+	assert.NoError(t, collector.Print(comms))
+	samples := collector.Samples()
+
+	// when
+	Annotate(samples)
+	var buf bytes.Buffer
+	err := PrintTop(&buf, samples, 1)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "1.5ms        server (42)\n"+
+		"\t[D] woken by kworker/u8:2\n"+
+		"\tio_schedule\n"+
+		"\tmain.read\n\n", buf.String())
+	assert.Equal(t, "[R+]", samples[1].Frames[0].Symbol)
+}
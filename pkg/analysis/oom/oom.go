@@ -0,0 +1,510 @@
+// Package oom watches the OOM kills and the memory cgroup reclaim of a node,
+// attributing them to the pods of the node.
+package oom
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/k8smeta"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Maps the program reports the memory cgroup reclaim in, keyed by cgroup.
+const (
+	ReclaimsMap  = "@reclaims"
+	ReclaimNsMap = "@reclaim_ns"
+	ReclaimedMap = "@reclaimed_pages"
+)
+
+// PageSize is the size of the pages the kernel counts memory in, assumed to
+// be the one of x86-64 and of most arm64 kernels.
+const PageSize = 4096
+
+// NameSize bounds the length of the cgroup names read, long enough for the
+// ones named after a container id, e.g. cri-containerd-<id>.scope.
+const NameSize = 128
+
+// TopTasks bounds the tasks listed with an OOM kill, the largest first.
+const TopTasks = 10
+
+// RssLayout is the layout of the RSS counters of the kernel's mm_struct.
+type RssLayout string
+
+const (
+	// RssAtomic counters are atomics, up to Linux 6.1.
+	RssAtomic RssLayout = "atomic"
+	// RssPercpu counters are per-CPU counters, since Linux 6.2.
+	RssPercpu RssLayout = "percpu"
+)
+
+var kernelVersionPattern = regexp.MustCompile(`^(\d+)\.(\d+)`)
+
+// RssLayoutOf returns the layout of the RSS counters of a kernel version,
+// e.g. 6.8.0-1012-aws as reported by the node.
+func RssLayoutOf(kernelVersion string) RssLayout {
+	match := kernelVersionPattern.FindStringSubmatch(kernelVersion)
+	if match == nil {
+		return RssPercpu
+	}
+
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	if major < 6 || major == 6 && minor < 2 {
+		return RssAtomic
+	}
+	return RssPercpu
+}
+
+// rss is the expression of the resident pages of an mm_struct: file, anon
+// and shmem pages, not swap entries.
+func (l RssLayout) rss(mm string) string {
+	counters := make([]string, 0, 3)
+	for _, counter := range []int{0, 1, 3} {
+		if l == RssAtomic {
+			counters = append(counters, fmt.Sprintf("%s->rss_stat.count[%d].counter", mm, counter))
+		} else {
+			counters = append(counters, fmt.Sprintf("%s->rss_stat[%d].count", mm, counter))
+		}
+	}
+	return strings.Join(counters, " + ")
+}
+
+// Program watches the OOM kills of the node: the OOM with the usage and
+// limit of the memory cgroup at fault, or the node's memory for a system
+// OOM, the tasks the kernel dumps while choosing the victim, and the victims
+// marked. It measures the direct reclaim of the memory cgroups meanwhile,
+// their tasks reclaiming memory as they reach their limit.
+func Program(duration time.Duration, layout RssLayout) string {
+	var b strings.Builder
+
+	b.WriteString("kfunc:oom_kill_process\n{\n")
+	b.WriteString("\t$oc = args->oc;\n\t@oom[tid] = 1;\n")
+	b.WriteString("\tif ($oc->memcg != 0) {\n")
+	b.WriteString("\t\tprintf(\"oom memcg=1 usage=%d limit=%d points=%d pid=%d cgroup=%s victim_cgroup=%s comm=%s\\n\",\n")
+	b.WriteString("\t\t\t$oc->memcg->memory.usage.counter, $oc->memcg->memory.max, $oc->chosen_points, $oc->chosen->tgid,\n")
+	b.WriteString("\t\t\tstr($oc->memcg->css.cgroup->kn->name), str($oc->chosen->cgroups->dfl_cgrp->kn->name), $oc->chosen->comm);\n")
+	b.WriteString("\t} else {\n")
+	b.WriteString("\t\tprintf(\"oom memcg=0 usage=%d limit=%d points=%d pid=%d cgroup=%s victim_cgroup=%s comm=%s\\n\",\n")
+	b.WriteString("\t\t\t0, $oc->totalpages, $oc->chosen_points, $oc->chosen->tgid,\n")
+	b.WriteString("\t\t\t\"\", str($oc->chosen->cgroups->dfl_cgrp->kn->name), $oc->chosen->comm);\n")
+	b.WriteString("\t}\n}\n\n")
+
+	// dumped when vm.oom_dump_tasks is set, the default
+	b.WriteString("kfunc:dump_task\n/@oom[tid] && args->p->mm != 0/\n{\n")
+	b.WriteString("\t$mm = args->p->mm;\n")
+	fmt.Fprintf(&b, "\tprintf(\"task pid=%%d rss=%%d comm=%%s\\n\", args->p->tgid, %s, args->p->comm);\n}\n\n", layout.rss("$mm"))
+
+	b.WriteString("tracepoint:oom:mark_victim\n/@oom[tid]/\n{\n\tprintf(\"victim pid=%d\\n\", args->pid);\n}\n\n")
+
+	b.WriteString("kretfunc:oom_kill_process\n/@oom[tid]/\n{\n\tdelete(@oom[tid]);\n\tprintf(\"end\\n\");\n}\n\n")
+
+	b.WriteString("kfunc:try_to_free_mem_cgroup_pages\n{\n")
+	b.WriteString("\t@reclaim_start[tid] = nsecs;\n\t@reclaim_cgroup[tid] = str(args->memcg->css.cgroup->kn->name);\n}\n\n")
+	b.WriteString("kretfunc:try_to_free_mem_cgroup_pages\n/@reclaim_start[tid]/\n{\n")
+	fmt.Fprintf(&b, "\t%s[@reclaim_cgroup[tid]] = count();\n", ReclaimsMap)
+	fmt.Fprintf(&b, "\t%s[@reclaim_cgroup[tid]] = sum(nsecs - @reclaim_start[tid]);\n", ReclaimNsMap)
+	fmt.Fprintf(&b, "\t%s[@reclaim_cgroup[tid]] = sum(retval);\n", ReclaimedMap)
+	b.WriteString("\tdelete(@reclaim_start[tid]);\n\tdelete(@reclaim_cgroup[tid]);\n}\n\n")
+
+	fmt.Fprintf(&b, "interval:s:%d {\n\tclear(@oom);\n\tclear(@reclaim_start);\n\tclear(@reclaim_cgroup);\n\texit();\n}\n", bpftrace.Seconds(duration))
+
+	return b.String()
+}
+
+// Task is a process of the OOM, sizes are in bytes.
+type Task struct {
+	Pid  int    `json:"pid"`
+	Comm string `json:"comm"`
+	Rss  int64  `json:"rssBytes,omitempty"`
+}
+
+// Kill is an OOM kill.
+type Kill struct {
+	Time time.Time `json:"time"`
+	// Memcg is set when the memory cgroup reached its limit, unset for a
+	// system OOM, the node running out of memory.
+	Memcg bool `json:"memcg"`
+	// Usage and Limit are the ones of the memory cgroup, Limit is the memory
+	// of the node for a system OOM.
+	Usage int64 `json:"usageBytes,omitempty"`
+	Limit int64 `json:"limitBytes"`
+	// Cgroup is the name of the memory cgroup at fault, VictimCgroup the one
+	// of the cgroup of the victim on cgroup v2.
+	Cgroup       string `json:"cgroup,omitempty"`
+	VictimCgroup string `json:"victimCgroup,omitempty"`
+	Victim       Task   `json:"victim"`
+	// Points is the badness of the victim: its resident, swapped and page
+	// table pages, adjusted by its oom_score_adj.
+	Points int64 `json:"points"`
+	// Killed are the processes marked as victims, those sharing the memory
+	// of the victim included.
+	Killed []int `json:"killed"`
+	// Tasks are the largest processes of the memory cgroup, or of the node,
+	// when the victim was chosen.
+	Tasks []Task `json:"tasks"`
+	// Owner is the container of the victim, unset when outside of a pod.
+	Owner *k8smeta.Workload `json:"owner,omitempty"`
+}
+
+// parseFields parses the space separated key=value fields of a line up to
+// comm=, the comm last as it may hold spaces.
+func parseFields(line string) (map[string]string, error) {
+	fields := map[string]string{}
+
+	i := strings.Index(line, " comm=")
+	if i >= 0 {
+		fields["comm"] = line[i+len(" comm="):]
+		line = line[:i]
+	}
+
+	for _, field := range strings.Fields(line)[1:] {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("malformed OOM event field: '%s'", field)
+		}
+		fields[parts[0]] = parts[1]
+	}
+
+	return fields, nil
+}
+
+func parseInts(fields map[string]string, names ...string) ([]int64, error) {
+	values := make([]int64, 0, len(names))
+	for _, name := range names {
+		value, err := strconv.ParseInt(fields[name], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "malformed OOM event field: '%s'", name)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// Owner returns the container, or the pod, of the first of the cgroups
+// named after a container id or a pod uid of the pods.
+func Owner(pods []corev1.Pod, cgroups ...string) (k8smeta.Workload, bool) {
+	workloads := k8smeta.Index(pods)
+
+	for _, cgroup := range cgroups {
+		if workload, ok := workloads[k8smeta.ParseContainerId(cgroup)]; ok {
+			return workload, true
+		}
+
+		// a pod level memory cgroup, e.g. kubepods-burstable-pod<uid>.slice
+		match := podUidPattern.FindStringSubmatch(cgroup)
+		if match == nil {
+			continue
+		}
+
+		uid := strings.ReplaceAll(match[1], "_", "-")
+		for _, pod := range pods {
+			if string(pod.UID) == uid {
+				return k8smeta.Workload{Namespace: pod.Namespace, Pod: pod.Name}, true
+			}
+		}
+	}
+
+	return k8smeta.Workload{}, false
+}
+
+var podUidPattern = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+
+// OwnerFunc resolves the container or pod of cgroups by name.
+type OwnerFunc func(cgroups ...string) (k8smeta.Workload, bool)
+
+// Reclaim is the direct reclaim of a memory cgroup.
+type Reclaim struct {
+	Cgroup    string            `json:"cgroup"`
+	Owner     *k8smeta.Workload `json:"owner,omitempty"`
+	Count     int64             `json:"count"`
+	Total     int64             `json:"totalNs"`
+	Reclaimed int64             `json:"reclaimedBytes"`
+}
+
+// Watcher is an output.Printer printing the OOM kills as they happen, and
+// the direct reclaim of the memory cgroups once tracing ends.
+type Watcher struct {
+	w      io.Writer
+	format string
+	owner  OwnerFunc
+	// filter drops the kills and reclaims it returns false for.
+	filter   func(owner *k8smeta.Workload) bool
+	now      func() time.Time
+	kill     *Kill
+	kills    int
+	reclaims map[string]*Reclaim
+}
+
+func NewWatcher(w io.Writer, format string, owner OwnerFunc, filter func(owner *k8smeta.Workload) bool) *Watcher {
+	return &Watcher{w: w, format: format, owner: owner, filter: filter, now: time.Now, reclaims: map[string]*Reclaim{}}
+}
+
+func (p *Watcher) Print(record bpftrace.Record) error {
+	switch record.Type {
+	case bpftrace.TypePrintf:
+		return p.printf(strings.TrimSuffix(record.String(), "\n"))
+	case bpftrace.TypeMap:
+		return p.maps(record)
+	case bpftrace.TypeText, bpftrace.TypeLostEvents:
+		log.Warn().Msg(record.String())
+		return nil
+	default:
+		return nil
+	}
+}
+
+func (p *Watcher) printf(line string) error {
+	kind := strings.SplitN(line, " ", 2)[0]
+
+	if kind == "end" {
+		if p.kill == nil {
+			return nil
+		}
+		kill := p.kill
+		p.kill = nil
+		return p.printKill(kill)
+	}
+
+	fields, err := parseFields(line)
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case "oom":
+		values, err := parseInts(fields, "memcg", "usage", "limit", "points", "pid")
+		if err != nil {
+			return err
+		}
+
+		p.kill = &Kill{
+			Time:         p.now(),
+			Memcg:        values[0] == 1,
+			Usage:        values[1] * PageSize,
+			Limit:        values[2] * PageSize,
+			Points:       values[3],
+			Victim:       Task{Pid: int(values[4]), Comm: fields["comm"]},
+			Cgroup:       fields["cgroup"],
+			VictimCgroup: fields["victim_cgroup"],
+		}
+	case "task":
+		if p.kill == nil {
+			return nil
+		}
+
+		values, err := parseInts(fields, "pid", "rss")
+		if err != nil {
+			return err
+		}
+
+		p.kill.Tasks = append(p.kill.Tasks, Task{Pid: int(values[0]), Rss: values[1] * PageSize, Comm: fields["comm"]})
+	case "victim":
+		if p.kill == nil {
+			return nil
+		}
+
+		values, err := parseInts(fields, "pid")
+		if err != nil {
+			return err
+		}
+
+		p.kill.Killed = append(p.kill.Killed, int(values[0]))
+	}
+
+	return nil
+}
+
+func (p *Watcher) printKill(kill *Kill) error {
+	sort.SliceStable(kill.Tasks, func(i, j int) bool {
+		return kill.Tasks[i].Rss > kill.Tasks[j].Rss
+	})
+
+	for _, task := range kill.Tasks {
+		if task.Pid == kill.Victim.Pid {
+			kill.Victim.Rss = task.Rss
+		}
+	}
+
+	if len(kill.Tasks) > TopTasks {
+		kill.Tasks = kill.Tasks[:TopTasks]
+	}
+
+	if p.owner != nil {
+		if owner, ok := p.owner(kill.VictimCgroup, kill.Cgroup); ok {
+			kill.Owner = &owner
+		}
+	}
+
+	if p.filter != nil && !p.filter(kill.Owner) {
+		return nil
+	}
+
+	p.kills++
+
+	if p.format == output.FormatJson {
+		return json.NewEncoder(p.w).Encode(kill)
+	}
+
+	_, err := fmt.Fprint(p.w, kill.String())
+	return err
+}
+
+// String formats the kill as a block of text output.
+func (k Kill) String() string {
+	var b strings.Builder
+
+	owner := "outside of a pod"
+	if k.Owner != nil {
+		owner = ownerName(*k.Owner)
+	}
+
+	if k.Memcg {
+		fmt.Fprintf(&b, "%s OOM kill: memory cgroup limit reached, %s\n", k.Time.Format("15:04:05"), owner)
+		fmt.Fprintf(&b, "  cgroup:  %s, %s used of %s\n", k.Cgroup, output.Size(k.Usage), output.Size(k.Limit))
+	} else {
+		fmt.Fprintf(&b, "%s OOM kill: node out of memory, %s\n", k.Time.Format("15:04:05"), owner)
+		fmt.Fprintf(&b, "  node:    %s of memory and swap\n", output.Size(k.Limit))
+	}
+
+	rss := "unknown"
+	if k.Victim.Rss > 0 {
+		rss = output.Size(k.Victim.Rss)
+	}
+	fmt.Fprintf(&b, "  victim:  pid %d (%s), %s resident, %d points\n", k.Victim.Pid, k.Victim.Comm, rss, k.Points)
+
+	if len(k.Killed) > 1 {
+		fmt.Fprintf(&b, "  killed:  %s\n", joinInts(k.Killed))
+	}
+
+	if len(k.Tasks) > 0 {
+		b.WriteString("  largest processes:\n")
+		for _, task := range k.Tasks {
+			fmt.Fprintf(&b, "    %-8d %10s  %s\n", task.Pid, output.Size(task.Rss), task.Comm)
+		}
+	}
+
+	return b.String()
+}
+
+// ownerName names a container, or a pod for a pod level memory cgroup.
+func ownerName(owner k8smeta.Workload) string {
+	if owner.Container == "" {
+		return fmt.Sprintf("%s/%s", owner.Namespace, owner.Pod)
+	}
+	return owner.String()
+}
+
+func joinInts(values []int) string {
+	s := make([]string, 0, len(values))
+	for _, value := range values {
+		s = append(s, strconv.Itoa(value))
+	}
+	return strings.Join(s, ", ")
+}
+
+func (p *Watcher) maps(record bpftrace.Record) error {
+	maps, err := record.Maps()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range maps {
+		for _, cgroup := range m.Keys() {
+			reclaim, ok := p.reclaims[cgroup]
+			if !ok {
+				reclaim = &Reclaim{Cgroup: cgroup}
+				p.reclaims[cgroup] = reclaim
+			}
+
+			value, err := bpftrace.ParseInt(m.Entries[cgroup])
+			if err != nil {
+				return err
+			}
+
+			switch m.Name {
+			case ReclaimsMap:
+				reclaim.Count = value
+			case ReclaimNsMap:
+				reclaim.Total = value
+			case ReclaimedMap:
+				reclaim.Reclaimed = value * PageSize
+			}
+		}
+	}
+
+	return nil
+}
+
+// Flush prints the direct reclaim of the memory cgroups, the ones the most
+// time was spent reclaiming in first.
+func (p *Watcher) Flush() error {
+	reclaims := make([]*Reclaim, 0, len(p.reclaims))
+	for _, reclaim := range p.reclaims {
+		if p.owner != nil {
+			if owner, ok := p.owner(reclaim.Cgroup); ok {
+				reclaim.Owner = &owner
+			}
+		}
+
+		if p.filter != nil && !p.filter(reclaim.Owner) {
+			continue
+		}
+
+		reclaims = append(reclaims, reclaim)
+	}
+
+	sort.Slice(reclaims, func(i, j int) bool {
+		if reclaims[i].Total != reclaims[j].Total {
+			return reclaims[i].Total > reclaims[j].Total
+		}
+		return reclaims[i].Cgroup < reclaims[j].Cgroup
+	})
+
+	if p.format == output.FormatJson {
+		return json.NewEncoder(p.w).Encode(map[string]interface{}{"reclaims": reclaims})
+	}
+
+	if p.kills == 0 {
+		if _, err := fmt.Fprintln(p.w, "no OOM kills"); err != nil {
+			return err
+		}
+	}
+
+	if len(reclaims) == 0 {
+		_, err := fmt.Fprintln(p.w, "no memory cgroup reclaim")
+		return err
+	}
+
+	rows := make([][]string, 0, len(reclaims))
+	for _, reclaim := range reclaims {
+		owner := "-"
+		if reclaim.Owner != nil {
+			owner = ownerName(*reclaim.Owner)
+		}
+
+		rows = append(rows, []string{
+			owner,
+			reclaim.Cgroup,
+			strconv.FormatInt(reclaim.Count, 10),
+			time.Duration(reclaim.Total).Round(time.Microsecond).String(),
+			output.Size(reclaim.Reclaimed),
+		})
+	}
+
+	if _, err := fmt.Fprintln(p.w, "\nmemory cgroup direct reclaim:"); err != nil {
+		return err
+	}
+
+	return output.WriteTable(p.w, []string{"OWNER", "CGROUP", "RECLAIMS", "TIME", "RECLAIMED"}, rows)
+}
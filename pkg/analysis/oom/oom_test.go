@@ -0,0 +1,126 @@
+package oom
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/k8smeta"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/alam0rt/kubectl-doktor/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var pods = []corev1.Pod{testutil.Pod()}
+
+func TestRssLayoutOf(t *testing.T) {
+	assert.Equal(t, RssAtomic, RssLayoutOf("5.15.0-1051-aws"))
+	assert.Equal(t, RssAtomic, RssLayoutOf("6.1.55-75.123.amzn2023.x86_64"))
+	assert.Equal(t, RssPercpu, RssLayoutOf("6.2.0"))
+	assert.Equal(t, RssPercpu, RssLayoutOf("6.8.0-1012-aws"))
+	assert.Equal(t, RssPercpu, RssLayoutOf("unknown"))
+}
+
+func TestProgram(t *testing.T) {
+	// when
+	program := Program(10*time.Minute, RssAtomic)
+
+	// then
+	assert.Contains(t, program, "kfunc:oom_kill_process\n{\n\t$oc = args->oc;")
+	assert.Contains(t, program, "$oc->memcg->memory.usage.counter, $oc->memcg->memory.max")
+	assert.Contains(t, program, "$mm->rss_stat.count[0].counter + $mm->rss_stat.count[1].counter + $mm->rss_stat.count[3].counter")
+	assert.Contains(t, program, "tracepoint:oom:mark_victim\n/@oom[tid]/")
+	assert.Contains(t, program, "@reclaim_ns[@reclaim_cgroup[tid]] = sum(nsecs - @reclaim_start[tid]);")
+	assert.Contains(t, program, "interval:s:600 {")
+
+	// when
+	program = Program(time.Minute, RssPercpu)
+
+	// then
+	assert.Contains(t, program, "$mm->rss_stat[0].count + $mm->rss_stat[1].count + $mm->rss_stat[3].count")
+}
+
+func TestOwner(t *testing.T) {
+	// when
+	byContainer, okContainer := Owner(pods, "", "cri-containerd-"+testutil.ContainerId+".scope")
+	byPod, okPod := Owner(pods, "kubepods-burstable-pod"+strings.ReplaceAll(testutil.PodUID, "-", "_")+".slice")
+	_, okHost := Owner(pods, "sshd.service")
+
+	// then
+	assert.True(t, okContainer)
+	assert.Equal(t, k8smeta.Workload{Namespace: testutil.Namespace, Pod: testutil.PodName, Container: testutil.Container}, byContainer)
+	assert.True(t, okPod)
+	assert.Equal(t, k8smeta.Workload{Namespace: testutil.Namespace, Pod: testutil.PodName}, byPod)
+	assert.False(t, okHost)
+}
+
+func TestWatcher(t *testing.T) {
+	// given
+	var buf bytes.Buffer
+	owner := func(cgroups ...string) (k8smeta.Workload, bool) {
+		return Owner(pods, cgroups...)
+	}
+	watcher := NewWatcher(&buf, output.FormatText, owner, func(owner *k8smeta.Workload) bool {
+		return owner != nil && owner.Pod == testutil.PodName
+	})
+	watcher.now = func() time.Time { return time.Date(2024, 1, 1, 12, 0, 5, 0, time.UTC) }
+
+	records := []string{
+		`{"type": "printf", "data": "oom memcg=1 usage=131072 limit=131072 points=120000 pid=4123 cgroup=cri-containerd-` + testutil.ContainerId + `.scope victim_cgroup=cri-containerd-` + testutil.ContainerId + `.scope comm=java\n"}`,
+		`{"type": "printf", "data": "task pid=4200 rss=2560 comm=sh\n"}`,
+		`{"type": "printf", "data": "task pid=4123 rss=122880 comm=java\n"}`,
+		`{"type": "printf", "data": "victim pid=4123\n"}`,
+		`{"type": "printf", "data": "end\n"}`,
+		`{"type": "printf", "data": "oom memcg=0 usage=0 limit=4194304 points=900 pid=812 cgroup= victim_cgroup=sshd.service comm=sshd\n"}`,
+		`{"type": "printf", "data": "end\n"}`,
+		`{"type": "map", "data": {"@reclaims": {"cri-containerd-` + testutil.ContainerId + `.scope": 40, "sshd.service": 2}}}`,
+		`{"type": "map", "data": {"@reclaim_ns": {"cri-containerd-` + testutil.ContainerId + `.scope": 1500000000, "sshd.service": 2000}}}`,
+		`{"type": "map", "data": {"@reclaimed_pages": {"cri-containerd-` + testutil.ContainerId + `.scope": 25600}}}`,
+	}
+
+	// when
+	for _, record := range records {
+		assert.NoError(t, watcher.Print(bpftrace.ParseRecord([]byte(record))))
+	}
+	assert.NoError(t, watcher.Flush())
+
+	// then
+	assert.Equal(t, ""+
+		"12:00:05 OOM kill: memory cgroup limit reached, "+testutil.Workload+"\n"+
+		"  cgroup:  cri-containerd-"+testutil.ContainerId+".scope, 512.0MiB used of 512.0MiB\n"+
+		"  victim:  pid 4123 (java), 480.0MiB resident, 120000 points\n"+
+		"  largest processes:\n"+
+		"    4123       480.0MiB  java\n"+
+		"    4200        10.0MiB  sh\n"+
+		"\n"+
+		"memory cgroup direct reclaim:\n"+
+		"OWNER                  CGROUP                                                                                 RECLAIMS  TIME  RECLAIMED\n"+
+		testutil.Workload+"  cri-containerd-"+testutil.ContainerId+".scope  40        1.5s  100.0MiB\n",
+		buf.String())
+}
+
+func TestWatcher_Json(t *testing.T) {
+	// given
+	var buf bytes.Buffer
+	watcher := NewWatcher(&buf, output.FormatJson, nil, nil)
+	watcher.now = func() time.Time { return time.Date(2024, 1, 1, 12, 0, 5, 0, time.UTC) }
+
+	// when
+	for _, record := range []string{
+		`{"type": "printf", "data": "oom memcg=0 usage=0 limit=4194304 points=900 pid=812 cgroup= victim_cgroup=sshd.service comm=ssh d\n"}`,
+		`{"type": "printf", "data": "end\n"}`,
+	} {
+		assert.NoError(t, watcher.Print(bpftrace.ParseRecord([]byte(record))))
+	}
+	assert.NoError(t, watcher.Flush())
+
+	// then
+	assert.Equal(t, ""+
+		`{"time":"2024-01-01T12:00:05Z","memcg":false,"limitBytes":17179869184,"victimCgroup":"sshd.service",`+
+		`"victim":{"pid":812,"comm":"ssh d"},"points":900,"killed":null,"tasks":null}`+"\n"+
+		`{"reclaims":[]}`+"\n",
+		buf.String())
+}
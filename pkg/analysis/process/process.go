@@ -0,0 +1,421 @@
+// Package process records the processes a pod executes and their exits, as
+// events or as a process tree.
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/analysis/signals"
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// Event types.
+const (
+	EventExec = "exec"
+	EventExit = "exit"
+)
+
+// ArgvSize bounds the bytes of the arguments read.
+const ArgvSize = 256
+
+// cwdDepth is the number of directories of the working directory read, from
+// the innermost one.
+const cwdDepth = 8
+
+// PodPredicate matches the tasks of the containers of a pod, the cgroups of
+// which are the children of the pod's cgroup v2 directory podCgroup.
+func PodPredicate(podCgroup string) string {
+	return fmt.Sprintf("curtask->cgroups->dfl_cgrp->kn->parent->id == cgroupid(\"%s\")", podCgroup)
+}
+
+// NamespacePredicate matches the tasks of the network namespace netns, shared
+// by the containers of a pod, and by the whole node for host network pods.
+func NamespacePredicate(netns string) string {
+	return fmt.Sprintf("curtask->nsproxy->net_ns->ns.inum == %s", netns)
+}
+
+// Program records the processes executed by the tasks predicate matches,
+// those of a pod, and the exits of its processes. The arguments are read
+// from the memory of the new program, as the exec syscall may still fail
+// when it's entered.
+func Program(predicate string, duration time.Duration) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "tracepoint:sched:sched_process_exec\n/%s/\n{\n", predicate)
+	b.WriteString("\t$mm = curtask->mm;\n")
+	b.WriteString("\t$len = $mm->arg_end - $mm->arg_start;\n")
+	fmt.Fprintf(&b, "\t$len = $len > %d ? %d : $len;\n", ArgvSize, ArgvSize)
+
+	// the working directory from the innermost directory, as the names of
+	// its dentries up to the root of its filesystem
+	b.WriteString("\t$d0 = curtask->fs->pwd.dentry;\n")
+	for i := 1; i < cwdDepth; i++ {
+		fmt.Fprintf(&b, "\t$d%d = $d%d->d_parent;\n", i, i-1)
+	}
+
+	format := []string{EventExec, "%d", "%d", "%d", "%d", "%llu", "%s", "%r"}
+	args := []string{"pid", "curtask->real_parent->tgid", "uid", "cgroup", "nsecs", "str(args->filename)",
+		"buf(uptr($mm->arg_start), $len)"}
+	for i := 0; i < cwdDepth; i++ {
+		format = append(format, "%s")
+		args = append(args, fmt.Sprintf("str($d%d->d_name.name)", i))
+	}
+	fmt.Fprintf(&b, "\tprintf(\"%s\\n\",\n\t\t%s);\n}\n\n", strings.Join(format, `\t`), strings.Join(args, ", "))
+
+	// the exit of the thread group leader, the exit code holds the exit
+	// status and the terminating signal
+	fmt.Fprintf(&b, "tracepoint:sched:sched_process_exit\n/pid == tid && %s/\n{\n", predicate)
+	format = []string{EventExit, "%d", "%d", "%d", "%d", "%llu", "%d", "%llu", "%s"}
+	args = []string{"pid", "curtask->real_parent->tgid", "uid", "cgroup", "nsecs", "curtask->exit_code",
+		"nsecs - curtask->start_time", "comm"}
+	fmt.Fprintf(&b, "\tprintf(\"%s\\n\",\n\t\t%s);\n}\n\n", strings.Join(format, `\t`), strings.Join(args, ", "))
+
+	fmt.Fprintf(&b, "interval:s:%d {\n\texit();\n}\n", bpftrace.Seconds(duration))
+
+	return b.String()
+}
+
+// Event is the execution of a program or the exit of a process.
+type Event struct {
+	Type string `json:"type"`
+	// Time is the monotonic time of the event in nanoseconds, the time
+	// since the node booted.
+	Time      int64  `json:"timeNs"`
+	Pid       int    `json:"pid"`
+	Ppid      int    `json:"ppid"`
+	Uid       int    `json:"uid"`
+	Cgroup    string `json:"cgroup"`
+	Container string `json:"container,omitempty"`
+
+	// Filename is the program executed, Argv its arguments and Cwd the
+	// working directory of the process, relative to its filesystem.
+	Filename string   `json:"filename,omitempty"`
+	Argv     []string `json:"argv,omitempty"`
+	Cwd      string   `json:"cwd,omitempty"`
+
+	// Comm is the name of the exiting process, ExitCode its exit status,
+	// Signal the signal that terminated it, and Runtime the time since it
+	// was forked in nanoseconds.
+	Comm       string `json:"comm,omitempty"`
+	ExitCode   int    `json:"exitCode"`
+	Signal     int    `json:"signal,omitempty"`
+	CoreDumped bool   `json:"coreDumped,omitempty"`
+	Runtime    int64  `json:"runtimeNs,omitempty"`
+}
+
+// ParseEvent parses a line printed by the program, its fields separated by
+// tabs.
+func ParseEvent(line string) (Event, error) {
+	fields := strings.Split(strings.TrimSuffix(line, "\n"), "\t")
+
+	var event Event
+	var numbers []int64
+	var err error
+
+	switch fields[0] {
+	case EventExec:
+		if len(fields) != 8+cwdDepth {
+			return Event{}, errors.Errorf("malformed exec event: '%s'", line)
+		}
+
+		numbers, err = parseInts(fields[1:6])
+		if err != nil {
+			return Event{}, err
+		}
+
+		event = Event{Filename: fields[6], Argv: ParseArgv(fields[7]), Cwd: joinCwd(fields[8:])}
+	case EventExit:
+		if len(fields) != 9 {
+			return Event{}, errors.Errorf("malformed exit event: '%s'", line)
+		}
+
+		numbers, err = parseInts(fields[1:8])
+		if err != nil {
+			return Event{}, err
+		}
+
+		code := int(numbers[5])
+		event = Event{
+			Comm:       fields[8],
+			ExitCode:   code >> 8 & 0xff,
+			Signal:     code & 0x7f,
+			CoreDumped: code&0x80 != 0,
+			Runtime:    numbers[6],
+		}
+	default:
+		return Event{}, errors.Errorf("unknown process event: '%s'", line)
+	}
+
+	event.Type = fields[0]
+	event.Pid = int(numbers[0])
+	event.Ppid = int(numbers[1])
+	event.Uid = int(numbers[2])
+	event.Cgroup = fields[4]
+	event.Time = numbers[4]
+
+	return event, nil
+}
+
+func parseInts(fields []string) ([]int64, error) {
+	numbers := make([]int64, 0, len(fields))
+	for _, field := range fields {
+		number, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "malformed process event field: '%s'", field)
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, nil
+}
+
+// ParseArgv decodes the arguments printed with %r, the bytes that aren't
+// printable escaped as \xNN, the arguments ending with a NUL byte.
+func ParseArgv(escaped string) []string {
+	raw := make([]byte, 0, len(escaped))
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] == '\\' && i+3 < len(escaped) && escaped[i+1] == 'x' {
+			if value, err := strconv.ParseUint(escaped[i+2:i+4], 16, 8); err == nil {
+				raw = append(raw, byte(value))
+				i += 3
+				continue
+			}
+		}
+		raw = append(raw, escaped[i])
+	}
+
+	argv := strings.Split(strings.TrimRight(string(raw), "\x00"), "\x00")
+	if len(argv) == 1 && argv[0] == "" {
+		return nil
+	}
+	return argv
+}
+
+// joinCwd joins the names of the directories of the working directory, the
+// innermost first, up to the root whose parent is itself.
+func joinCwd(names []string) string {
+	var dirs []string
+	for _, name := range names {
+		if name == "/" || name == "" {
+			break
+		}
+		dirs = append(dirs, name)
+	}
+
+	for i, j := 0, len(dirs)-1; i < j; i, j = i+1, j-1 {
+		dirs[i], dirs[j] = dirs[j], dirs[i]
+	}
+
+	cwd := "/" + strings.Join(dirs, "/")
+	if len(dirs) == len(names) {
+		// deeper than what was read
+		cwd = "..." + cwd
+	}
+	return cwd
+}
+
+// String formats the event as a line of text output.
+func (e Event) String() string {
+	container := e.Container
+	if container == "" {
+		container = "-"
+	}
+
+	if e.Type == EventExec {
+		return fmt.Sprintf("%-4s %-16s pid=%d ppid=%d uid=%d cwd=%s %s", e.Type, container, e.Pid, e.Ppid, e.Uid, e.Cwd, e.command())
+	}
+
+	return fmt.Sprintf("%-4s %-16s pid=%d ppid=%d uid=%d %s %s after %s", e.Type, container, e.Pid, e.Ppid, e.Uid, e.Comm,
+		e.status(), time.Duration(e.Runtime).Round(time.Millisecond))
+}
+
+// command is the command line of an exec, quoting the arguments holding
+// spaces.
+func (e Event) command() string {
+	if len(e.Argv) == 0 {
+		return e.Filename
+	}
+
+	args := make([]string, 0, len(e.Argv))
+	for _, arg := range e.Argv {
+		if arg == "" || strings.ContainsAny(arg, " \t\"'") {
+			arg = strconv.Quote(arg)
+		}
+		args = append(args, arg)
+	}
+	return strings.Join(args, " ")
+}
+
+// status describes how the process exited.
+func (e Event) status() string {
+	if e.Signal != 0 {
+		status := "killed by " + signals.Name(e.Signal)
+		if e.CoreDumped {
+			status += " (core dumped)"
+		}
+		return status
+	}
+	return fmt.Sprintf("exited %d", e.ExitCode)
+}
+
+// ContainerFunc resolves the container of a cgroup id.
+type ContainerFunc func(cgroup string) (string, bool)
+
+// Printer is an output.Printer printing the events as they come, or
+// collecting them into a process tree printed once tracing ends.
+type Printer struct {
+	w         io.Writer
+	format    string
+	tree      bool
+	container ContainerFunc
+	events    []Event
+}
+
+func NewPrinter(w io.Writer, format string, tree bool, container ContainerFunc) *Printer {
+	return &Printer{w: w, format: format, tree: tree, container: container}
+}
+
+func (p *Printer) Print(record bpftrace.Record) error {
+	switch record.Type {
+	case bpftrace.TypePrintf:
+	case bpftrace.TypeText, bpftrace.TypeLostEvents:
+		log.Warn().Msg(record.String())
+		return nil
+	default:
+		return nil
+	}
+
+	event, err := ParseEvent(record.String())
+	if err != nil {
+		return err
+	}
+
+	if p.container != nil {
+		event.Container, _ = p.container(event.Cgroup)
+	}
+
+	if p.tree {
+		p.events = append(p.events, event)
+		return nil
+	}
+
+	if p.format == output.FormatJson {
+		return json.NewEncoder(p.w).Encode(event)
+	}
+
+	_, err = fmt.Fprintln(p.w, event.String())
+	return err
+}
+
+func (p *Printer) Flush() error {
+	if !p.tree {
+		return nil
+	}
+
+	roots := Tree(p.events)
+
+	if p.format == output.FormatJson {
+		return json.NewEncoder(p.w).Encode(roots)
+	}
+
+	var b strings.Builder
+	for _, root := range roots {
+		root.write(&b, "", "")
+	}
+
+	_, err := io.WriteString(p.w, b.String())
+	return err
+}
+
+// Process is a node of the process tree.
+type Process struct {
+	Pid  int `json:"pid"`
+	Ppid int `json:"ppid"`
+	// Execs are the programs the process executed, in order.
+	Execs []Event `json:"execs,omitempty"`
+	// Exit is unset for the processes still running once tracing ended.
+	Exit     *Event     `json:"exit,omitempty"`
+	Children []*Process `json:"children,omitempty"`
+}
+
+// Tree builds the trees of the processes of the events, rooted at the ones
+// whose parent wasn't seen. Pids reused while tracing start a new process.
+func Tree(events []Event) []*Process {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time < events[j].Time
+	})
+
+	var all []*Process
+	live := map[int]*Process{}
+
+	for i := range events {
+		event := events[i]
+
+		process, ok := live[event.Pid]
+		if !ok {
+			process = &Process{Pid: event.Pid, Ppid: event.Ppid}
+			all = append(all, process)
+			live[event.Pid] = process
+		}
+
+		switch event.Type {
+		case EventExec:
+			process.Execs = append(process.Execs, event)
+		case EventExit:
+			process.Exit = &event
+			delete(live, event.Pid)
+		}
+	}
+
+	// the parent is the process of the parent pid last seen before the
+	// child, the child's pid being reused otherwise
+	var roots []*Process
+	byPid := map[int]*Process{}
+	for _, process := range all {
+		if parent, ok := byPid[process.Ppid]; ok && parent != process {
+			parent.Children = append(parent.Children, process)
+		} else {
+			roots = append(roots, process)
+		}
+		byPid[process.Pid] = process
+	}
+
+	return roots
+}
+
+func (p *Process) write(b *strings.Builder, prefix string, childPrefix string) {
+	command := "(forked)"
+	container := ""
+	if len(p.Execs) > 0 {
+		last := p.Execs[len(p.Execs)-1]
+		command = last.command()
+		if last.Container != "" {
+			container = fmt.Sprintf(" [%s]", last.Container)
+		}
+	} else if p.Exit != nil {
+		command = fmt.Sprintf("(forked) %s", p.Exit.Comm)
+	}
+
+	status := "running"
+	if p.Exit != nil {
+		status = fmt.Sprintf("%s after %s", p.Exit.status(), time.Duration(p.Exit.Runtime).Round(time.Millisecond))
+	}
+
+	fmt.Fprintf(b, "%s%d%s %s (%s)\n", prefix, p.Pid, container, command, status)
+
+	for i, child := range p.Children {
+		if i == len(p.Children)-1 {
+			child.write(b, childPrefix+"└─ ", childPrefix+"   ")
+		} else {
+			child.write(b, childPrefix+"├─ ", childPrefix+"│  ")
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package process
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgram(t *testing.T) {
+	// when
+	program := Program(NamespacePredicate("4026532589"), 90*time.Second)
+
+	// then
+	assert.Contains(t, program, "tracepoint:sched:sched_process_exec\n/curtask->nsproxy->net_ns->ns.inum == 4026532589/\n{")
+	assert.Contains(t, program, "$len = $len > 256 ? 256 : $len;")
+	assert.Contains(t, program, "$d7 = $d6->d_parent;")
+	assert.Contains(t, program, `printf("exec\t%d\t%d\t%d\t%d\t%llu\t%s\t%r\t%s`)
+	assert.Contains(t, program, "buf(uptr($mm->arg_start), $len), str($d0->d_name.name)")
+	assert.Contains(t, program, "tracepoint:sched:sched_process_exit\n/pid == tid && curtask->nsproxy->net_ns->ns.inum == 4026532589/")
+	assert.Contains(t, program, "curtask->exit_code, nsecs - curtask->start_time, comm);")
+	assert.Contains(t, program, "interval:s:90 {")
+
+	// when
+	program = Program(PodPredicate("/host/sys/fs/cgroup/kubepods/burstable/pod1234"), time.Minute)
+
+	// then
+	assert.Contains(t, program, "tracepoint:sched:sched_process_exec\n"+
+		"/curtask->cgroups->dfl_cgrp->kn->parent->id == cgroupid(\"/host/sys/fs/cgroup/kubepods/burstable/pod1234\")/\n{")
+}
+
+func TestParseArgv(t *testing.T) {
+	assert.Equal(t, []string{"sh", "-c", "echo\tok"}, ParseArgv(`sh\x00-c\x00echo\x09ok\x00`))
+	assert.Equal(t, []string{"ls", "", "-l"}, ParseArgv(`ls\x00\x00-l`))
+	assert.Equal(t, []string{`C:\x`}, ParseArgv(`C:\x`))
+	assert.Nil(t, ParseArgv(""))
+}
+
+func TestParseEvent(t *testing.T) {
+	// when
+	exec, err := ParseEvent("exec\t4200\t4123\t1000\t8812\t5000000\t/bin/sh\tsh\\x00-c\\x00echo hi\\x00\tscripts\tapp\t/\t/\t/\t/\t/\t/")
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, Event{
+		Type:     EventExec,
+		Time:     5000000,
+		Pid:      4200,
+		Ppid:     4123,
+		Uid:      1000,
+		Cgroup:   "8812",
+		Filename: "/bin/sh",
+		Argv:     []string{"sh", "-c", "echo hi"},
+		Cwd:      "/app/scripts",
+	}, exec)
+
+	// when
+	exit, err := ParseEvent("exit\t4200\t4123\t1000\t8812\t9000000\t139\t4000000\tsh")
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, Event{
+		Type:       EventExit,
+		Time:       9000000,
+		Pid:        4200,
+		Ppid:       4123,
+		Uid:        1000,
+		Cgroup:     "8812",
+		Comm:       "sh",
+		Signal:     11,
+		CoreDumped: true,
+		Runtime:    4000000,
+	}, exit)
+
+	// when
+	exit, err = ParseEvent("exit\t4200\t4123\t0\t8812\t9000000\t256\t4000000\tsh")
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, 1, exit.ExitCode)
+	assert.Equal(t, 0, exit.Signal)
+
+	// when
+	_, err = ParseEvent("exit\t4200")
+
+	// then
+	assert.EqualError(t, err, "malformed exit event: 'exit\t4200'")
+
+	// when
+	_, err = ParseEvent("fork\t4200")
+
+	// then
+	assert.EqualError(t, err, "unknown process event: 'fork\t4200'")
+}
+
+func TestJoinCwd(t *testing.T) {
+	assert.Equal(t, "/", joinCwd([]string{"/", "/", "/"}))
+	assert.Equal(t, "/var/lib", joinCwd([]string{"lib", "var", "/"}))
+	assert.Equal(t, ".../a/b/c", joinCwd([]string{"c", "b", "a"}))
+}
+
+var records = []string{
+	`{"type": "printf", "data": "exec\t4123\t4100\t0\t8812\t1000000\t/bin/sh\tsh\\x00/entrypoint.sh\\x00\t/\t/\t/\t/\t/\t/\t/\t/\n"}`,
+	`{"type": "printf", "data": "exec\t4200\t4123\t0\t8812\t2000000\t/usr/bin/curl\tcurl\\x00-s\\x00http://config/\\x00\tapp\t/\t/\t/\t/\t/\t/\t/\n"}`,
+	`{"type": "printf", "data": "exit\t4200\t4123\t0\t8812\t3000000\t1536\t1000000\tcurl\n"}`,
+	`{"type": "printf", "data": "exit\t4201\t4123\t0\t8812\t3500000\t9\t250000000\tsh\n"}`,
+	`{"type": "printf", "data": "exec\t4202\t4123\t0\t8812\t4000000\t/app/server\tserver\\x00--port 8080\\x00\tapp\t/\t/\t/\t/\t/\t/\t/\n"}`,
+}
+
+func printRecords(t *testing.T, printer *Printer) {
+	for _, record := range records {
+		assert.NoError(t, printer.Print(bpftrace.ParseRecord([]byte(record))))
+	}
+	assert.NoError(t, printer.Flush())
+}
+
+func container(cgroup string) (string, bool) {
+	return "app", cgroup == "8812"
+}
+
+func TestPrinter(t *testing.T) {
+	// given
+	var buf bytes.Buffer
+	printer := NewPrinter(&buf, output.FormatText, false, container)
+
+	// when
+	printRecords(t, printer)
+
+	// then
+	assert.Equal(t, ""+
+		"exec app              pid=4123 ppid=4100 uid=0 cwd=/ sh /entrypoint.sh\n"+
+		"exec app              pid=4200 ppid=4123 uid=0 cwd=/app curl -s http://config/\n"+
+		"exit app              pid=4200 ppid=4123 uid=0 curl exited 6 after 1ms\n"+
+		"exit app              pid=4201 ppid=4123 uid=0 sh killed by SIGKILL after 250ms\n"+
+		"exec app              pid=4202 ppid=4123 uid=0 cwd=/app server \"--port 8080\"\n",
+		buf.String())
+}
+
+func TestPrinter_Tree(t *testing.T) {
+	// given
+	var buf bytes.Buffer
+	printer := NewPrinter(&buf, output.FormatText, true, container)
+
+	// when
+	printRecords(t, printer)
+
+	// then
+	assert.Equal(t, ""+
+		"4123 [app] sh /entrypoint.sh (running)\n"+
+		"├─ 4200 [app] curl -s http://config/ (exited 6 after 1ms)\n"+
+		"├─ 4201 (forked) sh (killed by SIGKILL after 250ms)\n"+
+		"└─ 4202 [app] server \"--port 8080\" (running)\n",
+		buf.String())
+}
+
+func TestTree_ReusedPid(t *testing.T) {
+	// given
+	events := []Event{
+		{Type: EventExec, Time: 1, Pid: 10, Ppid: 1},
+		{Type: EventExit, Time: 2, Pid: 10, Ppid: 1},
+		{Type: EventExec, Time: 3, Pid: 10, Ppid: 1},
+		{Type: EventExec, Time: 4, Pid: 11, Ppid: 10},
+	}
+
+	// when
+	roots := Tree(events)
+
+	// then
+	assert.Len(t, roots, 2)
+	assert.NotNil(t, roots[0].Exit)
+	assert.Empty(t, roots[0].Children)
+	assert.Nil(t, roots[1].Exit)
+	assert.Equal(t, 11, roots[1].Children[0].Pid)
+}
@@ -0,0 +1,61 @@
+package profile
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/stack"
+	"github.com/google/pprof/profile"
+)
+
+// StacksMap is the map the program aggregates sampled stacks in.
+const StacksMap = "@stacks"
+
+// Options controls the on-CPU sampling.
+type Options struct {
+	// Frequency is the number of samples per second, per CPU.
+	Frequency int
+	Duration  time.Duration
+}
+
+// Program samples the on-CPU kernel and user stacks of the target processes
+// and exits after the configured duration.
+func Program(target bpftrace.Target, options Options) string {
+	return fmt.Sprintf(`profile:hz:%d /%s/ {
+	%s[pid, kstack(perf), ustack(perf)] = count();
+	%s[pid] = comm;
+}
+
+interval:s:%d {
+	exit();
+}
+`, options.Frequency, target.Predicate(), StacksMap, stack.CommMap, bpftrace.Seconds(options.Duration))
+}
+
+// period returns the CPU time a single sample stands for.
+func (o Options) period() int64 {
+	return int64(time.Second) / int64(o.Frequency)
+}
+
+// Build converts the sample counts into a CPU profile, the way the Go runtime
+// reports them, so it opens in go tool pprof.
+func Build(samples []stack.Sample, options Options, start time.Time) *profile.Profile {
+	period := options.period()
+
+	for i := range samples {
+		count := samples[i].Values[0]
+		samples[i].Values = []int64{count, count * period}
+	}
+
+	return stack.BuildProfile(samples, stack.ProfileOptions{
+		SampleTypes: []*profile.ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "cpu", Unit: "nanoseconds"},
+		},
+		PeriodType: &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:     period,
+		Start:      start,
+		Duration:   options.Duration,
+	})
+}
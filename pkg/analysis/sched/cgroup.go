@@ -0,0 +1,184 @@
+package sched
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/pkg/errors"
+)
+
+// CgroupPath is the cgroup file of a host process.
+func CgroupPath(pid string) string {
+	return fmt.Sprintf("%s/%s/cgroup", bpftrace.HostProcPath, pid)
+}
+
+// Cgroup is the directory of the CPU controller of a container's cgroup.
+type Cgroup struct {
+	Dir string
+	V2  bool
+}
+
+// ParseV1Cgroup finds the cgroup of the CPU controller of a process from its
+// cgroup file on cgroup v1 hosts. On cgroup v2 the container's cgroup is the
+// one the tracer resolved, its directory holding every controller.
+func ParseV1Cgroup(content []byte) (Cgroup, error) {
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), ":", 3)
+		if len(fields) != 3 || !hasController(fields[1], "cpu") {
+			continue
+		}
+
+		// a path outside of the cgroup namespace of the privileged pod
+		if strings.Contains(fields[2], "..") {
+			return Cgroup{}, errors.Errorf("cgroup: '%s' is outside of the cgroup namespace of the privileged pod", fields[2])
+		}
+
+		return Cgroup{Dir: path.Join(bpftrace.HostCgroupPath, fields[1], fields[2])}, nil
+	}
+
+	return Cgroup{}, errors.New("no cpu cgroup found")
+}
+
+func hasController(controllers string, controller string) bool {
+	for _, c := range strings.Split(controllers, ",") {
+		if c == controller {
+			return true
+		}
+	}
+	return false
+}
+
+// StatPath is the file of the CPU usage and throttling statistics.
+func (c Cgroup) StatPath() string {
+	return path.Join(c.Dir, "cpu.stat")
+}
+
+// QuotaPaths are the files of the CFS bandwidth limit, a single one on
+// cgroup v2.
+func (c Cgroup) QuotaPaths() []string {
+	if c.V2 {
+		return []string{path.Join(c.Dir, "cpu.max")}
+	}
+	return []string{path.Join(c.Dir, "cpu.cfs_quota_us"), path.Join(c.Dir, "cpu.cfs_period_us")}
+}
+
+// CpuStat holds the throttling counters of cpu.stat.
+type CpuStat struct {
+	// Periods is the number of enforcement periods the cgroup had runnable
+	// tasks in.
+	Periods int64 `json:"periods"`
+	// Throttled is the number of those periods the cgroup was throttled in,
+	// having used its quota.
+	Throttled int64 `json:"throttledPeriods"`
+	// ThrottledTime is the total time tasks of the cgroup were throttled for.
+	ThrottledTime time.Duration `json:"throttledNs"`
+}
+
+// ParseCpuStat parses a cpu.stat file, throttled_time being in nanoseconds
+// on cgroup v1 and throttled_usec in microseconds on v2.
+func ParseCpuStat(content []byte) (CpuStat, error) {
+	var stat CpuStat
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return CpuStat{}, errors.Wrapf(err, "malformed cpu.stat line: '%s'", line)
+		}
+
+		switch fields[0] {
+		case "nr_periods":
+			stat.Periods = value
+		case "nr_throttled":
+			stat.Throttled = value
+		case "throttled_time":
+			stat.ThrottledTime = time.Duration(value)
+		case "throttled_usec":
+			stat.ThrottledTime = time.Duration(value) * time.Microsecond
+		}
+	}
+
+	return stat, nil
+}
+
+// Sub returns the throttling between two readings of cpu.stat.
+func (s CpuStat) Sub(previous CpuStat) CpuStat {
+	return CpuStat{
+		Periods:       s.Periods - previous.Periods,
+		Throttled:     s.Throttled - previous.Throttled,
+		ThrottledTime: s.ThrottledTime - previous.ThrottledTime,
+	}
+}
+
+// Quota is the CFS bandwidth limit of a cgroup: the CPU time its tasks can
+// use per period.
+type Quota struct {
+	// Quota is negative when the cgroup isn't limited.
+	Quota  time.Duration `json:"quotaNs"`
+	Period time.Duration `json:"periodNs"`
+}
+
+// Cpus returns the number of CPUs the quota amounts to, 0 when unlimited.
+func (q Quota) Cpus() float64 {
+	if q.Quota < 0 || q.Period <= 0 {
+		return 0
+	}
+	return float64(q.Quota) / float64(q.Period)
+}
+
+// ParseQuota parses the contents of the files of QuotaPaths: cpu.max, or
+// cpu.cfs_quota_us and cpu.cfs_period_us.
+func ParseQuota(contents ...[]byte) (Quota, error) {
+	var fields []string
+	for _, content := range contents {
+		fields = append(fields, strings.Fields(string(content))...)
+	}
+
+	if len(fields) != 2 {
+		return Quota{}, errors.Errorf("malformed cpu quota: '%s'", strings.Join(fields, " "))
+	}
+
+	period, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return Quota{}, errors.Wrapf(err, "malformed cpu period: '%s'", fields[1])
+	}
+
+	quota := Quota{Quota: -1, Period: time.Duration(period) * time.Microsecond}
+
+	if fields[0] != "max" && fields[0] != "-1" {
+		value, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return Quota{}, errors.Wrapf(err, "malformed cpu quota: '%s'", fields[0])
+		}
+		quota.Quota = time.Duration(value) * time.Microsecond
+	}
+
+	return quota, nil
+}
+
+// Sample is a reading of cpu.stat.
+type Sample struct {
+	Time time.Time
+	Stat CpuStat
+}
+
+// at returns the counters as of t, those of the last sample taken by then.
+func at(samples []Sample, t time.Time) (CpuStat, bool) {
+	var stat CpuStat
+	found := false
+	for _, sample := range samples {
+		if sample.Time.After(t) {
+			break
+		}
+		stat, found = sample.Stat, true
+	}
+	return stat, found
+}
@@ -0,0 +1,398 @@
+// Package sched measures the run queue latency of the threads of a
+// container, the time they wait for a CPU once runnable, and correlates it
+// with the CFS throttling of the container's cgroup.
+package sched
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/rs/zerolog/log"
+)
+
+// Maps the program reports in.
+const (
+	LatencyMap       = "@runq_ns"
+	ThreadLatencyMap = "@runq_comm_ns"
+	SecondLatencyMap = "@second_ns"
+)
+
+// tick is printed by the program once every second, after the latency of
+// the second.
+const tick = "tick"
+
+// Program measures the run queue latency of the threads of the processes:
+// from being woken up, or preempted while still runnable, to running. The
+// threads are recognized once they were switched out or created by one of
+// the processes, as the wakeups happen in the context of their waker. The
+// latency of every second is printed as it ends.
+func Program(target bpftrace.Target, duration time.Duration) string {
+	predicate := target.Predicate()
+
+	var b strings.Builder
+
+	b.WriteString("tracepoint:sched:sched_wakeup\n/@tids[args->pid]/\n{\n\t@queued[args->pid] = nsecs;\n}\n\n")
+
+	fmt.Fprintf(&b, "tracepoint:sched:sched_wakeup_new\n/%s/\n{\n", predicate)
+	b.WriteString("\t@tids[args->pid] = 1;\n\t@queued[args->pid] = nsecs;\n}\n\n")
+
+	b.WriteString("tracepoint:sched:sched_switch\n{\n")
+	fmt.Fprintf(&b, "\tif (%s) {\n", predicate)
+	b.WriteString("\t\t@tids[args->prev_pid] = 1;\n")
+	// preempted tasks are reported as running, or with a state above the
+	// ones of sleeping tasks
+	b.WriteString("\t\tif ((args->prev_state & 0xff) == 0) {\n\t\t\t@queued[args->prev_pid] = nsecs;\n\t\t}\n\t}\n\n")
+	b.WriteString("\tif (@queued[args->next_pid]) {\n")
+	b.WriteString("\t\t$ns = nsecs - @queued[args->next_pid];\n")
+	fmt.Fprintf(&b, "\t\t%s = hist($ns);\n", LatencyMap)
+	fmt.Fprintf(&b, "\t\t%s[args->next_comm] = hist($ns);\n", ThreadLatencyMap)
+	fmt.Fprintf(&b, "\t\t%s = hist($ns);\n", SecondLatencyMap)
+	b.WriteString("\t\tdelete(@queued[args->next_pid]);\n\t}\n}\n\n")
+
+	b.WriteString("tracepoint:sched:sched_process_exit\n/@tids[tid]/\n{\n\tdelete(@tids[tid]);\n\tdelete(@queued[tid]);\n}\n\n")
+
+	fmt.Fprintf(&b, "interval:s:1 {\n\tprint(%s);\n\tclear(%s);\n\tprintf(\"%s\\n\");\n}\n\n", SecondLatencyMap, SecondLatencyMap, tick)
+
+	fmt.Fprintf(&b, "interval:s:%d {\n\tclear(@tids);\n\tclear(@queued);\n\tclear(%s);\n\texit();\n}\n", bpftrace.Seconds(duration), SecondLatencyMap)
+
+	return b.String()
+}
+
+// Latency summarizes run queue latencies, durations are in nanoseconds.
+type Latency struct {
+	Count uint64 `json:"count"`
+	P50   int64  `json:"p50Ns"`
+	P99   int64  `json:"p99Ns"`
+	Max   int64  `json:"maxNs"`
+}
+
+func summarize(buckets []bpftrace.Bucket) Latency {
+	latency := Latency{
+		P50: bpftrace.Percentile(buckets, 50),
+		P99: bpftrace.Percentile(buckets, 99),
+		Max: bpftrace.Percentile(buckets, 100),
+	}
+	for _, bucket := range buckets {
+		latency.Count += bucket.Count
+	}
+	return latency
+}
+
+// Thread is the run queue latency of the threads of a name.
+type Thread struct {
+	Comm    string  `json:"comm"`
+	Latency Latency `json:"runQueue"`
+}
+
+// Second is the run queue latency and the throttling of a second.
+type Second struct {
+	Time     time.Time `json:"time"`
+	RunQueue Latency   `json:"runQueue"`
+	// Throttling is unset when cpu.stat wasn't read around the second.
+	Throttling *CpuStat `json:"throttling,omitempty"`
+
+	buckets []bpftrace.Bucket
+}
+
+// Collector is an output.Printer collecting the latency of every second as
+// it's printed, and the latency of the whole tracing once it ends.
+type Collector struct {
+	now     func() time.Time
+	pending []bpftrace.Bucket
+	seconds []Second
+	total   []bpftrace.Bucket
+	threads []Thread
+}
+
+func NewCollector() *Collector {
+	return &Collector{now: time.Now}
+}
+
+func (c *Collector) Print(record bpftrace.Record) error {
+	switch record.Type {
+	case bpftrace.TypePrintf:
+		if strings.TrimSpace(record.String()) == tick {
+			c.seconds = append(c.seconds, Second{Time: c.now(), RunQueue: summarize(c.pending), buckets: c.pending})
+			c.pending = nil
+		}
+		return nil
+	case bpftrace.TypeHist:
+	case bpftrace.TypeText:
+		log.Warn().Msg(record.String())
+		return nil
+	default:
+		return nil
+	}
+
+	maps, err := record.Maps()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range maps {
+		for _, key := range m.Keys() {
+			buckets, err := bpftrace.ParseBuckets(m.Entries[key])
+			if err != nil {
+				return err
+			}
+
+			switch m.Name {
+			case SecondLatencyMap:
+				c.pending = buckets
+			case LatencyMap:
+				c.total = buckets
+			case ThreadLatencyMap:
+				c.threads = append(c.threads, Thread{Comm: key, Latency: summarize(buckets)})
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Collector) Flush() error {
+	return nil
+}
+
+// Limit is the CPU limit of the container, from its spec and its cgroup.
+type Limit struct {
+	// Spec is the limit of the pod spec, e.g. 500m, empty when unset.
+	Spec  string `json:"spec,omitempty"`
+	Quota *Quota `json:"cgroup,omitempty"`
+}
+
+// Report correlates the run queue latency with the throttling of the
+// container.
+type Report struct {
+	Limit Limit `json:"limit"`
+	// Throttling is unset when cpu.stat couldn't be read.
+	Throttling *CpuStat `json:"throttling,omitempty"`
+	RunQueue   Latency  `json:"runQueue"`
+	// ThrottledRunQueue and UnthrottledRunQueue are the latency of the
+	// seconds the container was throttled in and of the others.
+	ThrottledRunQueue   Latency  `json:"throttledRunQueue"`
+	UnthrottledRunQueue Latency  `json:"unthrottledRunQueue"`
+	Threads             []Thread `json:"threads"`
+	Seconds             []Second `json:"seconds"`
+	// Explanation tells whether the CPU limit explains the latency.
+	Explanation string `json:"explanation"`
+}
+
+// Report correlates the latency collected with the samples of cpu.stat
+// taken while tracing, in the order they were taken.
+func (c *Collector) Report(limit Limit, samples []Sample) Report {
+	report := Report{
+		Limit:    limit,
+		RunQueue: summarize(c.total),
+		Threads:  c.threads,
+		Seconds:  c.seconds,
+	}
+
+	sort.Slice(report.Threads, func(i, j int) bool {
+		if report.Threads[i].Latency.P99 != report.Threads[j].Latency.P99 {
+			return report.Threads[i].Latency.P99 > report.Threads[j].Latency.P99
+		}
+		return report.Threads[i].Comm < report.Threads[j].Comm
+	})
+
+	if len(samples) >= 2 {
+		total := samples[len(samples)-1].Stat.Sub(samples[0].Stat)
+		report.Throttling = &total
+	}
+
+	var throttled, unthrottled []bpftrace.Bucket
+	for i := range report.Seconds {
+		second := &report.Seconds[i]
+
+		end, endOk := at(samples, second.Time)
+		start, startOk := at(samples, second.Time.Add(-time.Second))
+		if endOk && startOk {
+			throttling := end.Sub(start)
+			second.Throttling = &throttling
+		}
+
+		if second.Throttling != nil && second.Throttling.Throttled > 0 {
+			throttled = mergeBuckets(throttled, second.buckets)
+		} else {
+			unthrottled = mergeBuckets(unthrottled, second.buckets)
+		}
+	}
+
+	report.ThrottledRunQueue = summarize(throttled)
+	report.UnthrottledRunQueue = summarize(unthrottled)
+	report.Explanation = explain(report)
+
+	return report
+}
+
+// explain compares the latency of the seconds the container was throttled
+// in with the one of the others.
+func explain(report Report) string {
+	throttled, unthrottled := report.ThrottledRunQueue, report.UnthrottledRunQueue
+
+	switch {
+	case report.Throttling == nil:
+		return "the throttling of the container is unknown, cpu.stat couldn't be read"
+	case report.Throttling.Throttled == 0:
+		if report.Limit.Quota != nil && report.Limit.Quota.Quota < 0 {
+			return "the container has no CPU limit, run queue latency comes from contention for the CPUs of the node"
+		}
+		return "the container wasn't throttled, run queue latency comes from contention for the CPUs of the node rather than its CPU limit"
+	case throttled.Count == 0:
+		return "the container was throttled but its threads didn't wait for a CPU meanwhile"
+	case unthrottled.Count == 0 || throttled.P99 >= 2*unthrottled.P99:
+		return fmt.Sprintf("the CPU limit causes the latency spikes: p99 run queue latency is %s while throttled against %s otherwise, "+
+			"consider raising the limit or reducing the CPU usage", duration(throttled.P99), duration(unthrottled.P99))
+	default:
+		return fmt.Sprintf("the container was throttled but p99 run queue latency is similar while throttled, %s, and otherwise, %s: "+
+			"the CPU limit doesn't explain the latency", duration(throttled.P99), duration(unthrottled.P99))
+	}
+}
+
+// mergeBuckets adds up the counts of the buckets of two histograms.
+func mergeBuckets(a []bpftrace.Bucket, b []bpftrace.Bucket) []bpftrace.Bucket {
+	bound := func(value *int64) int64 {
+		if value == nil {
+			return math.MinInt64
+		}
+		return *value
+	}
+
+	merged := append([]bpftrace.Bucket{}, a...)
+	for _, bucket := range b {
+		found := false
+		for i := range merged {
+			if bound(merged[i].Min) == bound(bucket.Min) && bound(merged[i].Max) == bound(bucket.Max) {
+				merged[i].Count += bucket.Count
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, bucket)
+		}
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		return bound(merged[i].Min) < bound(merged[j].Min)
+	})
+
+	return merged
+}
+
+// Print writes the report as text, with the latency and throttling of every
+// second when timeline is set, or as JSON for the json format.
+func Print(w io.Writer, format string, report Report, timeline bool) error {
+	if format == output.FormatJson {
+		return json.NewEncoder(w).Encode(report)
+	}
+
+	limit := "none"
+	if report.Limit.Spec != "" {
+		limit = report.Limit.Spec
+	}
+	if quota := report.Limit.Quota; quota != nil {
+		if quota.Quota >= 0 {
+			limit += fmt.Sprintf(" (cgroup quota: %s per %s period, %.2f CPUs)", quota.Quota, quota.Period, quota.Cpus())
+		} else {
+			limit += " (cgroup quota: none)"
+		}
+	}
+	if _, err := fmt.Fprintf(w, "CPU limit: %s\n", limit); err != nil {
+		return err
+	}
+
+	if throttling := report.Throttling; throttling != nil {
+		ratio := 0.0
+		if throttling.Periods > 0 {
+			ratio = float64(throttling.Throttled) / float64(throttling.Periods) * 100
+		}
+		if _, err := fmt.Fprintf(w, "Throttled: %d of %d periods (%.1f%%), for %s\n",
+			throttling.Throttled, throttling.Periods, ratio, throttling.ThrottledTime); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "Diagnosis: %s\n\n", report.Explanation); err != nil {
+		return err
+	}
+
+	rows := [][]string{
+		latencyRow("all", report.RunQueue),
+		latencyRow("throttled seconds", report.ThrottledRunQueue),
+		latencyRow("unthrottled seconds", report.UnthrottledRunQueue),
+	}
+	if err := output.WriteTable(w, []string{"RUN QUEUE", "WAKEUPS", "P50", "P99", "MAX"}, rows); err != nil {
+		return err
+	}
+
+	if len(report.Threads) > 0 {
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+
+		rows = rows[:0]
+		for _, thread := range report.Threads {
+			rows = append(rows, latencyRow(thread.Comm, thread.Latency))
+		}
+		if err := output.WriteTable(w, []string{"THREAD", "WAKEUPS", "P50", "P99", "MAX"}, rows); err != nil {
+			return err
+		}
+	}
+
+	if !timeline || len(report.Seconds) == 0 {
+		return nil
+	}
+
+	if _, err := fmt.Fprintln(w); err != nil {
+		return err
+	}
+
+	rows = rows[:0]
+	for _, second := range report.Seconds {
+		throttled, throttledTime := "-", "-"
+		if second.Throttling != nil {
+			throttled = fmt.Sprintf("%d/%d", second.Throttling.Throttled, second.Throttling.Periods)
+			throttledTime = second.Throttling.ThrottledTime.String()
+		}
+
+		rows = append(rows, []string{
+			second.Time.Format("15:04:05"),
+			strconv.FormatUint(second.RunQueue.Count, 10),
+			duration(second.RunQueue.P99),
+			duration(second.RunQueue.Max),
+			throttled,
+			throttledTime,
+		})
+	}
+
+	return output.WriteTable(w, []string{"TIME", "WAKEUPS", "P99", "MAX", "THROTTLED", "THROTTLED TIME"}, rows)
+}
+
+func latencyRow(name string, latency Latency) []string {
+	return []string{
+		name,
+		strconv.FormatUint(latency.Count, 10),
+		duration(latency.P50),
+		duration(latency.P99),
+		duration(latency.Max),
+	}
+}
+
+// duration rounds latencies to tens of microseconds past a millisecond, as
+// throttling delays tasks for milliseconds.
+func duration(ns int64) string {
+	d := time.Duration(ns)
+	if d >= time.Millisecond {
+		return d.Round(10 * time.Microsecond).String()
+	}
+	return d.Round(time.Microsecond / 10).String()
+}
@@ -0,0 +1,178 @@
+package sched
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgram(t *testing.T) {
+	// when
+	program := Program(bpftrace.Target{Pids: []string{"4123", "4130"}}, 30*time.Second)
+
+	// then
+	assert.Contains(t, program, "tracepoint:sched:sched_wakeup\n/@tids[args->pid]/")
+	assert.Contains(t, program, "tracepoint:sched:sched_wakeup_new\n/pid == 4123 || pid == 4130/")
+	assert.Contains(t, program, "\tif (pid == 4123 || pid == 4130) {\n\t\t@tids[args->prev_pid] = 1;")
+	assert.Contains(t, program, "if ((args->prev_state & 0xff) == 0) {")
+	assert.Contains(t, program, "@runq_comm_ns[args->next_comm] = hist($ns);")
+	assert.Contains(t, program, "interval:s:1 {\n\tprint(@second_ns);\n\tclear(@second_ns);\n\tprintf(\"tick\\n\");\n}")
+	assert.Contains(t, program, "interval:s:30 {")
+
+	// when
+	program = Program(bpftrace.Target{Cgroup: "/host/sys/fs/cgroup/kubepods/pod1/3f2c", Pids: []string{"4123"}}, 30*time.Second)
+
+	// then
+	assert.Contains(t, program, "tracepoint:sched:sched_wakeup_new\n/cgroup == cgroupid(\"/host/sys/fs/cgroup/kubepods/pod1/3f2c\")/")
+	assert.NotContains(t, program, "pid == 4123")
+}
+
+func TestParseV1Cgroup(t *testing.T) {
+	// when
+	cgroup, err := ParseV1Cgroup([]byte("12:memory:/kubepods/burstable/pod1/3f2c\n4:cpu,cpuacct:/kubepods/burstable/pod1/3f2c\n"))
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "/host/sys/fs/cgroup/cpu,cpuacct/kubepods/burstable/pod1/3f2c/cpu.stat", cgroup.StatPath())
+	assert.Len(t, cgroup.QuotaPaths(), 2)
+
+	// when
+	v2 := Cgroup{Dir: "/host/sys/fs/cgroup/kubepods.slice/kubepods-burstable.slice/cri-containerd-3f2c.scope", V2: true}
+
+	// then
+	assert.Equal(t, "/host/sys/fs/cgroup/kubepods.slice/kubepods-burstable.slice/cri-containerd-3f2c.scope/cpu.stat", v2.StatPath())
+	assert.Equal(t, []string{"/host/sys/fs/cgroup/kubepods.slice/kubepods-burstable.slice/cri-containerd-3f2c.scope/cpu.max"}, v2.QuotaPaths())
+
+	// when
+	_, errV2 := ParseV1Cgroup([]byte("0::/kubepods.slice/kubepods-burstable.slice/cri-containerd-3f2c.scope\n"))
+	_, errOutside := ParseV1Cgroup([]byte("4:cpu,cpuacct:/../../kubepods/burstable/pod1/3f2c\n"))
+
+	// then
+	assert.Error(t, errV2)
+	assert.Error(t, errOutside)
+}
+
+func TestParseCpuStat(t *testing.T) {
+	// when
+	v2, err := ParseCpuStat([]byte("usage_usec 1000\nnr_periods 300\nnr_throttled 120\nthrottled_usec 12300000\n"))
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, CpuStat{Periods: 300, Throttled: 120, ThrottledTime: 12300 * time.Millisecond}, v2)
+
+	// when
+	v1, err := ParseCpuStat([]byte("nr_periods 10\nnr_throttled 2\nthrottled_time 5000000\n"))
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, CpuStat{Periods: 10, Throttled: 2, ThrottledTime: 5 * time.Millisecond}, v1)
+	assert.Equal(t, CpuStat{Periods: 290, Throttled: 118, ThrottledTime: 12295 * time.Millisecond}, v2.Sub(v1))
+}
+
+func TestParseQuota(t *testing.T) {
+	// when
+	quota, err := ParseQuota([]byte("50000 100000\n"))
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, Quota{Quota: 50 * time.Millisecond, Period: 100 * time.Millisecond}, quota)
+	assert.Equal(t, 0.5, quota.Cpus())
+
+	// when
+	quota, err = ParseQuota([]byte("-1\n"), []byte("100000\n"))
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(-1), quota.Quota)
+	assert.Equal(t, 0.0, quota.Cpus())
+
+	// when
+	_, err = ParseQuota([]byte("max"))
+
+	// then
+	assert.EqualError(t, err, "malformed cpu quota: 'max'")
+}
+
+func TestCollectorReport(t *testing.T) {
+	// given
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	collector := NewCollector()
+	collector.now = func() time.Time { return now }
+
+	records := []string{
+		`{"type": "hist", "data": {"@second_ns": [{"min": 4096, "max": 8191, "count": 100}]}}`,
+		`{"type": "printf", "data": "tick\n"}`,
+		`{"type": "hist", "data": {"@second_ns": [{"min": 4096, "max": 8191, "count": 50}, {"min": 33554432, "max": 67108863, "count": 10}]}}`,
+		`{"type": "printf", "data": "tick\n"}`,
+		`{"type": "hist", "data": {"@runq_ns": [{"min": 4096, "max": 8191, "count": 150}, {"min": 33554432, "max": 67108863, "count": 10}]}}`,
+		`{"type": "hist", "data": {"@runq_comm_ns": {"worker": [{"min": 33554432, "max": 67108863, "count": 10}], "api": [{"min": 4096, "max": 8191, "count": 150}]}}}`,
+	}
+	for _, record := range records {
+		now = now.Add(time.Second)
+		assert.NoError(t, collector.Print(bpftrace.ParseRecord([]byte(record))))
+	}
+
+	samples := []Sample{
+		{Time: start, Stat: CpuStat{Periods: 100, Throttled: 5, ThrottledTime: time.Second}},
+		{Time: start.Add(2 * time.Second), Stat: CpuStat{Periods: 110, Throttled: 5, ThrottledTime: time.Second}},
+		{Time: start.Add(4 * time.Second), Stat: CpuStat{Periods: 120, Throttled: 9, ThrottledTime: 1200 * time.Millisecond}},
+	}
+	limit := Limit{Spec: "500m", Quota: &Quota{Quota: 50 * time.Millisecond, Period: 100 * time.Millisecond}}
+
+	// when
+	report := collector.Report(limit, samples)
+
+	// then
+	assert.Equal(t, &CpuStat{Periods: 20, Throttled: 4, ThrottledTime: 200 * time.Millisecond}, report.Throttling)
+	assert.Len(t, report.Seconds, 2)
+	assert.Equal(t, &CpuStat{Periods: 10}, report.Seconds[0].Throttling)
+	assert.Equal(t, &CpuStat{Periods: 10, Throttled: 4, ThrottledTime: 200 * time.Millisecond}, report.Seconds[1].Throttling)
+	assert.Equal(t, uint64(60), report.ThrottledRunQueue.Count)
+	assert.Equal(t, uint64(100), report.UnthrottledRunQueue.Count)
+	assert.Equal(t, "worker", report.Threads[0].Comm)
+
+	// when
+	var buf bytes.Buffer
+	err := Print(&buf, output.FormatText, report, true)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, ""+
+		"CPU limit: 500m (cgroup quota: 50ms per 100ms period, 0.50 CPUs)\n"+
+		"Throttled: 4 of 20 periods (20.0%), for 200ms\n"+
+		"Diagnosis: the CPU limit causes the latency spikes: p99 run queue latency is 65.1ms while throttled against 8.2µs otherwise, "+
+		"consider raising the limit or reducing the CPU usage\n"+
+		"\n"+
+		"RUN QUEUE            WAKEUPS  P50    P99      MAX\n"+
+		"all                  160      6.3µs  61.74ms  67.11ms\n"+
+		"throttled seconds    60       6.6µs  65.1ms   67.11ms\n"+
+		"unthrottled seconds  100      6.1µs  8.2µs    8.2µs\n"+
+		"\n"+
+		"THREAD  WAKEUPS  P50      P99      MAX\n"+
+		"worker  10       50.33ms  66.77ms  67.11ms\n"+
+		"api     150      6.1µs    8.2µs    8.2µs\n"+
+		"\n"+
+		"TIME      WAKEUPS  P99     MAX      THROTTLED  THROTTLED TIME\n"+
+		"12:00:02  100      8.2µs   8.2µs    0/10       0s\n"+
+		"12:00:04  60       65.1ms  67.11ms  4/10       200ms\n",
+		buf.String())
+}
+
+func TestMergeBuckets(t *testing.T) {
+	// given
+	low, high := int64(1024), int64(2047)
+	a := []bpftrace.Bucket{{Min: &low, Max: &high, Count: 2}}
+	b := []bpftrace.Bucket{{Max: &low, Count: 1}, {Min: &low, Max: &high, Count: 3}}
+
+	// when
+	merged := mergeBuckets(a, b)
+
+	// then
+	assert.Equal(t, []bpftrace.Bucket{{Max: &low, Count: 1}, {Min: &low, Max: &high, Count: 5}}, merged)
+	assert.Equal(t, uint64(2), a[0].Count)
+}
@@ -0,0 +1,136 @@
+package signals
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	// realtimeSignals is the first real-time signal of the kernel, the
+	// first two being reserved by the C library.
+	realtimeSignals = 32
+	// sigrtmin is the first real-time signal left to applications.
+	sigrtmin  = 34
+	maxSignal = 64
+)
+
+// signalNames maps the Linux signals of amd64 and arm64 to their names.
+var signalNames = map[int]string{
+	1:  "SIGHUP",
+	2:  "SIGINT",
+	3:  "SIGQUIT",
+	4:  "SIGILL",
+	5:  "SIGTRAP",
+	6:  "SIGABRT",
+	7:  "SIGBUS",
+	8:  "SIGFPE",
+	9:  "SIGKILL",
+	10: "SIGUSR1",
+	11: "SIGSEGV",
+	12: "SIGUSR2",
+	13: "SIGPIPE",
+	14: "SIGALRM",
+	15: "SIGTERM",
+	16: "SIGSTKFLT",
+	17: "SIGCHLD",
+	18: "SIGCONT",
+	19: "SIGSTOP",
+	20: "SIGTSTP",
+	21: "SIGTTIN",
+	22: "SIGTTOU",
+	23: "SIGURG",
+	24: "SIGXCPU",
+	25: "SIGXFSZ",
+	26: "SIGVTALRM",
+	27: "SIGPROF",
+	28: "SIGWINCH",
+	29: "SIGIO",
+	30: "SIGPWR",
+	31: "SIGSYS",
+}
+
+// fatalSignals terminate a process unless handled, the ones dumping its
+// core map to true. Real-time signals are fatal too.
+var fatalSignals = map[int]bool{
+	1: false, 2: false, 3: true, 4: true, 5: true, 6: true, 7: true, 8: true, 9: false, 10: false, 11: true,
+	12: false, 13: false, 14: false, 15: false, 16: false, 24: true, 25: true, 26: false, 27: false, 29: false,
+	30: false, 31: true,
+}
+
+// codeNames maps the si_code of the signals sent by processes, the same for
+// every signal.
+var codeNames = map[int]string{
+	0x80: "SI_KERNEL",
+	0:    "SI_USER",
+	-1:   "SI_QUEUE",
+	-2:   "SI_TIMER",
+	-3:   "SI_MESGQ",
+	-4:   "SI_ASYNCIO",
+	-5:   "SI_SIGIO",
+	-6:   "SI_TKILL",
+}
+
+// faultCodeNames maps the si_code of the signals raised by faults, by
+// signal.
+var faultCodeNames = map[int]map[int]string{
+	4: {1: "ILL_ILLOPC", 2: "ILL_ILLOPN", 3: "ILL_ILLADR", 4: "ILL_ILLTRP", 5: "ILL_PRVOPC", 6: "ILL_PRVREG",
+		7: "ILL_COPROC", 8: "ILL_BADSTK"},
+	7: {1: "BUS_ADRALN", 2: "BUS_ADRERR", 3: "BUS_OBJERR", 4: "BUS_MCEERR_AR", 5: "BUS_MCEERR_AO"},
+	8: {1: "FPE_INTDIV", 2: "FPE_INTOVF", 3: "FPE_FLTDIV", 4: "FPE_FLTOVF", 5: "FPE_FLTUND", 6: "FPE_FLTRES",
+		7: "FPE_FLTINV", 8: "FPE_FLTSUB"},
+	11: {1: "SEGV_MAPERR", 2: "SEGV_ACCERR", 3: "SEGV_BNDERR", 4: "SEGV_PKUERR"},
+}
+
+// Name returns the name of a signal, e.g. SIGTERM.
+func Name(signal int) string {
+	if name, ok := signalNames[signal]; ok {
+		return name
+	}
+	if signal >= sigrtmin && signal <= maxSignal {
+		return "SIGRTMIN+" + strconv.Itoa(signal-sigrtmin)
+	}
+	return "signal " + strconv.Itoa(signal)
+}
+
+// Number returns the signal of a name, with or without the SIG prefix, or
+// of a number.
+func Number(name string) (int, bool) {
+	if signal, err := strconv.Atoi(name); err == nil {
+		return signal, signal > 0 && signal <= maxSignal
+	}
+
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	for signal, signalName := range signalNames {
+		if signalName == name {
+			return signal, true
+		}
+	}
+	return 0, false
+}
+
+// CodeName returns the name of the si_code of a signal, e.g. SEGV_MAPERR.
+func CodeName(signal int, code int) string {
+	if code > 0 && code < 0x80 {
+		if name, ok := faultCodeNames[signal][code]; ok {
+			return name
+		}
+	} else if name, ok := codeNames[code]; ok {
+		return name
+	}
+	return "code " + strconv.Itoa(code)
+}
+
+// IsFatal tells whether a signal terminates a process unless handled.
+func IsFatal(signal int) bool {
+	_, ok := fatalSignals[signal]
+	return ok || signal >= realtimeSignals && signal <= maxSignal
+}
+
+// DumpsCore tells whether a signal dumps the core of a process unless
+// handled.
+func DumpsCore(signal int) bool {
+	return fatalSignals[signal]
+}
@@ -0,0 +1,331 @@
+// Package signals traces the signals sent to the processes of a container
+// and delivered to them, with their senders, and the faulting address and
+// user stack of the signals killing them.
+package signals
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// Event types.
+const (
+	// EventGenerate is a signal sent to a process, by another process, the
+	// process itself or the kernel.
+	EventGenerate = "generate"
+	// EventDeliver is a signal delivered to a thread, run by its handler.
+	EventDeliver = "deliver"
+	// EventFatal is a signal delivered to a thread killing its process.
+	EventFatal = "fatal"
+)
+
+// Results of the generation of a signal, enum trace_signal_generate_result.
+var results = []string{"delivered", "ignored", "already pending", "queue overflow", "info lost"}
+
+// DefaultIgnored are signals left out by default, sent all the time: the
+// exits of child processes and the preemption of goroutines by Go's runtime.
+var DefaultIgnored = []string{"SIGCHLD", "SIGURG"}
+
+// Program traces the signals sent to the threads of the processes, whether
+// they were running when tracing began or were created since, and their
+// delivery, the user stack and the faulting address of fatal ones, signals
+// ignored left out. Faults are recognized as the kernel forces their signal
+// on the faulting thread.
+func Program(target bpftrace.Target, ignored []int, duration time.Duration) string {
+	var b strings.Builder
+
+	b.WriteString("BEGIN\n{\n")
+	for _, pid := range target.Pids {
+		fmt.Fprintf(&b, "\t@tids[%s] = 1;\n", pid)
+	}
+	b.WriteString("}\n\n")
+
+	// the threads already running are recognized once switched out
+	fmt.Fprintf(&b, "tracepoint:sched:sched_switch\n/%s/\n{\n\t@tids[args->prev_pid] = 1;\n}\n\n", target.Predicate())
+	b.WriteString("tracepoint:sched:sched_process_fork\n/@tids[tid]/\n{\n\t@tids[args->child_pid] = 1;\n}\n\n")
+
+	b.WriteString("kprobe:force_sig_fault\n/@tids[tid]/\n{\n\t@faults[tid] = 1;\n\t@fault_addr[tid] = arg2;\n}\n\n")
+
+	traced := func(tid string) string {
+		predicate := fmt.Sprintf("@tids[%s]", tid)
+		for _, signal := range ignored {
+			predicate += fmt.Sprintf(" && args->sig != %d", signal)
+		}
+		return predicate
+	}
+
+	fmt.Fprintf(&b, "tracepoint:signal:signal_generate\n/%s/\n{\n", traced("args->pid"))
+	b.WriteString("\tprintf(\"generate\\t%d\\t%d\\t%d\\t%s\\t%d\\t%d\\t%d\\t%d\\t%s\\t%d\\n\",\n")
+	b.WriteString("\t\targs->sig, args->code, args->pid, args->comm, args->group, args->result, pid, tid, comm, cgroup);\n}\n\n")
+
+	fmt.Fprintf(&b, "tracepoint:signal:signal_deliver\n/%s/\n{\n", traced("tid"))
+	// the default action of fatal signals kills the process
+	fmt.Fprintf(&b, "\tif (args->sa_handler == 0 && (%s)) {\n", fatalPredicate())
+	b.WriteString("\t\tprintf(\"fatal\\t%d\\t%d\\t%d\\t%d\\t%s\\t%d\\t%lu\\t%s\\n\",\n")
+	b.WriteString("\t\t\targs->sig, args->code, pid, tid, comm, @faults[tid], @fault_addr[tid], ustack);\n")
+	b.WriteString("\t} else {\n")
+	b.WriteString("\t\tprintf(\"deliver\\t%d\\t%d\\t%d\\t%d\\t%s\\t%lu\\n\",\n")
+	b.WriteString("\t\t\targs->sig, args->code, pid, tid, comm, args->sa_handler);\n")
+	b.WriteString("\t}\n\tdelete(@faults[tid]);\n\tdelete(@fault_addr[tid]);\n}\n\n")
+
+	b.WriteString("tracepoint:sched:sched_process_exit\n/@tids[tid]/\n{\n\tdelete(@tids[tid]);\n\tdelete(@faults[tid]);\n\tdelete(@fault_addr[tid]);\n}\n\n")
+
+	fmt.Fprintf(&b, "interval:s:%d {\n\tclear(@tids);\n\tclear(@faults);\n\tclear(@fault_addr);\n\texit();\n}\n", bpftrace.Seconds(duration))
+
+	return b.String()
+}
+
+func fatalPredicate() string {
+	var fatal []int
+	for signal := range fatalSignals {
+		fatal = append(fatal, signal)
+	}
+	sort.Ints(fatal)
+
+	conditions := make([]string, 0, len(fatal)+1)
+	for _, signal := range fatal {
+		conditions = append(conditions, fmt.Sprintf("args->sig == %d", signal))
+	}
+	conditions = append(conditions, fmt.Sprintf("args->sig >= %d", realtimeSignals))
+
+	return strings.Join(conditions, " || ")
+}
+
+// Sender is the process a signal was sent from, the target itself for the
+// signals raised by its faults.
+type Sender struct {
+	Pid  int    `json:"pid"`
+	Tid  int    `json:"tid"`
+	Comm string `json:"comm"`
+	// Cgroup is the cgroup v2 id of the sender, Workload the container
+	// it's from, unset outside of pods.
+	Cgroup   string `json:"cgroup"`
+	Workload string `json:"workload,omitempty"`
+}
+
+// Event is a signal sent to a process of the container or delivered to one
+// of its threads.
+type Event struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Signal string    `json:"signal"`
+	Code   string    `json:"code"`
+	// Pid is the process of the thread, unknown when the signal is sent
+	// and then 0.
+	Pid  int    `json:"pid,omitempty"`
+	Tid  int    `json:"tid"`
+	Comm string `json:"comm"`
+
+	// Group tells whether the signal was sent to the whole process rather
+	// than to the thread, Result what became of it.
+	Group  bool    `json:"group,omitempty"`
+	Result string  `json:"result,omitempty"`
+	Sender *Sender `json:"sender,omitempty"`
+
+	// Handler is how the thread handles the signal once delivered:
+	// default, ignore or the address of its handler.
+	Handler string `json:"handler,omitempty"`
+
+	// FaultAddress is the address whose access raised a fatal signal,
+	// CoreDump whether the signal dumps the core of the process and Stack
+	// the user stack of the thread.
+	FaultAddress string   `json:"faultAddress,omitempty"`
+	CoreDump     bool     `json:"coreDump,omitempty"`
+	Stack        []string `json:"stack,omitempty"`
+}
+
+// ParseEvent parses a line printed by the program, its fields separated by
+// tabs, the stack of fatal signals last.
+func ParseEvent(line string) (Event, error) {
+	line = strings.TrimSuffix(line, "\n")
+
+	eventType := strings.SplitN(line, "\t", 2)[0]
+	counts := map[string]int{EventGenerate: 11, EventDeliver: 7, EventFatal: 9}
+
+	count, ok := counts[eventType]
+	if !ok {
+		return Event{}, errors.Errorf("unknown signal event: '%s'", line)
+	}
+
+	fields := strings.SplitN(line, "\t", count)
+	if len(fields) != count {
+		return Event{}, errors.Errorf("malformed %s event: '%s'", eventType, line)
+	}
+
+	var numbers []int64
+	numberFields := map[string][]int{
+		EventGenerate: {1, 2, 3, 5, 6, 7, 8},
+		EventDeliver:  {1, 2, 3, 4},
+		EventFatal:    {1, 2, 3, 4, 6},
+	}
+	for _, i := range numberFields[eventType] {
+		value, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil {
+			return Event{}, errors.Wrapf(err, "malformed %s event field: '%s'", eventType, fields[i])
+		}
+		numbers = append(numbers, value)
+	}
+
+	signal, code := int(numbers[0]), int(numbers[1])
+	event := Event{Type: eventType, Signal: Name(signal), Code: CodeName(signal, code)}
+
+	switch eventType {
+	case EventGenerate:
+		event.Tid = int(numbers[2])
+		event.Comm = fields[4]
+		event.Group = numbers[3] != 0
+		event.Result = result(numbers[4])
+		event.Sender = &Sender{Pid: int(numbers[5]), Tid: int(numbers[6]), Comm: fields[9], Cgroup: fields[10]}
+	case EventDeliver:
+		event.Pid, event.Tid = int(numbers[2]), int(numbers[3])
+		event.Comm = fields[5]
+		event.Handler = handler(fields[6])
+	case EventFatal:
+		event.Pid, event.Tid = int(numbers[2]), int(numbers[3])
+		event.Comm = fields[5]
+		if numbers[4] != 0 {
+			address, err := strconv.ParseUint(fields[7], 10, 64)
+			if err != nil {
+				return Event{}, errors.Wrapf(err, "malformed fatal event field: '%s'", fields[7])
+			}
+			event.FaultAddress = fmt.Sprintf("0x%x", address)
+		}
+		event.CoreDump = DumpsCore(signal)
+		event.Stack = ParseStack(fields[8])
+	}
+
+	return event, nil
+}
+
+func result(value int64) string {
+	if value >= 0 && value < int64(len(results)) {
+		return results[value]
+	}
+	return "result " + strconv.FormatInt(value, 10)
+}
+
+// handler describes the sa_handler of a signal: SIG_DFL, SIG_IGN or the
+// address of a function.
+func handler(value string) string {
+	switch value {
+	case "0":
+		return "default"
+	case "1":
+		return "ignore"
+	}
+
+	address, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return value
+	}
+	return fmt.Sprintf("0x%x", address)
+}
+
+// ParseStack returns the frames of a stack printed by bpftrace, one per
+// line, innermost first.
+func ParseStack(stack string) []string {
+	var frames []string
+	for _, frame := range strings.Split(stack, "\n") {
+		if frame = strings.TrimSpace(frame); frame != "" {
+			frames = append(frames, frame)
+		}
+	}
+	return frames
+}
+
+// String formats the event as text, the stack of fatal signals on the lines
+// following it.
+func (e Event) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s %-8s %-9s ", e.Time.Format("15:04:05"), e.Type, e.Signal)
+
+	switch e.Type {
+	case EventGenerate:
+		target := "thread"
+		if e.Group {
+			target = "process"
+		}
+		fmt.Fprintf(&b, "to %s %d (%s) from %s, %s, %s", target, e.Tid, e.Comm, e.Sender, e.Code, e.Result)
+	case EventDeliver:
+		fmt.Fprintf(&b, "to %d/%d (%s), %s, handler %s", e.Pid, e.Tid, e.Comm, e.Code, e.Handler)
+	case EventFatal:
+		fmt.Fprintf(&b, "to %d/%d (%s), %s", e.Pid, e.Tid, e.Comm, e.Code)
+		if e.FaultAddress != "" {
+			fmt.Fprintf(&b, " at %s", e.FaultAddress)
+		}
+		if e.CoreDump {
+			b.WriteString(", dumping core")
+		}
+		for _, frame := range e.Stack {
+			fmt.Fprintf(&b, "\n    %s", frame)
+		}
+	}
+
+	return b.String()
+}
+
+func (s *Sender) String() string {
+	workload := s.Workload
+	if workload == "" {
+		workload = "outside of pods"
+	}
+	return fmt.Sprintf("%d/%d (%s) [%s]", s.Pid, s.Tid, s.Comm, workload)
+}
+
+// WorkloadFunc resolves the container of a cgroup id to its workload.
+type WorkloadFunc func(cgroup string) (string, bool)
+
+// Printer is an output.Printer printing the signal events as they come.
+type Printer struct {
+	w        io.Writer
+	format   string
+	workload WorkloadFunc
+	now      func() time.Time
+}
+
+func NewPrinter(w io.Writer, format string, workload WorkloadFunc) *Printer {
+	return &Printer{w: w, format: format, workload: workload, now: time.Now}
+}
+
+func (p *Printer) Print(record bpftrace.Record) error {
+	switch record.Type {
+	case bpftrace.TypePrintf:
+	case bpftrace.TypeText, bpftrace.TypeLostEvents:
+		log.Warn().Msg(record.String())
+		return nil
+	default:
+		return nil
+	}
+
+	event, err := ParseEvent(record.String())
+	if err != nil {
+		return err
+	}
+
+	event.Time = p.now()
+	if event.Sender != nil && p.workload != nil {
+		event.Sender.Workload, _ = p.workload(event.Sender.Cgroup)
+	}
+
+	if p.format == output.FormatJson {
+		return json.NewEncoder(p.w).Encode(event)
+	}
+
+	_, err = fmt.Fprintln(p.w, event.String())
+	return err
+}
+
+func (p *Printer) Flush() error {
+	return nil
+}
@@ -0,0 +1,175 @@
+package signals
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/alam0rt/kubectl-doktor/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNames(t *testing.T) {
+	assert.Equal(t, "SIGSEGV", Name(11))
+	assert.Equal(t, "SIGRTMIN+2", Name(36))
+	assert.Equal(t, "signal 70", Name(70))
+
+	for _, name := range []string{"SIGTERM", "term", "15"} {
+		signal, ok := Number(name)
+		assert.True(t, ok)
+		assert.Equal(t, 15, signal)
+	}
+	_, ok := Number("SIGFOO")
+	assert.False(t, ok)
+	_, ok = Number("65")
+	assert.False(t, ok)
+
+	assert.Equal(t, "SEGV_MAPERR", CodeName(11, 1))
+	assert.Equal(t, "SI_USER", CodeName(15, 0))
+	assert.Equal(t, "SI_TKILL", CodeName(6, -6))
+	assert.Equal(t, "SI_KERNEL", CodeName(9, 0x80))
+	assert.Equal(t, "code 3", CodeName(15, 3))
+
+	assert.True(t, IsFatal(9))
+	assert.True(t, IsFatal(40))
+	assert.False(t, IsFatal(17))
+	assert.True(t, DumpsCore(11))
+	assert.False(t, DumpsCore(15))
+}
+
+func TestProgram(t *testing.T) {
+	// when
+	program := Program(bpftrace.Target{Pids: []string{"4123", "4200"}}, []int{17, 23}, 10*time.Minute)
+
+	// then
+	assert.Contains(t, program, "BEGIN\n{\n\t@tids[4123] = 1;\n\t@tids[4200] = 1;\n}")
+	assert.Contains(t, program, "tracepoint:sched:sched_switch\n/pid == 4123 || pid == 4200/\n{\n\t@tids[args->prev_pid] = 1;")
+	assert.Contains(t, program, "tracepoint:sched:sched_process_fork\n/@tids[tid]/\n{\n\t@tids[args->child_pid] = 1;")
+	assert.Contains(t, program, "kprobe:force_sig_fault\n/@tids[tid]/\n{\n\t@faults[tid] = 1;\n\t@fault_addr[tid] = arg2;")
+	assert.Contains(t, program, "tracepoint:signal:signal_generate\n/@tids[args->pid] && args->sig != 17 && args->sig != 23/")
+	assert.Contains(t, program, "tracepoint:signal:signal_deliver\n/@tids[tid] && args->sig != 17 && args->sig != 23/")
+	assert.Contains(t, program, "if (args->sa_handler == 0 && (args->sig == 1 || args->sig == 2 || args->sig == 3 ||")
+	assert.Contains(t, program, "args->sig == 31 || args->sig >= 32)) {")
+	assert.Contains(t, program, "@faults[tid], @fault_addr[tid], ustack);")
+	assert.Contains(t, program, "interval:s:600 {")
+}
+
+func TestParseEvent(t *testing.T) {
+	// when
+	generate, err := ParseEvent("generate\t15\t0\t4123\tjava\t1\t0\t812\t812\tcontainerd-shim\t1024")
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, Event{
+		Type:   EventGenerate,
+		Signal: "SIGTERM",
+		Code:   "SI_USER",
+		Tid:    4123,
+		Comm:   "java",
+		Group:  true,
+		Result: "delivered",
+		Sender: &Sender{Pid: 812, Tid: 812, Comm: "containerd-shim", Cgroup: "1024"},
+	}, generate)
+
+	// when
+	deliver, err := ParseEvent("deliver\t15\t0\t4123\t4123\tjava\t94557999988736")
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "0x560000000000", deliver.Handler)
+
+	// when
+	fatal, err := ParseEvent("fatal\t11\t1\t4123\t4130\tjava\t1\t0\t\n\tcrash+12 (/app/server)\n\tmain+40 (/app/server)\n")
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, Event{
+		Type:         EventFatal,
+		Signal:       "SIGSEGV",
+		Code:         "SEGV_MAPERR",
+		Pid:          4123,
+		Tid:          4130,
+		Comm:         "java",
+		FaultAddress: "0x0",
+		CoreDump:     true,
+		Stack:        []string{"crash+12 (/app/server)", "main+40 (/app/server)"},
+	}, fatal)
+
+	// when
+	fatal, err = ParseEvent("fatal\t9\t0\t4123\t4123\tjava\t0\t0\t")
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "", fatal.FaultAddress)
+	assert.False(t, fatal.CoreDump)
+	assert.Nil(t, fatal.Stack)
+
+	// when
+	_, err = ParseEvent("deliver\t15\t0")
+
+	// then
+	assert.EqualError(t, err, "malformed deliver event: 'deliver\t15\t0'")
+
+	// when
+	_, err = ParseEvent("deliver\tx\t0\t1\t1\tsh\t0")
+
+	// then
+	assert.Error(t, err)
+
+	// when
+	_, err = ParseEvent("queue\t15")
+
+	// then
+	assert.EqualError(t, err, "unknown signal event: 'queue\t15'")
+}
+
+func TestPrinter(t *testing.T) {
+	// given
+	var buf bytes.Buffer
+	printer := NewPrinter(&buf, output.FormatText, func(cgroup string) (string, bool) {
+		if cgroup == "8812" {
+			return testutil.Workload, true
+		}
+		return "", false
+	})
+	printer.now = func() time.Time { return time.Date(2024, 1, 1, 12, 0, 5, 0, time.UTC) }
+
+	// when
+	for _, record := range []string{
+		`{"type": "printf", "data": "generate\t15\t0\t4123\tjava\t1\t0\t812\t812\tcontainerd-shim\t1024\n"}`,
+		`{"type": "printf", "data": "generate\t10\t-6\t4130\tjava\t0\t2\t4123\t4125\tGC Thread\t8812\n"}`,
+		`{"type": "printf", "data": "deliver\t15\t0\t4123\t4123\tjava\t94557999988736\n"}`,
+		`{"type": "printf", "data": "fatal\t11\t1\t4123\t4130\tjava\t1\t16\t\n\tcrash+12 (/app/server)\n\tmain+40 (/app/server)\n\n"}`,
+		`{"type": "lost_events", "data": {"events": 3}}`,
+	} {
+		assert.NoError(t, printer.Print(bpftrace.ParseRecord([]byte(record))))
+	}
+	assert.NoError(t, printer.Flush())
+
+	// then
+	assert.Equal(t, ""+
+		"12:00:05 generate SIGTERM   to process 4123 (java) from 812/812 (containerd-shim) [outside of pods], SI_USER, delivered\n"+
+		"12:00:05 generate SIGUSR1   to thread 4130 (java) from 4123/4125 (GC Thread) ["+testutil.Workload+"], SI_TKILL, already pending\n"+
+		"12:00:05 deliver  SIGTERM   to 4123/4123 (java), SI_USER, handler 0x560000000000\n"+
+		"12:00:05 fatal    SIGSEGV   to 4123/4130 (java), SEGV_MAPERR at 0x10, dumping core\n"+
+		"    crash+12 (/app/server)\n"+
+		"    main+40 (/app/server)\n",
+		buf.String())
+}
+
+func TestPrinter_Json(t *testing.T) {
+	// given
+	var buf bytes.Buffer
+	printer := NewPrinter(&buf, output.FormatJson, nil)
+	printer.now = func() time.Time { return time.Date(2024, 1, 1, 12, 0, 5, 0, time.UTC) }
+
+	// when
+	assert.NoError(t, printer.Print(bpftrace.ParseRecord([]byte(
+		`{"type": "printf", "data": "fatal\t9\t128\t4123\t4123\tjava\t0\t0\t\n"}`))))
+
+	// then
+	assert.Equal(t, `{"type":"fatal","time":"2024-01-01T12:00:05Z","signal":"SIGKILL","code":"SI_KERNEL","pid":4123,"tid":4123,"comm":"java"}`+"\n",
+		buf.String())
+}
@@ -0,0 +1,269 @@
+package syscalls
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/rs/zerolog/log"
+)
+
+// Maps the program reports in, keyed by the sys_exit tracepoint name.
+const (
+	CallsMap   = "@calls"
+	ErrorsMap  = "@errors"
+	TotalMap   = "@total_ns"
+	LatencyMap = "@latency_ns"
+
+	exitProbePrefix = "tracepoint:syscalls:sys_exit_"
+)
+
+// Sort orders of the summary.
+const (
+	SortTime   = "time"
+	SortCalls  = "calls"
+	SortErrors = "errors"
+)
+
+var SortOrders = []string{SortTime, SortCalls, SortErrors}
+
+// Options controls the syscall tracing.
+type Options struct {
+	Duration time.Duration
+}
+
+// Program measures the count, errors and latency of every syscall made by
+// the target processes. The per syscall tracepoints are used rather than
+// raw_syscalls so bpftrace names the syscalls, whatever the architecture.
+func Program(target bpftrace.Target, options Options) string {
+	return fmt.Sprintf(`tracepoint:syscalls:sys_enter_* /%[1]s/ {
+	@start[tid] = nsecs;
+}
+
+tracepoint:syscalls:sys_exit_* /@start[tid]/ {
+	$ns = nsecs - @start[tid];
+	%[2]s[probe] = count();
+	%[3]s[probe] = sum($ns);
+	%[4]s[probe] = hist($ns);
+	if (args->ret < 0) {
+		%[5]s[probe, -args->ret] = count();
+	}
+	delete(@start[tid]);
+}
+
+interval:s:%[6]d {
+	clear(@start);
+	exit();
+}
+`, target.Predicate(), CallsMap, TotalMap, LatencyMap, ErrorsMap, bpftrace.Seconds(options.Duration))
+}
+
+// Syscall is the summary of a syscall.
+type Syscall struct {
+	Name   string           `json:"name"`
+	Calls  int64            `json:"calls"`
+	Errors map[string]int64 `json:"errors,omitempty"`
+	// Total and percentiles are in nanoseconds.
+	Total int64 `json:"totalNs"`
+	P50   int64 `json:"p50Ns"`
+	P90   int64 `json:"p90Ns"`
+	P99   int64 `json:"p99Ns"`
+}
+
+// ErrorCount returns the number of failed calls.
+func (s *Syscall) ErrorCount() int64 {
+	var count int64
+	for _, n := range s.Errors {
+		count += n
+	}
+
+	return count
+}
+
+// Collector is an output.Printer summarizing the maps of the program.
+type Collector struct {
+	syscalls map[string]*Syscall
+}
+
+func NewCollector() *Collector {
+	return &Collector{syscalls: map[string]*Syscall{}}
+}
+
+func (c *Collector) syscall(probe string) *Syscall {
+	name := strings.TrimPrefix(strings.TrimSpace(probe), exitProbePrefix)
+
+	syscall, ok := c.syscalls[name]
+	if !ok {
+		syscall = &Syscall{Name: name, Errors: map[string]int64{}}
+		c.syscalls[name] = syscall
+	}
+
+	return syscall
+}
+
+func (c *Collector) Print(record bpftrace.Record) error {
+	switch record.Type {
+	case bpftrace.TypeMap, bpftrace.TypeHist:
+	case bpftrace.TypeText:
+		log.Warn().Msg(record.String())
+		return nil
+	default:
+		return nil
+	}
+
+	maps, err := record.Maps()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range maps {
+		for _, key := range m.Keys() {
+			value := m.Entries[key]
+
+			switch m.Name {
+			case CallsMap:
+				c.syscall(key).Calls, err = bpftrace.ParseInt(value)
+			case TotalMap:
+				c.syscall(key).Total, err = bpftrace.ParseInt(value)
+			case ErrorsMap:
+				err = c.addErrors(key, value)
+			case LatencyMap:
+				err = c.addLatency(key, value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Collector) addErrors(key string, value json.RawMessage) error {
+	fields := strings.Split(key, ",")
+	if len(fields) != 2 {
+		return nil
+	}
+
+	count, err := bpftrace.ParseInt(value)
+	if err != nil {
+		return err
+	}
+
+	c.syscall(fields[0]).Errors[ErrnoName(strings.TrimSpace(fields[1]))] += count
+	return nil
+}
+
+func (c *Collector) addLatency(key string, value json.RawMessage) error {
+	buckets, err := bpftrace.ParseBuckets(value)
+	if err != nil {
+		return err
+	}
+
+	syscall := c.syscall(key)
+	syscall.P50 = bpftrace.Percentile(buckets, 50)
+	syscall.P90 = bpftrace.Percentile(buckets, 90)
+	syscall.P99 = bpftrace.Percentile(buckets, 99)
+	return nil
+}
+
+func (c *Collector) Flush() error {
+	return nil
+}
+
+// Syscalls returns the summary of every syscall made, sorted by order.
+func (c *Collector) Syscalls(order string) []*Syscall {
+	syscalls := make([]*Syscall, 0, len(c.syscalls))
+	for _, syscall := range c.syscalls {
+		syscalls = append(syscalls, syscall)
+	}
+
+	value := func(s *Syscall) int64 {
+		switch order {
+		case SortCalls:
+			return s.Calls
+		case SortErrors:
+			return s.ErrorCount()
+		default:
+			return s.Total
+		}
+	}
+
+	sort.Slice(syscalls, func(i, j int) bool {
+		if value(syscalls[i]) != value(syscalls[j]) {
+			return value(syscalls[i]) > value(syscalls[j])
+		}
+		return syscalls[i].Name < syscalls[j].Name
+	})
+
+	return syscalls
+}
+
+// ErrnoName returns the name of a positive errno value, e.g. ENOENT.
+func ErrnoName(errno string) string {
+	value, err := strconv.ParseInt(errno, 10, 64)
+	if err != nil {
+		return errno
+	}
+
+	if name, ok := errnoNames[value]; ok {
+		return name
+	}
+
+	return "errno " + errno
+}
+
+// Print writes the summary as a table, or as JSON for the json format.
+func Print(w io.Writer, format string, syscalls []*Syscall) error {
+	if format == output.FormatJson {
+		return json.NewEncoder(w).Encode(syscalls)
+	}
+
+	rows := make([][]string, 0, len(syscalls))
+	for _, s := range syscalls {
+		rows = append(rows, []string{
+			s.Name,
+			strconv.FormatInt(s.Calls, 10),
+			strconv.FormatInt(s.ErrorCount(), 10),
+			duration(s.P50),
+			duration(s.P90),
+			duration(s.P99),
+			duration(s.Total),
+			errorBreakdown(s.Errors),
+		})
+	}
+
+	return output.WriteTable(w, []string{"SYSCALL", "CALLS", "ERRORS", "P50", "P90", "P99", "TOTAL", "ERRNO"}, rows)
+}
+
+func duration(ns int64) string {
+	return time.Duration(ns).Round(time.Microsecond / 10).String()
+}
+
+// errorBreakdown lists the errnos of a syscall, most frequent first.
+func errorBreakdown(errors map[string]int64) string {
+	names := make([]string, 0, len(errors))
+	for name := range errors {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if errors[names[i]] != errors[names[j]] {
+			return errors[names[i]] > errors[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	breakdown := make([]string, 0, len(names))
+	for _, name := range names {
+		breakdown = append(breakdown, fmt.Sprintf("%s:%d", name, errors[name]))
+	}
+
+	return strings.Join(breakdown, " ")
+}
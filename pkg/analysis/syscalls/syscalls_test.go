@@ -0,0 +1,43 @@
+package syscalls
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	// given
+	collector := NewCollector()
+	records := []string{
+		`{"type": "map", "data": {"@calls": {"tracepoint:syscalls:sys_exit_openat": 10, "tracepoint:syscalls:sys_exit_read": 200}}}`,
+		`{"type": "map", "data": {"@errors": {"tracepoint:syscalls:sys_exit_openat, 2": 3, "tracepoint:syscalls:sys_exit_openat, 13": 1, "tracepoint:syscalls:sys_exit_read, 11": 5}}}`,
+		`{"type": "hist", "data": {"@latency_ns": {"tracepoint:syscalls:sys_exit_openat": [{"min": 1024, "max": 2047, "count": 10}]}}}`,
+		`{"type": "map", "data": {"@total_ns": {"tracepoint:syscalls:sys_exit_openat": 15000, "tracepoint:syscalls:sys_exit_read": 9000}}}`,
+	}
+	for _, record := range records {
+		assert.NoError(t, collector.Print(bpftrace.ParseRecord([]byte(record))))
+	}
+
+	// when
+	var buf bytes.Buffer
+	err := Print(&buf, output.FormatText, collector.Syscalls(SortTime))
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, ""+
+		"SYSCALL  CALLS  ERRORS  P50    P90    P99  TOTAL  ERRNO\n"+
+		"openat   10     4       1.5µs  1.9µs  2µs  15µs   ENOENT:3 EACCES:1\n"+
+		"read     200    5       0s     0s     0s   9µs    EAGAIN:5\n",
+		buf.String())
+	assert.Equal(t, "read", collector.Syscalls(SortErrors)[0].Name)
+}
+
+func TestErrnoName(t *testing.T) {
+	assert.Equal(t, "ENOENT", ErrnoName("2"))
+	assert.Equal(t, "ERESTARTSYS", ErrnoName("512"))
+	assert.Equal(t, "errno 4000", ErrnoName("4000"))
+}
@@ -0,0 +1,125 @@
+package usdt
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/symbolize"
+)
+
+// maxArguments is the number of USDT arguments bpftrace supports.
+const maxArguments = 12
+
+// Options controls the USDT tracing.
+type Options struct {
+	Duration time.Duration
+}
+
+// Probe is a USDT probe of a binary, all the call sites sharing its name.
+type Probe struct {
+	// Binary is the path of the binary defining the probe in the container,
+	// Path the one it's reachable at from the privileged pod.
+	Binary    string
+	Path      string
+	Provider  string
+	Name      string
+	Arguments int
+	// Semaphore tells whether the probe is only fired once activated.
+	Semaphore bool
+}
+
+// FullName returns the "provider:name" bpftrace refers to the probe by.
+func (p Probe) FullName() string {
+	return p.Provider + ":" + p.Name
+}
+
+// Probes merges the probe sites of binary by name, path is where the
+// privileged pod reaches binary.
+func Probes(binary string, path string, sites []symbolize.USDTProbe) []Probe {
+	index := map[string]int{}
+
+	var probes []Probe
+	for _, site := range sites {
+		fullName := site.Provider + ":" + site.Name
+
+		i, ok := index[fullName]
+		if !ok {
+			i = len(probes)
+			index[fullName] = i
+			probes = append(probes, Probe{Binary: binary, Path: path, Provider: site.Provider, Name: site.Name})
+		}
+
+		if len(site.Arguments) > probes[i].Arguments {
+			probes[i].Arguments = len(site.Arguments)
+		}
+		if site.Semaphore != 0 {
+			probes[i].Semaphore = true
+		}
+	}
+
+	sort.Slice(probes, func(i, j int) bool {
+		return probes[i].FullName() < probes[j].FullName()
+	})
+
+	return probes
+}
+
+// Match returns the probes named by one of names, either "provider:name" or
+// just "name".
+func Match(probes []Probe, names []string) []Probe {
+	var matched []Probe
+	for _, probe := range probes {
+		for _, name := range names {
+			if name == probe.FullName() || name == probe.Name {
+				matched = append(matched, probe)
+				break
+			}
+		}
+	}
+
+	return matched
+}
+
+// Filter returns the probes whose "provider:name" matches pattern.
+func Filter(probes []Probe, pattern *regexp.Regexp) []Probe {
+	var filtered []Probe
+	for _, probe := range probes {
+		if pattern.MatchString(probe.FullName()) {
+			filtered = append(filtered, probe)
+		}
+	}
+
+	return filtered
+}
+
+// Program prints every firing of the given probes by the target processes
+// with all of their arguments, until the configured duration elapsed.
+func Program(target bpftrace.Target, probes []Probe, options Options) string {
+	var b strings.Builder
+
+	for _, probe := range probes {
+		arguments := probe.Arguments
+		if arguments > maxArguments {
+			arguments = maxArguments
+		}
+
+		format := []string{probe.FullName(), "pid=%d"}
+		values := []string{"pid"}
+		for i := 0; i < arguments; i++ {
+			format = append(format, fmt.Sprintf("arg%d=%%d", i))
+			values = append(values, fmt.Sprintf("arg%d", i))
+		}
+
+		fmt.Fprintf(&b, "usdt:%s:%s /%s/ {\n\tprintf(\"%s\\n\", %s);\n}\n\n",
+			probe.Path, probe.FullName(), target.Predicate(),
+			strings.Join(format, " "), strings.Join(values, ", "))
+	}
+
+	fmt.Fprintf(&b, "interval:s:%d {\n\texit();\n}\n", bpftrace.Seconds(options.Duration))
+
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package usdt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/symbolize"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbesAndProgram(t *testing.T) {
+	// given
+	sites := []symbolize.USDTProbe{
+		{Provider: "python", Name: "function__entry", Arguments: []string{"8@%rbx", "8@%r12", "-4@%eax"}},
+		{Provider: "python", Name: "function__entry", Arguments: []string{"8@%rbx", "8@%r12", "-4@%eax"}},
+		{Provider: "python", Name: "gc__start", Semaphore: 0x7a1000, Arguments: []string{"-4@%edi"}},
+	}
+	probes := Probes("/usr/lib/libpython3.9.so", "/host/proc/42/root/usr/lib/libpython3.9.so", sites)
+
+	// when
+	matched := Match(probes, []string{"python:function__entry"})
+	program := Program(bpftrace.Target{Pids: []string{"42"}}, matched, Options{Duration: time.Second})
+
+	// then
+	assert.Len(t, probes, 2)
+	assert.True(t, probes[1].Semaphore)
+	assert.Equal(t, `usdt:/host/proc/42/root/usr/lib/libpython3.9.so:python:function__entry /pid == 42/ {
+	printf("python:function__entry pid=%d arg0=%d arg1=%d arg2=%d\n", pid, arg0, arg1, arg2);
+}
+
+interval:s:1 {
+	exit();
+}
+`, program)
+}
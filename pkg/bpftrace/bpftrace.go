@@ -0,0 +1,175 @@
+package bpftrace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// RemoteDir is where doktor keeps everything it uploads to the helper pod.
+	RemoteDir = "/tmp/doktor"
+
+	// RemoteBinaryPath is where an uploaded bpftrace binary is placed.
+	RemoteBinaryPath = RemoteDir + "/bin/bpftrace"
+
+	// RemoteScriptsDir is where an uploaded script bundle is placed.
+	RemoteScriptsDir = RemoteDir + "/scripts"
+
+	// RemoteArtifactsDir is where tools run during a session are expected to
+	// write files that should be downloaded with --output-dir.
+	RemoteArtifactsDir = RemoteDir + "/artifacts"
+
+	// HostProcPath is the host procfs as seen from the helper pod, each
+	// process root filesystem is reachable below it at /host/proc/<pid>/root.
+	HostProcPath = "/host/proc"
+
+	// HostCgroupPath is the host cgroup hierarchy as seen from the helper pod.
+	HostCgroupPath = "/host/sys/fs/cgroup"
+
+	// BundleEntrypoint is the script run when a directory is given as bundle.
+	BundleEntrypoint = "main.bt"
+
+	defaultBinary = "bpftrace"
+)
+
+// HasEmbeddedBinary reports whether a static bpftrace was embedded at build time.
+func HasEmbeddedBinary() bool {
+	return len(embeddedBinary) > 0
+}
+
+// ExtractEmbeddedBinary writes the embedded bpftrace binary to the local temp
+// directory, named after its checksum so it's only written once, and returns
+// its path.
+func ExtractEmbeddedBinary() (string, error) {
+	sum := sha256.Sum256(embeddedBinary)
+	localPath := filepath.Join(os.TempDir(), "doktor-bpftrace-"+hex.EncodeToString(sum[:8]))
+
+	if info, err := os.Stat(localPath); err == nil && info.Size() == int64(len(embeddedBinary)) {
+		return localPath, nil
+	}
+
+	if err := ioutil.WriteFile(localPath, embeddedBinary, 0755); err != nil {
+		return "", err
+	}
+
+	return localPath, nil
+}
+
+// Command describes a bpftrace invocation inside the helper pod.
+type Command struct {
+	// Binary is the bpftrace executable, defaults to bpftrace from PATH.
+	Binary string
+	// Program is an inline bpftrace program, used when ScriptPath is empty.
+	Program string
+	// ScriptPath is a script file on the helper pod.
+	ScriptPath string
+	// IncludeDir is added to the #include search path.
+	IncludeDir string
+	// Pid is passed with -p, scoping uprobes and USDT probes to the process.
+	Pid *string
+	// UsdtFileActivation activates the semaphores of USDT probes in every
+	// process mapping the probed file, rather than in Pid only.
+	UsdtFileActivation bool
+	// MaxStrlen is the maximum length of the strings and buffers bpftrace
+	// reads, str() and buf() truncating to it, instead of the default of 64
+	// bytes. Analyses pass the size of the largest string they read, bpftrace
+	// before 0.20 accepting no more than 200.
+	MaxStrlen int
+	// Format is the bpftrace output format, e.g. json.
+	Format string
+}
+
+// RemoteScriptPath returns the path a script bundle uploaded from localPath is
+// run from on the helper pod.
+func RemoteScriptPath(localPath string, isDir bool) string {
+	if isDir {
+		return path.Join(RemoteScriptsDir, BundleEntrypoint)
+	}
+
+	return path.Join(RemoteScriptsDir, filepath.Base(localPath))
+}
+
+func (c *Command) Build() []string {
+	binary := c.Binary
+	if binary == "" {
+		binary = defaultBinary
+	}
+
+	var command []string
+
+	// the variable was renamed in bpftrace 0.20
+	if c.MaxStrlen > 0 {
+		command = append(command, "env",
+			fmt.Sprintf("BPFTRACE_STRLEN=%d", c.MaxStrlen), fmt.Sprintf("BPFTRACE_MAX_STRLEN=%d", c.MaxStrlen))
+	}
+
+	command = append(command, binary)
+
+	if c.IncludeDir != "" {
+		command = append(command, "-I", c.IncludeDir)
+	}
+
+	if c.Pid != nil {
+		command = append(command, "-p", *c.Pid)
+	}
+
+	if c.UsdtFileActivation {
+		command = append(command, "--usdt-file-activation")
+	}
+
+	if c.Format != "" {
+		command = append(command, "-f", c.Format)
+	}
+
+	if c.ScriptPath != "" {
+		return append(command, c.ScriptPath)
+	}
+
+	return append(command, "-e", c.Program)
+}
+
+// Target selects the processes of the target container in predicates.
+type Target struct {
+	// Cgroup is the cgroup v2 directory of the container as seen from the
+	// helper pod, empty on cgroup v1 hosts.
+	Cgroup string
+
+	// Pids are the host pids of the container found during setup.
+	Pids []string
+}
+
+// Predicate returns a bpftrace predicate matching the processes of the
+// target: the ones in its cgroup, including those forked or restarted once
+// tracing began, or only the pids found during setup without a cgroup.
+func (t Target) Predicate() string {
+	if t.Cgroup != "" {
+		return fmt.Sprintf("cgroup == cgroupid(\"%s\")", t.Cgroup)
+	}
+
+	return PidPredicate(t.Pids)
+}
+
+// PidPredicate returns a bpftrace predicate matching any of the given
+// processes, pid being the process id as seen from the host.
+func PidPredicate(pids []string) string {
+	conditions := make([]string, 0, len(pids))
+	for _, pid := range pids {
+		conditions = append(conditions, "pid == "+pid)
+	}
+
+	return strings.Join(conditions, " || ")
+}
+
+// Seconds rounds a duration up to the whole seconds of an interval probe,
+// at least one.
+func Seconds(duration time.Duration) int {
+	return int(math.Max(1, math.Ceil(duration.Seconds())))
+}
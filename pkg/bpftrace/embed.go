@@ -0,0 +1,14 @@
+//go:build embed_bpftrace
+// +build embed_bpftrace
+
+package bpftrace
+
+import (
+	_ "embed"
+)
+
+// embeddedBinary is a static bpftrace build placed at bin/bpftrace before
+// building with `-tags embed_bpftrace`.
+//
+//go:embed bin/bpftrace
+var embeddedBinary []byte
@@ -0,0 +1,6 @@
+//go:build !embed_bpftrace
+// +build !embed_bpftrace
+
+package bpftrace
+
+var embeddedBinary []byte
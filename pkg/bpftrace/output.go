@@ -0,0 +1,215 @@
+package bpftrace
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Record types emitted by bpftrace when run with -f json.
+const (
+	TypeAttachedProbes = "attached_probes"
+	TypePrintf         = "printf"
+	TypeTime           = "time"
+	TypeMap            = "map"
+	TypeHist           = "hist"
+	TypeStats          = "stats"
+	TypeLostEvents     = "lost_events"
+
+	// TypeText isn't emitted by bpftrace, it wraps output lines that aren't
+	// JSON such as warnings or errors from the bpftrace parser.
+	TypeText = "text"
+)
+
+// Record is a single line of bpftrace's JSON output.
+type Record struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// ParseRecord parses one line of bpftrace JSON output. Lines that aren't JSON
+// are returned as a TypeText record.
+func ParseRecord(line []byte) Record {
+	var record Record
+
+	trimmed := bytes.TrimSpace(line)
+	if err := json.Unmarshal(trimmed, &record); err != nil || record.Type == "" {
+		data, _ := json.Marshal(string(line))
+		return Record{Type: TypeText, Data: data}
+	}
+
+	return record
+}
+
+// String returns the data of printf, time and text records.
+func (r Record) String() string {
+	var s string
+	if err := json.Unmarshal(r.Data, &s); err != nil {
+		return string(r.Data)
+	}
+
+	return s
+}
+
+// Map is a decoded bpftrace map. Unkeyed maps hold a single entry with an
+// empty key, tuple keys are joined with a comma by bpftrace.
+type Map struct {
+	Name    string
+	Keyed   bool
+	Entries map[string]json.RawMessage
+}
+
+// Keys returns the map keys in lexical order.
+func (m Map) Keys() []string {
+	keys := make([]string, 0, len(m.Entries))
+	for key := range m.Entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Maps decodes the maps held by map, hist and stats records.
+func (r Record) Maps() ([]Map, error) {
+	var maps map[string]json.RawMessage
+	if err := json.Unmarshal(r.Data, &maps); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode %s record", r.Type)
+	}
+
+	names := make([]string, 0, len(maps))
+	for name := range maps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]Map, 0, len(maps))
+	for _, name := range names {
+		m := Map{Name: name, Entries: map[string]json.RawMessage{}}
+
+		value := maps[name]
+		var entries map[string]json.RawMessage
+		if isObject(value) && json.Unmarshal(value, &entries) == nil && !(r.Type == TypeStats && isStats(entries)) {
+			m.Keyed = true
+			m.Entries = entries
+		} else {
+			m.Entries[""] = value
+		}
+
+		result = append(result, m)
+	}
+
+	return result, nil
+}
+
+func isObject(value json.RawMessage) bool {
+	trimmed := bytes.TrimSpace(value)
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+func isStats(entries map[string]json.RawMessage) bool {
+	_, hasCount := entries["count"]
+	_, hasAverage := entries["average"]
+	return len(entries) == 3 && hasCount && hasAverage
+}
+
+// Bucket is a single histogram bucket. A nil Min is the bucket of values
+// below the lowest bound, a nil Max the one above the highest.
+type Bucket struct {
+	Min   *int64 `json:"min,omitempty"`
+	Max   *int64 `json:"max,omitempty"`
+	Count uint64 `json:"count"`
+}
+
+// Stats is the value of a stats() map entry.
+type Stats struct {
+	Count   uint64 `json:"count"`
+	Average int64  `json:"average"`
+	Total   int64  `json:"total"`
+}
+
+// ParseBuckets decodes a hist or lhist map entry.
+func ParseBuckets(value json.RawMessage) ([]Bucket, error) {
+	var buckets []Bucket
+	if err := json.Unmarshal(value, &buckets); err != nil {
+		return nil, errors.Wrap(err, "failed to decode histogram")
+	}
+
+	return buckets, nil
+}
+
+// ParseStats decodes a stats map entry.
+func ParseStats(value json.RawMessage) (Stats, error) {
+	var stats Stats
+	if err := json.Unmarshal(value, &stats); err != nil {
+		return stats, errors.Wrap(err, "failed to decode stats")
+	}
+
+	return stats, nil
+}
+
+// ParseString decodes a string map value such as comm, returning other
+// values as they were printed.
+func ParseString(value json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return string(bytes.TrimSpace(value))
+	}
+
+	return s
+}
+
+// ParseInt decodes an integer map value such as the result of count() or sum().
+func ParseInt(value json.RawMessage) (int64, error) {
+	return strconv.ParseInt(string(bytes.TrimSpace(value)), 10, 64)
+}
+
+// Percentile estimates the p-th percentile, p in [0, 100], of the values a
+// histogram counted, interpolating linearly within the bucket it falls in.
+// Power of 2 histograms make it an approximation.
+func Percentile(buckets []Bucket, p float64) int64 {
+	var total uint64
+	for _, bucket := range buckets {
+		total += bucket.Count
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := p / 100 * float64(total)
+
+	var seen uint64
+	for _, bucket := range buckets {
+		if bucket.Count == 0 {
+			continue
+		}
+
+		if float64(seen+bucket.Count) >= rank {
+			var min, max int64
+			if bucket.Min != nil {
+				min = *bucket.Min
+			}
+			max = min
+			if bucket.Max != nil {
+				max = *bucket.Max
+			}
+
+			fraction := (rank - float64(seen)) / float64(bucket.Count)
+			return min + int64(fraction*float64(max-min))
+		}
+
+		seen += bucket.Count
+	}
+
+	last := buckets[len(buckets)-1]
+	if last.Max != nil {
+		return *last.Max
+	}
+	if last.Min != nil {
+		return *last.Min
+	}
+	return 0
+}
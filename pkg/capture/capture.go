@@ -0,0 +1,68 @@
+// Package capture captures the packets of a pod with tcpdump run in its
+// network namespace, and converts them to pcapng annotated with the pod.
+package capture
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+)
+
+const (
+	// RemoteBinaryPath is where an uploaded tcpdump binary is placed.
+	RemoteBinaryPath = bpftrace.RemoteDir + "/bin/tcpdump"
+
+	// ExitTimeout is the exit code of timeout once the capture duration
+	// elapsed, tcpdump having been interrupted.
+	ExitTimeout = 124
+
+	// DefaultInterface captures on every interface of the network namespace.
+	DefaultInterface = "any"
+
+	defaultBinary = "tcpdump"
+)
+
+// Command describes a tcpdump invocation inside the helper pod.
+type Command struct {
+	// Binary is the tcpdump executable, defaults to tcpdump from PATH.
+	Binary string
+	// Netns is the network namespace to capture in, as a file such as
+	// /host/proc/<pid>/ns/net.
+	Netns     string
+	Interface string
+	// Snaplen bounds the bytes captured per packet, tcpdump's default when 0.
+	Snaplen int
+	// Filter is a pcap-filter expression.
+	Filter   string
+	Duration time.Duration
+}
+
+// Build returns the command running tcpdump in the network namespace until
+// the duration elapsed, writing the packets to stdout as pcap as soon as
+// they are captured.
+func (c *Command) Build() []string {
+	binary := c.Binary
+	if binary == "" {
+		binary = defaultBinary
+	}
+
+	netInterface := c.Interface
+	if netInterface == "" {
+		netInterface = DefaultInterface
+	}
+
+	// tcpdump flushes its output when interrupted
+	command := []string{"timeout", "-s", "INT", strconv.Itoa(bpftrace.Seconds(c.Duration)),
+		"nsenter", "--net=" + c.Netns, binary, "-i", netInterface, "-U", "-w", "-"}
+
+	if c.Snaplen > 0 {
+		command = append(command, "-s", strconv.Itoa(c.Snaplen))
+	}
+
+	if c.Filter != "" {
+		command = append(command, c.Filter)
+	}
+
+	return command
+}
@@ -0,0 +1,174 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// pcapStream builds a pcap stream of the given packets, one second apart.
+func pcapStream(order binary.ByteOrder, magic uint32, linkType uint32, packets ...[]byte) []byte {
+	var b bytes.Buffer
+	_ = binary.Write(&b, order, []uint32{magic})
+	_ = binary.Write(&b, order, []uint16{2, 4})
+	_ = binary.Write(&b, order, []uint32{0, 0, 262144, linkType})
+
+	for i, packet := range packets {
+		_ = binary.Write(&b, order, []uint32{1700000000 + uint32(i), 250, uint32(len(packet)), uint32(len(packet)) + 100})
+		b.Write(packet)
+	}
+
+	return b.Bytes()
+}
+
+type block struct {
+	blockType uint32
+	body      []byte
+}
+
+func readBlocks(t *testing.T, data []byte) []block {
+	var blocks []block
+	for len(data) > 0 {
+		length := binary.LittleEndian.Uint32(data[4:8])
+		assert.Equal(t, uint32(0), length%4)
+		assert.Equal(t, length, binary.LittleEndian.Uint32(data[length-4:length]))
+
+		blocks = append(blocks, block{blockType: binary.LittleEndian.Uint32(data[0:4]), body: data[8 : length-4]})
+		data = data[length:]
+	}
+	return blocks
+}
+
+// readOptions returns the values of the options of a block by code.
+func readOptions(options []byte) map[uint16][]string {
+	values := map[uint16][]string{}
+	for len(options) >= 4 {
+		code, length := binary.LittleEndian.Uint16(options[0:2]), int(binary.LittleEndian.Uint16(options[2:4]))
+		if code == optionEnd {
+			break
+		}
+		values[code] = append(values[code], string(options[4:4+length]))
+		options = options[4+length+padding(length):]
+	}
+	return values
+}
+
+func TestCommandBuild(t *testing.T) {
+	// given
+	command := Command{
+		Netns:    "/host/proc/4123/ns/net",
+		Snaplen:  128,
+		Filter:   "tcp port 8080",
+		Duration: 90 * time.Second,
+	}
+
+	// when
+	built := command.Build()
+
+	// then
+	assert.Equal(t, []string{"timeout", "-s", "INT", "90", "nsenter", "--net=/host/proc/4123/ns/net",
+		"tcpdump", "-i", "any", "-U", "-w", "-", "-s", "128", "tcp port 8080"}, built)
+
+	// when
+	command = Command{Binary: RemoteBinaryPath, Netns: "/host/proc/4123/ns/net", Interface: "eth0", Duration: time.Second}
+
+	// then
+	assert.Equal(t, []string{"timeout", "-s", "INT", "1", "nsenter", "--net=/host/proc/4123/ns/net",
+		"/tmp/doktor/bin/tcpdump", "-i", "eth0", "-U", "-w", "-"}, command.Build())
+}
+
+func TestConvert(t *testing.T) {
+	// given
+	metadata := Metadata{
+		Namespace:   testutil.Namespace,
+		Pod:         testutil.PodName,
+		Container:   testutil.Container,
+		ContainerId: testutil.ContainerId,
+		Node:        testutil.Node,
+		Interface:   "any",
+		Filter:      "port 53",
+	}
+	stream := pcapStream(binary.LittleEndian, pcapMagicNanoseconds, 276, []byte("abcdef"), []byte("0123"))
+
+	// when
+	var out bytes.Buffer
+	packets, err := Convert(bytes.NewReader(stream), &out, metadata)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, 2, packets)
+
+	blocks := readBlocks(t, out.Bytes())
+	assert.Len(t, blocks, 4)
+
+	assert.Equal(t, uint32(blockSectionHeader), blocks[0].blockType)
+	assert.Equal(t, uint32(byteOrderMagic), binary.LittleEndian.Uint32(blocks[0].body[0:4]))
+	shb := readOptions(blocks[0].body[16:])
+	assert.Equal(t, []string{"pod: " + testutil.Namespace + "/" + testutil.PodName, "node: " + testutil.Node,
+		"container: " + testutil.Container, "container id: " + testutil.ContainerId,
+		"filter: port 53"}, shb[optionComment])
+	assert.Equal(t, []string{"kubectl-doktor"}, shb[optionShbUserAppl])
+
+	assert.Equal(t, uint32(blockInterface), blocks[1].blockType)
+	assert.Equal(t, uint16(276), binary.LittleEndian.Uint16(blocks[1].body[0:2]))
+	assert.Equal(t, uint32(262144), binary.LittleEndian.Uint32(blocks[1].body[4:8]))
+	idb := readOptions(blocks[1].body[8:])
+	assert.Equal(t, []string{"any"}, idb[optionIfName])
+	assert.Equal(t, []string{"network namespace of pod " + testutil.Namespace + "/" + testutil.PodName}, idb[optionIfDescription])
+	assert.Equal(t, []string{"\x09"}, idb[optionIfTsresol])
+
+	epb := blocks[2].body
+	assert.Equal(t, uint32(blockEnhancedPacket), blocks[2].blockType)
+	timestamp := uint64(binary.LittleEndian.Uint32(epb[4:8]))<<32 | uint64(binary.LittleEndian.Uint32(epb[8:12]))
+	assert.Equal(t, uint64(1700000000*1000000000+250), timestamp)
+	assert.Equal(t, uint32(6), binary.LittleEndian.Uint32(epb[12:16]))
+	assert.Equal(t, uint32(106), binary.LittleEndian.Uint32(epb[16:20]))
+	assert.Equal(t, []byte("abcdef\x00\x00"), epb[20:])
+
+	assert.Equal(t, []byte("0123"), blocks[3].body[20:])
+}
+
+func TestConvert_BigEndianMicroseconds(t *testing.T) {
+	// given
+	stream := pcapStream(binary.BigEndian, pcapMagicMicroseconds, 1, []byte("x"))
+
+	// when
+	var out bytes.Buffer
+	packets, err := Convert(bytes.NewReader(stream), &out, Metadata{Namespace: "default", Pod: "api"})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, 1, packets)
+
+	blocks := readBlocks(t, out.Bytes())
+	assert.Equal(t, uint16(1), binary.LittleEndian.Uint16(blocks[1].body[0:2]))
+	assert.Equal(t, []string{"\x06"}, readOptions(blocks[1].body[8:])[optionIfTsresol])
+	epb := blocks[2].body
+	timestamp := uint64(binary.LittleEndian.Uint32(epb[4:8]))<<32 | uint64(binary.LittleEndian.Uint32(epb[8:12]))
+	assert.Equal(t, uint64(1700000000*1000000+250), timestamp)
+}
+
+func TestConvert_Malformed(t *testing.T) {
+	// when
+	_, err := Convert(bytes.NewReader(nil), &bytes.Buffer{}, Metadata{})
+
+	// then
+	assert.Error(t, err)
+
+	// when
+	_, err = Convert(bytes.NewReader(make([]byte, pcapHeaderSize)), &bytes.Buffer{}, Metadata{})
+
+	// then
+	assert.EqualError(t, err, "not a pcap stream, magic: '0x00000000'")
+
+	// when
+	stream := pcapStream(binary.LittleEndian, pcapMagicMicroseconds, 1, []byte("abcdef"))
+	_, err = Convert(bytes.NewReader(stream[:len(stream)-2]), &bytes.Buffer{}, Metadata{})
+
+	// then
+	assert.Error(t, err)
+}
@@ -0,0 +1,234 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// pcap magic numbers, for microsecond and nanosecond timestamps.
+const (
+	pcapMagicMicroseconds = 0xa1b2c3d4
+	pcapMagicNanoseconds  = 0xa1b23c4d
+
+	pcapHeaderSize       = 24
+	pcapRecordHeaderSize = 16
+	// the upper bits of the link type of the header may hold FCS details
+	pcapLinkTypeMask = 0x0fffffff
+	// maxCapturedPacket bounds the packets read, guarding against a corrupt stream
+	maxCapturedPacket = 1 << 26
+)
+
+// pcapng blocks and options, draft-ietf-opsawg-pcapng.
+const (
+	blockSectionHeader  = 0x0a0d0d0a
+	blockInterface      = 0x00000001
+	blockEnhancedPacket = 0x00000006
+
+	byteOrderMagic = 0x1a2b3c4d
+	// the length of the section isn't known until the capture ends
+	unknownSectionLength = -1
+
+	optionEnd           = 0
+	optionComment       = 1
+	optionShbUserAppl   = 4
+	optionIfName        = 2
+	optionIfDescription = 3
+	optionIfTsresol     = 9
+
+	tsresolMicroseconds = 6
+	tsresolNanoseconds  = 9
+
+	// blockFramingSize is the size of the type and the two lengths framing
+	// the body of a block, bodies being aligned to 32 bits
+	blockFramingSize   = 12
+	blockAlignment     = 4
+	enhancedPacketSize = 20
+
+	userApplication = "kubectl-doktor"
+)
+
+// Metadata describes what was captured, recorded in the comments of the
+// pcapng section and interface.
+type Metadata struct {
+	Namespace   string
+	Pod         string
+	Container   string
+	ContainerId string
+	Node        string
+	Interface   string
+	Filter      string
+}
+
+func (m Metadata) comments() []string {
+	comments := []string{
+		fmt.Sprintf("pod: %s/%s", m.Namespace, m.Pod),
+		fmt.Sprintf("node: %s", m.Node),
+	}
+
+	if m.Container != "" {
+		comments = append(comments, fmt.Sprintf("container: %s", m.Container))
+	}
+	if m.ContainerId != "" {
+		comments = append(comments, fmt.Sprintf("container id: %s", m.ContainerId))
+	}
+	if m.Filter != "" {
+		comments = append(comments, fmt.Sprintf("filter: %s", m.Filter))
+	}
+
+	return comments
+}
+
+// Convert reads a pcap stream, as written by tcpdump -w -, and writes its
+// packets to w as pcapng as they come, so w can be a live viewer. It returns
+// the number of packets converted.
+func Convert(r io.Reader, w io.Writer, metadata Metadata) (int, error) {
+	header := make([]byte, pcapHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, errors.Wrap(err, "failed to read the pcap header")
+	}
+
+	var order binary.ByteOrder
+	var tsresol byte
+
+	switch {
+	case binary.LittleEndian.Uint32(header) == pcapMagicMicroseconds:
+		order, tsresol = binary.LittleEndian, tsresolMicroseconds
+	case binary.LittleEndian.Uint32(header) == pcapMagicNanoseconds:
+		order, tsresol = binary.LittleEndian, tsresolNanoseconds
+	case binary.BigEndian.Uint32(header) == pcapMagicMicroseconds:
+		order, tsresol = binary.BigEndian, tsresolMicroseconds
+	case binary.BigEndian.Uint32(header) == pcapMagicNanoseconds:
+		order, tsresol = binary.BigEndian, tsresolNanoseconds
+	default:
+		return 0, errors.Errorf("not a pcap stream, magic: '0x%x'", header[:4])
+	}
+
+	snaplen := order.Uint32(header[16:20])
+	linkType := order.Uint32(header[20:24]) & pcapLinkTypeMask
+
+	if err := writeSectionHeader(w, metadata); err != nil {
+		return 0, err
+	}
+
+	if err := writeInterface(w, linkType, snaplen, tsresol, metadata); err != nil {
+		return 0, err
+	}
+
+	units := uint64(1000000)
+	if tsresol == tsresolNanoseconds {
+		units = 1000000000
+	}
+
+	packets := 0
+	record := make([]byte, pcapRecordHeaderSize)
+
+	for {
+		if _, err := io.ReadFull(r, record); err != nil {
+			if err == io.EOF {
+				return packets, nil
+			}
+			return packets, errors.Wrap(err, "failed to read a pcap record")
+		}
+
+		seconds, fraction := order.Uint32(record[0:4]), order.Uint32(record[4:8])
+		captured, length := order.Uint32(record[8:12]), order.Uint32(record[12:16])
+
+		if captured > maxCapturedPacket {
+			return packets, errors.Errorf("malformed pcap record of: '%d' bytes", captured)
+		}
+
+		data := make([]byte, captured)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return packets, errors.Wrap(err, "failed to read a pcap packet")
+		}
+
+		timestamp := uint64(seconds)*units + uint64(fraction)
+		if err := writeEnhancedPacket(w, timestamp, length, data); err != nil {
+			return packets, err
+		}
+
+		packets++
+	}
+}
+
+func writeSectionHeader(w io.Writer, metadata Metadata) error {
+	var body bytes.Buffer
+
+	_ = binary.Write(&body, binary.LittleEndian, uint32(byteOrderMagic))
+	// version 1.0
+	_ = binary.Write(&body, binary.LittleEndian, uint16(1))
+	_ = binary.Write(&body, binary.LittleEndian, uint16(0))
+	_ = binary.Write(&body, binary.LittleEndian, int64(unknownSectionLength))
+
+	for _, comment := range metadata.comments() {
+		writeOption(&body, optionComment, []byte(comment))
+	}
+	writeOption(&body, optionShbUserAppl, []byte(userApplication))
+	writeOption(&body, optionEnd, nil)
+
+	return writeBlock(w, blockSectionHeader, body.Bytes())
+}
+
+func writeInterface(w io.Writer, linkType uint32, snaplen uint32, tsresol byte, metadata Metadata) error {
+	var body bytes.Buffer
+
+	_ = binary.Write(&body, binary.LittleEndian, uint16(linkType))
+	_ = binary.Write(&body, binary.LittleEndian, uint16(0))
+	_ = binary.Write(&body, binary.LittleEndian, snaplen)
+
+	if metadata.Interface != "" {
+		writeOption(&body, optionIfName, []byte(metadata.Interface))
+	}
+	writeOption(&body, optionIfDescription,
+		[]byte(fmt.Sprintf("network namespace of pod %s/%s", metadata.Namespace, metadata.Pod)))
+	writeOption(&body, optionIfTsresol, []byte{tsresol})
+	writeOption(&body, optionEnd, nil)
+
+	return writeBlock(w, blockInterface, body.Bytes())
+}
+
+func writeEnhancedPacket(w io.Writer, timestamp uint64, length uint32, data []byte) error {
+	body := make([]byte, enhancedPacketSize, enhancedPacketSize+len(data)+blockAlignment)
+
+	// the only interface of the section
+	binary.LittleEndian.PutUint32(body[0:4], 0)
+	binary.LittleEndian.PutUint32(body[4:8], uint32(timestamp>>32))
+	binary.LittleEndian.PutUint32(body[8:12], uint32(timestamp))
+	binary.LittleEndian.PutUint32(body[12:16], uint32(len(data)))
+	binary.LittleEndian.PutUint32(body[16:20], length)
+
+	body = append(body, data...)
+	body = append(body, make([]byte, padding(len(data)))...)
+
+	return writeBlock(w, blockEnhancedPacket, body)
+}
+
+// writeOption appends an option, its value padded to 32 bits.
+func writeOption(body *bytes.Buffer, code uint16, value []byte) {
+	_ = binary.Write(body, binary.LittleEndian, code)
+	_ = binary.Write(body, binary.LittleEndian, uint16(len(value)))
+	body.Write(value)
+	body.Write(make([]byte, padding(len(value))))
+}
+
+// writeBlock writes a block at once, its total length framing its body.
+func writeBlock(w io.Writer, blockType uint32, body []byte) error {
+	length := len(body) + blockFramingSize
+
+	block := make([]byte, length)
+	binary.LittleEndian.PutUint32(block[0:4], blockType)
+	binary.LittleEndian.PutUint32(block[4:8], uint32(length))
+	copy(block[8:], body)
+	binary.LittleEndian.PutUint32(block[length-4:], uint32(length))
+
+	_, err := w.Write(block)
+	return err
+}
+
+func padding(length int) int {
+	return (blockAlignment - length%blockAlignment) % blockAlignment
+}
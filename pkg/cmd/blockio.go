@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/analysis/blockio"
+	"github.com/alam0rt/kubectl-doktor/pkg/record"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	blockioExample = `
+	# measure the latency and throughput of the persistent volumes of a pod for 30 seconds
+	%[1]s doktor blockio example-pod -n default -p
+
+	# include the latency histograms of every volume
+	%[1]s doktor blockio example-pod -n default -p -d 2m --histogram
+	`
+)
+
+type BlockIo struct {
+	doktor     *Doktor
+	duration   time.Duration
+	histograms bool
+}
+
+func NewCmdBlockIo(doktor *Doktor) *cobra.Command {
+	b := &BlockIo{doktor: doktor}
+
+	cmd := &cobra.Command{
+		Use:          "blockio <pod>",
+		Short:        "Measure the read and write latency and throughput of the persistent volumes of a pod",
+		Example:      fmt.Sprintf(blockioExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := doktor.Complete(c, args); err != nil {
+				return err
+			}
+			if err := b.Validate(); err != nil {
+				return err
+			}
+			if err := doktor.Validate(); err != nil {
+				return err
+			}
+
+			return b.Run()
+		},
+	}
+
+	cmd.Flags().DurationVarP(&b.duration, "duration", "d", 30*time.Second,
+		"how long to trace for (e.g. 30s, 2m)")
+	cmd.Flags().BoolVarP(&b.histograms, "histogram", "", false,
+		"print the latency histograms of every volume after the summary")
+
+	return cmd
+}
+
+func (b *BlockIo) Validate() error {
+	if b.duration <= 0 {
+		return errors.New("tracing duration must be positive")
+	}
+
+	return nil
+}
+
+func (b *BlockIo) Run() error {
+	settings := b.doktor.settings
+
+	pod, err := b.doktor.kubernetesApiService.GetTargetPod(settings.UserSpecifiedPodName)
+	if err != nil {
+		return err
+	}
+
+	volumes := blockio.PodVolumes(pod, settings.UserSpecifiedContainer)
+	if len(volumes) == 0 {
+		return errors.Errorf("no persistent volume claims mounted in container: '%s'", settings.UserSpecifiedContainer)
+	}
+
+	for i := range volumes {
+		claim, err := b.doktor.kubernetesApiService.GetPersistentVolumeClaim(volumes[i].Claim)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Msgf("failed to get persistent volume claim: '%s'", volumes[i].Claim)
+			continue
+		}
+
+		if err := volumes[i].CheckClaim(claim, pod); err != nil {
+			log.Warn().
+				Err(err).
+				Msg("ignoring the claim, its persistent volume and storage class won't be reported")
+			continue
+		}
+
+		volumes[i].PersistentVolume = claim.Spec.VolumeName
+		if claim.Spec.StorageClassName != nil {
+			volumes[i].StorageClass = *claim.Spec.StorageClassName
+		}
+	}
+
+	tracerService := b.doktor.tracerService
+
+	return b.doktor.withTracer(func() error {
+		pids := tracerService.TargetPids()
+		if len(pids) == 0 {
+			return errors.Errorf("no processes found in container: '%s'", settings.UserSpecifiedContainer)
+		}
+
+		volumes, err := b.resolve(pids[0], volumes)
+		if err != nil {
+			return err
+		}
+
+		devices := make([]blockio.Device, 0, len(volumes))
+		for _, volume := range volumes {
+			log.Info().
+				Str("claim", volume.Claim).
+				Str("mount", volume.MountPath).
+				Str("device", volume.DeviceName).
+				Bool("shared", volume.Shared).
+				Msg("volume resolved")
+
+			devices = append(devices, volume.Device)
+		}
+
+		log.Info().
+			Str("pod", settings.UserSpecifiedPodName).
+			Dur("duration", b.duration).
+			Msg("block I/O tracing has begun")
+
+		collector := blockio.NewCollector()
+		program := blockio.Program(devices, b.duration)
+		err = b.doktor.stream(collector, record.InlineScripts(program), func(stdOut io.Writer) error {
+			return tracerService.StartProgram(program, stdOut)
+		})
+		if err != nil {
+			return err
+		}
+
+		return blockio.Print(b.doktor.Out, settings.UserSpecifiedOutputFormat,
+			collector.Reports(volumes, b.duration), b.histograms)
+	})
+}
+
+// resolve finds the disks backing the volumes from the mounts of a process
+// of the container, dropping the volumes not backed by one.
+func (b *BlockIo) resolve(pid string, volumes []blockio.Volume) ([]blockio.Volume, error) {
+	tracerService := b.doktor.tracerService
+
+	content, err := tracerService.ReadFile(blockio.MountInfoPath(pid))
+	if err != nil {
+		return nil, err
+	}
+
+	mounts, err := blockio.ParseMountInfo(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var resolved []blockio.Volume
+	for _, volume := range volumes {
+		if err := volume.Resolve(mounts); err != nil {
+			log.Warn().
+				Err(err).
+				Msgf("skipping volume: '%s' at: '%s', its block device wasn't resolved", volume.Name, volume.MountPath)
+			continue
+		}
+
+		volume.Device, volume.DeviceName = b.disk(volume.Device)
+		resolved = append(resolved, volume)
+	}
+
+	if len(resolved) == 0 {
+		return nil, errors.New("none of the persistent volumes is backed by a block device")
+	}
+
+	// the I/O of a disk the node's root filesystem is on isn't only the one
+	// of the volume, e.g. for volumes of a local path provisioner
+	var nodeDevices []blockio.Device
+	if content, err := tracerService.ReadFile(blockio.MountInfoPath("1")); err == nil {
+		if hostMounts, err := blockio.ParseMountInfo(content); err == nil {
+			if root, ok := blockio.RootDevice(hostMounts); ok {
+				disk, _ := b.disk(root)
+				nodeDevices = append(nodeDevices, disk)
+			}
+		}
+	}
+
+	blockio.MarkShared(resolved, nodeDevices...)
+
+	return resolved, nil
+}
+
+// disk returns the disk of a partition, the device the block tracepoints
+// report its I/O on, and the name of the disk.
+func (b *BlockIo) disk(device blockio.Device) (blockio.Device, string) {
+	tracerService := b.doktor.tracerService
+
+	if _, err := tracerService.ReadFile(blockio.SysfsPath(device) + "/partition"); err == nil {
+		content, err := tracerService.ReadFile(blockio.SysfsPath(device) + "/../dev")
+		if err == nil {
+			if disk, err := blockio.ParseDevice(string(content)); err == nil {
+				device = disk
+			}
+		}
+	}
+
+	link, err := tracerService.ReadLink(blockio.SysfsPath(device))
+	if err != nil {
+		return device, ""
+	}
+
+	name := path.Base(link)
+	if strings.HasPrefix(name, "dm-") {
+		log.Warn().
+			Msgf("device: '%s' is a device mapper device, its I/O is reported on the devices it maps to", name)
+	}
+
+	return device, name
+}
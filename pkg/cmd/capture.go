@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/analysis/network"
+	"github.com/alam0rt/kubectl-doktor/pkg/capture"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	captureExample = `
+	# capture the packets of a pod for a minute into a pcapng file
+	%[1]s doktor capture example-pod -n default -p -d 1m -w example-pod.pcapng
+
+	# watch the DNS traffic of a pod live in Wireshark
+	%[1]s doktor capture example-pod -n default -p --wireshark 'port 53'
+
+	# use a static tcpdump when the helper image has none
+	%[1]s doktor capture example-pod -n default -p --tcpdump-binary ./tcpdump -w - > example-pod.pcapng
+	`
+)
+
+type Capture struct {
+	doktor        *Doktor
+	command       capture.Command
+	writePath     string
+	wireshark     bool
+	wiresharkPath string
+}
+
+func NewCmdCapture(doktor *Doktor) *cobra.Command {
+	c := &Capture{doktor: doktor}
+
+	cmd := &cobra.Command{
+		Use:          "capture <pod> [filter]",
+		Short:        "Capture the packets of a pod into pcapng annotated with the pod, or live into Wireshark",
+		Example:      fmt.Sprintf(captureExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := doktor.Complete(cmd, args); err != nil {
+				return err
+			}
+			if err := c.Validate(args); err != nil {
+				return err
+			}
+			if err := doktor.Validate(); err != nil {
+				return err
+			}
+
+			return c.Run()
+		},
+	}
+
+	cmd.Flags().DurationVarP(&c.command.Duration, "duration", "d", 30*time.Second,
+		"how long to capture for (e.g. 30s, 2m)")
+	cmd.Flags().StringVarP(&doktor.settings.UserSpecifiedInterface, "interface", "i", capture.DefaultInterface,
+		"interface of the pod's network namespace to capture on")
+	_ = viper.BindEnv("interface", "KUBECTL_PLUGINS_LOCAL_FLAG_INTERFACE")
+	_ = viper.BindPFlag("interface", cmd.Flags().Lookup("interface"))
+	cmd.Flags().IntVarP(&c.command.Snaplen, "snaplen", "", 0,
+		"bytes captured per packet, defaults to tcpdump's (optional)")
+	cmd.Flags().StringVarP(&c.writePath, "write", "w", "",
+		"pcapng file to write the packets to, '-' for stdout")
+	cmd.Flags().BoolVarP(&c.wireshark, "wireshark", "", false,
+		"stream the packets into a local Wireshark as they are captured")
+	cmd.Flags().StringVarP(&c.wiresharkPath, "wireshark-path", "", "wireshark",
+		"local Wireshark executable used with --wireshark")
+	cmd.Flags().StringVarP(&doktor.settings.TcpdumpBinary, "tcpdump-binary", "", "",
+		"local static tcpdump binary to upload to the privileged pod, "+
+			"defaults to tcpdump from the image (optional)")
+
+	return cmd
+}
+
+func (c *Capture) Validate(args []string) error {
+	if c.command.Duration <= 0 {
+		return errors.New("capture duration must be positive")
+	}
+
+	if c.writePath == "" && !c.wireshark {
+		return errors.New("nowhere to write the packets to, provide a file with --write or use --wireshark")
+	}
+
+	if c.doktor.settings.UserSpecifiedRecordPath != "" {
+		return errors.New("capture can't be recorded, the packets are written with --write")
+	}
+
+	if c.command.Snaplen < 0 {
+		return errors.New("snaplen can't be negative")
+	}
+
+	if len(args) > 1 {
+		c.command.Filter = strings.Join(args[1:], " ")
+	}
+
+	return nil
+}
+
+func (c *Capture) Run() error {
+	tracerService := c.doktor.tracerService
+	settings := c.doktor.settings
+
+	out, closeOut, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer closeOut()
+
+	return c.doktor.withTracer(func() error {
+		pids := tracerService.TargetPids()
+		if len(pids) == 0 {
+			return errors.Errorf("no processes found in container: '%s'", settings.UserSpecifiedContainer)
+		}
+
+		c.command.Netns = network.NamespacePath(pids[0])
+		c.command.Interface = settings.UserSpecifiedInterface
+
+		metadata := capture.Metadata{
+			Namespace:   c.doktor.resultingContext.Namespace,
+			Pod:         settings.UserSpecifiedPodName,
+			Container:   settings.UserSpecifiedContainer,
+			ContainerId: settings.DetectedContainerId,
+			Node:        settings.DetectedPodNodeName,
+			Interface:   c.command.Interface,
+			Filter:      c.command.Filter,
+		}
+
+		log.Info().
+			Str("pod", settings.UserSpecifiedPodName).
+			Str("interface", c.command.Interface).
+			Str("filter", c.command.Filter).
+			Dur("duration", c.command.Duration).
+			Msg("packet capture has begun")
+
+		reader, writer := io.Pipe()
+
+		converted := make(chan error, 1)
+		go func() {
+			packets, err := capture.Convert(reader, out, metadata)
+			_ = reader.CloseWithError(err)
+
+			log.Info().
+				Msgf("%d packets captured", packets)
+			converted <- err
+		}()
+
+		err := tracerService.StartCapture(c.command, writer)
+		_ = writer.Close()
+
+		if convertErr := <-converted; err == nil {
+			err = convertErr
+		}
+
+		return err
+	})
+}
+
+// open opens the destinations of the packets: the file to write, stdout, a
+// local Wireshark reading its standard input, or both.
+func (c *Capture) open() (io.Writer, func(), error) {
+	var writers []io.Writer
+	var closers []func()
+
+	closeAll := func() {
+		for _, closer := range closers {
+			closer()
+		}
+	}
+
+	switch c.writePath {
+	case "":
+	case "-":
+		writers = append(writers, c.doktor.Out)
+	default:
+		file, err := os.Create(c.writePath)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to create: '%s'", c.writePath)
+		}
+
+		writers = append(writers, file)
+		closers = append(closers, func() {
+			_ = file.Close()
+			log.Info().
+				Msgf("packets written to: '%s'", c.writePath)
+		})
+	}
+
+	if c.wireshark {
+		wireshark := exec.Command(c.wiresharkPath, "-k", "-i", "-")
+
+		stdin, err := wireshark.StdinPipe()
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+
+		if err := wireshark.Start(); err != nil {
+			closeAll()
+			return nil, nil, errors.Wrapf(err, "failed to start Wireshark: '%s'", c.wiresharkPath)
+		}
+
+		// Wireshark is left running for the capture to be looked at
+		writers = append(writers, stdin)
+		closers = append(closers, func() { _ = stdin.Close() })
+	}
+
+	return io.MultiWriter(writers...), closeAll, nil
+}
@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/coredump"
+	"github.com/alam0rt/kubectl-doktor/pkg/symbolize"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	coredumpLong = `Dump the core of a process of a container, archived with its executable and shared libraries.
+
+The process is paused until its memory is read, which takes a while for large processes: it
+doesn't serve requests nor answer probes meanwhile, and may be restarted by a failing liveness
+probe.`
+
+	coredumpExample = `
+	# dump the core of the main process of a pod into core-example-pod-<pid>.tar.gz
+	%[1]s doktor coredump example-pod -n default -p
+
+	# dump the core of another process of the container
+	%[1]s doktor coredump example-pod -n default -p --pid 4200 -w worker.tar.gz
+	`
+)
+
+type Coredump struct {
+	doktor    *Doktor
+	pid       string
+	writePath string
+}
+
+func NewCmdCoredump(doktor *Doktor) *cobra.Command {
+	c := &Coredump{doktor: doktor}
+
+	cmd := &cobra.Command{
+		Use:          "coredump <pod>",
+		Short:        "Dump the core of a process of a container, archived with its executable and shared libraries",
+		Long:         coredumpLong,
+		Example:      fmt.Sprintf(coredumpExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := doktor.Complete(cmd, args); err != nil {
+				return err
+			}
+			if err := c.Validate(); err != nil {
+				return err
+			}
+			if err := doktor.Validate(); err != nil {
+				return err
+			}
+
+			return c.Run()
+		},
+	}
+
+	cmd.Flags().StringVarP(&c.pid, "pid", "", "",
+		"host process id of the process to dump, defaults to the container's main process (optional)")
+	cmd.Flags().StringVarP(&c.writePath, "write", "w", "",
+		"gzipped tar file to write the core to, defaults to core-<pod>-<pid>.tar.gz")
+
+	return cmd
+}
+
+func (c *Coredump) Validate() error {
+	if c.doktor.settings.UserSpecifiedRecordPath != "" {
+		return errors.New("a core dump can't be recorded, the core is written with --write")
+	}
+
+	return nil
+}
+
+func (c *Coredump) Run() error {
+	settings := c.doktor.settings
+	tracerService := c.doktor.tracerService
+
+	return c.doktor.withTracer(func() error {
+		pids := tracerService.TargetPids()
+		if len(pids) == 0 {
+			return errors.Errorf("no processes found in container: '%s'", settings.UserSpecifiedContainer)
+		}
+
+		pid, err := c.target(pids)
+		if err != nil {
+			return err
+		}
+
+		executable, err := tracerService.ReadLink(symbolize.ExePath(pid))
+		if err != nil {
+			return err
+		}
+
+		maps, err := tracerService.ReadFile(symbolize.MapsPath(pid))
+		if err != nil {
+			return err
+		}
+
+		files, deleted := coredump.Files(symbolize.ParseMaps(maps))
+		for _, file := range deleted {
+			log.Warn().
+				Msgf("mapped file deleted since, it won't be archived: '%s'", file)
+		}
+
+		writePath := c.writePath
+		if writePath == "" {
+			writePath = fmt.Sprintf("core-%s-%s.tar.gz", settings.UserSpecifiedPodName, pid)
+		}
+
+		// the core is written next to writePath and only renamed once
+		// complete, so an interrupted or failed dump leaves no truncated core
+		file, err := ioutil.TempFile(filepath.Dir(writePath), "."+filepath.Base(writePath)+".*.tmp")
+		if err != nil {
+			return errors.Wrapf(err, "failed to create: '%s'", writePath)
+		}
+		tempPath := file.Name()
+
+		stop := removeOnInterrupt(tempPath)
+		defer stop()
+
+		log.Info().
+			Str("pod", settings.UserSpecifiedPodName).
+			Str("pid", pid).
+			Str("executable", executable).
+			Int("files", len(files)).
+			Msg("core dump has begun, the process is stopped until its memory is read")
+
+		out := &countingWriter{w: file}
+		err = tracerService.DumpCore(coredump.Command{Pid: pid, Files: files}, out)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err == nil {
+			err = os.Rename(tempPath, writePath)
+		}
+
+		if err != nil {
+			_ = os.Remove(tempPath)
+			return err
+		}
+
+		log.Info().
+			Int64("bytes", out.written).
+			Msgf("core written to: '%s', debug it once extracted with: %s", writePath, coredump.GdbCommand(executable, pid))
+
+		return nil
+	})
+}
+
+// removeOnInterrupt removes path and exits when interrupted, until the
+// returned function is called.
+func removeOnInterrupt(path string) func() {
+	interrupted := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-interrupted:
+			_ = os.Remove(path)
+			log.Error().
+				Msg("core dump interrupted, the partial core was removed")
+			os.Exit(1)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(interrupted)
+		close(done)
+	}
+}
+
+// target returns the process to dump, one of the container's.
+func (c *Coredump) target(pids []string) (string, error) {
+	if c.pid == "" {
+		return pids[0], nil
+	}
+
+	for _, pid := range pids {
+		if pid == c.pid {
+			return pid, nil
+		}
+	}
+
+	return "", errors.Errorf("process: '%s' isn't one of container: '%s', its processes: %v",
+		c.pid, c.doktor.settings.UserSpecifiedContainer, pids)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w       io.Writer
+	written int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.written += int64(n)
+	return n, err
+}
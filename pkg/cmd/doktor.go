@@ -1,22 +1,25 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
 	"github.com/alam0rt/kubectl-doktor/kube"
 	"github.com/alam0rt/kubectl-doktor/pkg/config"
+	"github.com/alam0rt/kubectl-doktor/pkg/k8smeta"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/alam0rt/kubectl-doktor/pkg/record"
 	"github.com/alam0rt/kubectl-doktor/pkg/service/tracer"
 	"github.com/alam0rt/kubectl-doktor/pkg/service/tracer/runtime"
+	"github.com/alam0rt/kubectl-doktor/pkg/stack"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	corev1 "k8s.io/api/core/v1"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -26,22 +29,41 @@ import (
 
 var (
 	doktorExample = `
-	%[1]s doktor example-pod -n default
+	%[1]s doktor example-pod -n default --filter 'tracepoint:raw_syscalls:sys_enter { @[comm] = count(); }'
+
+	# run a script bundle (a directory with a main.bt and its includes) using a static bpftrace
+	%[1]s doktor example-pod -n default --script ./my-bundle --bpftrace-binary ./bpftrace
+
+	# download files written to /tmp/doktor/artifacts, and a core dump from the host, once done
+	%[1]s doktor example-pod -n default --script ./my-bundle --output-dir ./out --artifact /host/var/crash
+
+	# record the session to replay it later without a cluster
+	%[1]s doktor example-pod -n default --script ./my-bundle --record session.tar.gz
+
+	# render the stacks a script collects as a flame graph, keeping the folded stacks too
+	%[1]s doktor example-pod -n default -p --flamegraph stacks.svg --folded stacks.folded \
+		--filter 'profile:hz:99 { @[kstack(perf), ustack(perf)] = count(); }'
+
+	# run the privileged helper pod in a dedicated namespace
+	%[1]s doktor example-pod -n default --helper-namespace doktor-system
 	`
 )
 
 type Doktor struct {
-	configFlags      *genericclioptions.ConfigFlags
-	resultingContext *api.Context
-	clientset        *kubernetes.Clientset
-	restConfig       *rest.Config
-	rawConfig        api.Config
-	settings         *config.DoktorSettings
-	tracerService    tracer.TracerService
+	configFlags          *genericclioptions.ConfigFlags
+	resultingContext     *api.Context
+	clientset            *kubernetes.Clientset
+	restConfig           *rest.Config
+	rawConfig            api.Config
+	settings             *config.DoktorSettings
+	tracerService        tracer.TracerService
+	kubernetesApiService kube.KubernetesApiService
+
+	genericclioptions.IOStreams
 }
 
-func NewDoktor(settings *config.DoktorSettings) *Doktor {
-	return &Doktor{settings: settings, configFlags: genericclioptions.NewConfigFlags(true)}
+func NewDoktor(settings *config.DoktorSettings, streams genericclioptions.IOStreams) *Doktor {
+	return &Doktor{settings: settings, configFlags: genericclioptions.NewConfigFlags(true), IOStreams: streams}
 }
 
 // NamespaceOptions provides information required to update
@@ -52,17 +74,21 @@ func NewCmdDoktor(streams genericclioptions.IOStreams) *cobra.Command {
 
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 
-	doktor := NewDoktor(doktorSettings)
+	doktor := NewDoktor(doktorSettings, streams)
 
 	cmd := &cobra.Command{
-		Use:          "kubectl doktor",
+		Use:          "doktor <pod>",
 		Short:        "What'chu wanna know?!",
 		Example:      fmt.Sprintf(doktorExample, "kubectl"),
 		SilenceUsage: true,
+		Args:         cobra.ArbitraryArgs,
 		RunE: func(c *cobra.Command, args []string) error {
 			if err := doktor.Complete(c, args); err != nil {
 				return err
 			}
+			if err := doktor.ValidateProgram(); err != nil {
+				return err
+			}
 			if err := doktor.Validate(); err != nil {
 				return err
 			}
@@ -74,63 +100,209 @@ func NewCmdDoktor(streams genericclioptions.IOStreams) *cobra.Command {
 		},
 	}
 
-	cmd.Flags().StringVarP(&doktorSettings.UserSpecifiedNamespace, "namespace", "n", "", "namespace (optional)")
+	cmd.PersistentFlags().StringVarP(&doktorSettings.UserSpecifiedNamespace, "namespace", "n", "", "namespace (optional)")
 	_ = viper.BindEnv("namespace", "KUBECTL_PLUGINS_CURRENT_NAMESPACE")
-	_ = viper.BindPFlag("namespace", cmd.Flags().Lookup("namespace"))
-
-	cmd.Flags().StringVarP(&doktorSettings.UserSpecifiedContainer, "container", "c", "", "container (optional)")
+	_ = viper.BindPFlag("namespace", cmd.PersistentFlags().Lookup("namespace"))
+
+	cmd.PersistentFlags().StringVarP(&doktorSettings.UserSpecifiedOutputFormat, "output", "o", output.FormatText,
+		fmt.Sprintf("output format, one of: %v", output.Formats))
+	_ = viper.BindEnv("output", "KUBECTL_PLUGINS_LOCAL_FLAG_OUTPUT")
+	_ = viper.BindPFlag("output", cmd.PersistentFlags().Lookup("output"))
+
+	cmd.PersistentFlags().StringVarP(&doktorSettings.UserSpecifiedRecordPath, "record", "", "",
+		"write a self-describing archive of the session, the program run and its raw output, to this file, "+
+			"see 'kubectl doktor replay' (optional)")
+	_ = viper.BindEnv("record", "KUBECTL_PLUGINS_LOCAL_FLAG_RECORD")
+	_ = viper.BindPFlag("record", cmd.PersistentFlags().Lookup("record"))
+
+	cmd.PersistentFlags().StringVarP(&doktorSettings.UserSpecifiedFlamegraphPath, "flamegraph", "", "",
+		"file to render an interactive SVG flame graph of the collected kernel and user stacks to (optional)")
+	_ = viper.BindEnv("flamegraph", "KUBECTL_PLUGINS_LOCAL_FLAG_FLAMEGRAPH")
+	_ = viper.BindPFlag("flamegraph", cmd.PersistentFlags().Lookup("flamegraph"))
+
+	cmd.PersistentFlags().StringVarP(&doktorSettings.UserSpecifiedFoldedPath, "folded", "", "",
+		"file to write the collected stacks to in the folded format of flamegraph.pl (optional)")
+	_ = viper.BindEnv("folded", "KUBECTL_PLUGINS_LOCAL_FLAG_FOLDED")
+	_ = viper.BindPFlag("folded", cmd.PersistentFlags().Lookup("folded"))
+
+	cmd.PersistentFlags().StringVarP(&doktorSettings.UserSpecifiedK8sMetadata, "k8s-metadata", "", "",
+		fmt.Sprintf("replace the pids or cgroup ids leading map keys, or printed as 'pid=<id>', with the "+
+			"namespace/pod/container running them, one of: %v (optional)", k8smeta.Kinds))
+	_ = viper.BindEnv("k8s-metadata", "KUBECTL_PLUGINS_LOCAL_FLAG_K8S_METADATA")
+	_ = viper.BindPFlag("k8s-metadata", cmd.PersistentFlags().Lookup("k8s-metadata"))
+
+	cmd.PersistentFlags().StringVarP(&doktorSettings.UserSpecifiedJvmAttachDir, "jvm-attach", "", "",
+		"local directory with a static jattach binary, and optionally perf-map-agent's libperfmap.so, "+
+			"used to make the target JVMs write perf maps to symbolize their JIT frames (optional)")
+	_ = viper.BindEnv("jvm-attach", "KUBECTL_PLUGINS_LOCAL_FLAG_JVM_ATTACH")
+	_ = viper.BindPFlag("jvm-attach", cmd.PersistentFlags().Lookup("jvm-attach"))
+
+	cmd.PersistentFlags().StringVarP(&doktorSettings.UserSpecifiedHelperNamespace, "helper-namespace", "", "",
+		"namespace to create and execute the privileged helper pod in, e.g. one allowing privileged pods, "+
+			"while the target pod is looked up in --namespace; defaults to the target pod namespace (optional)")
+	_ = viper.BindEnv("helper-namespace", "KUBECTL_PLUGINS_LOCAL_FLAG_HELPER_NAMESPACE")
+	_ = viper.BindPFlag("helper-namespace", cmd.PersistentFlags().Lookup("helper-namespace"))
+
+	cmd.PersistentFlags().StringVarP(&doktorSettings.UserSpecifiedContainer, "container", "c", "", "container (optional)")
 	_ = viper.BindEnv("container", "KUBECTL_PLUGINS_LOCAL_FLAG_CONTAINER")
-	_ = viper.BindPFlag("container", cmd.Flags().Lookup("container"))
+	_ = viper.BindPFlag("container", cmd.PersistentFlags().Lookup("container"))
 
 	cmd.Flags().StringVarP(&doktorSettings.UserSpecifiedFilter, "filter", "f", "", "bpftrace filter (optional)")
 	_ = viper.BindEnv("filter", "KUBECTL_PLUGINS_LOCAL_FLAG_FILTER")
 	_ = viper.BindPFlag("filter", cmd.Flags().Lookup("filter"))
 
-	cmd.Flags().BoolVarP(&doktorSettings.UserSpecifiedVerboseMode, "verbose", "v", false,
+	cmd.Flags().StringVarP(&doktorSettings.UserSpecifiedScript, "script", "s", "",
+		"bpftrace script file, or a directory bundle with a main.bt and its includes (optional)")
+	_ = viper.BindEnv("script", "KUBECTL_PLUGINS_LOCAL_FLAG_SCRIPT")
+	_ = viper.BindPFlag("script", cmd.Flags().Lookup("script"))
+
+	cmd.PersistentFlags().StringVarP(&doktorSettings.BpftraceBinary, "bpftrace-binary", "", "",
+		"local static bpftrace binary to upload to the privileged pod, "+
+			"defaults to the embedded binary if any, otherwise bpftrace from the image (optional)")
+	_ = viper.BindEnv("bpftrace-binary", "KUBECTL_PLUGINS_LOCAL_FLAG_BPFTRACE_BINARY")
+	_ = viper.BindPFlag("bpftrace-binary", cmd.PersistentFlags().Lookup("bpftrace-binary"))
+
+	cmd.Flags().StringVarP(&doktorSettings.UserSpecifiedOutputDir, "output-dir", "", "",
+		"local directory to download the session artifacts to once tracing completes (optional)")
+	_ = viper.BindEnv("output-dir", "KUBECTL_PLUGINS_LOCAL_FLAG_OUTPUT_DIR")
+	_ = viper.BindPFlag("output-dir", cmd.Flags().Lookup("output-dir"))
+
+	cmd.Flags().StringSliceVarP(&doktorSettings.UserSpecifiedArtifacts, "artifact", "", nil,
+		"additional path on the privileged pod to download with --output-dir, "+
+			"the host filesystem is mounted at /host (optional)")
+	_ = viper.BindEnv("artifact", "KUBECTL_PLUGINS_LOCAL_FLAG_ARTIFACT")
+	_ = viper.BindPFlag("artifact", cmd.Flags().Lookup("artifact"))
+
+	cmd.PersistentFlags().BoolVarP(&doktorSettings.UserSpecifiedVerboseMode, "verbose", "v", false,
 		"if specified, ksniff output will include debug information (optional)")
 	_ = viper.BindEnv("verbose", "KUBECTL_PLUGINS_LOCAL_FLAG_VERBOSE")
-	_ = viper.BindPFlag("verbose", cmd.Flags().Lookup("verbose"))
+	_ = viper.BindPFlag("verbose", cmd.PersistentFlags().Lookup("verbose"))
 
-	cmd.Flags().BoolVarP(&doktorSettings.UserSpecifiedPrivilegedMode, "privileged", "p", false,
+	cmd.PersistentFlags().BoolVarP(&doktorSettings.UserSpecifiedPrivilegedMode, "privileged", "p", false,
 		"if specified, doktor will deploy another pod that have privileges to attach to host namespace")
 	_ = viper.BindEnv("privileged", "KUBECTL_PLUGINS_LOCAL_FLAG_PRIVILEGED")
-	_ = viper.BindPFlag("privileged", cmd.Flags().Lookup("privileged"))
+	_ = viper.BindPFlag("privileged", cmd.PersistentFlags().Lookup("privileged"))
 
-	cmd.Flags().DurationVarP(&doktorSettings.UserSpecifiedPodCreateTimeout, "pod-creation-timeout", "",
+	cmd.PersistentFlags().DurationVarP(&doktorSettings.UserSpecifiedPodCreateTimeout, "pod-creation-timeout", "",
 		1*time.Minute, "the length of time to wait for privileged pod to be created (e.g. 20s, 2m, 1h). "+
 			"A value of zero means the creation never times out.")
 
-	cmd.Flags().StringVarP(&doktorSettings.Image, "image", "", "",
+	cmd.PersistentFlags().StringVarP(&doktorSettings.Image, "image", "", "",
 		"the privileged container image (optional)")
 	_ = viper.BindEnv("image", "KUBECTL_PLUGINS_LOCAL_FLAG_IMAGE")
-	_ = viper.BindPFlag("image", cmd.Flags().Lookup("image"))
+	_ = viper.BindPFlag("image", cmd.PersistentFlags().Lookup("image"))
 
-	cmd.Flags().StringVarP(&doktorSettings.UserSpecifiedKubeContext, "context", "x", "",
+	cmd.PersistentFlags().StringVarP(&doktorSettings.UserSpecifiedKubeContext, "context", "x", "",
 		"kubectl context to work on (optional)")
 	_ = viper.BindEnv("context", "KUBECTL_PLUGINS_CURRENT_CONTEXT")
-	_ = viper.BindPFlag("context", cmd.Flags().Lookup("context"))
+	_ = viper.BindPFlag("context", cmd.PersistentFlags().Lookup("context"))
 
-	cmd.Flags().StringVarP(&doktorSettings.SocketPath, "socket", "", "",
+	cmd.PersistentFlags().StringVarP(&doktorSettings.SocketPath, "socket", "", "",
 		"the container runtime socket path (optional)")
 	_ = viper.BindEnv("socket", "KUBECTL_PLUGINS_SOCKET_PATH")
-	_ = viper.BindPFlag("socket", cmd.Flags().Lookup("socket"))
+	_ = viper.BindPFlag("socket", cmd.PersistentFlags().Lookup("socket"))
+
+	cmd.AddCommand(NewCmdReplay(doktorSettings, streams))
+	cmd.AddCommand(NewCmdProfile(doktor))
+	cmd.AddCommand(NewCmdOffCpu(doktor))
+	cmd.AddCommand(NewCmdMemleak(doktor))
+	cmd.AddCommand(NewCmdFuncs(doktor))
+	cmd.AddCommand(NewCmdUsdt(doktor))
+	cmd.AddCommand(NewCmdSyscalls(doktor))
+	cmd.AddCommand(NewCmdFileio(doktor))
+	cmd.AddCommand(NewCmdBlockIo(doktor))
+	cmd.AddCommand(NewCmdSched(doktor))
+	cmd.AddCommand(NewCmdOom(doktor))
+	cmd.AddCommand(NewCmdExec(doktor))
+	cmd.AddCommand(NewCmdSignals(doktor))
+	cmd.AddCommand(NewCmdCoredump(doktor))
+	cmd.AddCommand(NewCmdNet(doktor))
+	cmd.AddCommand(NewCmdCapture(doktor))
 
 	return cmd
 }
 
 func (o *Doktor) Run() error {
+	printer, err := o.newPrinter()
+	if err != nil {
+		return err
+	}
+
+	return o.runSession(printer)
+}
+
+// newPrinter creates the printer of the output format the user asked for,
+// annotating ids with the workload running them if asked to.
+func (o *Doktor) newPrinter() (output.Printer, error) {
+	printer, err := output.NewPrinter(o.settings.UserSpecifiedOutputFormat, o.Out)
+	if err != nil {
+		return nil, err
+	}
+
+	kind := o.settings.UserSpecifiedK8sMetadata
+	if kind == "" {
+		return printer, nil
+	}
+
+	resolver := k8smeta.NewResolver(o.tracerService, func() ([]corev1.Pod, error) {
+		return o.kubernetesApiService.ListNodePods(o.settings.DetectedPodNodeName)
+	})
+
+	return output.NewAnnotatingPrinter(printer, kind, func(id string) (string, bool) {
+		workload, ok := resolver.Resolve(kind, id)
+		return workload.String(), ok
+	}), nil
+}
+
+// runSession streams the output of the user's bpftrace program through
+// printer until tracing completes.
+func (o *Doktor) runSession(printer output.Printer) error {
 	log.Info().
 		Str("pod", o.settings.UserSpecifiedPodName).
 		Str("namespace", o.resultingContext.Namespace).
+		Str("helper namespace", o.settings.HelperNamespace(o.resultingContext.Namespace)).
 		Str("container", o.settings.UserSpecifiedContainer).
 		Str("filter", o.settings.UserSpecifiedFilter).
 		Msg("tracing has begun")
 
-	err := o.tracerService.Setup()
-	if err != nil {
+	return o.withTracer(func() error {
+		var scripts map[string][]byte
+		if o.settings.UserSpecifiedRecordPath != "" {
+			var err error
+			scripts, err = record.ReadScripts(o.settings.UserSpecifiedFilter, o.settings.UserSpecifiedScript)
+			if err != nil {
+				return err
+			}
+		}
+
+		var collector *stack.Collector
+		if o.wantsStacks() {
+			collector = stack.NewCollector("")
+			printer = output.MultiPrinter{printer, collector}
+		}
+
+		err := o.stream(printer, scripts, o.tracerService.Start)
+
+		if collector != nil && err == nil {
+			samples := collector.Samples()
+			if err = o.symbolize(samples); err == nil {
+				err = o.writeStacks(samples, "samples")
+			}
+		}
+
+		if o.settings.UserSpecifiedOutputDir != "" {
+			if downloadErr := o.tracerService.DownloadArtifacts(o.settings.UserSpecifiedOutputDir); downloadErr != nil && err == nil {
+				err = downloadErr
+			}
+		}
+
 		return err
-	}
+	})
+}
 
+// withTracer sets up the tracer, runs fn and tears everything down again,
+// whether fn succeeded or not.
+func (o *Doktor) withTracer(fn func() error) error {
 	defer func() {
 		log.Info().
 			Msg("starting sniffer cleanup")
@@ -147,6 +319,90 @@ func (o *Doktor) Run() error {
 			Msg("sniffer cleanup completed successfully")
 	}()
 
+	err := o.tracerService.Setup()
+	if err != nil {
+		return err
+	}
+
+	return fn()
+}
+
+// stream runs start and consumes the bpftrace output it writes while it's
+// produced. With --record, the output is recorded along with scripts, the
+// program that was run.
+func (o *Doktor) stream(printer output.Printer, scripts map[string][]byte, start func(stdOut io.Writer) error) error {
+	var recorder *record.Recorder
+	if o.settings.UserSpecifiedRecordPath != "" {
+		metadata := record.NewMetadata(o.settings)
+		metadata.Target.Namespace = o.resultingContext.Namespace
+
+		var err error
+		recorder, err = record.NewRecorder(metadata, scripts)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = recorder.Close() }()
+
+		printer = output.MultiPrinter{printer, recorder}
+	}
+
+	reader, writer := io.Pipe()
+
+	consumed := make(chan error, 1)
+	go func() {
+		var r io.Reader = reader
+		if recorder != nil {
+			r = io.TeeReader(reader, recorder)
+		}
+
+		err := output.Consume(r, printer)
+		_ = reader.CloseWithError(err)
+		consumed <- err
+	}()
+
+	err := start(writer)
+	_ = writer.Close()
+
+	if consumeErr := <-consumed; err == nil {
+		err = consumeErr
+	}
+
+	if recorder != nil {
+		if saveErr := o.saveRecording(recorder); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}
+
+	return err
+}
+
+func (o *Doktor) saveRecording(recorder *record.Recorder) error {
+	var nodeInfo record.NodeInfo
+
+	node, err := o.kubernetesApiService.GetNode(o.settings.DetectedPodNodeName)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msgf("failed to get node: '%s', recording without node info", o.settings.DetectedPodNodeName)
+	} else {
+		nodeInfo = record.NodeInfo{
+			KernelVersion:           node.Status.NodeInfo.KernelVersion,
+			OSImage:                 node.Status.NodeInfo.OSImage,
+			OperatingSystem:         node.Status.NodeInfo.OperatingSystem,
+			Architecture:            node.Status.NodeInfo.Architecture,
+			ContainerRuntimeVersion: node.Status.NodeInfo.ContainerRuntimeVersion,
+		}
+	}
+
+	if err := recorder.Save(o.settings.UserSpecifiedRecordPath, nodeInfo); err != nil {
+		log.Error().
+			Msgf("failed to save recording: '%s'", o.settings.UserSpecifiedRecordPath)
+		return err
+	}
+
+	log.Info().
+		Msgf("session recorded to: '%s'", o.settings.UserSpecifiedRecordPath)
+
 	return nil
 }
 
@@ -164,9 +420,20 @@ func (o *Doktor) Complete(cmd *cobra.Command, args []string) error {
 	}
 
 	o.settings.UserSpecifiedNamespace = viper.GetString("namespace")
+	o.settings.UserSpecifiedHelperNamespace = viper.GetString("helper-namespace")
 	o.settings.UserSpecifiedContainer = viper.GetString("container")
 	o.settings.UserSpecifiedInterface = viper.GetString("interface")
 	o.settings.UserSpecifiedFilter = viper.GetString("filter")
+	o.settings.UserSpecifiedScript = viper.GetString("script")
+	o.settings.BpftraceBinary = viper.GetString("bpftrace-binary")
+	o.settings.UserSpecifiedOutputDir = viper.GetString("output-dir")
+	o.settings.UserSpecifiedArtifacts = viper.GetStringSlice("artifact")
+	o.settings.UserSpecifiedOutputFormat = viper.GetString("output")
+	o.settings.UserSpecifiedRecordPath = viper.GetString("record")
+	o.settings.UserSpecifiedFlamegraphPath = viper.GetString("flamegraph")
+	o.settings.UserSpecifiedFoldedPath = viper.GetString("folded")
+	o.settings.UserSpecifiedJvmAttachDir = viper.GetString("jvm-attach")
+	o.settings.UserSpecifiedK8sMetadata = viper.GetString("k8s-metadata")
 	o.settings.UserSpecifiedVerboseMode = viper.GetBool("verbose")
 	o.settings.UserSpecifiedPrivilegedMode = viper.GetBool("privileged")
 	o.settings.UserSpecifiedKubeContext = viper.GetString("context")
@@ -224,6 +491,24 @@ func (o *Doktor) Complete(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// ValidateProgram checks the options of running the user's own bpftrace
+// program, as opposed to one of the built-in analyses.
+func (o *Doktor) ValidateProgram() error {
+	if o.settings.UserSpecifiedFilter == "" && o.settings.UserSpecifiedScript == "" {
+		return errors.New("a bpftrace program is required, provide one with --filter or --script")
+	}
+
+	if o.settings.UserSpecifiedFilter != "" && o.settings.UserSpecifiedScript != "" {
+		return errors.New("--filter and --script are mutually exclusive")
+	}
+
+	if len(o.settings.UserSpecifiedArtifacts) > 0 && o.settings.UserSpecifiedOutputDir == "" {
+		return errors.New("--artifact requires --output-dir")
+	}
+
+	return nil
+}
+
 func (o *Doktor) Validate() error {
 	if len(o.rawConfig.CurrentContext) == 0 {
 		return errors.New("context doesn't exist")
@@ -233,9 +518,14 @@ func (o *Doktor) Validate() error {
 		return errors.New("namespace value is empty should be custom or default")
 	}
 
-	var err error
+	if kind := o.settings.UserSpecifiedK8sMetadata; kind != "" && kind != k8smeta.KindPid && kind != k8smeta.KindCgroup {
+		return errors.Errorf("unsupported k8s metadata id: '%s', supported ids are: %v", kind, k8smeta.Kinds)
+	}
 
-	pod, err := o.clientset.CoreV1().Pods(o.resultingContext.Namespace).Get(context.TODO(), o.settings.UserSpecifiedPodName, v1.GetOptions{})
+	o.kubernetesApiService = kube.NewKubernetesApiService(o.clientset, o.restConfig,
+		o.resultingContext.Namespace, o.settings.HelperNamespace(o.resultingContext.Namespace))
+
+	pod, err := o.kubernetesApiService.GetTargetPod(o.settings.UserSpecifiedPodName)
 	if err != nil {
 		return err
 	}
@@ -264,13 +554,11 @@ func (o *Doktor) Validate() error {
 		return err
 	}
 
-	kubernetesApiService := kube.NewKubernetesApiService(o.clientset, o.restConfig, o.resultingContext.Namespace)
-
 	if o.settings.UserSpecifiedPrivilegedMode {
 		log.Info().
 			Str("sniffing method", "privileged pod")
 		bridge := runtime.NewContainerRuntimeBridge(o.settings.DetectedContainerRuntime)
-		o.tracerService = tracer.NewPrivilegedPodRemoteTracingService(o.settings, kubernetesApiService, bridge)
+		o.tracerService = tracer.NewPrivilegedPodRemoteTracingService(o.settings, o.kubernetesApiService, bridge)
 		log.Info().
 			Str("detected bridge", bridge.GetDefaultImage())
 	} else {
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/analysis/process"
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/k8smeta"
+	"github.com/alam0rt/kubectl-doktor/pkg/record"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	execExample = `
+	# print the processes a pod executes and their exits for a minute
+	%[1]s doktor exec example-pod -n default -p -d 1m
+
+	# print the tree of the processes a pod ran while starting
+	%[1]s doktor exec example-pod -n default -p -d 2m --tree
+	`
+)
+
+type Exec struct {
+	doktor   *Doktor
+	duration time.Duration
+	tree     bool
+}
+
+func NewCmdExec(doktor *Doktor) *cobra.Command {
+	e := &Exec{doktor: doktor}
+
+	cmd := &cobra.Command{
+		Use:          "exec <pod>",
+		Short:        "Trace the processes executed in a pod and their exits, as events or a process tree",
+		Example:      fmt.Sprintf(execExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := doktor.Complete(c, args); err != nil {
+				return err
+			}
+			if err := e.Validate(); err != nil {
+				return err
+			}
+			if err := doktor.Validate(); err != nil {
+				return err
+			}
+
+			return e.Run()
+		},
+	}
+
+	cmd.Flags().DurationVarP(&e.duration, "duration", "d", time.Minute,
+		"how long to trace for (e.g. 1m, 10m)")
+	cmd.Flags().BoolVarP(&e.tree, "tree", "", false,
+		"print the tree of the processes once tracing ends instead of every event")
+
+	return cmd
+}
+
+func (e *Exec) Validate() error {
+	if e.duration <= 0 {
+		return errors.New("trace duration must be positive")
+	}
+
+	return nil
+}
+
+func (e *Exec) Run() error {
+	settings := e.doktor.settings
+	tracerService := e.doktor.tracerService
+
+	resolver := k8smeta.NewResolver(tracerService, func() ([]corev1.Pod, error) {
+		return e.doktor.kubernetesApiService.ListNodePods(settings.DetectedPodNodeName)
+	})
+	container := func(cgroup string) (string, bool) {
+		workload, ok := resolver.Resolve(k8smeta.KindCgroup, cgroup)
+		return workload.Container, ok
+	}
+
+	return e.doktor.withTracer(func() error {
+		predicate, err := e.predicate()
+		if err != nil {
+			return err
+		}
+
+		log.Info().
+			Str("pod", settings.UserSpecifiedPodName).
+			Bool("tree", e.tree).
+			Dur("duration", e.duration).
+			Msg("process tracing has begun")
+
+		command := bpftrace.Command{
+			Program:   process.Program(predicate, e.duration),
+			MaxStrlen: process.ArgvSize,
+		}
+
+		printer := process.NewPrinter(e.doktor.Out, settings.UserSpecifiedOutputFormat, e.tree, container)
+		return e.doktor.stream(printer, record.InlineScripts(command.Program), func(stdOut io.Writer) error {
+			return tracerService.StartCommand(command, stdOut)
+		})
+	})
+}
+
+// predicate matches the processes of the containers of the target pod, by
+// the pod cgroup, the parent of the ones of its containers, or by its
+// network namespace on hosts without cgroup v2.
+func (e *Exec) predicate() (string, error) {
+	if cgroup := e.doktor.tracerService.Target().Cgroup; cgroup != "" {
+		podCgroup := path.Dir(cgroup)
+		log.Debug().
+			Msgf("tracing the processes of pod cgroup: '%s'", podCgroup)
+		return process.PodPredicate(podCgroup), nil
+	}
+
+	pod, err := e.doktor.kubernetesApiService.GetTargetPod(e.doktor.settings.UserSpecifiedPodName)
+	if err != nil {
+		return "", err
+	}
+
+	if pod.Spec.HostNetwork {
+		return "", errors.Errorf("pod: '%s' uses the host network, without cgroup v2 its processes "+
+			"can't be told apart from the ones of the node", pod.Name)
+	}
+
+	netns, err := e.doktor.netNamespace()
+	if err != nil {
+		return "", err
+	}
+
+	log.Debug().
+		Msgf("tracing the processes of network namespace: '%s'", netns)
+
+	return process.NamespacePredicate(netns), nil
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/analysis/fileio"
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/record"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fileioExample = `
+	# summarize the reads and writes of the files of the first container for 30 seconds
+	%[1]s doktor fileio example-pod -n default -p
+
+	# only the files of a volume mounted at /data
+	%[1]s doktor fileio example-pod -n default -p --prefix /data
+
+	# print the reads and writes of the volume lasting 20ms or more
+	%[1]s doktor fileio example-pod -n default -p --prefix /data --slow 20ms -d 5m
+	`
+)
+
+type Fileio struct {
+	doktor  *Doktor
+	options fileio.Options
+}
+
+func NewCmdFileio(doktor *Doktor) *cobra.Command {
+	f := &Fileio{doktor: doktor}
+
+	cmd := &cobra.Command{
+		Use:          "fileio <pod>",
+		Short:        "Summarize the reads, writes and their latency per file of a container, or print the slow ones",
+		Example:      fmt.Sprintf(fileioExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := doktor.Complete(c, args); err != nil {
+				return err
+			}
+			if err := f.Validate(); err != nil {
+				return err
+			}
+			if err := doktor.Validate(); err != nil {
+				return err
+			}
+
+			return f.Run()
+		},
+	}
+
+	cmd.Flags().DurationVarP(&f.options.Duration, "duration", "d", 30*time.Second,
+		"how long to trace for (e.g. 30s, 2m)")
+	cmd.Flags().StringSliceVarP(&f.options.Prefixes, "prefix", "", nil,
+		"only trace the files under these paths, as seen from the container (optional)")
+	cmd.Flags().DurationVarP(&f.options.Slow, "slow", "", 0,
+		"print the reads and writes lasting at least this long (e.g. 10ms) rather than a summary (optional)")
+
+	return cmd
+}
+
+func (f *Fileio) Validate() error {
+	if f.options.Duration <= 0 {
+		return errors.New("tracing duration must be positive")
+	}
+
+	if f.options.Slow < 0 {
+		return errors.New("slow operation latency can't be negative")
+	}
+
+	for _, prefix := range f.options.Prefixes {
+		if !strings.HasPrefix(prefix, "/") {
+			return errors.Errorf("path prefix: '%s' must be absolute", prefix)
+		}
+		if len(strings.TrimSuffix(prefix, "/"))+1 >= fileio.PathSize {
+			return errors.Errorf("path prefix: '%s' is longer than the %d bytes of paths read", prefix, fileio.PathSize)
+		}
+	}
+
+	return nil
+}
+
+func (f *Fileio) Run() error {
+	log.Info().
+		Str("pod", f.doktor.settings.UserSpecifiedPodName).
+		Str("container", f.doktor.settings.UserSpecifiedContainer).
+		Strs("prefixes", f.options.Prefixes).
+		Dur("duration", f.options.Duration).
+		Msg("file I/O tracing has begun")
+
+	tracerService := f.doktor.tracerService
+
+	return f.doktor.withTracer(func() error {
+		pids := tracerService.TargetPids()
+		if len(pids) == 0 {
+			return errors.Errorf("no processes found in container: '%s'", f.doktor.settings.UserSpecifiedContainer)
+		}
+
+		command := bpftrace.Command{
+			Program:   fileio.Program(tracerService.Target(), f.options),
+			MaxStrlen: fileio.PathSize,
+		}
+
+		start := func(stdOut io.Writer) error {
+			return tracerService.StartCommand(command, stdOut)
+		}
+
+		if f.options.Slow > 0 {
+			return f.doktor.stream(fileio.NewEventPrinter(f.doktor.Out, f.doktor.settings.UserSpecifiedOutputFormat),
+				record.InlineScripts(command.Program), start)
+		}
+
+		collector := fileio.NewCollector()
+		if err := f.doktor.stream(collector, record.InlineScripts(command.Program), start); err != nil {
+			return err
+		}
+
+		return fileio.Print(f.doktor.Out, f.doktor.settings.UserSpecifiedOutputFormat, collector.Files())
+	})
+}
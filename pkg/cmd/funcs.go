@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/analysis/funcs"
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/record"
+	"github.com/alam0rt/kubectl-doktor/pkg/symbolize"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	funcsExample = `
+	# list the functions of the container's executable matching a regular expression
+	%[1]s doktor funcs example-pod -n default -p 'main\.'
+
+	# count the calls of a Go function and their latency for a minute
+	%[1]s doktor funcs example-pod -n default -p --uprobe 'main.(*Server).handle' -d 1m
+	`
+)
+
+type Funcs struct {
+	doktor  *Doktor
+	options funcs.Options
+	pattern *regexp.Regexp
+	uprobes []string
+}
+
+func NewCmdFuncs(doktor *Doktor) *cobra.Command {
+	f := &Funcs{doktor: doktor}
+
+	cmd := &cobra.Command{
+		Use:          "funcs <pod> [pattern]",
+		Short:        "List the functions of a container's executable, or measure their calls and latency",
+		Example:      fmt.Sprintf(funcsExample, "kubectl"),
+		Args:         cobra.RangeArgs(1, 2),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := doktor.Complete(c, args); err != nil {
+				return err
+			}
+			if err := f.Validate(args); err != nil {
+				return err
+			}
+			if err := doktor.Validate(); err != nil {
+				return err
+			}
+
+			return f.Run()
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&f.uprobes, "uprobe", "", nil,
+		"function to measure the calls and latency of, e.g. 'main.(*Server).handle' (optional)")
+	cmd.Flags().DurationVarP(&f.options.Duration, "duration", "d", 30*time.Second,
+		"how long to measure --uprobe functions for (e.g. 30s, 2m)")
+
+	return cmd
+}
+
+func (f *Funcs) Validate(args []string) error {
+	pattern := ""
+	if len(args) > 1 {
+		pattern = args[1]
+	}
+
+	var err error
+	f.pattern, err = regexp.Compile(pattern)
+	if err != nil {
+		return errors.Wrapf(err, "invalid function pattern: '%s'", pattern)
+	}
+
+	if len(f.uprobes) > 0 && f.options.Duration <= 0 {
+		return errors.New("tracing duration must be positive")
+	}
+
+	return nil
+}
+
+func (f *Funcs) Run() error {
+	tracerService := f.doktor.tracerService
+
+	return f.doktor.withTracer(func() error {
+		pids := tracerService.TargetPids()
+		if len(pids) == 0 {
+			return errors.Errorf("no processes found in container: '%s'", f.doktor.settings.UserSpecifiedContainer)
+		}
+
+		// the first process of a container is usually its entrypoint
+		pid := pids[0]
+		exe, err := tracerService.ReadLink(symbolize.ExePath(pid))
+		if err != nil {
+			return err
+		}
+		exe = strings.TrimSuffix(exe, " (deleted)")
+		remotePath := symbolize.RootPath(pid, exe)
+
+		localDir, err := ioutil.TempDir("", "doktor-funcs")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(localDir)
+
+		log.Info().
+			Msgf("reading functions of: '%s'", exe)
+
+		if err := tracerService.DownloadFile(remotePath, localDir); err != nil {
+			return err
+		}
+
+		binary, err := symbolize.OpenBinary(filepath.Join(localDir, path.Base(exe)))
+		if err != nil {
+			return errors.Wrapf(err, "failed to read executable: '%s'", exe)
+		}
+
+		if len(f.uprobes) == 0 {
+			return f.list(binary)
+		}
+
+		return f.trace(binary, remotePath, tracerService.Target())
+	})
+}
+
+func (f *Funcs) list(binary *symbolize.Binary) error {
+	for _, function := range binary.Functions(f.pattern) {
+		if _, err := fmt.Fprintf(f.doktor.Out, "0x%x\t%s\n", function.Address, function.Name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *Funcs) trace(binary *symbolize.Binary, binaryPath string, target bpftrace.Target) error {
+	key := funcs.ThreadKey
+	if binary.IsGo() {
+		key = funcs.GoroutineKey(binary.Machine())
+	}
+
+	var probes []funcs.Probe
+	for _, name := range f.uprobes {
+		functions := binary.Functions(regexp.MustCompile("^" + regexp.QuoteMeta(name) + "$"))
+		if len(functions) == 0 {
+			return errors.Errorf("function: '%s' not found, list them with: kubectl doktor funcs %s",
+				name, f.doktor.settings.UserSpecifiedPodName)
+		}
+
+		probe := funcs.Probe{Name: name, Entry: functions[0].Address}
+
+		if binary.IsGo() {
+			returns, err := binary.Returns(functions[0])
+			if err != nil {
+				return err
+			}
+			probe.Returns = returns
+		}
+
+		probes = append(probes, probe)
+	}
+
+	printer, err := f.doktor.newPrinter()
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("pod", f.doktor.settings.UserSpecifiedPodName).
+		Strs("functions", f.uprobes).
+		Dur("duration", f.options.Duration).
+		Msg("function tracing has begun")
+
+	program := funcs.Program(binaryPath, target, probes, key, f.options)
+
+	return f.doktor.stream(printer, record.InlineScripts(program), func(stdOut io.Writer) error {
+		return f.doktor.tracerService.StartProgram(program, stdOut)
+	})
+}
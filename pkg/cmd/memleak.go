@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/analysis/memleak"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/alam0rt/kubectl-doktor/pkg/record"
+	"github.com/alam0rt/kubectl-doktor/pkg/stack"
+	"github.com/alam0rt/kubectl-doktor/pkg/symbolize"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	memleakExample = `
+	# report the 10 allocation sites with the most memory still outstanding after 30 seconds
+	%[1]s doktor memleak example-pod -n default -p
+
+	# trace jemalloc instead of the C library for 5 minutes and write a heap profile
+	%[1]s doktor memleak example-pod -n default -p -d 5m --library /usr/lib/libjemalloc.so.2 --pprof heap.pb.gz
+	`
+)
+
+type Memleak struct {
+	doktor    *Doktor
+	options   memleak.Options
+	pprofPath string
+	top       int
+}
+
+func NewCmdMemleak(doktor *Doktor) *cobra.Command {
+	m := &Memleak{doktor: doktor}
+
+	cmd := &cobra.Command{
+		Use:          "memleak <pod>",
+		Short:        "Report the allocation sites of a container's processes with unfreed memory",
+		Example:      fmt.Sprintf(memleakExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := doktor.Complete(c, args); err != nil {
+				return err
+			}
+			if err := m.Validate(); err != nil {
+				return err
+			}
+			if err := doktor.Validate(); err != nil {
+				return err
+			}
+
+			return m.Run()
+		},
+	}
+
+	cmd.Flags().DurationVarP(&m.options.Duration, "duration", "d", 30*time.Second,
+		"how long to track allocations for (e.g. 30s, 5m)")
+	cmd.Flags().StringVarP(&m.options.Library, "library", "", "",
+		"path in the container of the allocator library to trace, defaults to the C library (optional)")
+	cmd.Flags().StringVarP(&m.pprofPath, "pprof", "", "",
+		"file to write the gzipped pprof heap profile to (optional)")
+	cmd.Flags().IntVarP(&m.top, "top", "", 10,
+		"number of allocation sites to print, 0 prints all of them")
+
+	return cmd
+}
+
+func (m *Memleak) Validate() error {
+	if m.options.Duration <= 0 {
+		return errors.New("tracing duration must be positive")
+	}
+
+	if m.top < 0 {
+		return errors.New("number of allocation sites to print can't be negative")
+	}
+
+	return nil
+}
+
+func (m *Memleak) Run() error {
+	log.Info().
+		Str("pod", m.doktor.settings.UserSpecifiedPodName).
+		Str("container", m.doktor.settings.UserSpecifiedContainer).
+		Dur("duration", m.options.Duration).
+		Msg("allocation tracing has begun")
+
+	tracerService := m.doktor.tracerService
+
+	return m.doktor.withTracer(func() error {
+		pids := tracerService.TargetPids()
+		if len(pids) == 0 {
+			return errors.Errorf("no processes found in container: '%s'", m.doktor.settings.UserSpecifiedContainer)
+		}
+
+		libraries, err := m.allocators(pids)
+		if err != nil {
+			return err
+		}
+
+		program := memleak.Program(tracerService.Target(), libraries, m.options)
+		bytes := stack.NewCollector(memleak.BytesMap)
+		allocations := stack.NewCollector(memleak.AllocationsMap)
+		start := time.Now()
+
+		err = m.doktor.stream(output.MultiPrinter{bytes, allocations}, record.InlineScripts(program), func(stdOut io.Writer) error {
+			return tracerService.StartProgram(program, stdOut)
+		})
+		if err != nil {
+			return err
+		}
+
+		sites := memleak.Sites(bytes.Samples(), allocations.Samples())
+		log.Info().
+			Msgf("found %d allocation sites with outstanding memory, symbolizing", len(sites))
+
+		samples := memleak.Samples(sites)
+		if err := m.doktor.symbolize(samples); err != nil {
+			return err
+		}
+		for i := range sites {
+			sites[i].Frames = samples[i].Frames
+		}
+
+		if m.pprofPath != "" {
+			if err := stack.WriteProfile(m.pprofPath, memleak.Build(sites, m.options, start)); err != nil {
+				return err
+			}
+		}
+
+		if err := m.doktor.writeStacks(samples, "bytes"); err != nil {
+			return err
+		}
+
+		return memleak.PrintTop(m.doktor.Out, sites, m.top)
+	})
+}
+
+// allocators finds the allocator libraries mapped by the target processes.
+func (m *Memleak) allocators(pids []string) ([]string, error) {
+	seen := map[string]bool{}
+
+	var libraries []string
+	for _, pid := range pids {
+		content, err := m.doktor.tracerService.ReadFile(symbolize.MapsPath(pid))
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Msgf("failed to read memory mappings of pid: '%s'", pid)
+			continue
+		}
+
+		libraries = append(libraries, memleak.Allocators(pid, symbolize.ParseMaps(content), m.options.Library, seen)...)
+	}
+
+	if len(libraries) == 0 {
+		return nil, errors.New("no allocator library mapped by the target processes, statically linked " +
+			"binaries need --library pointing at the allocator they use")
+	}
+
+	for _, library := range libraries {
+		log.Info().
+			Msgf("tracing allocations through: '%s'", library)
+	}
+
+	return libraries, nil
+}
@@ -0,0 +1,476 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/analysis/network"
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/k8smeta"
+	"github.com/alam0rt/kubectl-doktor/pkg/record"
+	"github.com/alam0rt/kubectl-doktor/pkg/symbolize"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	netTcpExample = `
+	# trace the TCP connections of a pod for 30 seconds
+	%[1]s doktor net tcp example-pod -n default -p
+
+	# only trace retransmits and resets for 5 minutes, as JSON
+	%[1]s doktor net tcp example-pod -n default -p -d 5m --events retransmit,reset-sent,reset-received -o json
+	`
+
+	netDnsExample = `
+	# trace the DNS lookups of a pod for a minute, summarizing those slower than 100ms or failed
+	%[1]s doktor net dns example-pod -n default -p -d 1m
+
+	# consider lookups slow from 20ms
+	%[1]s doktor net dns example-pod -n default -p --slow 20ms
+	`
+
+	netHttpExample = `
+	# print the HTTP and gRPC requests served and made by a pod as they complete
+	%[1]s doktor net http example-pod -n default -p
+
+	# summarize their latency per endpoint over 2 minutes
+	%[1]s doktor net http example-pod -n default -p -d 2m --aggregate
+
+	# include the requests of TLS connections made with OpenSSL or Go's crypto/tls, masking ids in paths
+	%[1]s doktor net http example-pod -n default -p --tls --redact query,ids
+	`
+)
+
+// NewCmdNet groups the analyses tracing the network activity of a pod.
+func NewCmdNet(doktor *Doktor) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "net",
+		Short: "Trace the network activity of a pod",
+	}
+
+	cmd.AddCommand(NewCmdNetTcp(doktor))
+	cmd.AddCommand(NewCmdNetDns(doktor))
+	cmd.AddCommand(NewCmdNetHttp(doktor))
+
+	return cmd
+}
+
+type NetTcp struct {
+	doktor  *Doktor
+	options network.TcpOptions
+	resolve bool
+}
+
+func NewCmdNetTcp(doktor *Doktor) *cobra.Command {
+	n := &NetTcp{doktor: doktor}
+
+	cmd := &cobra.Command{
+		Use:          "tcp <pod>",
+		Short:        "Trace TCP connects, accepts, closes, retransmits, resets and backlog drops of a pod",
+		Example:      fmt.Sprintf(netTcpExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := doktor.Complete(c, args); err != nil {
+				return err
+			}
+			if err := n.Validate(); err != nil {
+				return err
+			}
+			if err := doktor.Validate(); err != nil {
+				return err
+			}
+
+			return n.Run()
+		},
+	}
+
+	cmd.Flags().DurationVarP(&n.options.Duration, "duration", "d", 30*time.Second,
+		"how long to trace for (e.g. 30s, 2m)")
+	cmd.Flags().StringSliceVarP(&n.options.Events, "events", "", network.TcpEvents,
+		fmt.Sprintf("events to trace, any of: %v", network.TcpEvents))
+	cmd.Flags().BoolVarP(&n.resolve, "resolve", "", true,
+		"resolve remote addresses to the services and pods of the cluster")
+
+	return cmd
+}
+
+func (n *NetTcp) Validate() error {
+	if n.options.Duration <= 0 {
+		return errors.New("tracing duration must be positive")
+	}
+
+	if err := validateEvents(n.options.Events, network.TcpEvents); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func validateEvents(events []string, supported []string) error {
+	if len(events) == 0 {
+		return errors.New("at least one event to trace is required")
+	}
+
+	for _, event := range events {
+		found := false
+		for _, s := range supported {
+			found = found || event == s
+		}
+
+		if !found {
+			return errors.Errorf("unsupported event: '%s', supported events are: %v", event, supported)
+		}
+	}
+
+	return nil
+}
+
+func (n *NetTcp) Run() error {
+	tracerService := n.doktor.tracerService
+
+	return n.doktor.withTracer(func() error {
+		netns, err := n.doktor.netNamespace()
+		if err != nil {
+			return err
+		}
+
+		log.Info().
+			Str("pod", n.doktor.settings.UserSpecifiedPodName).
+			Str("network namespace", netns).
+			Dur("duration", n.options.Duration).
+			Msg("TCP tracing has begun")
+
+		printer := network.NewTcpPrinter(n.doktor.Out, n.doktor.settings.UserSpecifiedOutputFormat,
+			n.options.Events, n.doktor.addressResolver(n.resolve))
+
+		program := network.TcpProgram(netns, n.options)
+
+		return n.doktor.stream(printer, record.InlineScripts(program), func(stdOut io.Writer) error {
+			return tracerService.StartProgram(program, stdOut)
+		})
+	})
+}
+
+type NetDns struct {
+	doktor  *Doktor
+	options network.DnsOptions
+	resolve bool
+}
+
+func NewCmdNetDns(doktor *Doktor) *cobra.Command {
+	n := &NetDns{doktor: doktor}
+
+	cmd := &cobra.Command{
+		Use:          "dns <pod>",
+		Short:        "Trace the DNS lookups of a pod with their latency and response code",
+		Example:      fmt.Sprintf(netDnsExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := doktor.Complete(c, args); err != nil {
+				return err
+			}
+			if err := n.Validate(); err != nil {
+				return err
+			}
+			if err := doktor.Validate(); err != nil {
+				return err
+			}
+
+			return n.Run()
+		},
+	}
+
+	cmd.Flags().DurationVarP(&n.options.Duration, "duration", "d", 30*time.Second,
+		"how long to trace for (e.g. 30s, 2m)")
+	cmd.Flags().DurationVarP(&n.options.Slow, "slow", "", 100*time.Millisecond,
+		"latency from which lookups are summarized as slow (e.g. 20ms, 1s)")
+	cmd.Flags().BoolVarP(&n.resolve, "resolve", "", true,
+		"resolve DNS server addresses to the services and pods of the cluster")
+
+	return cmd
+}
+
+func (n *NetDns) Validate() error {
+	if n.options.Duration <= 0 {
+		return errors.New("tracing duration must be positive")
+	}
+
+	if n.options.Slow <= 0 {
+		return errors.New("slow lookup latency must be positive")
+	}
+
+	return nil
+}
+
+func (n *NetDns) Run() error {
+	tracerService := n.doktor.tracerService
+
+	return n.doktor.withTracer(func() error {
+		netns, err := n.doktor.netNamespace()
+		if err != nil {
+			return err
+		}
+
+		log.Info().
+			Str("pod", n.doktor.settings.UserSpecifiedPodName).
+			Str("network namespace", netns).
+			Dur("duration", n.options.Duration).
+			Msg("DNS tracing has begun")
+
+		collector := network.NewDnsCollector(n.doktor.Out, n.doktor.settings.UserSpecifiedOutputFormat,
+			n.options.Slow, n.doktor.addressResolver(n.resolve))
+
+		command := bpftrace.Command{
+			Program:   network.DnsProgram(netns, n.options),
+			MaxStrlen: network.DnsCaptureSize,
+		}
+
+		return n.doktor.stream(collector, record.InlineScripts(command.Program), func(stdOut io.Writer) error {
+			return tracerService.StartCommand(command, stdOut)
+		})
+	})
+}
+
+type NetHttp struct {
+	doktor     *Doktor
+	options    network.HttpOptions
+	aggregate  bool
+	tls        bool
+	redactions []string
+}
+
+func NewCmdNetHttp(doktor *Doktor) *cobra.Command {
+	n := &NetHttp{doktor: doktor}
+
+	cmd := &cobra.Command{
+		Use:          "http <pod>",
+		Short:        "Trace the plaintext HTTP/1.x, HTTP/2 and gRPC requests of a pod with their status and latency",
+		Example:      fmt.Sprintf(netHttpExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := doktor.Complete(c, args); err != nil {
+				return err
+			}
+			if err := n.Validate(); err != nil {
+				return err
+			}
+			if err := doktor.Validate(); err != nil {
+				return err
+			}
+
+			return n.Run()
+		},
+	}
+
+	cmd.Flags().DurationVarP(&n.options.Duration, "duration", "d", 30*time.Second,
+		"how long to trace for (e.g. 30s, 2m)")
+	cmd.Flags().BoolVarP(&n.aggregate, "aggregate", "", false,
+		"print latency histograms per endpoint once tracing ends, rather than every request")
+	cmd.Flags().BoolVarP(&n.tls, "tls", "", false,
+		"also read the plaintext of TLS connections made with OpenSSL or Go's crypto/tls, through uprobes")
+	cmd.Flags().StringSliceVarP(&n.redactions, "redact", "", []string{network.RedactQuery},
+		fmt.Sprintf("parts of request paths to mask, any of: %v", network.Redactions))
+
+	return cmd
+}
+
+func (n *NetHttp) Validate() error {
+	if n.options.Duration <= 0 {
+		return errors.New("tracing duration must be positive")
+	}
+
+	if n.tls && n.doktor.settings.UserSpecifiedRecordPath != "" {
+		return errors.New("TLS connections can't be recorded, the recording would hold their plaintext")
+	}
+
+	for _, redaction := range n.redactions {
+		found := false
+		for _, r := range network.Redactions {
+			found = found || redaction == r
+		}
+
+		if !found {
+			return errors.Errorf("unsupported redaction: '%s', supported redactions are: %v", redaction, network.Redactions)
+		}
+	}
+
+	return nil
+}
+
+func (n *NetHttp) Run() error {
+	tracerService := n.doktor.tracerService
+
+	return n.doktor.withTracer(func() error {
+		pids := tracerService.TargetPids()
+		if len(pids) == 0 {
+			return errors.Errorf("no processes found in container: '%s'", n.doktor.settings.UserSpecifiedContainer)
+		}
+
+		if n.tls {
+			libraries, err := n.tlsLibraries(pids)
+			if err != nil {
+				return err
+			}
+			n.options.Tls = libraries
+		}
+
+		log.Info().
+			Str("pod", n.doktor.settings.UserSpecifiedPodName).
+			Str("container", n.doktor.settings.UserSpecifiedContainer).
+			Dur("duration", n.options.Duration).
+			Msg("HTTP tracing has begun")
+
+		printer := network.NewHttpPrinter(n.doktor.Out, n.doktor.settings.UserSpecifiedOutputFormat,
+			n.aggregate, n.redactions)
+
+		command := bpftrace.Command{
+			Program:   network.HttpProgram(tracerService.Target(), n.options),
+			MaxStrlen: network.HttpCaptureSize,
+		}
+
+		return n.doktor.stream(printer, record.InlineScripts(command.Program), func(stdOut io.Writer) error {
+			return tracerService.StartCommand(command, stdOut)
+		})
+	})
+}
+
+// tlsLibraries finds the TLS libraries of the target processes: the libssl
+// they map, and their executables when they are Go programs or statically
+// link OpenSSL or one of its forks.
+func (n *NetHttp) tlsLibraries(pids []string) ([]network.TlsLibrary, error) {
+	tracerService := n.doktor.tracerService
+
+	localDir, err := ioutil.TempDir("", "doktor-tls")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(localDir)
+
+	seen := map[string]bool{}
+
+	var libraries []network.TlsLibrary
+	for _, pid := range pids {
+		var candidates []string
+
+		if exe, err := tracerService.ReadLink(symbolize.ExePath(pid)); err == nil {
+			candidates = append(candidates, exe)
+		}
+
+		content, err := tracerService.ReadFile(symbolize.MapsPath(pid))
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Msgf("failed to read memory mappings of pid: '%s'", pid)
+			continue
+		}
+
+		for _, mapping := range symbolize.ParseMaps(content) {
+			if !mapping.Deleted() && network.IsOpensslLibrary(mapping.Path) {
+				candidates = append(candidates, mapping.Path)
+			}
+		}
+
+		for _, candidate := range candidates {
+			if seen[candidate] || strings.HasSuffix(candidate, " (deleted)") {
+				continue
+			}
+			seen[candidate] = true
+
+			remotePath := symbolize.RootPath(pid, candidate)
+			binaryDir := filepath.Join(localDir, filepath.FromSlash(path.Dir(candidate)))
+
+			if err := tracerService.DownloadFile(remotePath, binaryDir); err != nil {
+				log.Warn().
+					Err(err).
+					Msgf("failed to download: '%s', its TLS connections won't be traced", candidate)
+				continue
+			}
+
+			binary, err := symbolize.OpenBinary(filepath.Join(binaryDir, path.Base(candidate)))
+			if err != nil {
+				log.Warn().
+					Err(err).
+					Msgf("failed to read: '%s', its TLS connections won't be traced", candidate)
+				continue
+			}
+
+			library, ok, err := network.FindTlsLibrary(remotePath, binary)
+			if err != nil {
+				log.Warn().
+					Err(err).
+					Msgf("TLS connections of: '%s' won't be traced", candidate)
+				continue
+			}
+			if !ok {
+				continue
+			}
+
+			log.Info().
+				Msgf("tracing the plaintext of TLS connections made with %s by: '%s'", library.Kind, candidate)
+			libraries = append(libraries, library)
+		}
+	}
+
+	if len(libraries) == 0 {
+		return nil, errors.Errorf("no OpenSSL library or Go crypto/tls found in container: '%s'",
+			n.doktor.settings.UserSpecifiedContainer)
+	}
+
+	log.Warn().
+		Msg("the plaintext of TLS connections is read from the target processes, " +
+			"it is only parsed locally and never printed beyond request lines and statuses")
+
+	return libraries, nil
+}
+
+// netNamespace returns the inode number of the target pod's network
+// namespace, shared by all of its containers.
+func (o *Doktor) netNamespace() (string, error) {
+	pids := o.tracerService.TargetPids()
+	if len(pids) == 0 {
+		return "", errors.Errorf("no processes found in container: '%s'", o.settings.UserSpecifiedContainer)
+	}
+
+	link, err := o.tracerService.ReadLink(network.NamespacePath(pids[0]))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read the network namespace of pid: '%s'", pids[0])
+	}
+
+	netns, err := network.ParseNamespace(link)
+	if err != nil {
+		return "", err
+	}
+
+	if hostLink, err := o.tracerService.ReadLink(network.NamespacePath("1")); err == nil && hostLink == link {
+		log.Warn().
+			Msg("the target pod uses the host network, the network activity of the whole node will be traced")
+	}
+
+	return netns, nil
+}
+
+// addressResolver resolves IP addresses to the services and pods of the
+// cluster, when asked to.
+func (o *Doktor) addressResolver(resolve bool) network.ResolveFunc {
+	if !resolve {
+		return nil
+	}
+
+	resolver := k8smeta.NewAddressResolver(o.kubernetesApiService.ListPods, o.kubernetesApiService.ListServices)
+
+	return func(ip string) (string, bool) {
+		endpoint, ok := resolver.Resolve(ip)
+		if !ok {
+			return "", false
+		}
+		return endpoint.String(), true
+	}
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/analysis/offcpu"
+	"github.com/alam0rt/kubectl-doktor/pkg/record"
+	"github.com/alam0rt/kubectl-doktor/pkg/stack"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	offCpuExample = `
+	# print the 10 stacks the container's threads spent the most time blocked in
+	%[1]s doktor offcpu example-pod -n default -p
+
+	# only account for blocks of 10ms or more and render them as a flame graph
+	%[1]s doktor offcpu example-pod -n default -p --min-block 10ms --flamegraph offcpu.svg
+
+	# write a pprof profile and the folded stacks
+	%[1]s doktor offcpu example-pod -n default -p --pprof offcpu.pb.gz --folded offcpu.folded
+	`
+)
+
+type OffCpu struct {
+	doktor    *Doktor
+	options   offcpu.Options
+	pprofPath string
+	top       int
+}
+
+func NewCmdOffCpu(doktor *Doktor) *cobra.Command {
+	o := &OffCpu{doktor: doktor}
+
+	cmd := &cobra.Command{
+		Use:          "offcpu <pod>",
+		Short:        "Measure the time a container's threads spend blocked, by stack and wakeup reason",
+		Example:      fmt.Sprintf(offCpuExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := doktor.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			if err := doktor.Validate(); err != nil {
+				return err
+			}
+
+			return o.Run()
+		},
+	}
+
+	cmd.Flags().DurationVarP(&o.options.Duration, "duration", "d", 30*time.Second,
+		"how long to trace for (e.g. 30s, 2m)")
+	cmd.Flags().DurationVarP(&o.options.MinBlock, "min-block", "", time.Millisecond,
+		"shortest block to account for (e.g. 100us, 10ms)")
+	cmd.Flags().StringVarP(&o.pprofPath, "pprof", "", "",
+		"file to write the gzipped pprof profile to (optional)")
+	cmd.Flags().IntVarP(&o.top, "top", "", 10,
+		"number of blocking stacks to print, 0 prints all of them")
+
+	return cmd
+}
+
+func (o *OffCpu) Validate() error {
+	if o.options.Duration <= 0 {
+		return errors.New("tracing duration must be positive")
+	}
+
+	if o.options.MinBlock < 0 {
+		return errors.New("minimum block duration can't be negative")
+	}
+
+	if o.top < 0 {
+		return errors.New("number of stacks to print can't be negative")
+	}
+
+	return nil
+}
+
+func (o *OffCpu) Run() error {
+	log.Info().
+		Str("pod", o.doktor.settings.UserSpecifiedPodName).
+		Str("container", o.doktor.settings.UserSpecifiedContainer).
+		Dur("duration", o.options.Duration).
+		Dur("min block", o.options.MinBlock).
+		Msg("off-CPU tracing has begun")
+
+	tracerService := o.doktor.tracerService
+
+	return o.doktor.withTracer(func() error {
+		pids := tracerService.TargetPids()
+		if len(pids) == 0 {
+			return errors.Errorf("no processes found in container: '%s'", o.doktor.settings.UserSpecifiedContainer)
+		}
+
+		program := offcpu.Program(tracerService.Target(), o.options)
+		collector := stack.NewCollector(offcpu.StacksMap)
+		start := time.Now()
+
+		err := o.doktor.stream(collector, record.InlineScripts(program), func(stdOut io.Writer) error {
+			return tracerService.StartProgram(program, stdOut)
+		})
+		if err != nil {
+			return err
+		}
+
+		samples := collector.Samples()
+		log.Info().
+			Msgf("collected %d distinct blocking stacks, symbolizing", len(samples))
+
+		if err := o.doktor.symbolize(samples); err != nil {
+			return err
+		}
+
+		offcpu.Annotate(samples)
+
+		if o.pprofPath != "" {
+			if err := stack.WriteProfile(o.pprofPath, offcpu.Build(samples, o.options, start)); err != nil {
+				return err
+			}
+		}
+
+		if err := o.doktor.writeStacks(samples, "us"); err != nil {
+			return err
+		}
+
+		return offcpu.PrintTop(o.doktor.Out, samples, o.top)
+	})
+}
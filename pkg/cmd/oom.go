@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/analysis/oom"
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/k8smeta"
+	"github.com/alam0rt/kubectl-doktor/pkg/record"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	oomExample = `
+	# watch the OOM kills of a pod for 10 minutes
+	%[1]s doktor oom example-pod -n default -p
+
+	# watch the OOM kills and memory cgroup reclaim of every pod of the node of a pod for an hour
+	%[1]s doktor oom example-pod -n default -p --all-pods -d 1h
+	`
+)
+
+type Oom struct {
+	doktor   *Doktor
+	duration time.Duration
+	allPods  bool
+}
+
+func NewCmdOom(doktor *Doktor) *cobra.Command {
+	o := &Oom{doktor: doktor}
+
+	cmd := &cobra.Command{
+		Use:          "oom <pod>",
+		Short:        "Watch the OOM kills and memory cgroup reclaim of a pod, or of every pod of its node",
+		Example:      fmt.Sprintf(oomExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := doktor.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			if err := doktor.Validate(); err != nil {
+				return err
+			}
+
+			return o.Run()
+		},
+	}
+
+	cmd.Flags().DurationVarP(&o.duration, "duration", "d", 10*time.Minute,
+		"how long to watch for (e.g. 10m, 1h)")
+	cmd.Flags().BoolVarP(&o.allPods, "all-pods", "", false,
+		"report the OOM kills and reclaim of every pod of the node, and of processes outside of pods")
+
+	return cmd
+}
+
+func (o *Oom) Validate() error {
+	if o.duration <= 0 {
+		return errors.New("watch duration must be positive")
+	}
+
+	return nil
+}
+
+func (o *Oom) Run() error {
+	settings := o.doktor.settings
+	namespace := o.doktor.resultingContext.Namespace
+
+	node, err := o.doktor.kubernetesApiService.GetNode(settings.DetectedPodNodeName)
+	if err != nil {
+		return err
+	}
+	layout := oom.RssLayoutOf(node.Status.NodeInfo.KernelVersion)
+
+	// the pods are listed again for every kill, the victim's container
+	// being restarted meanwhile
+	owner := func(cgroups ...string) (k8smeta.Workload, bool) {
+		pods, err := o.doktor.kubernetesApiService.ListNodePods(settings.DetectedPodNodeName)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Msg("failed to list the pods of the node")
+			return k8smeta.Workload{}, false
+		}
+		return oom.Owner(pods, cgroups...)
+	}
+
+	filter := func(owner *k8smeta.Workload) bool {
+		return o.allPods || owner != nil && owner.Namespace == namespace && owner.Pod == settings.UserSpecifiedPodName
+	}
+
+	tracerService := o.doktor.tracerService
+
+	return o.doktor.withTracer(func() error {
+		log.Info().
+			Str("node", settings.DetectedPodNodeName).
+			Str("pod", settings.UserSpecifiedPodName).
+			Bool("all pods", o.allPods).
+			Dur("duration", o.duration).
+			Msg("OOM watch has begun")
+
+		command := bpftrace.Command{
+			Program:   oom.Program(o.duration, layout),
+			MaxStrlen: oom.NameSize,
+		}
+
+		watcher := oom.NewWatcher(o.doktor.Out, settings.UserSpecifiedOutputFormat, owner, filter)
+		return o.doktor.stream(watcher, record.InlineScripts(command.Program), func(stdOut io.Writer) error {
+			return tracerService.StartCommand(command, stdOut)
+		})
+	})
+}
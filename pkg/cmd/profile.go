@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/analysis/profile"
+	"github.com/alam0rt/kubectl-doktor/pkg/record"
+	"github.com/alam0rt/kubectl-doktor/pkg/stack"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	profileExample = `
+	# sample on-CPU stacks of the first container for 30 seconds
+	%[1]s doktor profile example-pod -n default -p
+
+	# sample at 999Hz for a minute and open the result
+	%[1]s doktor profile example-pod -n default -p -F 999 -d 1m --pprof cpu.pb.gz
+	go tool pprof -http :8080 cpu.pb.gz
+
+	# also render a flame graph
+	%[1]s doktor profile example-pod -n default -p --flamegraph cpu.svg
+	`
+)
+
+type Profile struct {
+	doktor    *Doktor
+	options   profile.Options
+	pprofPath string
+}
+
+func NewCmdProfile(doktor *Doktor) *cobra.Command {
+	p := &Profile{doktor: doktor}
+
+	cmd := &cobra.Command{
+		Use:          "profile <pod>",
+		Short:        "CPU profile a container to a pprof file",
+		Example:      fmt.Sprintf(profileExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := doktor.Complete(c, args); err != nil {
+				return err
+			}
+			if err := p.Validate(); err != nil {
+				return err
+			}
+			if err := doktor.Validate(); err != nil {
+				return err
+			}
+
+			return p.Run()
+		},
+	}
+
+	cmd.Flags().IntVarP(&p.options.Frequency, "frequency", "F", 99,
+		"number of stack samples per second and CPU")
+	cmd.Flags().DurationVarP(&p.options.Duration, "duration", "d", 30*time.Second,
+		"how long to sample for (e.g. 30s, 2m)")
+	cmd.Flags().StringVarP(&p.pprofPath, "pprof", "", "",
+		"file to write the gzipped pprof profile to, defaults to <pod>.pb.gz (optional)")
+
+	return cmd
+}
+
+func (p *Profile) Validate() error {
+	if p.options.Frequency <= 0 {
+		return errors.New("sampling frequency must be positive")
+	}
+
+	if p.options.Duration <= 0 {
+		return errors.New("sampling duration must be positive")
+	}
+
+	if p.pprofPath == "" {
+		p.pprofPath = p.doktor.settings.UserSpecifiedPodName + ".pb.gz"
+	}
+
+	return nil
+}
+
+func (p *Profile) Run() error {
+	log.Info().
+		Str("pod", p.doktor.settings.UserSpecifiedPodName).
+		Str("container", p.doktor.settings.UserSpecifiedContainer).
+		Int("frequency", p.options.Frequency).
+		Dur("duration", p.options.Duration).
+		Msg("profiling has begun")
+
+	tracerService := p.doktor.tracerService
+
+	return p.doktor.withTracer(func() error {
+		pids := tracerService.TargetPids()
+		if len(pids) == 0 {
+			return errors.Errorf("no processes found in container: '%s'", p.doktor.settings.UserSpecifiedContainer)
+		}
+
+		program := profile.Program(tracerService.Target(), p.options)
+		collector := stack.NewCollector(profile.StacksMap)
+		start := time.Now()
+
+		err := p.doktor.stream(collector, record.InlineScripts(program), func(stdOut io.Writer) error {
+			return tracerService.StartProgram(program, stdOut)
+		})
+		if err != nil {
+			return err
+		}
+
+		samples := collector.Samples()
+		log.Info().
+			Msgf("collected %d distinct stacks, symbolizing", len(samples))
+
+		if err := p.doktor.symbolize(samples); err != nil {
+			return err
+		}
+
+		if err := stack.WriteProfile(p.pprofPath, profile.Build(samples, p.options, start)); err != nil {
+			return err
+		}
+
+		return p.doktor.writeStacks(samples, "samples")
+	})
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/config"
+	"github.com/alam0rt/kubectl-doktor/pkg/output"
+	"github.com/alam0rt/kubectl-doktor/pkg/record"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+var (
+	replayExample = `
+	# re-render a session recorded with --record
+	%[1]s doktor replay session.tar.gz
+
+	# print the recorded events as JSON lines
+	%[1]s doktor replay session.tar.gz -o json
+	`
+)
+
+type Replay struct {
+	settings *config.DoktorSettings
+
+	genericclioptions.IOStreams
+}
+
+func NewReplay(settings *config.DoktorSettings, streams genericclioptions.IOStreams) *Replay {
+	return &Replay{settings: settings, IOStreams: streams}
+}
+
+func NewCmdReplay(settings *config.DoktorSettings, streams genericclioptions.IOStreams) *cobra.Command {
+	replay := NewReplay(settings, streams)
+
+	cmd := &cobra.Command{
+		Use:          "replay <file>",
+		Short:        "Re-render a recorded session without a cluster",
+		Example:      fmt.Sprintf(replayExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				_ = c.Usage()
+				return errors.New("provide the recording to replay")
+			}
+
+			return replay.Run(args[0])
+		},
+	}
+
+	return cmd
+}
+
+func (r *Replay) Run(filePath string) error {
+	recording, err := record.Read(filePath)
+	if err != nil {
+		return err
+	}
+
+	metadata := recording.Metadata
+	log.Info().
+		Str("pod", metadata.Target.Pod).
+		Str("namespace", metadata.Target.Namespace).
+		Str("container", metadata.Target.Container).
+		Str("node", metadata.Target.Node).
+		Str("kernel", metadata.Node.KernelVersion).
+		Time("started", metadata.StartedAt).
+		Time("finished", metadata.FinishedAt).
+		Msg("replaying recorded session")
+
+	printer, err := output.NewPrinter(viper.GetString("output"), r.Out)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range recording.Events {
+		if err := printer.Print(event.Record); err != nil {
+			return err
+		}
+	}
+
+	return printer.Flush()
+}
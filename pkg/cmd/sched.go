@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/analysis/sched"
+	"github.com/alam0rt/kubectl-doktor/pkg/record"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	schedExample = `
+	# measure the run queue latency of the first container and its CPU throttling for 30 seconds
+	%[1]s doktor sched example-pod -n default -p
+
+	# include the latency and throttling of every second
+	%[1]s doktor sched example-pod -n default -p -d 2m --timeline
+	`
+)
+
+type Sched struct {
+	doktor   *Doktor
+	duration time.Duration
+	timeline bool
+}
+
+func NewCmdSched(doktor *Doktor) *cobra.Command {
+	s := &Sched{doktor: doktor}
+
+	cmd := &cobra.Command{
+		Use:          "sched <pod>",
+		Short:        "Measure the run queue latency of a container and correlate it with its CPU limit throttling",
+		Example:      fmt.Sprintf(schedExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := doktor.Complete(c, args); err != nil {
+				return err
+			}
+			if err := s.Validate(); err != nil {
+				return err
+			}
+			if err := doktor.Validate(); err != nil {
+				return err
+			}
+
+			return s.Run()
+		},
+	}
+
+	cmd.Flags().DurationVarP(&s.duration, "duration", "d", 30*time.Second,
+		"how long to trace for (e.g. 30s, 2m)")
+	cmd.Flags().BoolVarP(&s.timeline, "timeline", "", false,
+		"print the run queue latency and throttling of every second after the summary")
+
+	return cmd
+}
+
+func (s *Sched) Validate() error {
+	if s.duration <= 0 {
+		return errors.New("tracing duration must be positive")
+	}
+
+	return nil
+}
+
+func (s *Sched) Run() error {
+	settings := s.doktor.settings
+
+	pod, err := s.doktor.kubernetesApiService.GetTargetPod(settings.UserSpecifiedPodName)
+	if err != nil {
+		return err
+	}
+
+	var limit sched.Limit
+	for _, container := range pod.Spec.Containers {
+		if container.Name == settings.UserSpecifiedContainer && !container.Resources.Limits.Cpu().IsZero() {
+			limit.Spec = container.Resources.Limits.Cpu().String()
+		}
+	}
+
+	tracerService := s.doktor.tracerService
+
+	return s.doktor.withTracer(func() error {
+		pids := tracerService.TargetPids()
+		if len(pids) == 0 {
+			return errors.Errorf("no processes found in container: '%s'", settings.UserSpecifiedContainer)
+		}
+
+		cgroup, err := s.cgroup(pids[0])
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Msg("failed to find the cgroup of the container, throttling won't be reported")
+		} else if quota, err := s.quota(cgroup); err != nil {
+			log.Warn().
+				Err(err).
+				Msg("failed to read the cpu quota of the container")
+		} else {
+			limit.Quota = &quota
+		}
+
+		var samples []sched.Sample
+		sample := func() {
+			if cgroup == nil {
+				return
+			}
+
+			content, err := tracerService.ReadFile(cgroup.StatPath())
+			if err != nil {
+				log.Debug().
+					Err(err).
+					Msg("failed to read cpu.stat")
+				return
+			}
+
+			stat, err := sched.ParseCpuStat(content)
+			if err != nil {
+				log.Debug().
+					Err(err).
+					Msg("failed to parse cpu.stat")
+				return
+			}
+
+			samples = append(samples, sched.Sample{Time: time.Now(), Stat: stat})
+		}
+
+		// cpu.stat is read every second while tracing, to tell the seconds
+		// the container was throttled in
+		stop, stopped := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(stopped)
+
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+
+			sample()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+					sample()
+				}
+			}
+		}()
+
+		log.Info().
+			Str("pod", settings.UserSpecifiedPodName).
+			Str("container", settings.UserSpecifiedContainer).
+			Str("limit", limit.Spec).
+			Dur("duration", s.duration).
+			Msg("scheduler tracing has begun")
+
+		collector := sched.NewCollector()
+		program := sched.Program(tracerService.Target(), s.duration)
+		err = s.doktor.stream(collector, record.InlineScripts(program), func(stdOut io.Writer) error {
+			return tracerService.StartProgram(program, stdOut)
+		})
+
+		close(stop)
+		<-stopped
+		sample()
+
+		if err != nil {
+			return err
+		}
+
+		return sched.Print(s.doktor.Out, settings.UserSpecifiedOutputFormat, collector.Report(limit, samples), s.timeline)
+	})
+}
+
+// cgroup returns the cgroup of the container's CPU controller: the cgroup v2
+// directory the tracer resolved, or on cgroup v1 the one of the cpu
+// controller of the process.
+func (s *Sched) cgroup(pid string) (*sched.Cgroup, error) {
+	if dir := s.doktor.tracerService.Target().Cgroup; dir != "" {
+		return &sched.Cgroup{Dir: dir, V2: true}, nil
+	}
+
+	content, err := s.doktor.tracerService.ReadFile(sched.CgroupPath(pid))
+	if err != nil {
+		return nil, err
+	}
+
+	cgroup, err := sched.ParseV1Cgroup(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cgroup, nil
+}
+
+func (s *Sched) quota(cgroup *sched.Cgroup) (sched.Quota, error) {
+	var contents [][]byte
+	for _, quotaPath := range cgroup.QuotaPaths() {
+		content, err := s.doktor.tracerService.ReadFile(quotaPath)
+		if err != nil {
+			return sched.Quota{}, err
+		}
+		contents = append(contents, content)
+	}
+
+	return sched.ParseQuota(contents...)
+}
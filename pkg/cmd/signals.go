@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/analysis/signals"
+	"github.com/alam0rt/kubectl-doktor/pkg/k8smeta"
+	"github.com/alam0rt/kubectl-doktor/pkg/record"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	signalsExample = `
+	# trace the signals sent to the processes of a pod for 10 minutes, and the crashes
+	%[1]s doktor signals example-pod -n default -p
+
+	# also trace the SIGCHLD and SIGURG signals, left out by default
+	%[1]s doktor signals example-pod -n default -p --ignore ''
+
+	# leave out the SIGPIPE signals too
+	%[1]s doktor signals example-pod -n default -p --ignore SIGCHLD,SIGURG,SIGPIPE
+	`
+)
+
+type Signals struct {
+	doktor   *Doktor
+	duration time.Duration
+	ignore   []string
+	ignored  []int
+}
+
+func NewCmdSignals(doktor *Doktor) *cobra.Command {
+	s := &Signals{doktor: doktor}
+
+	cmd := &cobra.Command{
+		Use:          "signals <pod>",
+		Short:        "Trace the signals sent to the processes of a container, their senders, and the crashes they cause",
+		Example:      fmt.Sprintf(signalsExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := doktor.Complete(c, args); err != nil {
+				return err
+			}
+			if err := s.Validate(); err != nil {
+				return err
+			}
+			if err := doktor.Validate(); err != nil {
+				return err
+			}
+
+			return s.Run()
+		},
+	}
+
+	cmd.Flags().DurationVarP(&s.duration, "duration", "d", 10*time.Minute,
+		"how long to trace for (e.g. 10m, 1h)")
+	cmd.Flags().StringSliceVarP(&s.ignore, "ignore", "", signals.DefaultIgnored,
+		"signals left out, by name or number")
+
+	return cmd
+}
+
+func (s *Signals) Validate() error {
+	if s.duration <= 0 {
+		return errors.New("trace duration must be positive")
+	}
+
+	s.ignored = nil
+	for _, name := range s.ignore {
+		if name == "" {
+			continue
+		}
+
+		signal, ok := signals.Number(name)
+		if !ok {
+			return errors.Errorf("unknown signal: '%s'", name)
+		}
+		s.ignored = append(s.ignored, signal)
+	}
+
+	return nil
+}
+
+func (s *Signals) Run() error {
+	settings := s.doktor.settings
+	tracerService := s.doktor.tracerService
+
+	resolver := k8smeta.NewResolver(tracerService, func() ([]corev1.Pod, error) {
+		return s.doktor.kubernetesApiService.ListNodePods(settings.DetectedPodNodeName)
+	})
+	workload := func(cgroup string) (string, bool) {
+		workload, ok := resolver.Resolve(k8smeta.KindCgroup, cgroup)
+		if !ok {
+			return "", false
+		}
+		return workload.String(), true
+	}
+
+	return s.doktor.withTracer(func() error {
+		pids := tracerService.TargetPids()
+		if len(pids) == 0 {
+			return errors.Errorf("no processes found in container: '%s'", settings.UserSpecifiedContainer)
+		}
+
+		log.Info().
+			Str("pod", settings.UserSpecifiedPodName).
+			Str("container", settings.UserSpecifiedContainer).
+			Strs("ignored", s.ignore).
+			Dur("duration", s.duration).
+			Msg("signal tracing has begun")
+
+		printer := signals.NewPrinter(s.doktor.Out, settings.UserSpecifiedOutputFormat, workload)
+		program := signals.Program(tracerService.Target(), s.ignored, s.duration)
+
+		return s.doktor.stream(printer, record.InlineScripts(program), func(stdOut io.Writer) error {
+			return tracerService.StartProgram(program, stdOut)
+		})
+	})
+}
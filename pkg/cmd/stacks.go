@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/flamegraph"
+	"github.com/alam0rt/kubectl-doktor/pkg/stack"
+	"github.com/alam0rt/kubectl-doktor/pkg/symbolize"
+	"github.com/rs/zerolog/log"
+)
+
+func (o *Doktor) wantsStacks() bool {
+	return o.settings.UserSpecifiedFlamegraphPath != "" || o.settings.UserSpecifiedFoldedPath != ""
+}
+
+// symbolize resolves the user frames bpftrace couldn't name using the
+// binaries of the target processes and the perf maps of JIT runtimes, it
+// must run while the tracer is set up.
+func (o *Doktor) symbolize(samples []stack.Sample) error {
+	// generated last so they cover the code compiled while tracing
+	if err := o.tracerService.GeneratePerfMaps(); err != nil {
+		return err
+	}
+
+	cacheDir, err := ioutil.TempDir("", "doktor-symbols")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(cacheDir)
+
+	stack.Symbolize(samples, symbolize.NewSymbolizer(o.tracerService, cacheDir))
+
+	return nil
+}
+
+// writeStacks writes the folded stacks and flame graph the user asked for,
+// unit names what the sample values count.
+func (o *Doktor) writeStacks(samples []stack.Sample, unit string) error {
+	if !o.wantsStacks() {
+		return nil
+	}
+
+	if len(samples) == 0 {
+		log.Warn().
+			Msg("no stacks were collected, make sure the program prints a map keyed by kstack or ustack")
+		return nil
+	}
+
+	folded := stack.Fold(samples)
+
+	if path := o.settings.UserSpecifiedFoldedPath; path != "" {
+		if err := writeFile(path, func(file *os.File) error {
+			return stack.WriteFolded(file, folded)
+		}); err != nil {
+			return err
+		}
+
+		log.Info().
+			Msgf("folded stacks written to: '%s'", path)
+	}
+
+	if path := o.settings.UserSpecifiedFlamegraphPath; path != "" {
+		options := flamegraph.Options{
+			Title: "doktor " + o.settings.UserSpecifiedPodName,
+			Unit:  unit,
+		}
+
+		if err := writeFile(path, func(file *os.File) error {
+			return flamegraph.Render(file, folded, options)
+		}); err != nil {
+			return err
+		}
+
+		log.Info().
+			Msgf("flame graph written to: '%s'", path)
+	}
+
+	return nil
+}
+
+func writeFile(path string, write func(file *os.File) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/analysis/syscalls"
+	"github.com/alam0rt/kubectl-doktor/pkg/record"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	syscallsExample = `
+	# summarize the syscalls of the first container for 30 seconds, slowest in total first
+	%[1]s doktor syscalls example-pod -n default -p
+
+	# find the syscalls failing the most over a minute
+	%[1]s doktor syscalls example-pod -n default -p -d 1m --sort errors
+	`
+)
+
+type Syscalls struct {
+	doktor  *Doktor
+	options syscalls.Options
+	order   string
+}
+
+func NewCmdSyscalls(doktor *Doktor) *cobra.Command {
+	s := &Syscalls{doktor: doktor}
+
+	cmd := &cobra.Command{
+		Use:          "syscalls <pod>",
+		Short:        "Summarize the count, errors and latency of a container's syscalls",
+		Example:      fmt.Sprintf(syscallsExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := doktor.Complete(c, args); err != nil {
+				return err
+			}
+			if err := s.Validate(); err != nil {
+				return err
+			}
+			if err := doktor.Validate(); err != nil {
+				return err
+			}
+
+			return s.Run()
+		},
+	}
+
+	cmd.Flags().DurationVarP(&s.options.Duration, "duration", "d", 30*time.Second,
+		"how long to trace for (e.g. 30s, 2m)")
+	cmd.Flags().StringVarP(&s.order, "sort", "", syscalls.SortTime,
+		fmt.Sprintf("column to sort syscalls by, one of: %v", syscalls.SortOrders))
+
+	return cmd
+}
+
+func (s *Syscalls) Validate() error {
+	if s.options.Duration <= 0 {
+		return errors.New("tracing duration must be positive")
+	}
+
+	switch s.order {
+	case syscalls.SortTime, syscalls.SortCalls, syscalls.SortErrors:
+	default:
+		return errors.Errorf("unsupported sort order: '%s', supported orders are: %v", s.order, syscalls.SortOrders)
+	}
+
+	return nil
+}
+
+func (s *Syscalls) Run() error {
+	log.Info().
+		Str("pod", s.doktor.settings.UserSpecifiedPodName).
+		Str("container", s.doktor.settings.UserSpecifiedContainer).
+		Dur("duration", s.options.Duration).
+		Msg("syscall tracing has begun")
+
+	tracerService := s.doktor.tracerService
+
+	return s.doktor.withTracer(func() error {
+		pids := tracerService.TargetPids()
+		if len(pids) == 0 {
+			return errors.Errorf("no processes found in container: '%s'", s.doktor.settings.UserSpecifiedContainer)
+		}
+
+		program := syscalls.Program(tracerService.Target(), s.options)
+		collector := syscalls.NewCollector()
+
+		err := s.doktor.stream(collector, record.InlineScripts(program), func(stdOut io.Writer) error {
+			return tracerService.StartProgram(program, stdOut)
+		})
+		if err != nil {
+			return err
+		}
+
+		return syscalls.Print(s.doktor.Out, s.doktor.settings.UserSpecifiedOutputFormat, collector.Syscalls(s.order))
+	})
+}
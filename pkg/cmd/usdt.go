@@ -0,0 +1,214 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/analysis/usdt"
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/record"
+	"github.com/alam0rt/kubectl-doktor/pkg/symbolize"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	usdtExample = `
+	# list the USDT probes of the container's binaries and shared libraries
+	%[1]s doktor usdt example-pod -n default -p
+
+	# list the probes of a provider
+	%[1]s doktor usdt example-pod -n default -p '^python:'
+
+	# print every firing of two probes with their arguments for a minute
+	%[1]s doktor usdt example-pod -n default -p --probe python:function__entry --probe gc__start -d 1m
+	`
+)
+
+type Usdt struct {
+	doktor  *Doktor
+	options usdt.Options
+	pattern *regexp.Regexp
+	probes  []string
+}
+
+func NewCmdUsdt(doktor *Doktor) *cobra.Command {
+	u := &Usdt{doktor: doktor}
+
+	cmd := &cobra.Command{
+		Use:          "usdt <pod> [pattern]",
+		Short:        "List the USDT probes of a container's binaries, or print their firings",
+		Example:      fmt.Sprintf(usdtExample, "kubectl"),
+		Args:         cobra.RangeArgs(1, 2),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := doktor.Complete(c, args); err != nil {
+				return err
+			}
+			if err := u.Validate(args); err != nil {
+				return err
+			}
+			if err := doktor.Validate(); err != nil {
+				return err
+			}
+
+			return u.Run()
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&u.probes, "probe", "", nil,
+		"USDT probe to print the firings of, as 'provider:name' or 'name' (optional)")
+	cmd.Flags().DurationVarP(&u.options.Duration, "duration", "d", 30*time.Second,
+		"how long to trace --probe probes for (e.g. 30s, 2m)")
+
+	return cmd
+}
+
+func (u *Usdt) Validate(args []string) error {
+	pattern := ""
+	if len(args) > 1 {
+		pattern = args[1]
+	}
+
+	var err error
+	u.pattern, err = regexp.Compile(pattern)
+	if err != nil {
+		return errors.Wrapf(err, "invalid probe pattern: '%s'", pattern)
+	}
+
+	if len(u.probes) > 0 && u.options.Duration <= 0 {
+		return errors.New("tracing duration must be positive")
+	}
+
+	return nil
+}
+
+func (u *Usdt) Run() error {
+	tracerService := u.doktor.tracerService
+
+	return u.doktor.withTracer(func() error {
+		pids := tracerService.TargetPids()
+		if len(pids) == 0 {
+			return errors.Errorf("no processes found in container: '%s'", u.doktor.settings.UserSpecifiedContainer)
+		}
+
+		probes, err := u.discover(pids)
+		if err != nil {
+			return err
+		}
+
+		if len(u.probes) == 0 {
+			return u.list(usdt.Filter(probes, u.pattern))
+		}
+
+		return u.trace(tracerService.Target(), usdt.Match(probes, u.probes))
+	})
+}
+
+// discover reads the USDT probes of every binary and shared library mapped
+// by the target processes.
+func (u *Usdt) discover(pids []string) ([]usdt.Probe, error) {
+	tracerService := u.doktor.tracerService
+
+	localDir, err := ioutil.TempDir("", "doktor-usdt")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(localDir)
+
+	seen := map[string]bool{}
+
+	var probes []usdt.Probe
+	for _, pid := range pids {
+		content, err := tracerService.ReadFile(symbolize.MapsPath(pid))
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Msgf("failed to read memory mappings of pid: '%s'", pid)
+			continue
+		}
+
+		for _, mapping := range symbolize.ParseMaps(content) {
+			if mapping.Deleted() || seen[mapping.Path] {
+				continue
+			}
+			seen[mapping.Path] = true
+
+			remotePath := symbolize.RootPath(pid, mapping.Path)
+			binaryDir := filepath.Join(localDir, filepath.FromSlash(path.Dir(mapping.Path)))
+
+			log.Debug().
+				Msgf("reading USDT probes of: '%s'", mapping.Path)
+
+			if err := tracerService.DownloadFile(remotePath, binaryDir); err != nil {
+				log.Warn().
+					Err(err).
+					Msgf("failed to download: '%s', its USDT probes won't be listed", mapping.Path)
+				continue
+			}
+
+			sites, err := symbolize.ReadUSDTProbes(filepath.Join(binaryDir, path.Base(mapping.Path)))
+			if err != nil {
+				log.Warn().
+					Err(err).
+					Msgf("failed to read USDT probes of: '%s'", mapping.Path)
+				continue
+			}
+
+			probes = append(probes, usdt.Probes(mapping.Path, remotePath, sites)...)
+		}
+	}
+
+	return probes, nil
+}
+
+func (u *Usdt) list(probes []usdt.Probe) error {
+	for _, probe := range probes {
+		semaphore := ""
+		if probe.Semaphore {
+			semaphore = " (semaphore)"
+		}
+
+		if _, err := fmt.Fprintf(u.doktor.Out, "%s\t%s\t%d args%s\n",
+			probe.Binary, probe.FullName(), probe.Arguments, semaphore); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (u *Usdt) trace(target bpftrace.Target, probes []usdt.Probe) error {
+	if len(probes) == 0 {
+		return errors.Errorf("no USDT probe named: '%v' found, list them with: kubectl doktor usdt %s",
+			u.probes, u.doktor.settings.UserSpecifiedPodName)
+	}
+
+	printer, err := u.doktor.newPrinter()
+	if err != nil {
+		return err
+	}
+
+	for _, probe := range probes {
+		log.Info().
+			Msgf("tracing USDT probe: '%s' of: '%s'", probe.FullName(), probe.Binary)
+	}
+
+	// semaphore gated probes only fire once activated in the processes
+	// mapping the binary, the predicate still scopes them to the target
+	command := bpftrace.Command{
+		Program:            usdt.Program(target, probes, u.options),
+		UsdtFileActivation: true,
+	}
+
+	return u.doktor.stream(printer, record.InlineScripts(command.Program), func(stdOut io.Writer) error {
+		return u.doktor.tracerService.StartCommand(command, stdOut)
+	})
+}
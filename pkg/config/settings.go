@@ -10,9 +10,11 @@ type DoktorSettings struct {
 	UserSpecifiedPodName          string
 	UserSpecifiedInterface        string
 	UserSpecifiedFilter           string
+	UserSpecifiedScript           string
 	UserSpecifiedPodCreateTimeout time.Duration
 	UserSpecifiedContainer        string
 	UserSpecifiedNamespace        string
+	UserSpecifiedHelperNamespace  string
 	UserSpecifiedVerboseMode      bool
 	UserSpecifiedPrivilegedMode   bool
 	UserSpecifiedImage            string
@@ -24,8 +26,28 @@ type DoktorSettings struct {
 	UserSpecifiedKubeContext      string
 	SocketPath                    string
 	UseDefaultSocketPath          bool
+	BpftraceBinary                string
+	TcpdumpBinary                 string
+	UserSpecifiedOutputDir        string
+	UserSpecifiedArtifacts        []string
+	UserSpecifiedOutputFormat     string
+	UserSpecifiedRecordPath       string
+	UserSpecifiedFlamegraphPath   string
+	UserSpecifiedFoldedPath       string
+	UserSpecifiedJvmAttachDir     string
+	UserSpecifiedK8sMetadata      string
 }
 
 func NewDoktorSettings(streams genericclioptions.IOStreams) *DoktorSettings {
 	return &DoktorSettings{}
 }
+
+// HelperNamespace returns the namespace the privileged helper pod is created
+// and executed in: the one given with --helper-namespace, otherwise the
+// namespace of the target pod.
+func (s *DoktorSettings) HelperNamespace(targetNamespace string) string {
+	if s.UserSpecifiedHelperNamespace != "" {
+		return s.UserSpecifiedHelperNamespace
+	}
+	return targetNamespace
+}
@@ -0,0 +1,21 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHelperNamespace(t *testing.T) {
+	// given
+	settings := DoktorSettings{}
+
+	// then
+	assert.Equal(t, "payments", settings.HelperNamespace("payments"))
+
+	// when
+	settings.UserSpecifiedHelperNamespace = "doktor-system"
+
+	// then
+	assert.Equal(t, "doktor-system", settings.HelperNamespace("payments"))
+}
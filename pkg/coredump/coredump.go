@@ -0,0 +1,86 @@
+// Package coredump dumps the core of a running process with gcore from the
+// helper pod, and archives it with the files the process mapped, so it can
+// be debugged offline.
+package coredump
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/symbolize"
+)
+
+const (
+	// RemoteDir is where the core is dumped to on the helper pod.
+	RemoteDir = bpftrace.RemoteDir + "/coredump"
+)
+
+// Command describes the dump of the core of a host process, and its archive.
+type Command struct {
+	Pid string
+	// Files are the files the process mapped, as seen from the process,
+	// archived with the core.
+	Files []string
+}
+
+// CoreName is the name of the core in the archive, the one gcore gives it.
+func (c *Command) CoreName() string {
+	return "core." + c.Pid
+}
+
+// DumpCommand returns the command dumping the core of the process, stopping
+// it while its memory is read.
+func (c *Command) DumpCommand() []string {
+	return []string{"gcore", "-o", RemoteDir + "/core", c.Pid}
+}
+
+// ArchiveCommand returns the command writing a gzipped tar to stdout, of the
+// core at its root and of the files laid out as in the root of the process,
+// the archive being a sysroot for debuggers. The files are named by the
+// process, they're made relative with a leading "./" so tar can't take one
+// for an option.
+func (c *Command) ArchiveCommand() []string {
+	command := []string{"tar", "-czf", "-", "-C", RemoteDir, c.CoreName(), "-C", symbolize.RootPath(c.Pid, "/")}
+
+	for _, file := range c.Files {
+		command = append(command, "./"+strings.TrimPrefix(file, "/"))
+	}
+
+	return command
+}
+
+// CleanupCommand returns the command removing the core from the helper pod.
+func (c *Command) CleanupCommand() []string {
+	return []string{"rm", "-f", RemoteDir + "/" + c.CoreName()}
+}
+
+// Files returns the files of the mappings a debugger needs, the executable
+// and the shared libraries, once each. The files deleted since they were
+// mapped are returned apart, they can't be archived.
+func Files(mappings []symbolize.Mapping) ([]string, []string) {
+	var files []string
+	var deleted []string
+	seen := map[string]bool{}
+
+	for _, mapping := range mappings {
+		if seen[mapping.Path] || strings.HasPrefix(mapping.Path, "/dev/") || strings.HasPrefix(mapping.Path, "/memfd:") {
+			continue
+		}
+		seen[mapping.Path] = true
+
+		if mapping.Deleted() {
+			deleted = append(deleted, mapping.Path)
+			continue
+		}
+		files = append(files, mapping.Path)
+	}
+
+	return files, deleted
+}
+
+// GdbCommand returns the command debugging the core once the archive is
+// extracted in the current directory.
+func GdbCommand(executable string, pid string) string {
+	return fmt.Sprintf("gdb -ex 'set sysroot .' %s core.%s", strings.TrimPrefix(executable, "/"), pid)
+}
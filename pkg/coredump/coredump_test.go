@@ -0,0 +1,52 @@
+package coredump
+
+import (
+	"testing"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/symbolize"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommand(t *testing.T) {
+	// given
+	command := Command{Pid: "4123", Files: []string{"/app/server", "/usr/lib/x86_64-linux-gnu/libc.so.6"}}
+
+	// then
+	assert.Equal(t, "core.4123", command.CoreName())
+	assert.Equal(t, []string{"gcore", "-o", "/tmp/doktor/coredump/core", "4123"}, command.DumpCommand())
+	assert.Equal(t, []string{"tar", "-czf", "-", "-C", "/tmp/doktor/coredump", "core.4123", "-C", "/host/proc/4123/root/",
+		"./app/server", "./usr/lib/x86_64-linux-gnu/libc.so.6"}, command.ArchiveCommand())
+	assert.Equal(t, []string{"rm", "-f", "/tmp/doktor/coredump/core.4123"}, command.CleanupCommand())
+}
+
+func TestCommand_ArchiveOptionLikeFiles(t *testing.T) {
+	// given
+	command := Command{Pid: "4123", Files: []string{"/--checkpoint=1", "/--checkpoint-action=exec=sh -c id"}}
+
+	// then
+	assert.Equal(t, []string{"tar", "-czf", "-", "-C", "/tmp/doktor/coredump", "core.4123", "-C", "/host/proc/4123/root/",
+		"./--checkpoint=1", "./--checkpoint-action=exec=sh -c id"}, command.ArchiveCommand())
+}
+
+func TestFiles(t *testing.T) {
+	// given
+	mappings := symbolize.ParseMaps([]byte("" +
+		"55d0c0a00000-55d0c0b00000 r-xp 00001000 fd:01 1234 /app/server\n" +
+		"7f1c2a000000-7f1c2a100000 r-xp 00028000 fd:01 2345 /usr/lib/x86_64-linux-gnu/libc.so.6\n" +
+		"7f1c2b000000-7f1c2b010000 r-xp 00000000 fd:01 3456 /tmp/plugin.so (deleted)\n" +
+		"7f1c2c000000-7f1c2c001000 r-xp 00000000 00:01 4567 /memfd:jit (deleted)\n" +
+		"7f1c2d000000-7f1c2d100000 r-xp 00000000 fd:01 2345 /usr/lib/x86_64-linux-gnu/libc.so.6\n" +
+		"7f1c2e000000-7f1c2e001000 r-xs 00000000 00:05 5678 /dev/zero\n" +
+		"7ffd5a1f0000-7ffd5a1f2000 r-xp 00000000 00:00 0 [vdso]\n"))
+
+	// when
+	files, deleted := Files(mappings)
+
+	// then
+	assert.Equal(t, []string{"/app/server", "/usr/lib/x86_64-linux-gnu/libc.so.6"}, files)
+	assert.Equal(t, []string{"/tmp/plugin.so (deleted)"}, deleted)
+}
+
+func TestGdbCommand(t *testing.T) {
+	assert.Equal(t, "gdb -ex 'set sysroot .' app/server core.4123", GdbCommand("/app/server", "4123"))
+}
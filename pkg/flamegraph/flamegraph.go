@@ -0,0 +1,269 @@
+package flamegraph
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/stack"
+)
+
+const (
+	defaultWidth = 1200
+	frameHeight  = 16
+	padding      = 10
+	headerHeight = 40
+	footerHeight = 30
+	charWidth    = 7
+	minWidth     = 0.1
+	rootName     = "all"
+)
+
+// Options controls the rendering of a flame graph.
+type Options struct {
+	Title string
+	// Unit names what the stack values count, e.g. samples or ns.
+	Unit  string
+	Width int
+}
+
+type node struct {
+	name     string
+	value    int64
+	children []*node
+	index    map[string]*node
+}
+
+func newNode(name string) *node {
+	return &node{name: name, index: map[string]*node{}}
+}
+
+func (n *node) child(name string) *node {
+	if child, ok := n.index[name]; ok {
+		return child
+	}
+
+	child := newNode(name)
+	n.index[name] = child
+	n.children = append(n.children, child)
+	return child
+}
+
+func (n *node) depth() int {
+	depth := 0
+	for _, child := range n.children {
+		if d := child.depth() + 1; d > depth {
+			depth = d
+		}
+	}
+	return depth
+}
+
+func build(folded []stack.Folded) *node {
+	root := newNode(rootName)
+
+	for _, s := range folded {
+		root.value += s.Value
+
+		current := root
+		for _, frame := range s.Frames {
+			current = current.child(frame)
+			current.value += s.Value
+		}
+	}
+
+	var sortChildren func(n *node)
+	sortChildren = func(n *node) {
+		sort.Slice(n.children, func(i, j int) bool {
+			return n.children[i].name < n.children[j].name
+		})
+		for _, child := range n.children {
+			sortChildren(child)
+		}
+	}
+	sortChildren(root)
+
+	return root
+}
+
+// Render writes an interactive SVG flame graph of the folded stacks: frames
+// can be clicked to zoom into and searched with a regular expression.
+func Render(w io.Writer, folded []stack.Folded, options Options) error {
+	if options.Width == 0 {
+		options.Width = defaultWidth
+	}
+	if options.Unit == "" {
+		options.Unit = "samples"
+	}
+
+	root := build(folded)
+	height := (root.depth()+1)*frameHeight + headerHeight + footerHeight
+
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, svgHeader, options.Width, height, options.Width, height)
+	fmt.Fprintf(bw, "<style>%s</style>\n", style)
+	fmt.Fprintf(bw, "<script type=\"text/ecmascript\"><![CDATA[\nvar W = %d, PAD = %d, CHAR = %d, UNIT = '%s';\n%s]]></script>\n",
+		options.Width, padding, charWidth, html.EscapeString(options.Unit), script)
+	fmt.Fprintf(bw, "<rect x=\"0\" y=\"0\" width=\"100%%\" height=\"100%%\" fill=\"#f8f8f8\"/>\n")
+	fmt.Fprintf(bw, "<text id=\"title\" x=\"%d\" y=\"24\">%s</text>\n", options.Width/2, html.EscapeString(options.Title))
+	fmt.Fprintf(bw, "<text id=\"reset\" x=\"%d\" y=\"24\" class=\"hidden\" onclick=\"unzoom()\">Reset Zoom</text>\n", padding)
+	fmt.Fprintf(bw, "<text id=\"search\" x=\"%d\" y=\"24\" onclick=\"search()\">Search</text>\n", options.Width-padding)
+	fmt.Fprintf(bw, "<text id=\"details\" x=\"%d\" y=\"%d\"> </text>\n", padding, height-10)
+	fmt.Fprintf(bw, "<g id=\"frames\">\n")
+
+	r := renderer{w: bw, total: root.value, width: options.Width, height: height, unit: options.Unit}
+	r.frame(root, 0, 0)
+
+	fmt.Fprintf(bw, "</g>\n</svg>\n")
+
+	return bw.Flush()
+}
+
+type renderer struct {
+	w      *bufio.Writer
+	total  int64
+	width  int
+	height int
+	unit   string
+}
+
+func (r *renderer) frame(n *node, start int64, depth int) {
+	if r.total == 0 {
+		return
+	}
+
+	scale := float64(r.width-2*padding) / float64(r.total)
+	width := float64(n.value) * scale
+	if width < minWidth {
+		return
+	}
+
+	x := padding + float64(start)*scale
+	y := r.height - footerHeight - (depth+1)*frameHeight
+	name := html.EscapeString(n.name)
+	info := fmt.Sprintf("%s (%d %s, %.2f%%)", name, n.value, html.EscapeString(r.unit),
+		100*float64(n.value)/float64(r.total))
+
+	fmt.Fprintf(r.w, "<g class=\"f\" data-n=\"%s\" data-s=\"%d\" data-v=\"%d\" onclick=\"zoom(this)\" onmouseover=\"details(this)\">",
+		name, start, n.value)
+	fmt.Fprintf(r.w, "<title>%s</title>", info)
+	fmt.Fprintf(r.w, "<rect x=\"%.2f\" y=\"%d\" width=\"%.2f\" height=\"%d\" fill=\"%s\" rx=\"2\"/>",
+		x, y, width, frameHeight-1, color(n.name))
+	fmt.Fprintf(r.w, "<text x=\"%.2f\" y=\"%d\">%s</text></g>\n", x+3, y+frameHeight-4,
+		html.EscapeString(label(n.name, width)))
+
+	offset := start
+	for _, child := range n.children {
+		r.frame(child, offset, depth+1)
+		offset += child.value
+	}
+}
+
+// label truncates name to what fits in width.
+func label(name string, width float64) string {
+	chars := int((width - 6) / charWidth)
+	if chars < 3 {
+		return ""
+	}
+	if len(name) <= chars {
+		return name
+	}
+
+	return name[:chars-2] + ".."
+}
+
+// color picks a stable warm color per function, kernel frames are orange
+// while user frames range from red to yellow.
+func color(name string) string {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(name))
+	v := hash.Sum32()
+
+	v1 := float64(v&0xff) / 255
+	v2 := float64((v>>8)&0xff) / 255
+
+	if strings.HasSuffix(name, "_[k]") {
+		return fmt.Sprintf("rgb(%d,%d,%d)", 200+int(55*v1), 110+int(60*v2), 30)
+	}
+
+	return fmt.Sprintf("rgb(%d,%d,%d)", 205+int(50*v1), int(230*v2), int(55*v1))
+}
+
+const svgHeader = `<?xml version="1.0" standalone="no"?>
+<svg version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg">
+`
+
+const style = `
+text { font-family: Verdana, sans-serif; font-size: 12px; fill: #000; }
+#title { font-size: 17px; text-anchor: middle; }
+#search { text-anchor: end; cursor: pointer; }
+#reset { cursor: pointer; }
+.f { cursor: pointer; }
+.f:hover rect { stroke: #000; stroke-width: 0.5; }
+.f.match rect { fill: rgb(230,0,230); }
+.hidden { display: none; }
+`
+
+const script = `
+function frames() { return document.querySelectorAll('.f'); }
+function num(g, attr) { return parseFloat(g.getAttribute(attr)); }
+
+function details(g) {
+	document.getElementById('details').textContent = g.querySelector('title').textContent;
+}
+
+function fit(g, x, w) {
+	var rect = g.querySelector('rect'), text = g.querySelector('text'), name = g.getAttribute('data-n');
+	rect.setAttribute('x', x);
+	rect.setAttribute('width', w);
+	text.setAttribute('x', x + 3);
+	var chars = Math.floor((w - 6) / CHAR);
+	text.textContent = chars < 3 ? '' : (name.length <= chars ? name : name.substring(0, chars - 2) + '..');
+}
+
+function layout(start, value) {
+	var scale = (W - 2 * PAD) / value;
+	frames().forEach(function (g) {
+		var s = num(g, 'data-s'), v = num(g, 'data-v');
+		if (s <= start && s + v >= start + value) {
+			g.classList.remove('hidden');
+			fit(g, PAD, W - 2 * PAD);
+		} else if (s >= start && s + v <= start + value) {
+			g.classList.remove('hidden');
+			fit(g, PAD + (s - start) * scale, v * scale);
+		} else {
+			g.classList.add('hidden');
+		}
+	});
+}
+
+function zoom(g) {
+	layout(num(g, 'data-s'), num(g, 'data-v'));
+	document.getElementById('reset').classList.remove('hidden');
+}
+
+function unzoom() {
+	var root = frames()[0];
+	layout(num(root, 'data-s'), num(root, 'data-v'));
+	document.getElementById('reset').classList.add('hidden');
+}
+
+function search() {
+	var term = prompt('Search frames (regular expression)', '');
+	if (term === null) return;
+	var re = new RegExp(term), total = num(frames()[0], 'data-v'), matched = 0, end = -1;
+	frames().forEach(function (g) {
+		var match = term !== '' && re.test(g.getAttribute('data-n'));
+		g.classList.toggle('match', match);
+		var s = num(g, 'data-s'), v = num(g, 'data-v');
+		if (match && s >= end) { matched += v; end = s + v; }
+	});
+	document.getElementById('details').textContent = term === '' ? ' ' :
+		'Matched: ' + (100 * matched / total).toFixed(2) + '% of ' + UNIT;
+}
+`
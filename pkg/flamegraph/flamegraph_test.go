@@ -0,0 +1,48 @@
+package flamegraph
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/stack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	// given
+	folded := []stack.Folded{
+		{Frames: []string{"server", "main.main", "main.handle<T>"}, Value: 3},
+		{Frames: []string{"server", "main.main", "do_syscall_64_[k]"}, Value: 1},
+	}
+
+	// when
+	var buf bytes.Buffer
+	err := Render(&buf, folded, Options{Title: "test"})
+
+	// then
+	assert.NoError(t, err)
+
+	var svg struct {
+		XMLName xml.Name
+		Frames  []struct {
+			Name  string `xml:"data-n,attr"`
+			Start int64  `xml:"data-s,attr"`
+			Value int64  `xml:"data-v,attr"`
+		} `xml:"g>g"`
+	}
+	assert.NoError(t, xml.Unmarshal(buf.Bytes(), &svg))
+	assert.Equal(t, "svg", svg.XMLName.Local)
+
+	names := map[string][2]int64{}
+	for _, frame := range svg.Frames {
+		names[frame.Name] = [2]int64{frame.Start, frame.Value}
+	}
+	assert.Equal(t, map[string][2]int64{
+		"all":               {0, 4},
+		"server":            {0, 4},
+		"main.main":         {0, 4},
+		"do_syscall_64_[k]": {0, 1},
+		"main.handle<T>":    {1, 3},
+	}, names)
+}
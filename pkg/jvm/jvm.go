@@ -0,0 +1,54 @@
+package jvm
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/pkg/errors"
+)
+
+const (
+	// AttachBinary is the name of the jattach binary in the attach directory,
+	// it loads agents into and runs commands in JVMs of other namespaces.
+	AttachBinary = "jattach"
+	// AgentLibrary is the name of the optional perf-map-agent library in the
+	// attach directory, for JVMs older than 17.
+	AgentLibrary = "libperfmap.so"
+
+	// RemoteDir is where the attach directory is uploaded on the helper pod.
+	RemoteDir = bpftrace.RemoteDir + "/jvm"
+	// ContainerAgentPath is where the agent is copied in the target
+	// container, the JVM loads it from its own root filesystem.
+	ContainerAgentPath = "/tmp/doktor-libperfmap.so"
+)
+
+// CheckAttachDir validates a local attach directory and tells whether it
+// holds the perf-map-agent library.
+func CheckAttachDir(localDir string) (bool, error) {
+	if _, err := os.Stat(filepath.Join(localDir, AttachBinary)); err != nil {
+		return false, errors.Wrapf(err, "attach directory: '%s' must contain a static %s binary", localDir, AttachBinary)
+	}
+
+	_, err := os.Stat(filepath.Join(localDir, AgentLibrary))
+	return err == nil, nil
+}
+
+// IsJvm tells whether exe, the executable of a process, runs a JVM.
+func IsJvm(exe string) bool {
+	return path.Base(exe) == "java"
+}
+
+// PerfMapCommand returns the command making the JVM of pid, a host pid,
+// write /tmp/perf-<pid>.map in its container. The agent is loaded when
+// available, otherwise the JVM is asked to write it itself which needs JDK 17.
+func PerfMapCommand(pid string, withAgent bool) []string {
+	attach := path.Join(RemoteDir, AttachBinary)
+
+	if withAgent {
+		return []string{attach, pid, "load", ContainerAgentPath, "true"}
+	}
+
+	return []string{attach, pid, "jcmd", "Compiler.perfmap"}
+}
@@ -0,0 +1,40 @@
+package jvm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAttachDir(t *testing.T) {
+	// given
+	dir, err := ioutil.TempDir("", "doktor-jvm")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// when
+	_, errMissing := CheckAttachDir(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, AttachBinary), []byte{}, 0755))
+	withoutAgent, errWithout := CheckAttachDir(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, AgentLibrary), []byte{}, 0644))
+	withAgent, errWith := CheckAttachDir(dir)
+
+	// then
+	assert.Error(t, errMissing)
+	assert.NoError(t, errWithout)
+	assert.False(t, withoutAgent)
+	assert.NoError(t, errWith)
+	assert.True(t, withAgent)
+}
+
+func TestPerfMapCommand(t *testing.T) {
+	assert.Equal(t, []string{"/tmp/doktor/jvm/jattach", "4123", "load", "/tmp/doktor-libperfmap.so", "true"},
+		PerfMapCommand("4123", true))
+	assert.Equal(t, []string{"/tmp/doktor/jvm/jattach", "4123", "jcmd", "Compiler.perfmap"},
+		PerfMapCommand("4123", false))
+	assert.True(t, IsJvm("/usr/lib/jvm/java-17-openjdk/bin/java"))
+	assert.False(t, IsJvm("/usr/bin/node"))
+}
@@ -0,0 +1,112 @@
+package k8smeta
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Kinds of endpoints an AddressResolver resolves addresses to.
+const (
+	EndpointService = "service"
+	EndpointPod     = "pod"
+)
+
+// Endpoint is the service or pod an IP address belongs to.
+type Endpoint struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+func (e Endpoint) String() string {
+	return fmt.Sprintf("%s %s/%s", e.Kind, e.Namespace, e.Name)
+}
+
+// ServiceLister lists the services of the cluster.
+type ServiceLister func() ([]corev1.Service, error)
+
+// AddressResolver maps IP addresses to the services and pods of the cluster,
+// listed once on the first resolution.
+type AddressResolver struct {
+	listPods     PodLister
+	listServices ServiceLister
+	endpoints    map[string]Endpoint
+}
+
+func NewAddressResolver(listPods PodLister, listServices ServiceLister) *AddressResolver {
+	return &AddressResolver{listPods: listPods, listServices: listServices}
+}
+
+// Resolve returns the endpoint of ip, services taking precedence over pods.
+func (r *AddressResolver) Resolve(ip string) (Endpoint, bool) {
+	if r.endpoints == nil {
+		r.endpoints = r.index()
+	}
+
+	endpoint, ok := r.endpoints[ip]
+	return endpoint, ok
+}
+
+func (r *AddressResolver) index() map[string]Endpoint {
+	pods, err := r.listPods()
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msg("failed to list pods, addresses won't be resolved to pods")
+	}
+
+	services, err := r.listServices()
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msg("failed to list services, addresses won't be resolved to services")
+	}
+
+	return IndexAddresses(pods, services)
+}
+
+// IndexAddresses maps the IP addresses of pods and services to them. Host
+// network pods are left out as their address is the node's.
+func IndexAddresses(pods []corev1.Pod, services []corev1.Service) map[string]Endpoint {
+	endpoints := map[string]Endpoint{}
+
+	for _, pod := range pods {
+		if pod.Spec.HostNetwork {
+			continue
+		}
+
+		endpoint := Endpoint{Kind: EndpointPod, Namespace: pod.Namespace, Name: pod.Name}
+
+		ips := []string{pod.Status.PodIP}
+		for _, podIp := range pod.Status.PodIPs {
+			ips = append(ips, podIp.IP)
+		}
+
+		for _, ip := range ips {
+			if ip != "" {
+				endpoints[ip] = endpoint
+			}
+		}
+	}
+
+	for _, service := range services {
+		endpoint := Endpoint{Kind: EndpointService, Namespace: service.Namespace, Name: service.Name}
+
+		ips := []string{service.Spec.ClusterIP}
+		ips = append(ips, service.Spec.ClusterIPs...)
+		ips = append(ips, service.Spec.ExternalIPs...)
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			ips = append(ips, ingress.IP)
+		}
+
+		for _, ip := range ips {
+			if ip != "" && ip != corev1.ClusterIPNone {
+				endpoints[ip] = endpoint
+			}
+		}
+	}
+
+	return endpoints
+}
@@ -0,0 +1,206 @@
+package k8smeta
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Kinds of ids a Resolver resolves.
+const (
+	KindPid    = "pid"
+	KindCgroup = "cgroup"
+)
+
+var Kinds = []string{KindPid, KindCgroup}
+
+// containerIdPattern matches the container ids container runtimes name cgroups
+// after, e.g. cri-containerd-<id>.scope, crio-<id>.scope or docker/<id>.
+var containerIdPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// Workload is the container a process belongs to.
+type Workload struct {
+	Namespace string
+	Pod       string
+	Container string
+}
+
+func (w Workload) String() string {
+	return fmt.Sprintf("%s/%s/%s", w.Namespace, w.Pod, w.Container)
+}
+
+// Source gives access to the host through the privileged helper pod.
+type Source interface {
+	Snapshot() (Snapshot, error)
+}
+
+// PodLister lists the pods of a node, to map container ids to workloads.
+type PodLister func() ([]corev1.Pod, error)
+
+// refreshInterval is the least time between two snapshots of the host, taken
+// again when an id isn't in the last one.
+const refreshInterval = 2 * time.Second
+
+// Resolver maps host pids and cgroup ids to the workload running them: a
+// snapshot of the host taken in the helper pod resolves them to a container
+// id through procfs or the cgroup hierarchy, then the pods of the node map
+// container ids to workloads. Resolutions are cached, ids missing from the
+// snapshot take a new one, at most every refreshInterval, listing the pods
+// again.
+type Resolver struct {
+	source     Source
+	listPods   PodLister
+	now        func() time.Time
+	snapshot   *Snapshot
+	snapshotAt time.Time
+	workloads  map[string]Workload
+	resolved   map[string]*Workload
+}
+
+func NewResolver(source Source, listPods PodLister) *Resolver {
+	return &Resolver{source: source, listPods: listPods, now: time.Now, resolved: map[string]*Workload{}}
+}
+
+// Resolve returns the workload of id, a host pid or cgroup id depending on kind.
+func (r *Resolver) Resolve(kind string, id string) (Workload, bool) {
+	key := kind + ":" + id
+	if workload, ok := r.resolved[key]; ok {
+		return derefWorkload(workload)
+	}
+
+	if r.snapshot == nil {
+		r.refresh()
+	}
+
+	workload, ok := r.lookup(kind, id)
+	if ok {
+		r.resolved[key] = &workload
+		return workload, true
+	}
+
+	// the id is newer than the snapshot, or its pod than the pods listed,
+	// it's only known not to be a workload once missing from a new one
+	if !r.refresh() {
+		return Workload{}, false
+	}
+
+	workload, ok = r.lookup(kind, id)
+	if !ok {
+		r.resolved[key] = nil
+		return Workload{}, false
+	}
+
+	r.resolved[key] = &workload
+	return workload, true
+}
+
+func derefWorkload(workload *Workload) (Workload, bool) {
+	if workload == nil {
+		return Workload{}, false
+	}
+
+	return *workload, true
+}
+
+func (r *Resolver) lookup(kind string, id string) (Workload, bool) {
+	var cgroup string
+	switch kind {
+	case KindPid:
+		cgroup = r.snapshot.Processes[id]
+	case KindCgroup:
+		cgroup = r.snapshot.Cgroups[id]
+	}
+
+	containerId := ParseContainerId(cgroup)
+	if containerId == "" {
+		return Workload{}, false
+	}
+
+	workload, ok := r.index()[containerId]
+	return workload, ok
+}
+
+// refresh takes a new snapshot of the host, unless the last one is more
+// recent than refreshInterval, and reports whether it did.
+func (r *Resolver) refresh() bool {
+	now := r.now()
+	if r.snapshot != nil && now.Sub(r.snapshotAt) < refreshInterval {
+		return false
+	}
+
+	snapshot, err := r.source.Snapshot()
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msg("failed to read the cgroups of the host, output won't be annotated with workloads")
+	}
+
+	r.snapshot, r.snapshotAt = &snapshot, now
+	r.workloads = nil
+
+	return true
+}
+
+func (r *Resolver) index() map[string]Workload {
+	if r.workloads != nil {
+		return r.workloads
+	}
+
+	pods, err := r.listPods()
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msg("failed to list the pods of the node, output won't be annotated with workloads")
+	}
+
+	r.workloads = Index(pods)
+	return r.workloads
+}
+
+// ParseContainerId returns the last container id of a cgroup path or of the
+// content of /proc/<pid>/cgroup, empty for processes outside of containers.
+func ParseContainerId(cgroup string) string {
+	ids := containerIdPattern.FindAllString(cgroup, -1)
+	if len(ids) == 0 {
+		return ""
+	}
+
+	return ids[len(ids)-1]
+}
+
+// Index maps the ids of the containers of pods, including init and
+// ephemeral containers and the previous instance of restarted containers, to
+// their workload.
+func Index(pods []corev1.Pod) map[string]Workload {
+	workloads := map[string]Workload{}
+
+	for _, pod := range pods {
+		var statuses []corev1.ContainerStatus
+		statuses = append(statuses, pod.Status.InitContainerStatuses...)
+		statuses = append(statuses, pod.Status.ContainerStatuses...)
+		statuses = append(statuses, pod.Status.EphemeralContainerStatuses...)
+
+		for _, status := range statuses {
+			containerIds := []string{status.ContainerID}
+			// the previous instance of a restarted container, e.g. OOM killed
+			if terminated := status.LastTerminationState.Terminated; terminated != nil {
+				containerIds = append(containerIds, terminated.ContainerID)
+			}
+
+			for _, containerId := range containerIds {
+				parts := strings.SplitN(containerId, "://", 2)
+				if len(parts) != 2 || parts[1] == "" {
+					continue
+				}
+
+				workloads[parts[1]] = Workload{Namespace: pod.Namespace, Pod: pod.Name, Container: status.Name}
+			}
+		}
+	}
+
+	return workloads
+}
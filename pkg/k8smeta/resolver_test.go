@@ -0,0 +1,177 @@
+package k8smeta
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type fakeSource struct {
+	snapshots int
+	processes map[string]string
+}
+
+func (f *fakeSource) Snapshot() (Snapshot, error) {
+	f.snapshots++
+
+	processes := map[string]string{}
+	for pid, cgroup := range f.processes {
+		processes[pid] = cgroup
+	}
+
+	return Snapshot{
+		Processes: processes,
+		Cgroups:   map[string]string{"7421": "/host/sys/fs/cgroup/kubepods/burstable/pod1234/" + testutil.ContainerId},
+	}, nil
+}
+
+func TestResolver(t *testing.T) {
+	// given
+	pods := []corev1.Pod{testutil.Pod()}
+	source := &fakeSource{processes: map[string]string{
+		"41231": "0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1234.slice/cri-containerd-" +
+			testutil.ContainerId + ".scope\n",
+		"1": "0::/init.scope\n",
+	}}
+	listed := 0
+	resolver := NewResolver(source, func() ([]corev1.Pod, error) {
+		listed++
+		return pods, nil
+	})
+	now := time.Unix(0, 0)
+	resolver.now = func() time.Time { return now }
+
+	// when
+	byPid, okPid := resolver.Resolve(KindPid, "41231")
+	_, _ = resolver.Resolve(KindPid, "41231")
+	byCgroup, okCgroup := resolver.Resolve(KindCgroup, "7421")
+	_, okHost := resolver.Resolve(KindPid, "1")
+
+	// then
+	assert.True(t, okPid)
+	assert.Equal(t, testutil.Workload, byPid.String())
+	assert.True(t, okCgroup)
+	assert.Equal(t, byPid, byCgroup)
+	assert.False(t, okHost)
+	assert.Equal(t, 1, source.snapshots)
+	assert.Equal(t, 1, listed)
+
+	// when a process is started after the snapshot
+	source.processes["41290"] = source.processes["41231"]
+	_, okEarly := resolver.Resolve(KindPid, "41290")
+	now = now.Add(refreshInterval)
+	byNewPid, okNewPid := resolver.Resolve(KindPid, "41290")
+	_, _ = resolver.Resolve(KindPid, "1")
+	_, okGone := resolver.Resolve(KindPid, "41300")
+	_, _ = resolver.Resolve(KindPid, "41300")
+
+	// then
+	assert.False(t, okEarly)
+	assert.True(t, okNewPid)
+	assert.Equal(t, byPid, byNewPid)
+	assert.False(t, okGone)
+	assert.Equal(t, 2, source.snapshots)
+	assert.Equal(t, 2, listed)
+}
+
+func TestParseSnapshot(t *testing.T) {
+	// given
+	output := "/host/proc/4123/cgroup:0::/kubepods/pod1234/" + testutil.ContainerId + "\n" +
+		"/host/proc/98/cgroup:12:cpu,cpuacct:/kubepods/pod1234/" + testutil.ContainerId + "\n" +
+		"/host/proc/98/cgroup:0::/kubepods/pod1234/" + testutil.ContainerId + "\n" +
+		"/host/proc/1/cgroup:0::/init.scope\n" +
+		"/host/proc/self/cgroup:0::/user.slice\n" +
+		"grep: /host/proc/5012/cgroup: No such file or directory\n" +
+		"1 /host/sys/fs/cgroup\n" +
+		"7421 /host/sys/fs/cgroup/kubepods/pod1234/" + testutil.ContainerId + "\n"
+
+	// when
+	snapshot := ParseSnapshot(output)
+
+	// then
+	assert.Len(t, snapshot.Processes, 3)
+	assert.Equal(t, "12:cpu,cpuacct:/kubepods/pod1234/"+testutil.ContainerId+"\n0::/kubepods/pod1234/"+testutil.ContainerId+"\n",
+		snapshot.Processes["98"])
+	assert.Equal(t, map[string]string{
+		"1":    "/host/sys/fs/cgroup",
+		"7421": "/host/sys/fs/cgroup/kubepods/pod1234/" + testutil.ContainerId,
+	}, snapshot.Cgroups)
+	assert.Equal(t, []string{"98", "4123"}, snapshot.Pids(testutil.ContainerId))
+
+	// when
+	cgroup, ok := snapshot.ContainerCgroup(testutil.ContainerId, "98")
+	_, okV1 := ParseSnapshot("/host/proc/98/cgroup:12:cpu,cpuacct:/kubepods/pod1234/"+testutil.ContainerId+"\n").
+		ContainerCgroup(testutil.ContainerId, "98")
+
+	// then
+	assert.True(t, ok)
+	assert.Equal(t, "/host/sys/fs/cgroup/kubepods/pod1234/"+testutil.ContainerId, cgroup)
+	assert.False(t, okV1)
+}
+
+func TestSnapshotCommand(t *testing.T) {
+	// when
+	command := SnapshotCommand()
+
+	// then
+	assert.Equal(t, "find", command[0])
+	assert.NotContains(t, command, "/bin/sh")
+	assert.Contains(t, command, "/host/proc/*/*")
+}
+
+func TestIndex_RestartedContainer(t *testing.T) {
+	// given
+	previous := "9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b"
+	pod := testutil.Pod()
+	pod.Status.ContainerStatuses[0].LastTerminationState = corev1.ContainerState{
+		Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ContainerID: "containerd://" + previous},
+	}
+	pods := []corev1.Pod{pod}
+
+	// when
+	workloads := Index(pods)
+
+	// then
+	assert.Len(t, workloads, 2)
+	assert.Equal(t, workloads[testutil.ContainerId], workloads[previous])
+}
+
+func TestIndexAddresses(t *testing.T) {
+	// given
+	pods := []corev1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: testutil.Namespace, Name: testutil.PodName},
+			Status:     corev1.PodStatus{PodIP: "10.244.1.12", PodIPs: []corev1.PodIP{{IP: "10.244.1.12"}, {IP: "fd00::12"}}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "kube-proxy-x2k4"},
+			Spec:       corev1.PodSpec{HostNetwork: true},
+			Status:     corev1.PodStatus{PodIP: "172.18.0.2"},
+		},
+	}
+	services := []corev1.Service{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "kube-dns"},
+			Spec:       corev1.ServiceSpec{ClusterIP: "10.96.0.10", ClusterIPs: []string{"10.96.0.10"}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "payments", Name: "api-headless"},
+			Spec:       corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone},
+		},
+	}
+
+	// when
+	endpoints := IndexAddresses(pods, services)
+
+	// then
+	assert.Equal(t, map[string]Endpoint{
+		"10.244.1.12": {Kind: EndpointPod, Namespace: testutil.Namespace, Name: testutil.PodName},
+		"fd00::12":    {Kind: EndpointPod, Namespace: testutil.Namespace, Name: testutil.PodName},
+		"10.96.0.10":  {Kind: EndpointService, Namespace: "kube-system", Name: "kube-dns"},
+	}, endpoints)
+	assert.Equal(t, "service kube-system/kube-dns", endpoints["10.96.0.10"].String())
+}
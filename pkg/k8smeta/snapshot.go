@@ -0,0 +1,114 @@
+package k8smeta
+
+import (
+	"bufio"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+)
+
+// Snapshot is the cgroup of every host process and the cgroup v2 directories
+// of the host, read from the helper pod in a single exec.
+type Snapshot struct {
+	// Processes maps host pids to the content of their cgroup file.
+	Processes map[string]string
+
+	// Cgroups maps the inode of cgroup v2 directories, the id bpftrace's
+	// cgroup builtin returns, to their path.
+	Cgroups map[string]string
+}
+
+// SnapshotCommand prints the cgroup files of the host processes prefixed
+// with their path, and the inode and path of every cgroup directory. It runs
+// without a shell, find batches the files it hands to grep and stat.
+func SnapshotCommand() []string {
+	return []string{"find", bpftrace.HostProcPath, bpftrace.HostCgroupPath,
+		"(", "-path", bpftrace.HostProcPath + "/*/*", "-prune", "-name", "cgroup", "-exec", "grep", "-H", "", "{}", "+", ")",
+		"-o",
+		"(", "-path", bpftrace.HostCgroupPath + "*", "-type", "d", "-exec", "stat", "-c", "%i %n", "{}", "+", ")"}
+}
+
+// ParseSnapshot reads the output of SnapshotCommand, skipping the lines of
+// processes that exited while it ran.
+func ParseSnapshot(output string) Snapshot {
+	snapshot := Snapshot{Processes: map[string]string{}, Cgroups: map[string]string{}}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.HasPrefix(line, bpftrace.HostProcPath+"/") {
+			parts := strings.SplitN(strings.TrimPrefix(line, bpftrace.HostProcPath+"/"), ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+
+			pid := strings.TrimSuffix(parts[0], "/cgroup")
+			if _, err := strconv.Atoi(pid); err != nil {
+				continue
+			}
+
+			snapshot.Processes[pid] += parts[1] + "\n"
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], bpftrace.HostCgroupPath) {
+			continue
+		}
+		if _, err := strconv.ParseUint(fields[0], 10, 64); err != nil {
+			continue
+		}
+
+		snapshot.Cgroups[fields[0]] = fields[1]
+	}
+
+	return snapshot
+}
+
+// Pids returns the host pids of the processes of a container, ordered
+// numerically so the container's init process comes first. This is runtime
+// agnostic as all runtimes name the container cgroup after the container id.
+func (s Snapshot) Pids(containerId string) []string {
+	var pids []int
+	for pid, cgroup := range s.Processes {
+		if !strings.Contains(cgroup, containerId) {
+			continue
+		}
+
+		number, _ := strconv.Atoi(pid)
+		pids = append(pids, number)
+	}
+
+	sort.Ints(pids)
+
+	result := make([]string, 0, len(pids))
+	for _, pid := range pids {
+		result = append(result, strconv.Itoa(pid))
+	}
+
+	return result
+}
+
+// ContainerCgroup returns the cgroup v2 directory of a container from the
+// cgroup file of one of its processes, false on cgroup v1 hosts where it
+// isn't one of the directories of the host cgroup hierarchy.
+func (s Snapshot) ContainerCgroup(containerId string, pid string) (string, bool) {
+	for _, line := range strings.Split(s.Processes[pid], "\n") {
+		if !strings.HasPrefix(line, "0::") || !strings.Contains(line, containerId) {
+			continue
+		}
+
+		dir := bpftrace.HostCgroupPath + strings.TrimPrefix(line, "0::")
+		for _, cgroup := range s.Cgroups {
+			if cgroup == dir {
+				return dir, true
+			}
+		}
+	}
+
+	return "", false
+}
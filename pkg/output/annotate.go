@@ -0,0 +1,203 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+)
+
+// AnnotateFunc returns the annotation replacing id, false when it has none.
+type AnnotateFunc func(id string) (string, bool)
+
+// AnnotatingPrinter replaces ids, such as pids, with annotations, such as
+// the workload running them, before passing records on. Ids are looked for
+// in the leading field of map keys and as "<kind>=<id>" or "<kind> <id>" in
+// printf output. Map entries whose keys end up equal are merged.
+type AnnotatingPrinter struct {
+	printer  Printer
+	pattern  *regexp.Regexp
+	annotate AnnotateFunc
+}
+
+func NewAnnotatingPrinter(printer Printer, kind string, annotate AnnotateFunc) *AnnotatingPrinter {
+	return &AnnotatingPrinter{
+		printer:  printer,
+		pattern:  regexp.MustCompile(`\b` + regexp.QuoteMeta(kind) + `[= ](\d+)\b`),
+		annotate: annotate,
+	}
+}
+
+func (a *AnnotatingPrinter) Print(record bpftrace.Record) error {
+	var err error
+
+	switch record.Type {
+	case bpftrace.TypePrintf:
+		record, err = a.printf(record)
+	case bpftrace.TypeMap, bpftrace.TypeHist, bpftrace.TypeStats:
+		record, err = a.maps(record)
+	}
+	if err != nil {
+		return err
+	}
+
+	return a.printer.Print(record)
+}
+
+func (a *AnnotatingPrinter) Flush() error {
+	return a.printer.Flush()
+}
+
+func (a *AnnotatingPrinter) printf(record bpftrace.Record) (bpftrace.Record, error) {
+	text := a.pattern.ReplaceAllStringFunc(record.String(), func(match string) string {
+		id := a.pattern.FindStringSubmatch(match)[1]
+		if annotation, ok := a.annotate(id); ok {
+			return annotation
+		}
+		return match
+	})
+
+	data, err := json.Marshal(text)
+	if err != nil {
+		return record, err
+	}
+
+	return bpftrace.Record{Type: record.Type, Data: data}, nil
+}
+
+func (a *AnnotatingPrinter) maps(record bpftrace.Record) (bpftrace.Record, error) {
+	maps, err := record.Maps()
+	if err != nil {
+		return record, err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(record.Data, &raw); err != nil {
+		return record, err
+	}
+
+	for _, m := range maps {
+		if !m.Keyed {
+			continue
+		}
+
+		entries := map[string]json.RawMessage{}
+		for _, key := range m.Keys() {
+			annotated := a.key(key)
+
+			value := m.Entries[key]
+			if existing, ok := entries[annotated]; ok {
+				if value, err = mergeValues(existing, value); err != nil {
+					return record, err
+				}
+			}
+			entries[annotated] = value
+		}
+
+		if raw[m.Name], err = json.Marshal(entries); err != nil {
+			return record, err
+		}
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return record, err
+	}
+
+	return bpftrace.Record{Type: record.Type, Data: data}, nil
+}
+
+// key annotates the leading field of a map key.
+func (a *AnnotatingPrinter) key(key string) string {
+	end := strings.IndexAny(key, ",\n")
+	if end < 0 {
+		end = len(key)
+	}
+
+	id := strings.TrimSpace(key[:end])
+	if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+		return key
+	}
+
+	annotation, ok := a.annotate(id)
+	if !ok {
+		return key
+	}
+
+	return annotation + key[end:]
+}
+
+// mergeValues combines the values of two map entries: integers are summed,
+// as are histogram buckets and stats, other values keep the first one.
+func mergeValues(a json.RawMessage, b json.RawMessage) (json.RawMessage, error) {
+	if x, err := bpftrace.ParseInt(a); err == nil {
+		if y, err := bpftrace.ParseInt(b); err == nil {
+			return json.RawMessage(strconv.FormatInt(x+y, 10)), nil
+		}
+	}
+
+	if x, err := bpftrace.ParseStats(a); err == nil && isObject(a) {
+		if y, err := bpftrace.ParseStats(b); err == nil && isObject(b) {
+			merged := bpftrace.Stats{Count: x.Count + y.Count, Total: x.Total + y.Total}
+			if merged.Count > 0 {
+				merged.Average = merged.Total / int64(merged.Count)
+			}
+			return json.Marshal(merged)
+		}
+	}
+
+	if x, err := bpftrace.ParseBuckets(a); err == nil {
+		if y, err := bpftrace.ParseBuckets(b); err == nil {
+			return json.Marshal(mergeBuckets(x, y))
+		}
+	}
+
+	return a, nil
+}
+
+func mergeBuckets(a []bpftrace.Bucket, b []bpftrace.Bucket) []bpftrace.Bucket {
+	bounds := func(bucket bpftrace.Bucket) string {
+		return fmt.Sprintf("%v:%v", derefBound(bucket.Min), derefBound(bucket.Max))
+	}
+
+	merged := append([]bpftrace.Bucket{}, a...)
+	index := map[string]int{}
+	for i, bucket := range merged {
+		index[bounds(bucket)] = i
+	}
+
+	for _, bucket := range b {
+		if i, ok := index[bounds(bucket)]; ok {
+			merged[i].Count += bucket.Count
+			continue
+		}
+		index[bounds(bucket)] = len(merged)
+		merged = append(merged, bucket)
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].Min == nil || merged[j].Min == nil {
+			return merged[i].Min == nil && merged[j].Min != nil
+		}
+		return *merged[i].Min < *merged[j].Min
+	})
+
+	return merged
+}
+
+func derefBound(bound *int64) string {
+	if bound == nil {
+		return "-"
+	}
+
+	return strconv.FormatInt(*bound, 10)
+}
+
+func isObject(value json.RawMessage) bool {
+	trimmed := strings.TrimSpace(string(value))
+	return strings.HasPrefix(trimmed, "{")
+}
@@ -0,0 +1,104 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/pkg/errors"
+)
+
+const (
+	FormatText = "text"
+	FormatJson = "json"
+)
+
+var Formats = []string{FormatText, FormatJson}
+
+// maxLineSize bounds a single bpftrace output line, maps are printed as one
+// JSON line and can get large.
+const maxLineSize = 64 * 1024 * 1024
+
+// Printer renders bpftrace records to the user.
+type Printer interface {
+	Print(record bpftrace.Record) error
+
+	// Flush is called once the session output ended.
+	Flush() error
+}
+
+func NewPrinter(format string, w io.Writer) (Printer, error) {
+	switch format {
+	case FormatText, "":
+		return NewTextPrinter(w), nil
+	case FormatJson:
+		return NewJsonPrinter(w), nil
+	default:
+		return nil, errors.Errorf("unsupported output format: '%s', supported formats are: %v", format, Formats)
+	}
+}
+
+// Consume parses the bpftrace JSON output read from r line by line and passes
+// every record to printer.
+func Consume(r io.Reader, printer Printer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		if err := printer.Print(bpftrace.ParseRecord(line)); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return printer.Flush()
+}
+
+// MultiPrinter passes every record to all of its printers.
+type MultiPrinter []Printer
+
+func (m MultiPrinter) Print(record bpftrace.Record) error {
+	for _, printer := range m {
+		if err := printer.Print(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m MultiPrinter) Flush() error {
+	for _, printer := range m {
+		if err := printer.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// JsonPrinter prints records as JSON lines, the same way bpftrace does.
+type JsonPrinter struct {
+	encoder *json.Encoder
+}
+
+func NewJsonPrinter(w io.Writer) *JsonPrinter {
+	return &JsonPrinter{encoder: json.NewEncoder(w)}
+}
+
+func (j *JsonPrinter) Print(record bpftrace.Record) error {
+	return j.encoder.Encode(record)
+}
+
+func (j *JsonPrinter) Flush() error {
+	return nil
+}
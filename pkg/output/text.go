@@ -0,0 +1,170 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+)
+
+const histBarWidth = 52
+
+// TextPrinter renders records the way bpftrace prints them by default.
+type TextPrinter struct {
+	w io.Writer
+}
+
+func NewTextPrinter(w io.Writer) *TextPrinter {
+	return &TextPrinter{w: w}
+}
+
+func (t *TextPrinter) Print(record bpftrace.Record) error {
+	var err error
+
+	switch record.Type {
+	case bpftrace.TypePrintf, bpftrace.TypeTime:
+		_, err = io.WriteString(t.w, record.String())
+	case bpftrace.TypeText:
+		_, err = fmt.Fprintln(t.w, record.String())
+	case bpftrace.TypeAttachedProbes:
+		var data struct {
+			Probes int `json:"probes"`
+		}
+		_ = json.Unmarshal(record.Data, &data)
+		_, err = fmt.Fprintf(t.w, "Attaching %d probes...\n", data.Probes)
+	case bpftrace.TypeLostEvents:
+		var data struct {
+			Events int `json:"events"`
+		}
+		_ = json.Unmarshal(record.Data, &data)
+		_, err = fmt.Fprintf(t.w, "Lost %d events\n", data.Events)
+	case bpftrace.TypeMap, bpftrace.TypeHist, bpftrace.TypeStats:
+		err = t.printMaps(record)
+	default:
+		_, err = fmt.Fprintln(t.w, string(record.Data))
+	}
+
+	return err
+}
+
+func (t *TextPrinter) Flush() error {
+	return nil
+}
+
+func (t *TextPrinter) printMaps(record bpftrace.Record) error {
+	maps, err := record.Maps()
+	if err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	for _, m := range maps {
+		switch record.Type {
+		case bpftrace.TypeHist:
+			for _, key := range m.Keys() {
+				buckets, err := bpftrace.ParseBuckets(m.Entries[key])
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(&sb, "%s:\n%s\n", entryName(m, key), FormatHistogram(buckets))
+			}
+		case bpftrace.TypeStats:
+			for _, key := range m.Keys() {
+				stats, err := bpftrace.ParseStats(m.Entries[key])
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(&sb, "%s: count %d, average %d, total %d\n",
+					entryName(m, key), stats.Count, stats.Average, stats.Total)
+			}
+			sb.WriteString("\n")
+		default:
+			for _, key := range sortedByValue(m) {
+				fmt.Fprintf(&sb, "%s: %s\n", entryName(m, key), bpftrace.ParseString(m.Entries[key]))
+			}
+			sb.WriteString("\n")
+		}
+	}
+
+	_, err = io.WriteString(t.w, sb.String())
+	return err
+}
+
+func entryName(m bpftrace.Map, key string) string {
+	if !m.Keyed {
+		return m.Name
+	}
+
+	return fmt.Sprintf("%s[%s]", m.Name, key)
+}
+
+// sortedByValue orders keys the way bpftrace does, ascending by value so the
+// largest entries end up at the bottom of the terminal.
+func sortedByValue(m bpftrace.Map) []string {
+	keys := m.Keys()
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		a, errA := bpftrace.ParseInt(m.Entries[keys[i]])
+		b, errB := bpftrace.ParseInt(m.Entries[keys[j]])
+		if errA != nil || errB != nil {
+			return false
+		}
+		return a < b
+	})
+
+	return keys
+}
+
+// FormatHistogram renders histogram buckets with bpftrace's ASCII bars.
+func FormatHistogram(buckets []bpftrace.Bucket) string {
+	var max uint64
+	for _, bucket := range buckets {
+		if bucket.Count > max {
+			max = bucket.Count
+		}
+	}
+
+	var sb strings.Builder
+	for _, bucket := range buckets {
+		width := 0
+		if max > 0 {
+			width = int(bucket.Count * histBarWidth / max)
+		}
+
+		fmt.Fprintf(&sb, "%-20s %8d |%s%s|\n", bucketLabel(bucket), bucket.Count,
+			strings.Repeat("@", width), strings.Repeat(" ", histBarWidth-width))
+	}
+
+	return sb.String()
+}
+
+func bucketLabel(bucket bpftrace.Bucket) string {
+	switch {
+	case bucket.Min == nil && bucket.Max != nil:
+		return fmt.Sprintf("(..., %s]", humanize(*bucket.Max))
+	case bucket.Min != nil && bucket.Max == nil:
+		return fmt.Sprintf("[%s, ...)", humanize(*bucket.Min))
+	case bucket.Min != nil && *bucket.Min == *bucket.Max:
+		return fmt.Sprintf("[%s]", humanize(*bucket.Min))
+	case bucket.Min != nil:
+		return fmt.Sprintf("[%s, %s)", humanize(*bucket.Min), humanize(*bucket.Max+1))
+	default:
+		return "[]"
+	}
+}
+
+// humanize shortens powers of two the way bpftrace labels log2 buckets.
+func humanize(value int64) string {
+	suffixes := []string{"", "K", "M", "G", "T", "P"}
+
+	i := 0
+	for value != 0 && value%1024 == 0 && i < len(suffixes)-1 {
+		value /= 1024
+		i++
+	}
+
+	return fmt.Sprintf("%d%s", value, suffixes[i])
+}
@@ -0,0 +1,103 @@
+package output
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextPrinter_Map(t *testing.T) {
+	// given
+	var buf bytes.Buffer
+	printer := NewTextPrinter(&buf)
+	record := bpftrace.ParseRecord([]byte(`{"type": "map", "data": {"@syscalls": {"read": 10, "write": 3, "openat": 7}}}`))
+
+	// when
+	err := printer.Print(record)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "@syscalls[write]: 3\n@syscalls[openat]: 7\n@syscalls[read]: 10\n\n", buf.String())
+}
+
+func TestTextPrinter_Hist(t *testing.T) {
+	// given
+	var buf bytes.Buffer
+	printer := NewTextPrinter(&buf)
+	record := bpftrace.ParseRecord([]byte(`{"type": "hist", "data": {"@us": [` +
+		`{"min": 0, "max": 0, "count": 1}, {"min": 2, "max": 3, "count": 4}, {"min": 1024, "max": 2047, "count": 2}]}}`))
+
+	// when
+	err := printer.Print(record)
+
+	// then
+	assert.NoError(t, err)
+	lines := strings.Split(buf.String(), "\n")
+	assert.Equal(t, "@us:", lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "[0] "))
+	assert.True(t, strings.HasPrefix(lines[2], "[2, 4) "))
+	assert.Contains(t, lines[2], strings.Repeat("@", histBarWidth))
+	assert.True(t, strings.HasPrefix(lines[3], "[1K, 2K) "))
+}
+
+func TestTextPrinter_Text(t *testing.T) {
+	// given
+	var buf bytes.Buffer
+	printer := NewTextPrinter(&buf)
+
+	// when
+	err := printer.Print(bpftrace.ParseRecord([]byte("stdin:1:1-6: ERROR: syntax error")))
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "stdin:1:1-6: ERROR: syntax error\n", buf.String())
+}
+
+func TestConsume_Json(t *testing.T) {
+	// given
+	var buf bytes.Buffer
+	input := `{"type": "attached_probes", "data": {"probes": 1}}` + "\n" +
+		`{"type": "printf", "data": "hello\n"}` + "\n"
+
+	// when
+	err := Consume(strings.NewReader(input), NewJsonPrinter(&buf))
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, `{"type":"attached_probes","data":{"probes":1}}`+"\n"+`{"type":"printf","data":"hello\n"}`+"\n", buf.String())
+}
+
+func TestAnnotatingPrinter(t *testing.T) {
+	// given
+	var buf bytes.Buffer
+	annotate := func(id string) (string, bool) {
+		if id == "41231" || id == "41232" {
+			return testutil.Workload, true
+		}
+		return "", false
+	}
+	printer := NewAnnotatingPrinter(NewJsonPrinter(&buf), "pid", annotate)
+
+	// when
+	assert.NoError(t, printer.Print(bpftrace.ParseRecord([]byte(`{"type": "map", "data": {"@": {"41231, api": 3, "41232, api": 4, "1, systemd": 1}}}`))))
+	assert.NoError(t, printer.Print(bpftrace.ParseRecord([]byte(`{"type": "printf", "data": "open pid=41231 tid=41240\n"}`))))
+	assert.NoError(t, printer.Print(bpftrace.ParseRecord([]byte(`{"type": "hist", "data": {"@us": {`+
+		`"41231": [{"min": 0, "max": 1, "count": 2}], "41232": [{"min": 0, "max": 1, "count": 1}, {"min": 2, "max": 3, "count": 5}]}}}`))))
+
+	// then
+	assert.Equal(t, `{"type":"map","data":{"@":{"1, systemd":1,"`+testutil.Workload+`, api":7}}}`+"\n"+
+		`{"type":"printf","data":"open `+testutil.Workload+` tid=41240\n"}`+"\n"+
+		`{"type":"hist","data":{"@us":{"`+testutil.Workload+`":[{"min":0,"max":1,"count":3},{"min":2,"max":3,"count":5}]}}}`+"\n",
+		buf.String())
+}
+
+func TestSize(t *testing.T) {
+	assert.Equal(t, "0B", Size(0))
+	assert.Equal(t, "1023B", Size(1023))
+	assert.Equal(t, "1.5KiB", Size(1536))
+	assert.Equal(t, "2.0GiB", Size(2<<30))
+}
@@ -0,0 +1,395 @@
+package record
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// Version is bumped whenever the archive layout changes incompatibly.
+const Version = 1
+
+const (
+	metadataFile = "metadata.json"
+	rawFile      = "output.raw"
+	eventsFile   = "events.jsonl"
+	scriptsDir   = "scripts/"
+
+	// inlineProgramName is the script name a --filter program is recorded as.
+	inlineProgramName = "program.bt"
+)
+
+// Target describes the traced container.
+type Target struct {
+	Pod              string `json:"pod"`
+	Namespace        string `json:"namespace"`
+	Container        string `json:"container"`
+	ContainerId      string `json:"containerId"`
+	ContainerRuntime string `json:"containerRuntime"`
+	Node             string `json:"node"`
+}
+
+// NodeInfo describes the kernel and OS of the node the session ran on.
+type NodeInfo struct {
+	KernelVersion           string `json:"kernelVersion"`
+	OSImage                 string `json:"osImage"`
+	OperatingSystem         string `json:"operatingSystem"`
+	Architecture            string `json:"architecture"`
+	ContainerRuntimeVersion string `json:"containerRuntimeVersion"`
+}
+
+// Metadata makes a recording self-describing.
+type Metadata struct {
+	Version    int       `json:"version"`
+	Target     Target    `json:"target"`
+	Node       NodeInfo  `json:"node"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// Event is a parsed bpftrace record and the time it was received.
+type Event struct {
+	Time time.Time `json:"time"`
+	bpftrace.Record
+}
+
+// Recording is a trace session captured for later analysis.
+type Recording struct {
+	Metadata Metadata
+	// Scripts holds the program that was run, keyed by file name.
+	Scripts map[string][]byte
+	// Raw is the output exactly as received from bpftrace.
+	Raw    []byte
+	Events []Event
+}
+
+// NewMetadata describes the target from the settings of the session.
+func NewMetadata(settings *config.DoktorSettings) Metadata {
+	return Metadata{
+		Version: Version,
+		Target: Target{
+			Pod:              settings.UserSpecifiedPodName,
+			Namespace:        settings.UserSpecifiedNamespace,
+			Container:        settings.UserSpecifiedContainer,
+			ContainerId:      settings.DetectedContainerId,
+			ContainerRuntime: settings.DetectedContainerRuntime,
+			Node:             settings.DetectedPodNodeName,
+		},
+	}
+}
+
+// ReadScripts loads the program of the session, either the inline program
+// or the script file or bundle directory at scriptPath.
+func ReadScripts(program string, scriptPath string) (map[string][]byte, error) {
+	scripts := map[string][]byte{}
+
+	if scriptPath == "" {
+		return InlineScripts(program), nil
+	}
+
+	info, err := os.Stat(scriptPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		content, err := ioutil.ReadFile(scriptPath)
+		if err != nil {
+			return nil, err
+		}
+		scripts[info.Name()] = content
+		return scripts, nil
+	}
+
+	err = filepath.Walk(scriptPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(scriptPath, filePath)
+		if err != nil {
+			return err
+		}
+
+		content, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		scripts[filepath.ToSlash(rel)] = content
+		return nil
+	})
+
+	return scripts, err
+}
+
+// InlineScripts returns the scripts of a session running program, an inline
+// program or one generated by a built-in analysis.
+func InlineScripts(program string) map[string][]byte {
+	return map[string][]byte{inlineProgramName: []byte(program)}
+}
+
+// Recorder captures a live session. It's written the raw bpftrace output as an
+// io.Writer and is given parsed records as an output.Printer, both spooled to
+// temporary files until the recording is saved, so long sessions aren't kept
+// in memory.
+type Recorder struct {
+	mu       sync.Mutex
+	metadata Metadata
+	scripts  map[string][]byte
+	raw      *os.File
+	events   *os.File
+	buffer   *bufio.Writer
+	encoder  *json.Encoder
+}
+
+func NewRecorder(metadata Metadata, scripts map[string][]byte) (*Recorder, error) {
+	metadata.StartedAt = time.Now().UTC()
+
+	raw, err := ioutil.TempFile("", "doktor-raw")
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := ioutil.TempFile("", "doktor-events")
+	if err != nil {
+		_ = raw.Close()
+		_ = os.Remove(raw.Name())
+		return nil, err
+	}
+
+	buffer := bufio.NewWriter(events)
+
+	return &Recorder{
+		metadata: metadata,
+		scripts:  scripts,
+		raw:      raw,
+		events:   events,
+		buffer:   buffer,
+		encoder:  json.NewEncoder(buffer),
+	}, nil
+}
+
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.raw.Write(p)
+}
+
+func (r *Recorder) Print(record bpftrace.Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.encoder.Encode(Event{Time: time.Now().UTC(), Record: record})
+}
+
+func (r *Recorder) Flush() error {
+	return nil
+}
+
+// Save marks the session as finished and writes the recording to filePath.
+func (r *Recorder) Save(filePath string, node NodeInfo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metadata.FinishedAt = time.Now().UTC()
+	r.metadata.Node = node
+
+	if err := r.buffer.Flush(); err != nil {
+		return err
+	}
+
+	metadata, err := json.MarshalIndent(r.metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	entries := []entry{{name: metadataFile, content: bytes.NewReader(metadata), size: int64(len(metadata))}}
+
+	for _, file := range []struct {
+		name string
+		file *os.File
+	}{{rawFile, r.raw}, {eventsFile, r.events}} {
+		size, err := file.file.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		if _, err := file.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		entries = append(entries, entry{name: file.name, content: file.file, size: size})
+	}
+
+	return writeArchive(filePath, r.metadata.FinishedAt, append(entries, scriptEntries(r.scripts)...))
+}
+
+// Close removes the temporary files of the recorder.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var err error
+	for _, file := range []*os.File{r.raw, r.events} {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if removeErr := os.Remove(file.Name()); err == nil {
+			err = removeErr
+		}
+	}
+
+	return err
+}
+
+// entry is a file of a recording archive.
+type entry struct {
+	name    string
+	content io.Reader
+	size    int64
+}
+
+func scriptEntries(scripts map[string][]byte) []entry {
+	entries := make([]entry, 0, len(scripts))
+	for name, content := range scripts {
+		entries = append(entries, entry{name: scriptsDir + name, content: bytes.NewReader(content), size: int64(len(content))})
+	}
+	return entries
+}
+
+// writeArchive stores the entries of a recording as a gzipped tar, so it can
+// be inspected with standard tools as well as replayed.
+func writeArchive(filePath string, modTime time.Time, entries []entry) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+
+	gw := gzip.NewWriter(file)
+	tw := tar.NewWriter(gw)
+
+	err = writeEntries(tw, modTime, entries)
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gw.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func writeEntries(tw *tar.Writer, modTime time.Time, entries []entry) error {
+	for _, entry := range entries {
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     entry.name,
+			Mode:     0644,
+			Size:     entry.size,
+			ModTime:  modTime,
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if _, err := io.CopyN(tw, entry.content, entry.size); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Read loads a recording written by Recorder.Save.
+func Read(filePath string) (*Recording, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	gr, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "'%s' isn't a doktor recording", filePath)
+	}
+
+	recording := &Recording{Scripts: map[string][]byte{}}
+	hasMetadata := false
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case hdr.Name == metadataFile:
+			if err := json.Unmarshal(content, &recording.Metadata); err != nil {
+				return nil, errors.Wrap(err, "failed to decode recording metadata")
+			}
+			hasMetadata = true
+		case hdr.Name == rawFile:
+			recording.Raw = content
+		case hdr.Name == eventsFile:
+			recording.Events, err = readEvents(content)
+			if err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(hdr.Name, scriptsDir):
+			recording.Scripts[strings.TrimPrefix(hdr.Name, scriptsDir)] = content
+		}
+	}
+
+	if !hasMetadata {
+		return nil, errors.Errorf("'%s' isn't a doktor recording, %s is missing", filePath, metadataFile)
+	}
+
+	if recording.Metadata.Version > Version {
+		return nil, errors.Errorf("recording version %d is newer than the supported version %d",
+			recording.Metadata.Version, Version)
+	}
+
+	return recording, nil
+}
+
+func readEvents(content []byte) ([]Event, error) {
+	var events []Event
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), len(content)+1)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, errors.Wrap(err, "failed to decode recorded event")
+		}
+		events = append(events, event)
+	}
+
+	return events, scanner.Err()
+}
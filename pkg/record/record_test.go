@@ -0,0 +1,81 @@
+package record
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/config"
+	"github.com/alam0rt/kubectl-doktor/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder_SaveAndRead(t *testing.T) {
+	// given
+	dir, err := ioutil.TempDir("", "doktor-record")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	settings := &config.DoktorSettings{
+		UserSpecifiedPodName:   testutil.PodName,
+		UserSpecifiedContainer: testutil.Container,
+		DetectedPodNodeName:    testutil.Node,
+	}
+	scripts, err := ReadScripts("BEGIN { printf(\"hi\\n\"); }", "")
+	assert.NoError(t, err)
+
+	raw := `{"type": "printf", "data": "hi\n"}` + "\n"
+	recorder, err := NewRecorder(NewMetadata(settings), scripts)
+	assert.NoError(t, err)
+	_, err = recorder.Write([]byte(raw))
+	assert.NoError(t, err)
+	assert.NoError(t, recorder.Print(bpftrace.ParseRecord([]byte(raw))))
+
+	filePath := filepath.Join(dir, "session.tar.gz")
+
+	// when
+	err = recorder.Save(filePath, NodeInfo{KernelVersion: "5.15.0"})
+	assert.NoError(t, err)
+	recording, err := Read(filePath)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, Version, recording.Metadata.Version)
+	assert.Equal(t, testutil.PodName, recording.Metadata.Target.Pod)
+	assert.Equal(t, testutil.Node, recording.Metadata.Target.Node)
+	assert.Equal(t, "5.15.0", recording.Metadata.Node.KernelVersion)
+	assert.False(t, recording.Metadata.FinishedAt.Before(recording.Metadata.StartedAt))
+	assert.Equal(t, raw, string(recording.Raw))
+	assert.Equal(t, "BEGIN { printf(\"hi\\n\"); }", string(recording.Scripts[inlineProgramName]))
+	assert.Len(t, recording.Events, 1)
+	assert.Equal(t, bpftrace.TypePrintf, recording.Events[0].Type)
+	assert.Equal(t, "hi\n", recording.Events[0].String())
+
+	// when
+	spooled := []string{recorder.raw.Name(), recorder.events.Name()}
+	err = recorder.Close()
+
+	// then
+	assert.NoError(t, err)
+	for _, name := range spooled {
+		_, err := os.Stat(name)
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestRead_NotARecording(t *testing.T) {
+	// given
+	file, err := ioutil.TempFile("", "doktor-record")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, _ = file.WriteString("not a recording")
+	_ = file.Close()
+
+	// when
+	_, err = Read(file.Name())
+
+	// then
+	assert.Error(t, err)
+}
@@ -3,10 +3,20 @@ package tracer
 import (
 	"bytes"
 	"io"
+	"os"
+	"path"
+	"strings"
 
 	"github.com/alam0rt/kubectl-doktor/kube"
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/capture"
 	"github.com/alam0rt/kubectl-doktor/pkg/config"
+	"github.com/alam0rt/kubectl-doktor/pkg/coredump"
+	"github.com/alam0rt/kubectl-doktor/pkg/jvm"
+	"github.com/alam0rt/kubectl-doktor/pkg/k8smeta"
 	"github.com/alam0rt/kubectl-doktor/pkg/service/tracer/runtime"
+	"github.com/alam0rt/kubectl-doktor/pkg/symbolize"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	v1 "k8s.io/api/core/v1"
 )
@@ -18,6 +28,13 @@ type PrivilegedPodTracerService struct {
 	targetProcessId         *string
 	kubernetesApiService    kube.KubernetesApiService
 	runtimeBridge           runtime.ContainerRuntimeBridge
+	bpftraceBinary          string
+	tcpdumpBinary           string
+	scriptPath              string
+	includeDir              string
+	targetPids              []string
+	targetCgroup            string
+	jvmAgent                bool
 }
 
 func NewPrivilegedPodRemoteTracingService(options *config.DoktorSettings, service kube.KubernetesApiService, bridge runtime.ContainerRuntimeBridge) TracerService {
@@ -68,29 +85,192 @@ func (p *PrivilegedPodTracerService) Setup() error {
 		}
 	}
 
-	return nil
+	if err := p.findTargetPids(); err != nil {
+		return err
+	}
+
+	command := []string{"mkdir", "-p", bpftrace.RemoteArtifactsDir}
+	exitCode, err := p.kubernetesApiService.ExecuteCommand(p.privilegedPod.Name, p.privilegedContainerName, command, &kube.NopWriter{})
+	if err != nil || exitCode != 0 {
+		log.Warn().
+			Msgf("failed to create artifacts directory: '%s', exit code: '%d'", bpftrace.RemoteArtifactsDir, exitCode)
+	}
+
+	if err := p.uploadBpftrace(); err != nil {
+		return err
+	}
+
+	if err := p.uploadTcpdump(); err != nil {
+		return err
+	}
+
+	if err := p.uploadJvmAttach(); err != nil {
+		return err
+	}
+
+	return p.uploadScript()
 }
 
-func (p *PrivilegedPodTracerService) Cleanup() error {
+func (p *PrivilegedPodTracerService) findTargetPids() error {
+	if p.settings.DetectedContainerId == "" {
+		return nil
+	}
+
+	snapshot, err := p.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	p.targetPids = snapshot.Pids(p.settings.DetectedContainerId)
+
 	log.Info().
-		Msgf("removing privileged container: '%s'", p.privilegedContainerName)
+		Msgf("found %d processes in container: '%s', pids: %v", len(p.targetPids), p.settings.UserSpecifiedContainer, p.targetPids)
 
-	command := p.runtimeBridge.BuildCleanupCommand()
+	if len(p.targetPids) == 0 {
+		return nil
+	}
 
-	exitCode, err := p.kubernetesApiService.ExecuteCommand(p.privilegedPod.Name, p.privilegedContainerName, command, &kube.NopWriter{})
+	cgroup, ok := snapshot.ContainerCgroup(p.settings.DetectedContainerId, p.targetPids[0])
+	if !ok {
+		log.Warn().
+			Msg("cgroup v2 hierarchy of the container not found, only the processes found now will be traced, " +
+				"not the ones forked or restarted later")
+		return nil
+	}
+
+	p.targetCgroup = cgroup
+
+	log.Debug().
+		Msgf("tracing the processes of cgroup: '%s'", p.targetCgroup)
+
+	return nil
+}
+
+func (p *PrivilegedPodTracerService) TargetPids() []string {
+	return p.targetPids
+}
+
+func (p *PrivilegedPodTracerService) Target() bpftrace.Target {
+	return bpftrace.Target{Cgroup: p.targetCgroup, Pids: p.targetPids}
+}
+
+// uploadBpftrace pushes a static bpftrace binary, either the one given with
+// --bpftrace-binary or the one embedded at build time, so the helper image
+// doesn't need to ship bpftrace itself.
+func (p *PrivilegedPodTracerService) uploadBpftrace() error {
+	localPath := p.settings.BpftraceBinary
+
+	if localPath == "" && bpftrace.HasEmbeddedBinary() {
+		var err error
+		localPath, err = bpftrace.ExtractEmbeddedBinary()
+		if err != nil {
+			return err
+		}
+	}
+
+	if localPath == "" {
+		log.Info().
+			Msg("no bpftrace binary provided, relying on bpftrace from the helper image")
+		return nil
+	}
+
+	err := p.kubernetesApiService.UploadFile(localPath, bpftrace.RemoteBinaryPath, p.privilegedPod.Name, p.privilegedContainerName)
 	if err != nil {
 		log.Error().
-			Msgf("failed to remove privileged container: '%s', exit code: '%d', "+
-				"please manually remove it", p.privilegedContainerName, exitCode)
-	} else {
-		log.Info().
-			Msgf("privileged container: '%s' removed successfully", p.privilegedContainerName)
+			Msgf("failed to upload bpftrace binary: '%s'", localPath)
+		return err
+	}
+
+	p.bpftraceBinary = bpftrace.RemoteBinaryPath
+
+	return nil
+}
+
+// uploadTcpdump pushes the static tcpdump binary given with
+// --tcpdump-binary, tcpdump from the helper image is used otherwise.
+func (p *PrivilegedPodTracerService) uploadTcpdump() error {
+	localPath := p.settings.TcpdumpBinary
+	if localPath == "" {
+		return nil
+	}
+
+	err := p.kubernetesApiService.UploadFile(localPath, capture.RemoteBinaryPath, p.privilegedPod.Name, p.privilegedContainerName)
+	if err != nil {
+		log.Error().
+			Msgf("failed to upload tcpdump binary: '%s'", localPath)
+		return err
+	}
+
+	p.tcpdumpBinary = capture.RemoteBinaryPath
+
+	return nil
+}
+
+func (p *PrivilegedPodTracerService) uploadJvmAttach() error {
+	localDir := p.settings.UserSpecifiedJvmAttachDir
+	if localDir == "" {
+		return nil
+	}
+
+	withAgent, err := jvm.CheckAttachDir(localDir)
+	if err != nil {
+		return err
+	}
+
+	err = p.kubernetesApiService.UploadFile(localDir, jvm.RemoteDir, p.privilegedPod.Name, p.privilegedContainerName)
+	if err != nil {
+		log.Error().
+			Msgf("failed to upload JVM attach directory: '%s'", localDir)
+		return err
+	}
+
+	p.jvmAgent = withAgent
+
+	return nil
+}
+
+// uploadScript pushes the script given with --script. A directory is uploaded
+// as a bundle, its main.bt is run and it is added to the include path.
+func (p *PrivilegedPodTracerService) uploadScript() error {
+	localPath := p.settings.UserSpecifiedScript
+	if localPath == "" {
+		return nil
+	}
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+
+	remotePath := bpftrace.RemoteScriptPath(localPath, false)
+	if info.IsDir() {
+		remotePath = bpftrace.RemoteScriptsDir
+	}
+
+	err = p.kubernetesApiService.UploadFile(localPath, remotePath, p.privilegedPod.Name, p.privilegedContainerName)
+	if err != nil {
+		log.Error().
+			Msgf("failed to upload script: '%s'", localPath)
+		return err
+	}
+
+	p.scriptPath = bpftrace.RemoteScriptPath(localPath, info.IsDir())
+	if info.IsDir() {
+		p.includeDir = bpftrace.RemoteScriptsDir
+	}
+
+	return nil
+}
+
+func (p *PrivilegedPodTracerService) Cleanup() error {
+	if p.privilegedPod == nil {
+		return nil
 	}
 
 	log.Info().
 		Msgf("removing pod: '%s'", p.privilegedPod.Name)
 
-	err = p.kubernetesApiService.DeletePod(p.privilegedPod.Name)
+	err := p.kubernetesApiService.DeletePod(p.privilegedPod.Name)
 	if err != nil {
 		log.Error().
 			Msgf("failed to remove pod: '%s", p.privilegedPod.Name)
@@ -104,16 +284,112 @@ func (p *PrivilegedPodTracerService) Cleanup() error {
 }
 
 func (p *PrivilegedPodTracerService) Start(stdOut io.Writer) error {
+	return p.start(bpftrace.Command{
+		Program:    p.settings.UserSpecifiedFilter,
+		ScriptPath: p.scriptPath,
+		IncludeDir: p.includeDir,
+		Pid:        p.targetProcessId,
+	}, stdOut)
+}
+
+func (p *PrivilegedPodTracerService) StartProgram(program string, stdOut io.Writer) error {
+	return p.start(bpftrace.Command{Program: program}, stdOut)
+}
+
+func (p *PrivilegedPodTracerService) StartCommand(command bpftrace.Command, stdOut io.Writer) error {
+	return p.start(command, stdOut)
+}
+
+func (p *PrivilegedPodTracerService) StartCapture(captureCommand capture.Command, stdOut io.Writer) error {
+	log.Info().
+		Msgf("starting remote packet capture using privileged pod")
+
+	captureCommand.Binary = p.tcpdumpBinary
+	command := captureCommand.Build()
+
+	exitCode, err := p.kubernetesApiService.ExecuteCommand(p.privilegedPod.Name, p.privilegedContainerName, command, stdOut)
+	if err != nil {
+		log.Error().
+			Msgf("failed to start packet capture using privileged pod, exit code: '%d'", exitCode)
+		return err
+	}
+
+	switch {
+	case exitCode == 0 || exitCode == capture.ExitTimeout:
+	case kube.IsMissingCommand(exitCode):
+		return errors.New("tcpdump or nsenter not found in the helper image, " +
+			"provide a static tcpdump with --tcpdump-binary or an image shipping them with --image")
+	default:
+		return errors.Errorf("packet capture failed, exit code: '%d'", exitCode)
+	}
+
+	log.Info().
+		Msg("remote packet capture using privileged pod completed")
+
+	return nil
+}
+
+func (p *PrivilegedPodTracerService) DumpCore(dumpCommand coredump.Command, stdOut io.Writer) error {
+	log.Info().
+		Msgf("dumping the core of process: '%s' using privileged pod", dumpCommand.Pid)
+
+	if err := p.execute([]string{"mkdir", "-p", coredump.RemoteDir}); err != nil {
+		return err
+	}
+
+	var buff bytes.Buffer
+	exitCode, err := p.kubernetesApiService.ExecuteCommand(p.privilegedPod.Name, p.privilegedContainerName, dumpCommand.DumpCommand(), &buff)
+	if err != nil {
+		log.Error().
+			Msgf("failed to dump core using privileged pod, exit code: '%d'", exitCode)
+		return err
+	}
+
+	// the core is removed from the pod once archived, or partially dumped
+	defer func() {
+		if err := p.execute(dumpCommand.CleanupCommand()); err != nil {
+			log.Warn().
+				Err(err).
+				Msg("failed to remove the core from the privileged pod")
+		}
+	}()
+
+	switch {
+	case exitCode == 0:
+	case kube.IsMissingCommand(exitCode):
+		return errors.New("gcore not found in the helper image, provide an image shipping gdb with --image")
+	default:
+		return errors.Errorf("core dump failed, exit code: '%d': %s", exitCode, strings.TrimSpace(buff.String()))
+	}
+
+	exitCode, err = p.kubernetesApiService.ExecuteCommand(p.privilegedPod.Name, p.privilegedContainerName, dumpCommand.ArchiveCommand(), stdOut)
+	if err != nil {
+		log.Error().
+			Msgf("failed to archive core using privileged pod, exit code: '%d'", exitCode)
+		return err
+	}
+
+	if kube.IsMissingCommand(exitCode) {
+		return kube.MissingCommandError("tar")
+	}
+
+	if exitCode != 0 {
+		return errors.Errorf("core archive failed, exit code: '%d'", exitCode)
+	}
+
+	log.Info().
+		Msg("core dump using privileged pod completed")
+
+	return nil
+}
+
+func (p *PrivilegedPodTracerService) start(bpftraceCommand bpftrace.Command, stdOut io.Writer) error {
 	log.Info().
 		Msgf("starting remote tracing using privileged pod")
 
-	command := p.runtimeBridge.BuildTcpdumpCommand(
-		&p.settings.DetectedContainerId,
-		p.settings.UserSpecifiedInterface,
-		p.settings.UserSpecifiedFilter,
-		p.targetProcessId,
-		p.settings.SocketPath,
-	)
+	bpftraceCommand.Binary = p.bpftraceBinary
+	bpftraceCommand.Format = "json"
+	command := bpftraceCommand.Build()
 
 	exitCode, err := p.kubernetesApiService.ExecuteCommand(p.privilegedPod.Name, p.privilegedContainerName, command, stdOut)
 	if err != nil {
@@ -122,8 +398,140 @@ func (p *PrivilegedPodTracerService) Start(stdOut io.Writer) error {
 		return err
 	}
 
+	if kube.IsMissingCommand(exitCode) {
+		return kube.MissingCommandError("bpftrace")
+	}
+
+	if exitCode != 0 {
+		return errors.Errorf("remote tracing failed, exit code: '%d'", exitCode)
+	}
+
 	log.Info().
 		Msg("remote tracing using privileged pod completed")
 
 	return nil
 }
+
+func (p *PrivilegedPodTracerService) ReadFile(remotePath string) ([]byte, error) {
+	var buff bytes.Buffer
+
+	command := []string{"cat", remotePath}
+	exitCode, err := p.kubernetesApiService.ExecuteCommand(p.privilegedPod.Name, p.privilegedContainerName, command, &buff)
+	if err != nil {
+		return nil, err
+	}
+
+	if exitCode != 0 {
+		return nil, errors.Errorf("failed to read: '%s', exit code: '%d'", remotePath, exitCode)
+	}
+
+	return buff.Bytes(), nil
+}
+
+func (p *PrivilegedPodTracerService) ReadLink(remotePath string) (string, error) {
+	var buff bytes.Buffer
+
+	command := []string{"readlink", remotePath}
+	exitCode, err := p.kubernetesApiService.ExecuteCommand(p.privilegedPod.Name, p.privilegedContainerName, command, &buff)
+	if err != nil {
+		return "", err
+	}
+
+	if exitCode != 0 {
+		return "", errors.Errorf("failed to resolve link: '%s', exit code: '%d'", remotePath, exitCode)
+	}
+
+	return strings.TrimSpace(buff.String()), nil
+}
+
+func (p *PrivilegedPodTracerService) Snapshot() (k8smeta.Snapshot, error) {
+	stdOut := new(kube.Writer)
+
+	// find exits non-zero when it can't read the /proc entries of processes
+	// that exited while it walked them, the others are still printed.
+	command := k8smeta.SnapshotCommand()
+	exitCode, err := p.kubernetesApiService.ExecuteCommand(p.privilegedPod.Name, p.privilegedContainerName, command, stdOut)
+	if err != nil {
+		return k8smeta.Snapshot{}, err
+	}
+
+	if kube.IsMissingCommand(exitCode) {
+		return k8smeta.Snapshot{}, kube.MissingCommandError("find")
+	}
+
+	return k8smeta.ParseSnapshot(stdOut.Output), nil
+}
+
+func (p *PrivilegedPodTracerService) GeneratePerfMaps() error {
+	if p.settings.UserSpecifiedJvmAttachDir == "" {
+		return nil
+	}
+
+	for _, pid := range p.targetPids {
+		exe, err := p.ReadLink(symbolize.ExePath(pid))
+		if err != nil || !jvm.IsJvm(exe) {
+			continue
+		}
+
+		if p.jvmAgent {
+			command := []string{"cp", path.Join(jvm.RemoteDir, jvm.AgentLibrary), symbolize.RootPath(pid, jvm.ContainerAgentPath)}
+			if err := p.execute(command); err != nil {
+				return err
+			}
+		}
+
+		log.Info().
+			Msgf("generating perf map of JVM: '%s'", pid)
+
+		if err := p.execute(jvm.PerfMapCommand(pid, p.jvmAgent)); err != nil {
+			log.Warn().
+				Err(err).
+				Msgf("failed to generate perf map of JVM: '%s', its JIT frames won't be symbolized", pid)
+		}
+	}
+
+	return nil
+}
+
+func (p *PrivilegedPodTracerService) execute(command []string) error {
+	var buff bytes.Buffer
+
+	exitCode, err := p.kubernetesApiService.ExecuteCommand(p.privilegedPod.Name, p.privilegedContainerName, command, &buff)
+	if err != nil {
+		return err
+	}
+
+	if exitCode != 0 {
+		return errors.Errorf("command: '%v' failed with exit code: '%d': %s", command, exitCode, strings.TrimSpace(buff.String()))
+	}
+
+	return nil
+}
+
+func (p *PrivilegedPodTracerService) DownloadFile(remotePath string, localDir string) error {
+	return p.kubernetesApiService.DownloadFile(remotePath, localDir, p.privilegedPod.Name, p.privilegedContainerName)
+}
+
+func (p *PrivilegedPodTracerService) DownloadArtifacts(localDir string) error {
+	remotePaths := append([]string{bpftrace.RemoteArtifactsDir}, p.settings.UserSpecifiedArtifacts...)
+
+	var failed []string
+	for _, remotePath := range remotePaths {
+		err := p.kubernetesApiService.DownloadFile(remotePath, localDir, p.privilegedPod.Name, p.privilegedContainerName)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Msgf("failed to download artifact: '%s'", remotePath)
+			failed = append(failed, remotePath)
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.Errorf("failed to download artifacts: %v", failed)
+	}
+
+	log.Info().
+		Msgf("artifacts downloaded to: '%s'", localDir)
+
+	return nil
+}
@@ -1,14 +1,6 @@
 package runtime
 
-import (
-	"fmt"
-
-	"github.com/alam0rt/kubectl-doktor/utils"
-)
-
 type DockerBridge struct {
-	tcpdumpContainerName string
-	cleanupCommand       []string
 }
 
 func NewDockerBridge() *DockerBridge {
@@ -27,25 +19,6 @@ func (d *DockerBridge) ExtractPid(inspection string) (*string, error) {
 	panic("Docker doesn't need this implemented")
 }
 
-func (d *DockerBridge) BuildTcpdumpCommand(containerId *string, netInterface string, filter string, pid *string, socketPath string) []string {
-	d.tcpdumpContainerName = "ksniff-container-" + utils.GenerateRandomString(8)
-	containerNameFlag := fmt.Sprintf("--name=%s", d.tcpdumpContainerName)
-
-	command := []string{"docker", "--host", "unix://" + socketPath,
-		"run", "--rm", containerNameFlag,
-		fmt.Sprintf("--net=container:%s", *containerId), "maintained/tcpdump", "-i",
-		netInterface, "-U", "-w", "-", filter}
-
-	d.cleanupCommand = []string{"docker", "--host", "unix://" + socketPath,
-		"rm", "-f", d.tcpdumpContainerName}
-
-	return command
-}
-
-func (d *DockerBridge) BuildCleanupCommand() []string {
-	return d.cleanupCommand
-}
-
 func (d *DockerBridge) GetDefaultImage() string {
 	return "docker"
 }
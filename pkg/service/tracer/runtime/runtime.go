@@ -10,8 +10,6 @@ type ContainerRuntimeBridge interface {
 	NeedsPid() bool
 	BuildInspectCommand(containerId string) []string
 	ExtractPid(inspection string) (*string, error)
-	BuildTcpdumpCommand(containerId *string, netInterface string, filter string, pid *string, socketPath string) []string
-	BuildCleanupCommand() []string
 	GetDefaultImage() string
 	GetDefaultSocketPath() string
 }
@@ -2,6 +2,11 @@ package tracer
 
 import (
 	"io"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/capture"
+	"github.com/alam0rt/kubectl-doktor/pkg/coredump"
+	"github.com/alam0rt/kubectl-doktor/pkg/k8smeta"
 )
 
 type TracerService interface {
@@ -14,4 +19,47 @@ type TracerService interface {
 	// Start remote sniffing
 	// write remote capture output to the given io writer.
 	Start(stdOut io.Writer) error
+
+	// Start remote tracing of a generated bpftrace program rather than the
+	// one the user provided, e.g. for built-in analyses.
+	StartProgram(program string, stdOut io.Writer) error
+
+	// Start remote tracing of a generated bpftrace command, for programs
+	// needing more than the default bpftrace options.
+	StartCommand(command bpftrace.Command, stdOut io.Writer) error
+
+	// Start a packet capture, write the pcap stream to the given io writer.
+	StartCapture(command capture.Command, stdOut io.Writer) error
+
+	// Dump the core of a process, write the gzipped tar of the core and of
+	// the files it mapped to the given io writer.
+	DumpCore(command coredump.Command, stdOut io.Writer) error
+
+	// Host process ids of the target container, discovered during Setup.
+	TargetPids() []string
+
+	// Processes of the target container to trace, by cgroup when the host
+	// runs cgroup v2, by the pids discovered during Setup otherwise.
+	Target() bpftrace.Target
+
+	// Read the cgroup of every host process and the cgroup v2 directories
+	// by inode, in a single exec.
+	Snapshot() (k8smeta.Snapshot, error)
+
+	// Read a small file, such as a procfs file, on the privileged pod.
+	ReadFile(remotePath string) ([]byte, error)
+
+	// Resolve a symbolic link, such as /proc/<pid>/exe, on the privileged pod.
+	ReadLink(remotePath string) (string, error)
+
+	// Make the JVMs of the target container write their perf map, when the
+	// user provided a JVM attach directory.
+	GeneratePerfMaps() error
+
+	// Download a single remote file or directory into the given local directory.
+	DownloadFile(remotePath string, localDir string) error
+
+	// Download the session artifacts, and any additional remote paths the
+	// user asked for, into the given local directory.
+	DownloadArtifacts(localDir string) error
 }
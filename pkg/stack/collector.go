@@ -0,0 +1,112 @@
+package stack
+
+import (
+	"strings"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/rs/zerolog/log"
+)
+
+// CommMap is the map stack collecting programs fill with process names,
+// keyed by pid.
+const CommMap = "@comm"
+
+// Collector is an output.Printer gathering the stacks a bpftrace program
+// printed as a map keyed by [pid, kstack(perf), ustack(perf)], together with
+// the process names of CommMap. A collector without a map name gathers the
+// stacks of every map whose keys hold stacks, for user provided programs.
+type Collector struct {
+	mapName string
+	samples []Sample
+	comms   map[string]string
+}
+
+func NewCollector(mapName string) *Collector {
+	return &Collector{mapName: mapName, comms: map[string]string{}}
+}
+
+func (c *Collector) Print(record bpftrace.Record) error {
+	switch record.Type {
+	case bpftrace.TypeMap:
+	case bpftrace.TypeText:
+		// user provided programs are printed alongside, which reports them
+		if c.mapName != "" {
+			log.Warn().Msg(record.String())
+		}
+		return nil
+	default:
+		return nil
+	}
+
+	maps, err := record.Maps()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range maps {
+		switch m.Name {
+		case CommMap:
+			for _, key := range m.Keys() {
+				c.comms[strings.TrimSpace(key)] = bpftrace.ParseString(m.Entries[key])
+			}
+		case c.mapName:
+			if err := c.collect(m); err != nil {
+				return err
+			}
+		default:
+			if c.mapName == "" && record.Type == bpftrace.TypeMap {
+				if err := c.collect(m); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Collector) Flush() error {
+	return nil
+}
+
+// Samples returns the collected stacks labeled with their process name.
+func (c *Collector) Samples() []Sample {
+	for i := range c.samples {
+		c.samples[i].Comm = c.comms[c.samples[i].Pid]
+	}
+
+	return c.samples
+}
+
+func (c *Collector) collect(m bpftrace.Map) error {
+	for _, key := range m.Keys() {
+		frames := ParseFrames(key)
+		if len(frames) == 0 {
+			continue
+		}
+
+		value, err := bpftrace.ParseInt(m.Entries[key])
+		if err != nil {
+			if c.mapName == "" {
+				continue
+			}
+			return err
+		}
+
+		// only the programs doktor generates are known to key by pid first
+		fields := KeyFields(key)
+		pid := ""
+		if c.mapName != "" && len(fields) > 0 {
+			pid = fields[0]
+		}
+
+		c.samples = append(c.samples, Sample{
+			Pid:    pid,
+			Fields: fields,
+			Frames: frames,
+			Values: []int64{value},
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package stack
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// kernelAnnotation marks kernel frames in folded stacks, the convention
+// flamegraph.pl and speedscope use to color them differently.
+const kernelAnnotation = "_[k]"
+
+// Folded is a stack in the folded format, frames ordered root first.
+type Folded struct {
+	Frames []string
+	Value  int64
+}
+
+// Fold aggregates samples into folded stacks rooted at the process name, or
+// the other fields of the map key when the process name isn't known. The
+// first sample value is used.
+func Fold(samples []Sample) []Folded {
+	values := map[string]int64{}
+
+	for _, sample := range samples {
+		if len(sample.Values) == 0 {
+			continue
+		}
+
+		var frames []string
+		if sample.Comm != "" {
+			frames = append(frames, sample.Comm)
+		} else {
+			frames = append(frames, sample.Fields...)
+		}
+
+		for i := len(sample.Frames) - 1; i >= 0; i-- {
+			frame := sample.Frames[i]
+			name := frame.Name()
+			if frame.Kernel {
+				name += kernelAnnotation
+			}
+			frames = append(frames, sanitize(name))
+		}
+
+		values[strings.Join(frames, ";")] += sample.Values[0]
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	folded := make([]Folded, 0, len(keys))
+	for _, key := range keys {
+		folded = append(folded, Folded{Frames: strings.Split(key, ";"), Value: values[key]})
+	}
+
+	return folded
+}
+
+// sanitize removes the characters the folded format uses as separators.
+func sanitize(name string) string {
+	return strings.NewReplacer(";", ":", "\n", " ").Replace(name)
+}
+
+// WriteFolded writes stacks as "root;...;leaf value" lines.
+func WriteFolded(w io.Writer, folded []Folded) error {
+	bw := bufio.NewWriter(w)
+
+	for _, stack := range folded {
+		if _, err := fmt.Fprintf(bw, "%s %d\n", strings.Join(stack.Frames, ";"), stack.Value); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
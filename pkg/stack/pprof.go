@@ -0,0 +1,169 @@
+package stack
+
+import (
+	"os"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/rs/zerolog/log"
+)
+
+const kernelModule = "[kernel.kallsyms]"
+
+// ProfileOptions describes the values of the samples of a profile.
+type ProfileOptions struct {
+	// SampleTypes describes each of the sample values.
+	SampleTypes []*profile.ValueType
+	PeriodType  *profile.ValueType
+	Period      int64
+	Start       time.Time
+	Duration    time.Duration
+}
+
+// BuildProfile converts samples into a pprof profile, labeling every sample
+// with the process it was taken from.
+func BuildProfile(samples []Sample, options ProfileOptions) *profile.Profile {
+	p := &profile.Profile{
+		SampleType:    options.SampleTypes,
+		PeriodType:    options.PeriodType,
+		Period:        options.Period,
+		TimeNanos:     options.Start.UnixNano(),
+		DurationNanos: options.Duration.Nanoseconds(),
+	}
+
+	b := &builder{
+		profile:   p,
+		mappings:  map[mappingKey]*profile.Mapping{},
+		locations: map[locationKey]*profile.Location{},
+		functions: map[string]*profile.Function{},
+	}
+
+	for _, sample := range samples {
+		pprofSample := &profile.Sample{
+			Value: sample.Values,
+			Label: map[string][]string{"comm": {sample.Comm}},
+		}
+
+		if pid, err := parsePid(sample.Pid); err == nil {
+			pprofSample.NumLabel = map[string][]int64{"pid": {pid}}
+		}
+
+		for _, frame := range sample.Frames {
+			pprofSample.Location = append(pprofSample.Location, b.location(sample.Pid, frame))
+		}
+
+		p.Sample = append(p.Sample, pprofSample)
+	}
+
+	return p
+}
+
+type mappingKey struct {
+	pid   string
+	start uint64
+	path  string
+}
+
+type locationKey struct {
+	mapping *profile.Mapping
+	address uint64
+	name    string
+}
+
+type builder struct {
+	profile   *profile.Profile
+	mappings  map[mappingKey]*profile.Mapping
+	locations map[locationKey]*profile.Location
+	functions map[string]*profile.Function
+}
+
+func (b *builder) mapping(pid string, frame Frame) *profile.Mapping {
+	key := mappingKey{pid: pid, path: frame.Module}
+	if frame.Kernel {
+		key = mappingKey{path: kernelModule}
+	} else if frame.Mapping != nil {
+		key.start = frame.Mapping.Start
+	}
+
+	if key.path == "" {
+		return nil
+	}
+
+	if mapping, ok := b.mappings[key]; ok {
+		return mapping
+	}
+
+	mapping := &profile.Mapping{
+		ID:              uint64(len(b.profile.Mapping) + 1),
+		File:            key.path,
+		HasFunctions:    true,
+		HasInlineFrames: false,
+	}
+
+	if !frame.Kernel && frame.Mapping != nil {
+		mapping.Start = frame.Mapping.Start
+		mapping.Limit = frame.Mapping.End
+		mapping.Offset = frame.Mapping.Offset
+	}
+
+	b.mappings[key] = mapping
+	b.profile.Mapping = append(b.profile.Mapping, mapping)
+
+	return mapping
+}
+
+func (b *builder) location(pid string, frame Frame) *profile.Location {
+	mapping := b.mapping(pid, frame)
+
+	key := locationKey{mapping: mapping, address: frame.Address, name: frame.Name()}
+	if location, ok := b.locations[key]; ok {
+		return location
+	}
+
+	location := &profile.Location{
+		ID:      uint64(len(b.profile.Location) + 1),
+		Mapping: mapping,
+		Address: frame.Address,
+		Line:    []profile.Line{{Function: b.function(frame.Name())}},
+	}
+
+	b.locations[key] = location
+	b.profile.Location = append(b.profile.Location, location)
+
+	return location
+}
+
+func (b *builder) function(name string) *profile.Function {
+	if function, ok := b.functions[name]; ok {
+		return function
+	}
+
+	function := &profile.Function{
+		ID:         uint64(len(b.profile.Function) + 1),
+		Name:       name,
+		SystemName: name,
+	}
+
+	b.functions[name] = function
+	b.profile.Function = append(b.profile.Function, function)
+
+	return function
+}
+
+// WriteProfile writes p gzipped to filePath, the format go tool pprof reads.
+func WriteProfile(filePath string, p *profile.Profile) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := p.Write(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	log.Info().
+		Msgf("profile written to: '%s'", filePath)
+
+	return file.Close()
+}
@@ -0,0 +1,177 @@
+package stack
+
+import (
+	"bufio"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/symbolize"
+)
+
+// kernelAddressStart is the lowest kernel space address on 64 bit platforms.
+const kernelAddressStart = 0xffff800000000000
+
+var offsetSuffix = regexp.MustCompile(`\+(0x)?[0-9a-fA-F]+$`)
+
+// Frame is a single stack frame.
+type Frame struct {
+	Address uint64
+	// Symbol is the function name, empty when unknown.
+	Symbol string
+	// Module is the binary or library path, or [kernel.kallsyms].
+	Module string
+	Kernel bool
+	// Mapping the address belongs to, for user frames resolved locally.
+	Mapping *symbolize.Mapping
+}
+
+// Sample is a stack together with the values observed for it, e.g. the
+// number of times it was sampled and the CPU time it represents.
+type Sample struct {
+	Pid  string
+	Comm string
+	// Fields are the non stack fields of the map key the sample came from.
+	Fields []string
+	// Frames are ordered leaf first, kernel frames before user frames.
+	Frames []Frame
+	Values []int64
+}
+
+// Name returns the function name of the frame, falling back to the module or
+// the address so unresolved frames still aggregate sensibly.
+func (f Frame) Name() string {
+	switch {
+	case f.Symbol != "":
+		return f.Symbol
+	case f.Module != "":
+		return "[" + strings.Trim(f.Module, "[]") + "]"
+	default:
+		return "0x" + strconv.FormatUint(f.Address, 16)
+	}
+}
+
+// ParseFrames parses the stacks of a bpftrace map key, one indented frame per
+// line. Frames printed with kstack(perf)/ustack(perf) read
+// "<address> <symbol>+<offset> (<module>)", the default bpftrace mode only
+// prints "<symbol>+<offset>". The other fields of the key aren't indented
+// and are ignored.
+func ParseFrames(text string) []Frame {
+	var frames []Frame
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "\t") && !strings.HasPrefix(line, " ") {
+			continue
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		frame, ok := parsePerfFrame(line)
+		if !ok {
+			frame = Frame{Symbol: symbolName(line)}
+		}
+
+		frames = append(frames, frame)
+	}
+
+	return frames
+}
+
+// KeyFields returns the fields of a bpftrace map key that aren't stacks,
+// such as a pid or comm.
+func KeyFields(key string) []string {
+	var fields []string
+
+	scanner := bufio.NewScanner(strings.NewReader(key))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "\t") || strings.HasPrefix(line, " ") {
+			continue
+		}
+
+		for _, field := range strings.Split(line, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				fields = append(fields, field)
+			}
+		}
+	}
+
+	return fields
+}
+
+func parsePerfFrame(line string) (Frame, bool) {
+	fields := strings.SplitN(line, " ", 2)
+	if len(fields) != 2 {
+		return Frame{}, false
+	}
+
+	address, err := strconv.ParseUint(fields[0], 16, 64)
+	if err != nil {
+		return Frame{}, false
+	}
+
+	symbol := fields[1]
+	module := ""
+	if strings.HasSuffix(symbol, ")") {
+		if i := strings.LastIndex(symbol, " ("); i >= 0 {
+			module = symbol[i+2 : len(symbol)-1]
+			symbol = symbol[:i]
+		}
+	}
+
+	if module == "[unknown]" {
+		module = ""
+	}
+
+	return Frame{
+		Address: address,
+		Symbol:  symbolName(symbol),
+		Module:  module,
+		Kernel:  address >= kernelAddressStart || module == "[kernel.kallsyms]",
+	}, true
+}
+
+func symbolName(symbol string) string {
+	symbol = offsetSuffix.ReplaceAllString(symbol, "")
+	if symbol == "[unknown]" {
+		return ""
+	}
+
+	return symbol
+}
+
+// Resolver resolves user space addresses of a process.
+type Resolver interface {
+	Resolve(pid string, address uint64) (string, *symbolize.Mapping)
+}
+
+// Symbolize resolves the user frames of the samples, preferring symbols read
+// from the binaries over the ones bpftrace managed to resolve.
+func Symbolize(samples []Sample, resolver Resolver) {
+	for i := range samples {
+		for j := range samples[i].Frames {
+			frame := &samples[i].Frames[j]
+			if frame.Kernel {
+				continue
+			}
+
+			symbol, mapping := resolver.Resolve(samples[i].Pid, frame.Address)
+			if symbol != "" {
+				frame.Symbol = symbol
+			}
+			if mapping != nil {
+				frame.Mapping = mapping
+				frame.Module = mapping.Path
+			}
+		}
+	}
+}
+
+func parsePid(pid string) (int64, error) {
+	return strconv.ParseInt(pid, 10, 64)
+}
@@ -0,0 +1,109 @@
+package stack
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/alam0rt/kubectl-doktor/pkg/symbolize"
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/assert"
+)
+
+const perfKey = "4123, \n" +
+	"\tffffffff8a2d1c5e do_syscall_64+94 ([kernel.kallsyms])\n" +
+	"\tffffffff8a400099 entry_SYSCALL_64_after_hwframe+97 ([kernel.kallsyms])\n" +
+	", \n" +
+	"\t4a5c21 [unknown] (/app/server)\n" +
+	"\t7f2c3a5a2010 operator new(unsigned long)+16 (/usr/lib/libstdc++.so.6)\n"
+
+type fakeResolver struct{}
+
+func (fakeResolver) Resolve(pid string, address uint64) (string, *symbolize.Mapping) {
+	if address == 0x4a5c21 {
+		return "main.handler", &symbolize.Mapping{Start: 0x400000, End: 0x500000, Path: "/app/server"}
+	}
+	return "", nil
+}
+
+func TestParseFrames(t *testing.T) {
+	// when
+	frames := ParseFrames(perfKey)
+
+	// then
+	assert.Equal(t, []Frame{
+		{Address: 0xffffffff8a2d1c5e, Symbol: "do_syscall_64", Module: "[kernel.kallsyms]", Kernel: true},
+		{Address: 0xffffffff8a400099, Symbol: "entry_SYSCALL_64_after_hwframe", Module: "[kernel.kallsyms]", Kernel: true},
+		{Address: 0x4a5c21, Symbol: "", Module: "/app/server"},
+		{Address: 0x7f2c3a5a2010, Symbol: "operator new(unsigned long)", Module: "/usr/lib/libstdc++.so.6"},
+	}, frames)
+}
+
+func TestCollector(t *testing.T) {
+	// given
+	collector := NewCollector("@stacks")
+	stacks := bpftrace.ParseRecord([]byte(`{"type": "map", "data": {"@stacks": {"4123, \n\t4a5c21 [unknown] (/app/server)\n": 7}}}`))
+	comms := bpftrace.ParseRecord([]byte(`{"type": "map", "data": {"@comm": {"4123": "server"}}}`))
+
+	// when
+	assert.NoError(t, collector.Print(stacks))
+	assert.NoError(t, collector.Print(comms))
+	samples := collector.Samples()
+
+	// then
+	assert.Len(t, samples, 1)
+	assert.Equal(t, "4123", samples[0].Pid)
+	assert.Equal(t, "server", samples[0].Comm)
+	assert.Equal(t, []int64{7}, samples[0].Values)
+	assert.Equal(t, uint64(0x4a5c21), samples[0].Frames[0].Address)
+}
+
+func TestBuildProfile(t *testing.T) {
+	// given
+	samples := []Sample{{Pid: "4123", Comm: "server", Frames: ParseFrames(perfKey), Values: []int64{3}}}
+	Symbolize(samples, fakeResolver{})
+
+	// when
+	p := BuildProfile(samples, ProfileOptions{
+		SampleTypes: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Start:       time.Unix(0, 0),
+		Duration:    time.Second,
+	})
+
+	// then
+	var buf bytes.Buffer
+	assert.NoError(t, p.Write(&buf))
+	parsed, err := profile.Parse(&buf)
+	assert.NoError(t, err)
+
+	assert.Len(t, parsed.Sample, 1)
+	assert.Equal(t, []int64{4123}, parsed.Sample[0].NumLabel["pid"])
+	names := []string{}
+	for _, location := range parsed.Sample[0].Location {
+		names = append(names, location.Line[0].Function.Name)
+	}
+	assert.Equal(t, []string{"do_syscall_64", "entry_SYSCALL_64_after_hwframe", "main.handler", "operator new(unsigned long)"}, names)
+	assert.Equal(t, "/app/server", parsed.Sample[0].Location[2].Mapping.File)
+}
+
+func TestFold(t *testing.T) {
+	// given
+	samples := []Sample{
+		{Comm: "server", Frames: ParseFrames(perfKey), Values: []int64{3}},
+		{Comm: "server", Frames: ParseFrames(perfKey), Values: []int64{2}},
+		{Fields: []string{"worker"}, Frames: ParseFrames("worker\n\tmain.run;inner+4\n"), Values: []int64{1}},
+	}
+	Symbolize(samples, fakeResolver{})
+
+	// when
+	var buf bytes.Buffer
+	err := WriteFolded(&buf, Fold(samples))
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t,
+		"server;operator new(unsigned long);main.handler;entry_SYSCALL_64_after_hwframe_[k];do_syscall_64_[k] 5\n"+
+			"worker;main.run:inner 1\n",
+		buf.String())
+}
@@ -0,0 +1,113 @@
+package symbolize
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"sort"
+)
+
+// Binary resolves file offsets of an ELF executable or shared library to
+// function symbols.
+type Binary struct {
+	path    string
+	machine elf.Machine
+	symbols []elf.Symbol
+	loads   []elf.ProgHeader
+	goTable *gosym.Table
+}
+
+// OpenBinary loads the function symbols of the ELF file at filePath from
+// .symtab and .dynsym, and for Go binaries from .gopclntab, which survives
+// stripping with -ldflags=-s.
+func OpenBinary(filePath string) (*Binary, error) {
+	file, err := elf.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	symbols, _ := file.Symbols()
+	dynamicSymbols, _ := file.DynamicSymbols()
+	symbols = append(symbols, dynamicSymbols...)
+
+	binary := &Binary{path: filePath, machine: file.Machine, goTable: goTable(file)}
+
+	for _, symbol := range symbols {
+		if elf.ST_TYPE(symbol.Info) == elf.STT_FUNC && symbol.Value != 0 {
+			binary.symbols = append(binary.symbols, symbol)
+		}
+	}
+
+	sort.Slice(binary.symbols, func(i, j int) bool {
+		return binary.symbols[i].Value < binary.symbols[j].Value
+	})
+
+	for _, prog := range file.Progs {
+		if prog.Type == elf.PT_LOAD && prog.Flags&elf.PF_X != 0 {
+			binary.loads = append(binary.loads, prog.ProgHeader)
+		}
+	}
+
+	return binary, nil
+}
+
+func goTable(file *elf.File) *gosym.Table {
+	pclntab := file.Section(".gopclntab")
+	text := file.Section(".text")
+	if pclntab == nil || text == nil {
+		return nil
+	}
+
+	data, err := pclntab.Data()
+	if err != nil {
+		return nil
+	}
+
+	table, err := gosym.NewTable(nil, gosym.NewLineTable(data, text.Addr))
+	if err != nil {
+		return nil
+	}
+
+	return table
+}
+
+// Lookup returns the function containing fileOffset.
+func (b *Binary) Lookup(fileOffset uint64) (string, bool) {
+	address, ok := b.virtualAddress(fileOffset)
+	if !ok {
+		return "", false
+	}
+
+	if b.goTable != nil {
+		if function := b.goTable.PCToFunc(address); function != nil {
+			return function.Name, true
+		}
+	}
+
+	i := sort.Search(len(b.symbols), func(i int) bool {
+		return b.symbols[i].Value > address
+	}) - 1
+
+	if i < 0 {
+		return "", false
+	}
+
+	symbol := b.symbols[i]
+	if symbol.Size != 0 && address >= symbol.Value+symbol.Size {
+		return "", false
+	}
+
+	return symbol.Name, true
+}
+
+// virtualAddress translates a file offset into the link time virtual address
+// symbols are expressed in, using the executable load segments.
+func (b *Binary) virtualAddress(fileOffset uint64) (uint64, bool) {
+	for _, load := range b.loads {
+		if fileOffset >= load.Off && fileOffset < load.Off+load.Filesz {
+			return fileOffset - load.Off + load.Vaddr, true
+		}
+	}
+
+	return 0, false
+}
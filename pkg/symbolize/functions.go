@@ -0,0 +1,140 @@
+package symbolize
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"regexp"
+	"sort"
+
+	"github.com/pkg/errors"
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// arm64Ret is the encoding of "ret" on arm64, instructions are 4 bytes.
+const arm64Ret = 0xd65f03c0
+
+// Function is a function of a binary, its address is the link time virtual
+// address uprobes accept.
+type Function struct {
+	Name    string
+	Address uint64
+	Size    uint64
+}
+
+// IsGo tells whether the binary was built by the Go toolchain.
+func (b *Binary) IsGo() bool {
+	return b.goTable != nil
+}
+
+// Machine returns the architecture the binary was built for.
+func (b *Binary) Machine() elf.Machine {
+	return b.machine
+}
+
+// Functions returns the functions whose name matches pattern, ordered by
+// name. Go functions come from .gopclntab so stripped binaries list them too.
+func (b *Binary) Functions(pattern *regexp.Regexp) []Function {
+	functions := map[string]Function{}
+
+	if b.goTable != nil {
+		for _, function := range b.goTable.Funcs {
+			if pattern.MatchString(function.Name) {
+				functions[function.Name] = Function{
+					Name:    function.Name,
+					Address: function.Entry,
+					Size:    function.End - function.Entry,
+				}
+			}
+		}
+	}
+
+	for _, symbol := range b.symbols {
+		if _, ok := functions[symbol.Name]; ok || !pattern.MatchString(symbol.Name) {
+			continue
+		}
+
+		functions[symbol.Name] = Function{Name: symbol.Name, Address: symbol.Value, Size: symbol.Size}
+	}
+
+	result := make([]Function, 0, len(functions))
+	for _, function := range functions {
+		result = append(result, function)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// Returns disassembles function and returns the addresses of its return
+// instructions. Uretprobes rewrite the return address on the stack, which
+// the Go runtime doesn't expect when it moves goroutine stacks, so Go
+// functions are probed on each of their RET instructions instead.
+func (b *Binary) Returns(function Function) ([]uint64, error) {
+	if function.Size == 0 {
+		return nil, errors.Errorf("size of function: '%s' is unknown", function.Name)
+	}
+
+	code, err := b.read(function.Address, function.Size)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read function: '%s'", function.Name)
+	}
+
+	var returns []uint64
+	switch b.machine {
+	case elf.EM_X86_64:
+		for offset := 0; offset < len(code); {
+			instruction, err := x86asm.Decode(code[offset:], 64)
+			if err != nil {
+				// padding between functions, resynchronize on the next byte
+				offset++
+				continue
+			}
+
+			if instruction.Op == x86asm.RET {
+				returns = append(returns, function.Address+uint64(offset))
+			}
+			offset += instruction.Len
+		}
+	case elf.EM_AARCH64:
+		for offset := 0; offset+4 <= len(code); offset += 4 {
+			if binary.LittleEndian.Uint32(code[offset:]) == arm64Ret {
+				returns = append(returns, function.Address+uint64(offset))
+			}
+		}
+	default:
+		return nil, errors.Errorf("disassembling %s binaries isn't supported", b.machine)
+	}
+
+	if len(returns) == 0 {
+		return nil, errors.Errorf("no return instruction found in function: '%s'", function.Name)
+	}
+
+	return returns, nil
+}
+
+// read returns size bytes of the binary at virtual address.
+func (b *Binary) read(address uint64, size uint64) ([]byte, error) {
+	file, err := elf.Open(b.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	for _, prog := range file.Progs {
+		if prog.Type != elf.PT_LOAD || address < prog.Vaddr || address+size > prog.Vaddr+prog.Filesz {
+			continue
+		}
+
+		code := make([]byte, size)
+		if _, err := prog.ReadAt(code, int64(address-prog.Vaddr)); err != nil {
+			return nil, err
+		}
+
+		return code, nil
+	}
+
+	return nil, errors.Errorf("address: '0x%x' isn't in a loaded segment", address)
+}
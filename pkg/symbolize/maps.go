@@ -0,0 +1,82 @@
+package symbolize
+
+import (
+	"bufio"
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Mapping is an executable, file backed memory mapping of a process as listed
+// in /proc/<pid>/maps.
+type Mapping struct {
+	Start  uint64
+	End    uint64
+	Offset uint64
+	Path   string
+}
+
+// ParseMaps parses the content of /proc/<pid>/maps, keeping the executable
+// mappings of files, ordered by address.
+func ParseMaps(content []byte) []Mapping {
+	var mappings []Mapping
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		// address perms offset dev inode path
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || !strings.Contains(fields[1], "x") || !strings.HasPrefix(fields[5], "/") {
+			continue
+		}
+
+		bounds := strings.SplitN(fields[0], "-", 2)
+		if len(bounds) != 2 {
+			continue
+		}
+
+		start, errStart := strconv.ParseUint(bounds[0], 16, 64)
+		end, errEnd := strconv.ParseUint(bounds[1], 16, 64)
+		offset, errOffset := strconv.ParseUint(fields[2], 16, 64)
+		if errStart != nil || errEnd != nil || errOffset != nil {
+			continue
+		}
+
+		mappings = append(mappings, Mapping{
+			Start:  start,
+			End:    end,
+			Offset: offset,
+			Path:   strings.Join(fields[5:], " "),
+		})
+	}
+
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].Start < mappings[j].Start
+	})
+
+	return mappings
+}
+
+// FindMapping returns the mapping containing address, if any.
+func FindMapping(mappings []Mapping, address uint64) *Mapping {
+	i := sort.Search(len(mappings), func(i int) bool {
+		return mappings[i].End > address
+	})
+
+	if i < len(mappings) && mappings[i].Start <= address {
+		return &mappings[i]
+	}
+
+	return nil
+}
+
+// FileOffset translates address into an offset in the mapped file.
+func (m *Mapping) FileOffset(address uint64) uint64 {
+	return address - m.Start + m.Offset
+}
+
+// Deleted reports whether the mapped file was deleted or replaced since it
+// was mapped, in which case it can't be read from the filesystem anymore.
+func (m *Mapping) Deleted() bool {
+	return strings.HasSuffix(m.Path, " (deleted)")
+}
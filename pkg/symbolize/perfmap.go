@@ -0,0 +1,98 @@
+package symbolize
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+)
+
+// PerfMapEntry is a JIT compiled function listed in a perf map file.
+type PerfMapEntry struct {
+	Start uint64
+	Size  uint64
+	Name  string
+}
+
+// PerfMap is the content of a /tmp/perf-<pid>.map file, written by JIT
+// runtimes such as the JVM through an agent or Node.js run with
+// --perf-basic-prof, ordered by address.
+type PerfMap []PerfMapEntry
+
+// ParsePerfMap parses "<start> <size> <name>" lines, addresses being hex.
+func ParsePerfMap(content []byte) PerfMap {
+	var perfMap PerfMap
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.SplitN(strings.TrimSpace(scanner.Text()), " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		start, errStart := strconv.ParseUint(strings.TrimPrefix(fields[0], "0x"), 16, 64)
+		size, errSize := strconv.ParseUint(strings.TrimPrefix(fields[1], "0x"), 16, 64)
+		if errStart != nil || errSize != nil {
+			continue
+		}
+
+		perfMap = append(perfMap, PerfMapEntry{Start: start, Size: size, Name: fields[2]})
+	}
+
+	// later entries win when code is recompiled at the same address
+	sort.SliceStable(perfMap, func(i, j int) bool {
+		return perfMap[i].Start < perfMap[j].Start
+	})
+
+	return perfMap
+}
+
+// Lookup returns the function containing address.
+func (m PerfMap) Lookup(address uint64) (string, bool) {
+	i := sort.Search(len(m), func(i int) bool {
+		return m[i].Start > address
+	}) - 1
+
+	if i < 0 || address >= m[i].Start+m[i].Size {
+		return "", false
+	}
+
+	return m[i].Name, true
+}
+
+// ParseNSpid returns the pid of a process in its own pid namespace from the
+// content of /proc/<pid>/status, the pid JIT runtimes name their perf map
+// after. An empty string is returned when the kernel doesn't report it.
+func ParseNSpid(status []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(status))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "NSpid:") {
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimPrefix(line, "NSpid:"))
+		if len(fields) == 0 {
+			return ""
+		}
+		return fields[len(fields)-1]
+	}
+
+	return ""
+}
+
+// StatusPath returns the path of the status of pid from the privileged pod.
+func StatusPath(pid string) string {
+	return fmt.Sprintf("%s/%s/status", bpftrace.HostProcPath, pid)
+}
+
+// PerfMapPath returns where the perf map of pid, nsPid in its own pid
+// namespace, is reachable from the privileged pod.
+func PerfMapPath(pid string, nsPid string) string {
+	return RootPath(pid, fmt.Sprintf("/tmp/perf-%s.map", nsPid))
+}
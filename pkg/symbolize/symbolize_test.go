@@ -0,0 +1,185 @@
+package symbolize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMaps(t *testing.T) {
+	// given
+	content := []byte("00400000-00452000 r-xp 00000000 08:02 173521 /usr/bin/dbus-daemon\n" +
+		"00651000-00652000 r--p 00051000 08:02 173521 /usr/bin/dbus-daemon\n" +
+		"7f2c3a5a2000-7f2c3a75d000 r-xp 00026000 08:02 135522 /usr/lib/x86_64-linux-gnu/libc.so.6\n" +
+		"7ffc1a7f2000-7ffc1a7f4000 r-xp 00000000 00:00 0 [vdso]\n")
+
+	// when
+	mappings := ParseMaps(content)
+
+	// then
+	assert.Equal(t, []Mapping{
+		{Start: 0x400000, End: 0x452000, Offset: 0, Path: "/usr/bin/dbus-daemon"},
+		{Start: 0x7f2c3a5a2000, End: 0x7f2c3a75d000, Offset: 0x26000, Path: "/usr/lib/x86_64-linux-gnu/libc.so.6"},
+	}, mappings)
+	assert.Equal(t, "/usr/lib/x86_64-linux-gnu/libc.so.6", FindMapping(mappings, 0x7f2c3a5a2010).Path)
+	assert.Nil(t, FindMapping(mappings, 0x452000))
+	assert.Equal(t, uint64(0x26010), FindMapping(mappings, 0x7f2c3a5a2010).FileOffset(0x7f2c3a5a2010))
+}
+
+func TestBinary_LookupSelf(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("reads /proc/self/maps")
+	}
+
+	// given
+	maps, err := ioutil.ReadFile("/proc/self/maps")
+	assert.NoError(t, err)
+	address := uint64(reflect.ValueOf(TestBinary_LookupSelf).Pointer())
+	mapping := FindMapping(ParseMaps(maps), address)
+	assert.NotNil(t, mapping)
+
+	binary, err := OpenBinary(mapping.Path)
+	assert.NoError(t, err)
+
+	// when
+	symbol, ok := binary.Lookup(mapping.FileOffset(address))
+
+	// then
+	assert.True(t, ok)
+	assert.Equal(t, "github.com/alam0rt/kubectl-doktor/pkg/symbolize.TestBinary_LookupSelf", symbol)
+}
+
+func TestBinary_ReturnsSelf(t *testing.T) {
+	if runtime.GOOS != "linux" || (runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64") {
+		t.Skip("disassembles the test binary")
+	}
+
+	// given
+	executable, err := os.Executable()
+	assert.NoError(t, err)
+
+	binary, err := OpenBinary(executable)
+	assert.NoError(t, err)
+
+	functions := binary.Functions(regexp.MustCompile(`/pkg/symbolize\.ParseMaps$`))
+	assert.Len(t, functions, 1)
+
+	// when
+	returns, err := binary.Returns(functions[0])
+
+	// then
+	assert.NoError(t, err)
+	assert.True(t, binary.IsGo())
+	assert.NotEmpty(t, returns)
+	for _, address := range returns {
+		assert.True(t, address >= functions[0].Address && address < functions[0].Address+functions[0].Size)
+
+		code, err := binary.read(address, 1)
+		assert.NoError(t, err)
+		if runtime.GOARCH == "amd64" {
+			assert.Equal(t, byte(0xc3), code[0])
+		}
+	}
+}
+
+func TestParseUSDTNotes(t *testing.T) {
+	// given
+	note := func(owner string, noteType uint32, desc []byte) []byte {
+		var buf bytes.Buffer
+		name := append([]byte(owner), 0)
+		_ = binary.Write(&buf, binary.LittleEndian, []uint32{uint32(len(name)), uint32(len(desc)), noteType})
+		buf.Write(name)
+		buf.Write(make([]byte, (4-len(name)%4)%4))
+		buf.Write(desc)
+		buf.Write(make([]byte, (4-len(desc)%4)%4))
+		return buf.Bytes()
+	}
+	desc := func(address, semaphore uint64, strs string) []byte {
+		var buf bytes.Buffer
+		_ = binary.Write(&buf, binary.LittleEndian, []uint64{address, 0x5c0000, semaphore})
+		buf.WriteString(strs)
+		return buf.Bytes()
+	}
+
+	data := append(note("stapsdt", 3, desc(0x4a5c21, 0, "python\x00function__entry\x008@%rbx 8@%r12 -4@%eax\x00")),
+		note("GNU", 3, []byte{1, 2, 3, 4})...)
+	data = append(data, note("stapsdt", 3, desc(0x4a6000, 0x7a1000, "node\x00gc__start\x00\x00"))...)
+
+	// when
+	probes, err := ParseUSDTNotes(data, binary.LittleEndian, 8)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []USDTProbe{
+		{Provider: "python", Name: "function__entry", Address: 0x4a5c21, Arguments: []string{"8@%rbx", "8@%r12", "-4@%eax"}},
+		{Provider: "node", Name: "gc__start", Address: 0x4a6000, Semaphore: 0x7a1000, Arguments: []string{}},
+	}, probes)
+}
+
+func TestPerfMap(t *testing.T) {
+	// given
+	content := []byte("7f3c2d001000 40 Interpreter\n" +
+		"7f3c2d0a0000 1a0 LazyCompile:~handle /app/server.js:12\n" +
+		"not a perf map line\n" +
+		"0x7f3c2d0b0000 0x20 Lcom/example/Server;::handle\n")
+
+	// when
+	perfMap := ParsePerfMap(content)
+
+	// then
+	assert.Len(t, perfMap, 3)
+	name, ok := perfMap.Lookup(0x7f3c2d0a0010)
+	assert.True(t, ok)
+	assert.Equal(t, "LazyCompile:~handle /app/server.js:12", name)
+	name, ok = perfMap.Lookup(0x7f3c2d0b0010)
+	assert.True(t, ok)
+	assert.Equal(t, "Lcom/example/Server;::handle", name)
+	_, ok = perfMap.Lookup(0x7f3c2d0a01a0)
+	assert.False(t, ok)
+}
+
+func TestParseNSpid(t *testing.T) {
+	// given
+	status := []byte("Name:\tjava\nTgid:\t4123\nPid:\t4123\nNSpid:\t4123\t1\n")
+
+	// when
+	nsPid := ParseNSpid(status)
+
+	// then
+	assert.Equal(t, "1", nsPid)
+	assert.Equal(t, "/host/proc/4123/root/tmp/perf-1.map", PerfMapPath("4123", nsPid))
+}
+
+type recordingSource struct {
+	reads []string
+}
+
+func (r *recordingSource) ReadFile(remotePath string) ([]byte, error) {
+	r.reads = append(r.reads, remotePath)
+	return nil, os.ErrNotExist
+}
+
+func (r *recordingSource) DownloadFile(remotePath string, localDir string) error {
+	return os.ErrNotExist
+}
+
+func TestSymbolizer_ResolveWithoutPid(t *testing.T) {
+	// given
+	source := &recordingSource{}
+	symbolizer := NewSymbolizer(source, t.TempDir())
+
+	// when
+	symbol, mapping := symbolizer.Resolve("", 0x7f3c2d0a0010)
+
+	// then
+	assert.Empty(t, symbol)
+	assert.Nil(t, mapping)
+	assert.Empty(t, source.reads)
+}
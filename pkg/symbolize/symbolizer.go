@@ -0,0 +1,162 @@
+package symbolize
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/alam0rt/kubectl-doktor/pkg/bpftrace"
+	"github.com/rs/zerolog/log"
+)
+
+// RootPath returns where path of the root filesystem of pid can be found
+// from the privileged pod.
+func RootPath(pid string, path string) string {
+	return fmt.Sprintf("%s/%s/root%s", bpftrace.HostProcPath, pid, path)
+}
+
+// MapsPath returns the path of the memory mappings of pid from the
+// privileged pod.
+func MapsPath(pid string) string {
+	return fmt.Sprintf("%s/%s/maps", bpftrace.HostProcPath, pid)
+}
+
+// ExePath returns the path of the link to the executable of pid from the
+// privileged pod.
+func ExePath(pid string) string {
+	return fmt.Sprintf("%s/%s/exe", bpftrace.HostProcPath, pid)
+}
+
+// Source gives access to the files of the target processes, typically
+// through the privileged helper pod.
+type Source interface {
+	ReadFile(remotePath string) ([]byte, error)
+	DownloadFile(remotePath string, localDir string) error
+}
+
+// Symbolizer resolves user space addresses of the target processes by
+// downloading the mapped binaries from their root filesystem and reading
+// their ELF symbols locally.
+type Symbolizer struct {
+	source   Source
+	cacheDir string
+	mappings map[string][]Mapping
+	binaries map[string]*Binary
+	perfMaps map[string]PerfMap
+}
+
+// NewSymbolizer creates a symbolizer keeping downloaded binaries in cacheDir.
+func NewSymbolizer(source Source, cacheDir string) *Symbolizer {
+	return &Symbolizer{
+		source:   source,
+		cacheDir: cacheDir,
+		mappings: map[string][]Mapping{},
+		binaries: map[string]*Binary{},
+		perfMaps: map[string]PerfMap{},
+	}
+}
+
+// Mappings returns the executable mappings of pid, read once per process.
+func (s *Symbolizer) Mappings(pid string) []Mapping {
+	if mappings, ok := s.mappings[pid]; ok {
+		return mappings
+	}
+
+	content, err := s.source.ReadFile(MapsPath(pid))
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msgf("failed to read memory mappings of pid: '%s', its frames won't be symbolized", pid)
+	}
+
+	s.mappings[pid] = ParseMaps(content)
+	return s.mappings[pid]
+}
+
+// Resolve returns the function at address in pid and the mapping it belongs
+// to. The symbol is empty when it couldn't be resolved.
+func (s *Symbolizer) Resolve(pid string, address uint64) (string, *Mapping) {
+	// frames printed without their process can't be symbolized
+	if pid == "" {
+		return "", nil
+	}
+
+	mapping := FindMapping(s.Mappings(pid), address)
+	if mapping == nil {
+		// JIT compiled code lives in anonymous mappings
+		symbol, _ := s.perfMap(pid).Lookup(address)
+		return symbol, nil
+	}
+
+	if mapping.Deleted() {
+		return "", mapping
+	}
+
+	binary := s.binary(pid, mapping.Path)
+	if binary == nil {
+		return "", mapping
+	}
+
+	symbol, _ := binary.Lookup(mapping.FileOffset(address))
+	return symbol, mapping
+}
+
+// perfMap returns the perf map of pid, empty when the process doesn't write
+// one. It's read once per process.
+func (s *Symbolizer) perfMap(pid string) PerfMap {
+	if perfMap, ok := s.perfMaps[pid]; ok {
+		return perfMap
+	}
+
+	s.perfMaps[pid] = nil
+	if pid == "" {
+		return nil
+	}
+
+	nsPid := pid
+	if status, err := s.source.ReadFile(StatusPath(pid)); err == nil {
+		if id := ParseNSpid(status); id != "" {
+			nsPid = id
+		}
+	}
+
+	content, err := s.source.ReadFile(PerfMapPath(pid, nsPid))
+	if err != nil {
+		log.Debug().
+			Msgf("no perf map for pid: '%s', its JIT frames won't be symbolized", pid)
+		return nil
+	}
+
+	s.perfMaps[pid] = ParsePerfMap(content)
+	return s.perfMaps[pid]
+}
+
+// binary loads the binary mapped at path. Binaries are cached by path as the
+// processes of a container share its root filesystem.
+func (s *Symbolizer) binary(pid string, path string) *Binary {
+	if binary, ok := s.binaries[path]; ok {
+		return binary
+	}
+
+	s.binaries[path] = nil
+
+	remotePath := RootPath(pid, path)
+	localDir := filepath.Join(s.cacheDir, filepath.FromSlash(filepath.Dir(path)))
+
+	if err := s.source.DownloadFile(remotePath, localDir); err != nil {
+		log.Warn().
+			Err(err).
+			Msgf("failed to download: '%s', its frames won't be symbolized", remotePath)
+		return nil
+	}
+
+	binary, err := OpenBinary(filepath.Join(localDir, filepath.Base(path)))
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msgf("failed to read symbols of: '%s'", path)
+		return nil
+	}
+
+	s.binaries[path] = binary
+	return binary
+}
@@ -0,0 +1,134 @@
+package symbolize
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	stapsdtSection  = ".note.stapsdt"
+	stapsdtOwner    = "stapsdt"
+	stapsdtNoteType = 3
+)
+
+// USDTProbe is a statically defined tracing probe site, as described by the
+// .note.stapsdt section of the binary defining it.
+type USDTProbe struct {
+	Provider  string
+	Name      string
+	Address   uint64
+	Semaphore uint64
+	// Arguments are the argument specifications, e.g. "-4@%edi" for a signed
+	// 4 bytes argument held in edi.
+	Arguments []string
+}
+
+// ReadUSDTProbes returns the USDT probe sites of the ELF file at filePath, a
+// probe with several call sites is returned once per site.
+func ReadUSDTProbes(filePath string) ([]USDTProbe, error) {
+	file, err := elf.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	section := file.Section(stapsdtSection)
+	if section == nil {
+		return nil, nil
+	}
+
+	data, err := section.Data()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s of: '%s'", stapsdtSection, filePath)
+	}
+
+	addressSize := 8
+	if file.Class == elf.ELFCLASS32 {
+		addressSize = 4
+	}
+
+	return ParseUSDTNotes(data, file.ByteOrder, addressSize)
+}
+
+// ParseUSDTNotes parses the content of a .note.stapsdt section. Each note
+// holds the probe address, the link time address of .stapsdt.base and the
+// semaphore address, followed by the NUL terminated provider, name and
+// argument specifications.
+func ParseUSDTNotes(data []byte, order binary.ByteOrder, addressSize int) ([]USDTProbe, error) {
+	var probes []USDTProbe
+
+	for len(data) > 0 {
+		if len(data) < 12 {
+			return nil, errors.New("truncated note header")
+		}
+
+		nameSize := int(order.Uint32(data[0:4]))
+		descSize := int(order.Uint32(data[4:8]))
+		noteType := order.Uint32(data[8:12])
+		data = data[12:]
+
+		nameEnd := align4(nameSize)
+		descEnd := nameEnd + align4(descSize)
+		if nameEnd+descSize > len(data) {
+			return nil, errors.New("truncated note")
+		}
+		if descEnd > len(data) {
+			descEnd = len(data)
+		}
+
+		owner := string(bytes.TrimRight(data[:nameSize], "\x00"))
+		desc := data[nameEnd : nameEnd+descSize]
+		data = data[descEnd:]
+
+		if owner != stapsdtOwner || noteType != stapsdtNoteType {
+			continue
+		}
+
+		probe, err := parseUSDTDesc(desc, order, addressSize)
+		if err != nil {
+			return nil, err
+		}
+
+		probes = append(probes, probe)
+	}
+
+	return probes, nil
+}
+
+func parseUSDTDesc(desc []byte, order binary.ByteOrder, addressSize int) (USDTProbe, error) {
+	var probe USDTProbe
+
+	if len(desc) < 3*addressSize {
+		return probe, errors.New("truncated stapsdt note")
+	}
+
+	address := func(i int) uint64 {
+		field := desc[i*addressSize : (i+1)*addressSize]
+		if addressSize == 4 {
+			return uint64(order.Uint32(field))
+		}
+		return order.Uint64(field)
+	}
+
+	probe.Address = address(0)
+	probe.Semaphore = address(2)
+
+	strs := strings.SplitN(string(desc[3*addressSize:]), "\x00", 4)
+	if len(strs) < 3 {
+		return probe, errors.New("truncated stapsdt note strings")
+	}
+
+	probe.Provider = strs[0]
+	probe.Name = strs[1]
+	probe.Arguments = strings.Fields(strs[2])
+
+	return probe, nil
+}
+
+func align4(size int) int {
+	return (size + 3) &^ 3
+}
@@ -0,0 +1,31 @@
+// Package testutil holds the fixtures shared by the tests of the other
+// packages: a pod of a payments namespace running a single app container.
+package testutil
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	Namespace   = "payments"
+	PodName     = "api-7d9f"
+	PodUID      = "0f6c2a1e-5b7d-4c3e-9a8b-1d2e3f4a5b6c"
+	Container   = "app"
+	ContainerId = "3f4e1a0c9b2d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f"
+	Node        = "node-1"
+
+	// Workload is how the container is named in annotated output.
+	Workload = Namespace + "/" + PodName + "/" + Container
+)
+
+// Pod returns the pod, its container running under containerd.
+func Pod() corev1.Pod {
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: Namespace, Name: PodName, UID: PodUID},
+		Spec:       corev1.PodSpec{NodeName: Node},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{Name: Container, ContainerID: "containerd://" + ContainerId},
+		}},
+	}
+}